@@ -1,3 +1,9 @@
 module github.com/imaravin/factory
 
 go 1.21
+
+require (
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.29.0
+	gopkg.in/yaml.v3 v3.0.1
+)
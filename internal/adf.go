@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// --- ADF Rendering ---
+
+// adfNode is a node in an Atlassian Document Format tree
+type adfNode struct {
+	Type    string                 `json:"type"`
+	Version int                    `json:"version,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Marks   []adfMark              `json:"marks,omitempty"`
+	Content []adfNode              `json:"content,omitempty"`
+}
+
+type adfMark struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// renderADF flattens an ADF document into markdown-ish plain text
+func renderADF(doc adfNode) string {
+	var b strings.Builder
+	writeADF(&b, doc, "")
+	return strings.TrimSpace(regexp.MustCompile(`\n{3,}`).ReplaceAllString(b.String(), "\n\n"))
+}
+
+func writeADF(b *strings.Builder, n adfNode, indent string) {
+	switch n.Type {
+	case "text":
+		b.WriteString(n.Text)
+	case "hardBreak":
+		b.WriteString("\n" + indent)
+	case "mention", "emoji":
+		if t, ok := n.Attrs["text"].(string); ok {
+			b.WriteString(t)
+		}
+	case "inlineCard", "blockCard":
+		if u, ok := n.Attrs["url"].(string); ok {
+			b.WriteString(u)
+		}
+	case "paragraph", "heading":
+		if n.Type == "heading" {
+			level := 1
+			if l, ok := n.Attrs["level"].(float64); ok {
+				level = int(l)
+			}
+			b.WriteString(strings.Repeat("#", level) + " ")
+		}
+		writeADFChildren(b, n, indent)
+		b.WriteString("\n\n")
+	case "codeBlock":
+		b.WriteString("```\n")
+		writeADFChildren(b, n, indent)
+		b.WriteString("\n```\n\n")
+	case "bulletList", "orderedList":
+		for i, item := range n.Content {
+			marker := "- "
+			if n.Type == "orderedList" {
+				marker = fmt.Sprintf("%d. ", i+1)
+			}
+			b.WriteString(indent + marker)
+			var inner strings.Builder
+			writeADFChildren(&inner, item, indent+"  ")
+			b.WriteString(strings.TrimSpace(inner.String()) + "\n")
+		}
+		b.WriteString("\n")
+	case "blockquote":
+		var inner strings.Builder
+		writeADFChildren(&inner, n, indent)
+		for _, line := range strings.Split(strings.TrimSpace(inner.String()), "\n") {
+			b.WriteString("> " + line + "\n")
+		}
+		b.WriteString("\n")
+	default:
+		writeADFChildren(b, n, indent)
+	}
+}
+
+func writeADFChildren(b *strings.Builder, n adfNode, indent string) {
+	for _, c := range n.Content {
+		writeADF(b, c, indent)
+	}
+}
+
+// --- ADF Building ---
+
+func adfDoc(blocks ...adfNode) adfNode {
+	return adfNode{Type: "doc", Version: 1, Content: blocks}
+}
+
+func adfParagraph(inline ...adfNode) adfNode {
+	return adfNode{Type: "paragraph", Content: inline}
+}
+
+func adfHeading(level int, inline ...adfNode) adfNode {
+	return adfNode{Type: "heading", Attrs: map[string]interface{}{"level": level}, Content: inline}
+}
+
+func adfText(text string, marks ...adfMark) adfNode {
+	return adfNode{Type: "text", Text: text, Marks: marks}
+}
+
+func adfLink(text, href string) adfNode {
+	return adfText(text, adfMark{Type: "link", Attrs: map[string]interface{}{"href": href}})
+}
+
+func adfCodeBlock(language, code string) adfNode {
+	n := adfNode{Type: "codeBlock", Content: []adfNode{adfText(code)}}
+	if code == "" {
+		n.Content = nil
+	}
+	if language != "" {
+		n.Attrs = map[string]interface{}{"language": language}
+	}
+	return n
+}
+
+// adfPanel wraps blocks in a panel; panelType is info, note, warning,
+// success, or error
+func adfPanel(panelType string, blocks ...adfNode) adfNode {
+	return adfNode{Type: "panel", Attrs: map[string]interface{}{"panelType": panelType}, Content: blocks}
+}
+
+func adfList(ordered bool, items ...[]adfNode) adfNode {
+	n := adfNode{Type: "bulletList"}
+	if ordered {
+		n.Type = "orderedList"
+	}
+	for _, blocks := range items {
+		n.Content = append(n.Content, adfNode{Type: "listItem", Content: blocks})
+	}
+	return n
+}
+
+func adfRule() adfNode {
+	return adfNode{Type: "rule"}
+}
+
+// --- Markdown to ADF ---
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdBullet   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrdered  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdPanel    = regexp.MustCompile(`^:::(info|note|warning|success|error)\s*$`)
+	mdInline   = regexp.MustCompile("`([^`]+)`|\\*\\*([^*]+)\\*\\*|\\[([^\\]]+)\\]\\(([^)\\s]+)\\)|(https?://[^\\s)>\\]]+)")
+	mdCheckbox = regexp.MustCompile(`^\[[ xX]\]\s+`)
+)
+
+// markdownToADF converts the Markdown subset factory writes in comments
+// (headings, lists, quotes, fenced code, rules, links, bold, inline code)
+// into an ADF document. ":::info" ... ":::" fences become panels.
+func markdownToADF(md string) adfNode {
+	return adfDoc(markdownBlocks(strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n"))...)
+}
+
+func markdownBlocks(lines []string) []adfNode {
+	var blocks []adfNode
+	var para []string
+
+	flush := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, adfParagraph(markdownLines(para)...))
+			para = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flush()
+
+		case strings.HasPrefix(trimmed, "```"):
+			flush()
+			lang := strings.TrimSpace(strings.TrimPrefix(trimmed, "```"))
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			blocks = append(blocks, adfCodeBlock(lang, strings.Join(code, "\n")))
+
+		case mdPanel.MatchString(trimmed):
+			flush()
+			panelType := mdPanel.FindStringSubmatch(trimmed)[1]
+			var inner []string
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != ":::"; i++ {
+				inner = append(inner, lines[i])
+			}
+			blocks = append(blocks, adfPanel(panelType, markdownBlocks(inner)...))
+
+		case mdHeading.MatchString(trimmed):
+			flush()
+			m := mdHeading.FindStringSubmatch(trimmed)
+			blocks = append(blocks, adfHeading(len(m[1]), markdownInline(m[2])...))
+
+		case trimmed == "---" || trimmed == "***":
+			flush()
+			blocks = append(blocks, adfRule())
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quoted []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			i--
+			blocks = append(blocks, adfNode{Type: "blockquote", Content: markdownBlocks(quoted)})
+
+		case mdBullet.MatchString(line) || mdOrdered.MatchString(line):
+			flush()
+			ordered := !mdBullet.MatchString(line)
+			re := mdBullet
+			if ordered {
+				re = mdOrdered
+			}
+			var items [][]adfNode
+			for ; i < len(lines) && re.MatchString(lines[i]); i++ {
+				text := mdCheckbox.ReplaceAllStringFunc(re.FindStringSubmatch(lines[i])[1], func(box string) string {
+					if strings.ContainsAny(box, "xX") {
+						return "☑ "
+					}
+					return "☐ "
+				})
+				items = append(items, []adfNode{adfParagraph(markdownInline(text)...)})
+			}
+			i--
+			blocks = append(blocks, adfList(ordered, items...))
+
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// markdownLines renders consecutive paragraph lines joined by hard breaks
+func markdownLines(lines []string) []adfNode {
+	var nodes []adfNode
+	for i, line := range lines {
+		if i > 0 {
+			nodes = append(nodes, adfNode{Type: "hardBreak"})
+		}
+		nodes = append(nodes, markdownInline(line)...)
+	}
+	return nodes
+}
+
+func markdownInline(s string) []adfNode {
+	var nodes []adfNode
+	last := 0
+	for _, m := range mdInline.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > last {
+			nodes = append(nodes, adfText(s[last:m[0]]))
+		}
+		switch {
+		case m[2] >= 0:
+			nodes = append(nodes, adfText(s[m[2]:m[3]], adfMark{Type: "code"}))
+		case m[4] >= 0:
+			nodes = append(nodes, adfText(s[m[4]:m[5]], adfMark{Type: "strong"}))
+		case m[6] >= 0:
+			nodes = append(nodes, adfLink(s[m[6]:m[7]], s[m[8]:m[9]]))
+		default:
+			url := s[m[10]:m[11]]
+			nodes = append(nodes, adfLink(url, url))
+		}
+		last = m[1]
+	}
+	if last < len(s) {
+		nodes = append(nodes, adfText(s[last:]))
+	}
+	return nodes
+}
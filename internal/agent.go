@@ -0,0 +1,211 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AgentProgress summarizes what Claude Code has done so far in a run
+type AgentProgress struct {
+	Step      string `json:"step"` // latest tool call, e.g. "Edit engine.go"
+	ToolCalls int    `json:"toolCalls"`
+	FilesRead int    `json:"filesRead"`
+	Edits     int    `json:"edits"`
+	Turns     int    `json:"turns,omitempty"`
+	// Files lists the files edited so far, in the order first touched
+	Files []string `json:"files,omitempty"`
+	// Reported when the agent finishes; input counts cached tokens too
+	CostUSD      float64   `json:"costUsd,omitempty"`
+	InputTokens  int       `json:"inputTokens,omitempty"`
+	OutputTokens int       `json:"outputTokens,omitempty"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	// SessionID is Claude Code's session, reported when it starts
+	SessionID string `json:"sessionId,omitempty"`
+	// Result is the agent's final response, reported when it finishes
+	Result string `json:"-"`
+}
+
+func (p AgentProgress) String() string {
+	s := fmt.Sprintf("%s (turn %d, %d tool calls, %d files read, %d edits)", p.Step, p.Turns, p.ToolCalls, p.FilesRead, p.Edits)
+	if len(p.Files) > 0 {
+		s += "; editing " + summarizeList(p.Files, 3)
+	}
+	return s
+}
+
+// summarizeList joins up to n items, noting how many more there are
+func summarizeList(items []string, n int) string {
+	if len(items) <= n {
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(items[:n], ", "), len(items)-n)
+}
+
+// streamEvent is one line of `claude --output-format stream-json`
+type streamEvent struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	Message struct {
+		ID      string `json:"id"`
+		Content []struct {
+			Type  string                 `json:"type"`
+			Text  string                 `json:"text"`
+			Name  string                 `json:"name"`
+			Input map[string]interface{} `json:"input"`
+		} `json:"content"`
+	} `json:"message"`
+	SessionID string  `json:"session_id"`
+	IsError   bool    `json:"is_error"`
+	Result    string  `json:"result"`
+	NumTurns  int     `json:"num_turns"`
+	CostUSD   float64 `json:"total_cost_usd"`
+	Usage     struct {
+		InputTokens              int `json:"input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	} `json:"usage"`
+}
+
+// followAgentStream prints a readable trace of Claude Code's stream-json
+// output, passes every tool call to onToolCall, and reports progress after
+// each. It returns an error if the agent reported one, or onToolCall's error
+// as soon as it rejects a call.
+func followAgentStream(r io.Reader, onToolCall func(tool string, input map[string]interface{}) error, onProgress func(AgentProgress)) error {
+	var p AgentProgress
+	lastMessage := ""
+	edited := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		var ev streamEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			fmt.Println(line)
+			continue
+		}
+
+		if ev.SessionID != "" && p.SessionID == "" {
+			p.SessionID = ev.SessionID
+			if onProgress != nil {
+				onProgress(p)
+			}
+		}
+
+		switch ev.Type {
+		case "assistant":
+			// Content blocks of one reply can arrive as separate events
+			if ev.Message.ID == "" || ev.Message.ID != lastMessage {
+				p.Turns++
+				lastMessage = ev.Message.ID
+			}
+			for _, c := range ev.Message.Content {
+				switch c.Type {
+				case "text":
+					if text := strings.TrimSpace(c.Text); text != "" {
+						fmt.Printf("  > %s\n", truncate(120, strings.SplitN(text, "\n", 2)[0]))
+					}
+				case "tool_use":
+					if err := onToolCall(c.Name, c.Input); err != nil {
+						fmt.Printf("  ✗ %v\n", err)
+						return err
+					}
+					p.ToolCalls++
+					switch c.Name {
+					case "Read":
+						p.FilesRead++
+					case "Edit", "MultiEdit", "Write", "NotebookEdit":
+						p.Edits++
+						if file := toolTarget(c.Input); file != "" && !edited[file] {
+							edited[file] = true
+							p.Files = append(p.Files, file)
+						}
+					}
+					p.Step = strings.TrimSpace(c.Name + " " + toolTarget(c.Input))
+					p.UpdatedAt = time.Now()
+					fmt.Printf("  · %s\n", p.Step)
+					if onProgress != nil {
+						onProgress(p)
+					}
+				}
+			}
+		case "result":
+			p.Turns = ev.NumTurns
+			p.CostUSD = ev.CostUSD
+			p.InputTokens = ev.Usage.InputTokens + ev.Usage.CacheCreationInputTokens + ev.Usage.CacheReadInputTokens
+			p.OutputTokens = ev.Usage.OutputTokens
+			p.Result = ev.Result
+			p.Step = "finished"
+			p.UpdatedAt = time.Now()
+			if onProgress != nil {
+				onProgress(p)
+			}
+			fmt.Printf("  Agent finished: %d turns, %d tool calls, %d files read, %d edits, %s\n",
+				p.Turns, p.ToolCalls, p.FilesRead, p.Edits, formatCost(p.CostUSD, p.InputTokens, p.OutputTokens))
+			if ev.IsError {
+				return fmt.Errorf("agent error (%s): %s", ev.Subtype, truncate(500, ev.Result))
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// formatCost renders agent spend, e.g. "$0.42 (1.2M in / 35k out tokens)"
+func formatCost(usd float64, in, out int) string {
+	return fmt.Sprintf("$%.2f (%s in / %s out tokens)", usd, formatTokens(in), formatTokens(out))
+}
+
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%dk", n/1_000)
+	}
+	return fmt.Sprint(n)
+}
+
+// GetTranscriptPath is where the raw agent stream of the issue's latest run
+// is kept
+func GetTranscriptPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "transcripts", issueKey+".jsonl")
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// openTranscript starts a fresh transcript for the issue. Failures only
+// warn, since the transcript is a record rather than part of the run.
+func openTranscript(issueKey string) io.WriteCloser {
+	path := GetTranscriptPath(issueKey)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+		if f, err := os.Create(path); err == nil {
+			return redactFile{newRedactWriter(f), f}
+		}
+	}
+	fmt.Printf("  Warning: could not write transcript %s\n", path)
+	return nopWriteCloser{io.Discard}
+}
+
+// toolTarget picks the most telling argument of a tool call for display
+func toolTarget(input map[string]interface{}) string {
+	for _, key := range []string{"file_path", "notebook_path", "command", "pattern", "url", "path", "description"} {
+		v, ok := input[key].(string)
+		if !ok || v == "" {
+			continue
+		}
+		if strings.HasSuffix(key, "_path") {
+			return filepath.Base(v)
+		}
+		return truncate(80, strings.SplitN(v, "\n", 2)[0])
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultArtifactPatterns are build and tooling outputs that should never
+// land in an automated commit. A trailing "/" matches a directory anywhere
+// in the path, or only at the repo root with a leading "/" too, as for
+// names like build/ that are also source packages; anything else is a
+// glob on the file name.
+var defaultArtifactPatterns = []string{
+	"node_modules/",
+	"coverage/",
+	"/dist/",
+	"/build/",
+	"/target/",
+	"__pycache__/",
+	".pytest_cache/",
+	".gradle/",
+	".next/",
+	"*.pyc",
+	"*.class",
+	"*.o",
+	"*.log",
+	"coverage.out",
+	".DS_Store",
+}
+
+func artifactPatterns(cfg *Config) []string {
+	if len(cfg.Repo.ArtifactPatterns) > 0 {
+		return cfg.Repo.ArtifactPatterns
+	}
+	return defaultArtifactPatterns
+}
+
+// matchPath returns the first of patterns the path matches, or "" for
+// none; patterns are written like defaultArtifactPatterns
+func matchPath(path string, patterns []string) string {
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	for _, p := range patterns {
+		if dir, ok := strings.CutSuffix(p, "/"); ok {
+			dirs := segments[:len(segments)-1]
+			if root, ok := strings.CutPrefix(dir, "/"); ok {
+				if len(dirs) > 0 && dirs[0] == root {
+					return p
+				}
+				continue
+			}
+			for _, seg := range dirs {
+				if seg == dir {
+					return p
+				}
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(p, segments[len(segments)-1]); ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// FilterArtifacts splits changed paths into files to commit and artifacts to
+// leave out, and returns the distinct patterns that matched
+func FilterArtifacts(paths, patterns []string) (keep, artifacts, matched []string) {
+	seen := map[string]bool{}
+	for _, path := range paths {
+		p := matchPath(path, patterns)
+		if p == "" {
+			keep = append(keep, path)
+			continue
+		}
+		artifacts = append(artifacts, path)
+		if !seen[p] {
+			seen[p] = true
+			matched = append(matched, p)
+		}
+	}
+	return keep, artifacts, matched
+}
+
+// AmendGitignore appends patterns missing from the repo's .gitignore and
+// reports whether the file changed
+func (g *Git) AmendGitignore(patterns []string) (bool, error) {
+	path := filepath.Join(g.repoPath, ".gitignore")
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	existing := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+
+	var add []string
+	for _, p := range patterns {
+		// An unanchored pattern covers the root too
+		if !existing[p] && !existing["/"+p] && !existing[strings.TrimPrefix(p, "/")] {
+			add = append(add, p)
+		}
+	}
+	if len(add) == 0 {
+		return false, nil
+	}
+
+	content := string(data)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += "\n# Build artifacts (added by factory)\n" + strings.Join(add, "\n") + "\n"
+	return true, os.WriteFile(path, []byte(content), 0644)
+}
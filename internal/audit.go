@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// auditEntry is one externally visible action: a push, a PR, a change on a
+// Jira issue, a notification
+type auditEntry struct {
+	Time   string `json:"time"`
+	Action string `json:"action"` // git.push, github.pr.create, jira.comment, ...
+	Issue  string `json:"issue,omitempty"`
+	// Target is what was acted on: a branch, a PR, an issue, a page
+	Target string     `json:"target"`
+	Detail string     `json:"detail,omitempty"`
+	Error  string     `json:"error,omitempty"`
+	Actor  auditActor `json:"actor"`
+}
+
+// auditActor is who acted: the factory process, and the accounts and repo
+// its config acts as
+type auditActor struct {
+	Worker     string `json:"worker"` // host:pid
+	JiraSite   string `json:"jiraSite,omitempty"`
+	JiraUser   string `json:"jiraUser,omitempty"`
+	Repo       string `json:"repo,omitempty"`       // owner/repo
+	GitHubAuth string `json:"githubAuth,omitempty"` // token, app ID, or gh
+	GitEmail   string `json:"gitEmail,omitempty"`   // committer
+}
+
+// actorOf is the actor a config acts as; for Jira actions, pass the
+// issue's site config
+func actorOf(cfg *Config) auditActor {
+	auth := "token"
+	switch {
+	case cfg.GitHub.UseGHCLI:
+		auth = "gh"
+	case cfg.GitHub.App != nil:
+		auth = fmt.Sprintf("app %d", cfg.GitHub.App.AppID)
+	}
+	_, email := NewGit(cfg).identity()
+	return auditActor{
+		Worker:     leaseOwner(),
+		JiraSite:   cfg.Jira.BaseURL,
+		JiraUser:   cfg.Jira.Email,
+		Repo:       cfg.GitHub.Owner + "/" + cfg.GitHub.Repo,
+		GitHubAuth: auth,
+		GitEmail:   email,
+	}
+}
+
+// GetAuditPath is the audit log, which factory only ever appends to
+func GetAuditPath() string {
+	return filepath.Join(GetConfigDir(), "audit.jsonl")
+}
+
+// recordAudit appends an action to the audit log, with the error it failed
+// with, if any. A log that can't be written only warns.
+func recordAudit(actor auditActor, action, issueKey, target, detail string, err error) {
+	e := auditEntry{
+		Time:   time.Now().Format(time.RFC3339),
+		Action: action,
+		Issue:  issueKey,
+		Target: target,
+		Detail: redact(truncate(200, strings.SplitN(detail, "\n", 2)[0])),
+		Actor:  actor,
+	}
+	if err != nil {
+		e.Error = redact(err.Error())
+	}
+	data, _ := json.Marshal(e)
+	if werr := appendLine(GetAuditPath(), append(data, '\n')); werr != nil {
+		fmt.Printf("  Warning: could not write audit log: %v\n", werr)
+	}
+}
+
+// appendLine writes a line to a log such as the audit log in one write,
+// which O_APPEND keeps whole when several processes record at once
+func appendLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// AuditOptions filters `factory audit`
+type AuditOptions struct {
+	Issue  string // only actions on this issue
+	Action string // only this action, or a group of them like "jira"
+	Since  string // only actions in this window, e.g. "7d" or "12h"
+	Failed bool   // only actions that failed
+	JSON   bool
+}
+
+// readAudit reads the audit log, oldest first, keeping the entries that
+// match opts
+func readAudit(opts AuditOptions) ([]auditEntry, error) {
+	var cutoff time.Time
+	if opts.Since != "" {
+		d, err := parseSince(opts.Since)
+		if err != nil {
+			return nil, err
+		}
+		cutoff = time.Now().Add(-d)
+	}
+	entries := []auditEntry{}
+	f, err := os.Open(GetAuditPath())
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e auditEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if opts.Issue != "" && !strings.EqualFold(e.Issue, opts.Issue) {
+			continue
+		}
+		if opts.Action != "" && e.Action != opts.Action && !strings.HasPrefix(e.Action, opts.Action+".") {
+			continue
+		}
+		if opts.Failed && e.Error == "" {
+			continue
+		}
+		if !cutoff.IsZero() {
+			if t, err := time.Parse(time.RFC3339, e.Time); err != nil || t.Before(cutoff) {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// ShowAudit lists the audit log's actions that match opts, oldest first
+func ShowAudit(opts AuditOptions) error {
+	entries, err := readAudit(opts)
+	if err != nil {
+		return err
+	}
+	if opts.JSON {
+		return PrintJSON(entries)
+	}
+	if len(entries) == 0 {
+		fmt.Println("No matching actions")
+		return nil
+	}
+
+	fmt.Printf("%-15s %-20s %-12s %-40s %s\n", "When", "Action", "Issue", "Target", "Detail")
+	fmt.Println(strings.Repeat("-", 110))
+	for _, e := range entries {
+		detail := e.Detail
+		if e.Error != "" {
+			detail = "FAILED: " + e.Error
+		}
+		t, _ := time.Parse(time.RFC3339, e.Time)
+		fmt.Printf("%-15s %-20s %-12s %-40s %s\n", t.Format("Jan 02 15:04:05"), e.Action, orDash(e.Issue),
+			truncate(40, e.Target), truncate(60, detail))
+	}
+	fmt.Printf("\n%d action(s); actors and full details: factory audit --json\n", len(entries))
+	return nil
+}
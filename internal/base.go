@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+)
+
+// baseBranch picks the branch a run works against: the override from
+// `factory trigger --base`, the branch repo.baseBranches maps one of the
+// issue's fix versions to, or repo.defaultBranch
+func baseBranch(cfg *Config, issue *Issue, override string) string {
+	if override != "" {
+		return override
+	}
+	for _, version := range issue.FixVersions {
+		if branch, ok := cfg.Repo.BaseBranches[version]; ok {
+			return branch
+		}
+	}
+	// Then patterns such as "2.4.*", in a stable order
+	patterns := make([]string, 0, len(cfg.Repo.BaseBranches))
+	for p := range cfg.Repo.BaseBranches {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, version := range issue.FixVersions {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, version); ok {
+				return cfg.Repo.BaseBranches[p]
+			}
+		}
+	}
+	return cfg.Repo.DefaultBranch
+}
+
+// withBase returns cfg with repo.defaultBranch set to base, so the run
+// branches from, diffs against, and opens its PR into base
+func withBase(cfg *Config, base string) *Config {
+	if base == cfg.Repo.DefaultBranch {
+		return cfg
+	}
+	c := *cfg
+	c.Repo.defaultBranch = cfg.Repo.DefaultBranch
+	c.Repo.DefaultBranch = base
+	return &c
+}
+
+// ValidateBaseBranch rejects a --base that isn't a valid branch name
+func ValidateBaseBranch(name string) error {
+	if err := exec.Command("git", "check-ref-format", "--branch", name).Run(); err != nil {
+		return fmt.Errorf("invalid base branch %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// budgetAlerted is the cap and period last alerted about, so a hit cap is
+// reported once rather than on every poll
+var budgetAlerted string
+
+// spendEntry is one run's agent cost in the spend ledger
+type spendEntry struct {
+	Time    string  `json:"time"`
+	Issue   string  `json:"issue"`
+	CostUSD float64 `json:"costUsd"`
+}
+
+// GetSpendPath is the spend ledger, which factory only ever appends to.
+// processed.json keeps only an issue's last run, so a reprocessed issue's
+// earlier spend is counted from here.
+func GetSpendPath() string {
+	return filepath.Join(GetConfigDir(), "spend.jsonl")
+}
+
+// recordSpend appends a run's agent cost to the spend ledger
+func recordSpend(result *Result) {
+	if result.CostUSD <= 0 {
+		return
+	}
+	data, _ := json.Marshal(spendEntry{Time: time.Now().Format(time.RFC3339), Issue: result.IssueKey, CostUSD: result.CostUSD})
+	if err := appendLine(GetSpendPath(), append(data, '\n')); err != nil {
+		fmt.Printf("  Warning: could not record spend: %v\n", err)
+	}
+}
+
+// spentSince sums the agent cost of runs finished at or after t. Before
+// there is a ledger, it falls back to the costs in the loaded processed
+// state.
+func spentSince(t time.Time) float64 {
+	total := 0.0
+	f, err := os.Open(GetSpendPath())
+	if os.IsNotExist(err) {
+		for _, info := range processedSnapshot() {
+			if at, err := time.Parse(time.RFC3339, info.ProcessedAt); err == nil && !at.Before(t) {
+				total += info.CostUSD
+			}
+		}
+		return total
+	}
+	if err != nil {
+		fmt.Printf("  Warning: could not read the spend ledger: %v\n", err)
+		return total
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e spendEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, e.Time); err == nil && !at.Before(t) {
+			total += e.CostUSD
+		}
+	}
+	return total
+}
+
+// startOfDay and startOfWeek are in local time; weeks start on Monday
+func startOfDay(now time.Time) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+}
+
+func startOfWeek(now time.Time) time.Time {
+	day := startOfDay(now)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// budgetExhausted returns why no new issue may start, or "" while spend is
+// under the daily and weekly caps
+func budgetExhausted(cfg *Config, now time.Time) (reason, period string) {
+	caps := []struct {
+		name  string
+		limit float64
+		since time.Time
+	}{
+		{"daily", cfg.Budget.DailyUSD, startOfDay(now)},
+		{"weekly", cfg.Budget.WeeklyUSD, startOfWeek(now)},
+	}
+	for _, c := range caps {
+		if c.limit <= 0 {
+			continue
+		}
+		if spent := spentSince(c.since); spent >= c.limit {
+			return fmt.Sprintf("%s budget of $%.2f reached ($%.2f spent since %s); not starting new issues",
+				c.name, c.limit, spent, c.since.Format("Mon Jan 02")), c.name + ":" + c.since.Format("2006-01-02")
+		}
+	}
+	return "", ""
+}
+
+// checkBudget reports whether new issues may start, alerting once per
+// period when a cap is hit
+func checkBudget(cfg *Config) bool {
+	reason, period := budgetExhausted(cfg, time.Now())
+	if reason == "" {
+		return true
+	}
+	fmt.Printf("Budget: %s\n", reason)
+	if period != budgetAlerted {
+		budgetAlerted = period
+		notify(cfg, reason)
+	}
+	return false
+}
+
+// CheckBudget returns an error once the daily or weekly cap is hit, for runs
+// started outside the daemon's queue
+func CheckBudget(cfg *Config) error {
+	loadProcessed()
+	if reason, _ := budgetExhausted(cfg, time.Now()); reason != "" {
+		return fmt.Errorf("%s", reason)
+	}
+	return nil
+}
+
+// issueBudgetErr stops retries and escalation once a run has spent its
+// budget.perIssueUsd
+func issueBudgetErr(cfg *Config, result *Result) error {
+	if limit := cfg.Budget.PerIssueUSD; limit > 0 && result.CostUSD >= limit {
+		return fmt.Errorf("per-issue budget of $%.2f reached ($%.2f spent)", limit, result.CostUSD)
+	}
+	return nil
+}
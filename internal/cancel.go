@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// errCancelled is the error of an agent run stopped by `factory cancel`
+var errCancelled = errors.New("cancelled")
+
+const (
+	// cancelPollInterval is how often a run checks whether it was cancelled
+	cancelPollInterval = 2 * time.Second
+	// maxCancelWait bounds how long `factory cancel` waits for the run to stop
+	maxCancelWait = time.Minute
+)
+
+// cancelPath is the marker `factory cancel` leaves next to the issue's
+// lease. The worker holding the lease, on this host or any other sharing
+// the lease dir, stops the run when it sees it.
+func cancelPath(cfg *Config, issueKey string) string {
+	return filepath.Join(leaseDir(cfg), issueKey+".cancel")
+}
+
+// watchCancel closes the lease's cancel channel once the issue's cancel
+// marker appears or the daemon interrupts its run, until the lease is
+// released
+func (h *leaseHandle) watchCancel(path string) {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-control.interrupt:
+			h.interrupted.Store(true)
+			h.stopRun()
+			return
+		case <-ticker.C:
+			if _, err := os.Stat(path); err == nil {
+				os.Remove(path)
+				h.stopRun()
+				return
+			}
+		}
+	}
+}
+
+// cancelled reports whether the run holding the lease was cancelled
+func (h *leaseHandle) cancelled() bool {
+	select {
+	case <-h.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestCancel stops the issue: a running issue is told to stop through its
+// cancel marker, and a queued one is taken off the queue and marked
+// cancelled. It reports whether the issue was running.
+func requestCancel(cfg *Config, issueKey string) (bool, error) {
+	if hasLease(cfg, issueKey) {
+		if err := os.WriteFile(cancelPath(cfg, issueKey), []byte(time.Now().Format(time.RFC3339)), 0644); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	queue := loadQueue()
+	i := queueIndex(queue, issueKey)
+	if i < 0 {
+		return false, fmt.Errorf("%s is not running or queued", issueKey)
+	}
+	saveQueue(append(queue[:i], queue[i+1:]...))
+	RecordResult(&Result{IssueKey: issueKey, Status: "cancelled", Error: "cancelled while queued"})
+	return false, nil
+}
+
+// handleCancel cancels an issue's run, or takes it off the queue
+func handleCancel(w http.ResponseWriter, r *http.Request) {
+	key, ok := issueParam(w, r)
+	if !ok {
+		return
+	}
+	running, err := requestCancel(control.config(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if running {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CancelIssue stops an issue's run, through the daemon if one is running,
+// and waits for the run to clean up: its agent is stopped, its changes and
+// branch are discarded, and it is recorded as cancelled. A queued issue is
+// taken off the queue instead.
+func CancelIssue(cfg *Config, issueKey string) error {
+	running := false
+	_, err := callDaemon("POST", "/api/cancel?issue="+url.QueryEscape(issueKey))
+	switch {
+	case err == nil:
+		// The daemon only leaves a marker for a run, which still holds its lease
+		running = hasLease(cfg, issueKey)
+	case IsNoDaemon(err):
+		if running, err = requestCancel(cfg, issueKey); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	if !running {
+		fmt.Printf("Cancelled %s; it was queued and won't be picked up again until `factory clear %s`\n", issueKey, issueKey)
+		return nil
+	}
+
+	fmt.Printf("Cancelling %s...\n", issueKey)
+	deadline := time.Now().Add(maxCancelWait)
+	for hasLease(cfg, issueKey) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is still running after %s; check `factory status`", issueKey, maxCancelWait)
+		}
+		time.Sleep(time.Second)
+	}
+	if info := readProcessed()[issueKey]; info.Status != "cancelled" {
+		fmt.Printf("%s finished before it could be cancelled (%s)\n", issueKey, orDash(info.Status))
+		return nil
+	}
+	fmt.Printf("Cancelled %s; its changes and branch were discarded\n", issueKey)
+	return nil
+}
+
+// hasLease reports whether a worker holds the issue's lease
+func hasLease(cfg *Config, issueKey string) bool {
+	for _, l := range activeLeases(cfg) {
+		if l.IssueKey == issueKey {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelRun ends a cancelled run: the agent's changes are reverted, the
+// workspace goes back to the default branch, and the issue's branch is
+// deleted unless it was already pushed. A run whose lease was taken over
+// is skipped, leaving the result to the new owner. A run interrupted by the
+// daemon stopping, or by losing its repo slot, is recorded as interrupted
+// instead, and its workspace is left as it is when its agent session can be
+// resumed.
+func cancelRun(git *Git, changed []string, result *Result, lease *leaseHandle) *Result {
+	markCancelled(result, lease)
+	if result.Status == "interrupted" {
+		if loadAgentSession(result.IssueKey) != nil {
+			fmt.Printf("\n✗ Interrupted: %s (its agent session resumes on the next run)\n", result.IssueKey)
+			return result
+		}
+	}
+
+	fmt.Println("→ Discarding the run's changes...")
+	if err := git.Discard(changed); err != nil {
+		fmt.Printf("  Warning: could not discard changes: %v\n", err)
+	}
+	if result.branch != "" {
+		if err := git.DropBranch(result.branch); err != nil {
+			fmt.Printf("  Warning: could not remove branch %s: %v\n", result.branch, err)
+		}
+	}
+	clearAgentSession(result.IssueKey)
+	switch result.Status {
+	case "interrupted":
+		fmt.Printf("\n✗ Interrupted: %s\n", result.IssueKey)
+	case "skipped":
+		fmt.Printf("\n✗ Stopped: %s (%s)\n", result.IssueKey, result.Error)
+	default:
+		fmt.Printf("\n✗ Cancelled: %s\n", result.IssueKey)
+	}
+	return result
+}
+
+// markCancelled records why the run holding the lease stopped
+func markCancelled(result *Result, lease *leaseHandle) {
+	result.Status, result.Stage = "cancelled", result.stage
+	result.Error = "cancelled during " + orDash(result.stage)
+	if owner, _ := lease.takenOverBy.Load().(string); owner != "" {
+		// The new owner records the issue's result
+		result.Status = "skipped"
+		result.Error = "lease taken over by " + owner + " during " + orDash(result.stage)
+	}
+	if owner, _ := lease.slotTakenBy.Load().(string); owner != "" {
+		// The issue is still ours, and goes back on the queue
+		result.Status = "interrupted"
+		result.Error = "repo slot taken over by " + owner + " during " + orDash(result.stage)
+	}
+	if lease.interrupted.Load() {
+		result.Status = "interrupted"
+		result.Error = "interrupted during " + orDash(result.stage) + " by the daemon stopping"
+	}
+}
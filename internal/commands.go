@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCommandMention starts a Jira comment that is a command to factory
+const defaultCommandMention = "@factory"
+
+// CommandConfig lets people re-run an issue from a Jira comment starting
+// with "@factory", such as "@factory retry" or "@factory implement with
+// approach X". The rest of the comment is added to the prompt.
+type CommandConfig struct {
+	Enabled bool `json:"enabled"`
+	// Mention starts a command, default "@factory"; set it to the bot
+	// account's name, e.g. "@Factory Bot", to command it with @-mentions
+	Mention string `json:"mention,omitempty"`
+	// Authors limits commands to comments by these display names; empty
+	// accepts them from the issue's assignee and reporter
+	Authors []string `json:"authors,omitempty"`
+}
+
+// commentCommand is an "@factory" comment asking for a new run
+type commentCommand struct {
+	Author string
+	// Retry picks up from the stage the last run failed at, where possible
+	Retry bool
+	// Request is the comment without the mention (and, for a retry,
+	// without "retry"), for the prompt
+	Request string
+}
+
+// parseCommand reads the command in a comment, reporting false for an
+// ordinary comment
+func parseCommand(mention string, c Comment) (commentCommand, bool) {
+	body := strings.TrimSpace(c.Body)
+	if len(body) < len(mention) || !strings.EqualFold(body[:len(mention)], mention) {
+		return commentCommand{}, false
+	}
+	cmd := commentCommand{Author: c.Author, Request: strings.TrimSpace(body[len(mention):])}
+	if fields := strings.Fields(cmd.Request); len(fields) > 0 && strings.EqualFold(strings.TrimRight(fields[0], ".,:!"), "retry") {
+		cmd.Retry = true
+		cmd.Request = strings.TrimSpace(cmd.Request[len(fields[0]):])
+	}
+	return cmd, true
+}
+
+func (c CommandConfig) mention() string {
+	if c.Mention == "" {
+		return defaultCommandMention
+	}
+	return c.Mention
+}
+
+// allows reports whether the author may send commands on the issue
+func (c CommandConfig) allows(issue *Issue, author string) bool {
+	authors := c.Authors
+	if len(authors) == 0 {
+		authors = []string{issue.Assignee, issue.Reporter}
+	}
+	for _, a := range authors {
+		if a != "" && strings.EqualFold(a, author) {
+			return true
+		}
+	}
+	return false
+}
+
+// pollCommands looks for new "@factory" comments on assigned issues factory
+// has run before, and queues a run for each issue that got one. A command
+// counts when it was posted after the issue's last run started; the run it
+// queues moves that point past it. It returns the issues queued.
+func pollCommands(cfg *Config, issues []Issue) []string {
+	if !cfg.Jira.Commands.Enabled {
+		return nil
+	}
+	queue := loadQueue()
+	var queued []string
+	for _, issue := range issues {
+		info, ok := processedEntry(issue.Key)
+		if !ok || queueIndex(queue, issue.Key) >= 0 || hasLease(cfg, issue.Key) {
+			continue
+		}
+		ranAt, err := time.Parse(time.RFC3339, info.ProcessedAt)
+		if err != nil {
+			continue
+		}
+		since := ranAt.Add(-runDuration(info.Stages))
+
+		comments, err := GetComments(cfg, issue.Key)
+		if err != nil {
+			fmt.Printf("  Warning: could not check %s for commands: %v\n", issue.Key, err)
+			continue
+		}
+		if len(cfg.Jira.Commands.Authors) == 0 && issue.Assignee == "" && issue.Reporter == "" {
+			// jira.useAcli lists issues without their people
+			if full, err := GetIssue(cfg, issue.Key); err == nil {
+				issue.Assignee, issue.Reporter = full.Assignee, full.Reporter
+			}
+		}
+		var cmd *commentCommand
+		for _, c := range comments {
+			if !c.created.After(since) || !cfg.Jira.Commands.allows(&issue, c.Author) {
+				continue
+			}
+			// "@factory approve" on a plan is for pollPlans
+			if info.Status == "planned" && isApproval(cfg.Jira.Commands.mention(), c.Body) {
+				continue
+			}
+			if parsed, ok := parseCommand(cfg.Jira.Commands.mention(), c); ok {
+				cmd = &parsed // the latest wins
+			}
+		}
+		if cmd == nil {
+			continue
+		}
+
+		fmt.Printf("%s asked for a new run of %s: %s\n", cmd.Author, issue.Key, cmd.Request)
+		if cmd.Retry && info.Status == "failed" {
+			requestRetry(issue.Key)
+		}
+		requeueRun(issue, info, cmd.Request)
+		queued = append(queued, issue.Key)
+		if err := AddComment(cfg, issue.Key, fmt.Sprintf("factory: queued a new run, as %s asked", cmd.Author)); err != nil {
+			fmt.Printf("  Warning: could not acknowledge the command: %v\n", err)
+		}
+	}
+	return queued
+}
+
+// maxWebhookBody bounds a Jira webhook request body
+const maxWebhookBody = 1 << 20
+
+// handleJiraWebhook receives Jira's comment_created webhook, so a command
+// is picked up right away rather than on the next poll. The poll it wakes
+// checks the comment like any other, so a comment that isn't a command, or
+// is from someone who may not send one, does nothing.
+func handleJiraWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := control.config()
+	if !cfg.Jira.Commands.Enabled {
+		http.Error(w, "jira.commands is not enabled", http.StatusNotFound)
+		return
+	}
+	var event struct {
+		WebhookEvent string `json:"webhookEvent"`
+		Issue        struct {
+			Key string `json:"key"`
+		} `json:"issue"`
+		Comment struct {
+			Body   json.RawMessage `json:"body"`
+			Author jiraUser        `json:"author"`
+		} `json:"comment"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBody)).Decode(&event); err != nil {
+		http.Error(w, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+	c := Comment{Author: event.Comment.Author.DisplayName, Body: jiraText(event.Comment.Body)}
+	if !issueKeyPattern.MatchString(event.Issue.Key) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if _, ok := parseCommand(cfg.Jira.Commands.mention(), c); !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	fmt.Printf("Jira webhook: %s commented on %s; polling\n", c.Author, event.Issue.Key)
+	wakeDaemon()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// formatRequest renders the prompt section for an "@factory" comment
+func formatRequest(request string) string {
+	if request == "" {
+		return ""
+	}
+	return "\n## Requested in Jira\nThis run was requested with a comment on the issue; follow it:\n> " +
+		strings.ReplaceAll(request, "\n", "\n> ") + "\n"
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// factoryComment is the one comment factory keeps per issue when
+// jira.comments.consolidate is on
+type factoryComment struct {
+	ID   string `json:"id"`
+	Body string `json:"body"`
+}
+
+func GetCommentsPath() string {
+	return filepath.Join(GetConfigDir(), "comments.json")
+}
+
+func loadFactoryComments() map[string]factoryComment {
+	comments := make(map[string]factoryComment)
+	if data, err := os.ReadFile(GetCommentsPath()); err == nil {
+		json.Unmarshal(data, &comments)
+	}
+	return comments
+}
+
+func saveFactoryComments(comments map[string]factoryComment) {
+	data, _ := json.MarshalIndent(comments, "", "  ")
+	os.WriteFile(GetCommentsPath(), data, 0644)
+}
+
+// addConsolidatedComment appends an update to the issue's factory comment,
+// posting a new one the first time or if the old one was deleted
+func addConsolidatedComment(cfg *Config, issueKey, comment string) error {
+	comments := loadFactoryComments()
+	if prev, ok := comments[issueKey]; ok {
+		body := prev.Body + "\n\n---\n\n" + comment
+		err := UpdateCommentREST(cfg, issueKey, prev.ID, body)
+		if err == nil {
+			comments[issueKey] = factoryComment{ID: prev.ID, Body: body}
+			saveFactoryComments(comments)
+			return nil
+		}
+		fmt.Printf("  Warning: could not update comment %s, posting a new one: %v\n", prev.ID, err)
+	}
+
+	id, err := AddCommentREST(cfg, issueKey, comment)
+	if err != nil {
+		return err
+	}
+	comments[issueKey] = factoryComment{ID: id, Body: comment}
+	saveFactoryComments(comments)
+	return nil
+}
@@ -5,22 +5,334 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Config struct {
-	Jira   JiraConfig   `json:"jira"`
-	GitHub GitHubConfig `json:"github"`
-	Repo   RepoConfig   `json:"repo"`
-	Poll   PollConfig   `json:"poll"`
+	Jira        JiraConfig        `json:"jira"`
+	GitHub      GitHubConfig      `json:"github"`
+	Repo        RepoConfig        `json:"repo"`
+	Poll        PollConfig        `json:"poll"`
+	Transitions TransitionMapping `json:"transitions"`
+	Templates   TemplateConfig    `json:"templates"`
+	Server      ServerConfig      `json:"server"`
+	Lease       LeaseConfig       `json:"lease"`
+	Report      ReportConfig      `json:"report"`
+	Hooks       HookConfig        `json:"hooks"`
+	Agent       AgentConfig       `json:"agent"`
+	Verify      VerifyConfig      `json:"verify"`
+	Packets     PacketConfig      `json:"packets"`
+	Budget      BudgetConfig      `json:"budget"`
+	Notify      NotifyConfig      `json:"notify"`
+	GC          GCConfig          `json:"gc"`
+	Plan        PlanConfig        `json:"plan"`
+	Estimate    EstimateConfig    `json:"estimate"`
+	Triage      TriageConfig      `json:"triage"`
+	TestsOnly   TestsOnlyConfig   `json:"testsOnly"`
+	TDD         TDDConfig         `json:"tdd"`
+	SelfReview  SelfReviewConfig  `json:"selfReview"`
+	Scan        ScanConfig        `json:"scan"`
+	Review      ReviewConfig      `json:"review"`
+}
+
+// BudgetConfig caps agent spend in USD. Zero means no limit.
+type BudgetConfig struct {
+	PerIssueUSD float64 `json:"perIssueUsd,omitempty"` // no retries or escalation past this
+	DailyUSD    float64 `json:"dailyUsd,omitempty"`    // since local midnight
+	WeeklyUSD   float64 `json:"weeklyUsd,omitempty"`   // since Monday, local time
+}
+
+// NotifyConfig sends operator alerts, such as a hit spending cap, to a
+// chat webhook
+type NotifyConfig struct {
+	// WebhookURL receives {"text": "..."}, which Slack, Mattermost, and
+	// Google Chat incoming webhooks accept
+	WebhookURL string `json:"webhookUrl,omitempty"`
+}
+
+// PacketConfig turns on review-packet mode for air-gapped review: runs
+// bundle their change instead of pushing it, and `factory apply-packet`
+// pushes an approved packet later.
+type PacketConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Dir     string `json:"dir,omitempty"` // default ~/.factory/packets
+}
+
+// VerifyConfig runs the repo's build, lint, and tests on the agent's
+// changes before a PR is opened. Failing output goes back to the agent until
+// every check passes or MaxAttempts runs are used up.
+type VerifyConfig struct {
+	BuildCommand string `json:"buildCommand,omitempty"` // "auto" detects it from the project files
+	LintCommand  string `json:"lintCommand,omitempty"`  // "auto" detects it from the project files
+	TestCommand  string `json:"testCommand,omitempty"`  // e.g. "go test ./..."
+	MaxAttempts  int    `json:"maxAttempts,omitempty"`  // agent runs per model, default 3
+}
+
+// AgentConfig picks the models Claude Code runs with. Models is an
+// escalation chain, cheapest first: a run where a model fails or changes
+// nothing is retried once with the next one.
+type AgentConfig struct {
+	Models []string `json:"models,omitempty"` // e.g. ["haiku", "sonnet", "opus"]
+
+	// TimeoutMinutes bounds one agent run, default 10. The per-type and
+	// per-priority overrides are keyed by Jira name, e.g. {"Story": 45};
+	// the issue type's override wins over its priority's.
+	TimeoutMinutes           float64            `json:"timeoutMinutes,omitempty"`
+	TimeoutMinutesByType     map[string]float64 `json:"timeoutMinutesByType,omitempty"`
+	TimeoutMinutesByPriority map[string]float64 `json:"timeoutMinutesByPriority,omitempty"`
+
+	// Resume continues an interrupted Claude Code session (timeout, daemon
+	// restart) in the same workspace instead of starting over. A timed-out
+	// run is resumed up to MaxResumes times, default 1.
+	Resume     bool `json:"resume,omitempty"`
+	MaxResumes int  `json:"maxResumes,omitempty"`
+}
+
+// restAPI returns the path of the Jira REST API, e.g. "/rest/api/3"
+func (j JiraConfig) restAPI() string {
+	if j.serverAPI() {
+		return "/rest/api/2"
+	}
+	return "/rest/api/3"
+}
+
+// serverAPI reports whether Jira is reached through REST API v2, as for
+// Jira Server and Data Center
+func (j JiraConfig) serverAPI() bool {
+	return j.APIVersion == "2"
+}
+
+// apiURL returns the GitHub REST API root without a trailing slash
+func (g GitHubConfig) apiURL() string {
+	if g.APIURL == "" {
+		return "https://api.github.com"
+	}
+	return strings.TrimSuffix(g.APIURL, "/")
+}
+
+// defaultAgentTimeout bounds an agent run when agent.timeoutMinutes is unset
+const defaultAgentTimeout = 10 * time.Minute
+
+// timeout returns how long the agent may run on the issue
+func (a AgentConfig) timeout(issue *Issue) time.Duration {
+	for _, byName := range []struct {
+		overrides map[string]float64
+		name      string
+	}{
+		{a.TimeoutMinutesByType, issue.Type},
+		{a.TimeoutMinutesByPriority, issue.Priority},
+	} {
+		for name, minutes := range byName.overrides {
+			if strings.EqualFold(name, byName.name) && minutes > 0 {
+				return time.Duration(minutes * float64(time.Minute))
+			}
+		}
+	}
+	if a.TimeoutMinutes > 0 {
+		return time.Duration(a.TimeoutMinutes * float64(time.Minute))
+	}
+	return defaultAgentTimeout
+}
+
+// models returns the escalation chain; a single "" uses Claude Code's
+// default model
+func (a AgentConfig) models() []string {
+	if len(a.Models) == 0 {
+		return []string{""}
+	}
+	return a.Models
+}
+
+// HookConfig holds shell commands run at points in the pipeline, with the
+// issue's details in FACTORY_* environment variables. A failing hook fails
+// the run, except postPR, which only warns.
+type HookConfig struct {
+	PreClone  string `json:"preClone,omitempty"`  // before the repo is cloned or updated
+	PreAgent  string `json:"preAgent,omitempty"`  // before Claude Code runs, e.g. install deps
+	PostAgent string `json:"postAgent,omitempty"` // after Claude Code, e.g. codegen or formatting
+	PrePush   string `json:"prePush,omitempty"`   // before committing and pushing, e.g. policy checks
+	PostPR    string `json:"postPR,omitempty"`    // after the PR is opened
+}
+
+func (h HookConfig) command(name string) string {
+	switch name {
+	case HookPreClone:
+		return h.PreClone
+	case HookPreAgent:
+		return h.PreAgent
+	case HookPostAgent:
+		return h.PostAgent
+	case HookPrePush:
+		return h.PrePush
+	case HookPostPR:
+		return h.PostPR
+	}
+	return ""
+}
+
+// ReportConfig is where the daemon publishes its weekly digest. Confluence
+// pages are created on the Jira site's /wiki with the Jira credentials.
+type ReportConfig struct {
+	JiraIssue        string `json:"jiraIssue,omitempty"`        // comment on this issue
+	ConfluenceSpace  string `json:"confluenceSpace,omitempty"`  // create a page in this space
+	ConfluenceParent string `json:"confluenceParent,omitempty"` // under this page ID
+}
+
+// LeaseConfig controls per-issue leases. Point Dir at a shared filesystem
+// to coordinate several daemons working the same queue.
+type LeaseConfig struct {
+	Dir        string `json:"dir,omitempty"`        // default ~/.factory/leases
+	TTLSeconds int    `json:"ttlSeconds,omitempty"` // default 300
+}
+
+// ServerConfig enables the daemon's HTTP API, e.g. "127.0.0.1:7777".
+// Listen on a non-loopback address to let teammates watch runs remotely.
+type ServerConfig struct {
+	Listen string `json:"listen,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// TemplateConfig holds paths to Go text/template files overriding the
+// built-in prompt, PR, commit, and comment text. Relative paths resolve
+// against ~/.factory.
+type TemplateConfig struct {
+	Prompt  string `json:"prompt,omitempty"`
+	PRTitle string `json:"prTitle,omitempty"`
+	PRBody  string `json:"prBody,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Comment string `json:"comment,omitempty"`
+	// CommitTypes maps Jira issue types to conventional-commit types,
+	// overriding defaultCommitTypes, e.g. {"Spike": "chore"}
+	CommitTypes map[string]string `json:"commitTypes,omitempty"`
+	// PromptVariants splits runs between prompt templates to compare them
+	// in `factory report`
+	PromptVariants []PromptVariant `json:"promptVariants,omitempty"`
+}
+
+// PromptVariant is one arm of a prompt experiment. An empty Template uses
+// templates.prompt or the built-in prompt, as a control.
+type PromptVariant struct {
+	Name     string `json:"name"`
+	Template string `json:"template,omitempty"`
+	Weight   int    `json:"weight,omitempty"` // share of runs, default 1
+}
+
+func (t TemplateConfig) path(name string) string {
+	switch name {
+	case TemplatePrompt:
+		return t.Prompt
+	case TemplatePRTitle:
+		return t.PRTitle
+	case TemplatePRBody:
+		return t.PRBody
+	case TemplateCommit:
+		return t.Commit
+	case TemplateComment:
+		return t.Comment
+	}
+	return ""
 }
 
 type JiraConfig struct {
-	BaseURL  string `json:"baseUrl"`
-	Email    string `json:"email"`
-	APIToken string `json:"apiToken"`
-	UseACLI  bool   `json:"useAcli"`
+	BaseURL  string        `json:"baseUrl"`
+	Email    string        `json:"email"`
+	APIToken string        `json:"apiToken"`
+	UseACLI  bool          `json:"useAcli"`
+	Fields   FieldMapping  `json:"fields"`
+	Comments CommentConfig `json:"comments"`
+	// APIVersion is the REST API version: "3" (default) for Jira Cloud, "2"
+	// for Jira Server and Data Center, which take plain-text bodies
+	APIVersion string `json:"apiVersion,omitempty"`
+	// PersonalAccessToken is sent as a bearer token instead of Email and
+	// APIToken, as Jira Server and Data Center issue them
+	PersonalAccessToken string `json:"personalAccessToken,omitempty"`
+	// SmartCommit adds Jira smart-commit commands to factory's commits
+	SmartCommit SmartCommitConfig `json:"smartCommit"`
+	// RemoteLink links the PR from the issue via the remote-link API
+	RemoteLink bool `json:"remoteLink"`
+	// OAuth signs in with an Atlassian OAuth 2.0 app instead of Email and
+	// APIToken; REST only
+	OAuth *JiraOAuthConfig `json:"oauth,omitempty"`
+	// Sites are further Jira sites, each serving the issues of its
+	// projects; the daemon polls them all
+	Sites []JiraSite `json:"sites,omitempty"`
+	// Commands re-run issues from "@factory" comments
+	Commands CommandConfig `json:"commands"`
+	// Reprocess re-runs processed issues that are reopened or rewritten
+	Reprocess ReprocessConfig `json:"reprocess"`
+
+	// projects are the projects a site's copy of the config serves
+	// (see siteConfig)
+	projects []string
+}
+
+// SmartCommitConfig holds the smart-commit commands Jira's GitHub
+// integration runs when it sees factory's commit
+type SmartCommitConfig struct {
+	Comment string `json:"comment,omitempty"` // #comment text
+	Time    string `json:"time,omitempty"`    // #time spent, e.g. "1h 30m"
+}
+
+// commands renders the smart-commit command string, e.g.
+// "#comment Implemented by factory #time 1h"
+func (s SmartCommitConfig) commands() string {
+	var cmds []string
+	if s.Comment != "" {
+		cmds = append(cmds, "#comment "+s.Comment)
+	}
+	if s.Time != "" {
+		cmds = append(cmds, "#time "+s.Time)
+	}
+	return strings.Join(cmds, " ")
+}
+
+// CommentConfig keeps factory's comments from flooding watchers. Only the
+// REST client supports it.
+type CommentConfig struct {
+	// Consolidate keeps one factory comment per issue and edits it with
+	// each update, without notifying watchers
+	Consolidate bool `json:"consolidate"`
+	// Visibility restricts comments to a Jira group or project role
+	Visibility *CommentVisibility `json:"visibility,omitempty"`
+}
+
+type CommentVisibility struct {
+	Type  string `json:"type"` // "group" or "role"
+	Value string `json:"value"`
+}
+
+// FieldMapping maps Jira custom field IDs (e.g. customfield_10042) onto issue
+// data. Only the REST client reads custom fields.
+type FieldMapping struct {
+	AcceptanceCriteria string `json:"acceptanceCriteria,omitempty"`
+	StoryPoints        string `json:"storyPoints,omitempty"`
+	// PRURL is a URL/text field that receives the PR link once it exists
+	PRURL string `json:"prUrl,omitempty"`
+	// Variables are extra named fields included in the prompt
+	Variables map[string]string `json:"variables,omitempty"`
+	// Number fields that receive a run's actual effort once its PR is open,
+	// for comparison with the estimate
+	AgentMinutes string `json:"agentMinutes,omitempty"` // agent and verification wall time
+	FilesChanged string `json:"filesChanged,omitempty"`
+	LinesChanged string `json:"linesChanged,omitempty"` // added plus deleted
+}
+
+// customFieldIDs returns every custom field ID referenced by the mapping
+func (m FieldMapping) customFieldIDs() []string {
+	var ids []string
+	for _, id := range []string{m.AcceptanceCriteria, m.StoryPoints} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range m.Variables {
+		ids = append(ids, id)
+	}
+	return ids
 }
 
 type GitHubConfig struct {
@@ -28,26 +340,197 @@ type GitHubConfig struct {
 	Owner    string `json:"owner"`
 	Repo     string `json:"repo"`
 	UseGHCLI bool   `json:"useGhCli"`
+	// App authenticates as a GitHub App installation instead of with Token
+	App *GitHubAppConfig `json:"app,omitempty"`
+	// APIURL is the REST API root, default https://api.github.com; for
+	// GitHub Enterprise Server use https://HOST/api/v3
+	APIURL string `json:"apiUrl,omitempty"`
+	// DraftPR opens PRs as drafts; ReadyWhen ("checks" or "approval")
+	// lets the daemon mark them ready, otherwise use `factory ready KEY`
+	DraftPR   bool   `json:"draftPR"`
+	ReadyWhen string `json:"readyWhen,omitempty"`
+	// Reviewers are users or "org/team" slugs asked to review every PR;
+	// UseCodeowners adds the CODEOWNERS of the changed files
+	Reviewers     []string `json:"reviewers,omitempty"`
+	UseCodeowners bool     `json:"useCodeowners"`
+	// Labels are applied to every PR along with the issue type; nil means
+	// ["automated"]
+	Labels []string `json:"labels,omitempty"`
 }
 
 type RepoConfig struct {
 	CloneURL      string `json:"cloneUrl"`
 	LocalPath     string `json:"localPath"`
 	DefaultBranch string `json:"defaultBranch"`
+	// BranchPattern is a Go template for feature branch names, rendered
+	// against BranchData; defaults to defaultBranchPattern
+	BranchPattern string `json:"branchPattern,omitempty"`
+	// BaseBranches maps Jira fix versions, or patterns such as "2.4.*", to
+	// the branch their issues are implemented against instead of
+	// DefaultBranch, e.g. {"2.4.1": "release/2.4"}
+	BaseBranches map[string]string `json:"baseBranches,omitempty"`
+	// defaultBranch keeps DefaultBranch when a run works against another
+	// base (see withBase)
+	defaultBranch string
+	// StashDirty allows factory to stash uncommitted workspace changes
+	// instead of refusing to run
+	StashDirty bool `json:"stashDirty"`
+	// SyncBase brings a run's branch up to date with its base branch on
+	// origin before pushing: "rebase" (default), "merge", or "off"
+	SyncBase string `json:"syncBase,omitempty"`
+	// ResolveConflicts has the agent resolve conflicts from syncing with
+	// the base branch, instead of pushing the branch as it was
+	ResolveConflicts bool `json:"resolveConflicts,omitempty"`
+	// ArtifactPatterns lists build outputs never to commit; defaults to
+	// defaultArtifactPatterns when empty
+	ArtifactPatterns []string `json:"artifactPatterns,omitempty"`
+	// AmendGitignore adds matched artifact patterns to .gitignore in the PR
+	AmendGitignore bool `json:"amendGitignore"`
+	// AllowedHosts are extra hosts the agent may reach with curl or wget,
+	// on top of defaultAllowedHosts
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	// SSHKey is the private key for git@ / ssh:// clone URLs; KnownHosts
+	// overrides ~/.ssh/known_hosts, which must hold the remote's host key
+	SSHKey     string `json:"sshKey,omitempty"`
+	KnownHosts string `json:"knownHosts,omitempty"`
+	// CloneDepth and Filter (e.g. "blob:none") speed up cloning large
+	// repos; history is fetched when the agent reads it
+	CloneDepth int    `json:"cloneDepth,omitempty"`
+	Filter     string `json:"filter,omitempty"`
+	// ComponentPaths maps Jira components to the subtrees their issues may
+	// change, e.g. {"payments": "services/payments"}; SparseCheckout also
+	// limits the working tree to them
+	ComponentPaths map[string]string `json:"componentPaths,omitempty"`
+	SparseCheckout bool              `json:"sparseCheckout"`
+	// MaxConcurrent caps how many runs may work on this repo at once across
+	// all workers sharing the lease dir; 1 serializes them, 0 is unlimited
+	MaxConcurrent int `json:"maxConcurrent,omitempty"`
+	// Signing signs factory's commits with a GPG, SSH, or X.509 key
+	Signing SigningConfig `json:"signing"`
+	// MCPServers are passed to Claude Code via --mcp-config, keyed by name
+	MCPServers map[string]MCPServer `json:"mcpServers,omitempty"`
+}
+
+// SigningConfig signs commits for branch protection that requires it. Key is
+// a GPG key ID, or the path to an SSH key when Format is "ssh".
+type SigningConfig struct {
+	Format string `json:"format,omitempty"` // gpg (default), ssh, or x509
+	Key    string `json:"key,omitempty"`
+	// Name and Email set the committer identity; GitHub only marks a commit
+	// verified when the email belongs to the key's owner
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// MCPServer uses the same shape as Claude Code's .mcp.json entries
+type MCPServer struct {
+	Type    string            `json:"type,omitempty"` // stdio (default), http, or sse
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 type PollConfig struct {
-	IntervalMinutes int  `json:"intervalMinutes"`
-	AutoTransition  bool `json:"autoTransition"`
+	IntervalMinutes int `json:"intervalMinutes"`
+	// MaxIntervalMinutes lets the interval back off, up to this, while
+	// polls find nothing new; unset, it never backs off
+	MaxIntervalMinutes int  `json:"maxIntervalMinutes,omitempty"`
+	AutoTransition     bool `json:"autoTransition"`
+	// ObserveOnly makes factory plan and report via "[preview]" Jira
+	// comments without creating branches, commits, or PRs
+	ObserveOnly bool `json:"observeOnly"`
+	// ProgressComments posts a Jira comment at each pipeline stage
+	ProgressComments bool `json:"progressComments"`
+	// Assignee polls issues assigned to this user (account ID for REST,
+	// username for the CLI and Jira Server) instead of the authenticated user
+	Assignee string `json:"assignee,omitempty"`
+	// AssigneeGroup polls issues assigned to any member of a Jira group
+	AssigneeGroup string `json:"assigneeGroup,omitempty"`
+	// AssignTo reassigns each issue to this user (e.g. the bot account)
+	// before processing
+	AssignTo string `json:"assignTo,omitempty"`
+	// DrainMinutes is how long a stopping daemon lets the run in progress
+	// finish before interrupting it, default 10
+	DrainMinutes float64 `json:"drainMinutes,omitempty"`
+	// WatchdogMinutes restarts the daemon when its poll loop shows no
+	// progress for this long. Unset, /healthz reports the daemon stalled
+	// after an hour and nothing is restarted.
+	WatchdogMinutes float64 `json:"watchdogMinutes,omitempty"`
+	// ActiveHours ("09:00-18:00") and ActiveDays (["mon-fri"]) limit when
+	// the daemon starts runs and updates PRs and Jira; issues found outside
+	// them wait in the queue. Timezone is an IANA name, default local time.
+	ActiveHours string   `json:"activeHours,omitempty"`
+	ActiveDays  []string `json:"activeDays,omitempty"`
+	Timezone    string   `json:"timezone,omitempty"`
+}
+
+// defaultDrainTimeout bounds a stopping daemon's wait for its last run when
+// poll.drainMinutes is unset
+const defaultDrainTimeout = 10 * time.Minute
+
+// drainTimeout returns how long a stopping daemon waits for its last run
+func (p PollConfig) drainTimeout() time.Duration {
+	if p.DrainMinutes > 0 {
+		return time.Duration(p.DrainMinutes * float64(time.Minute))
+	}
+	return defaultDrainTimeout
+}
+
+// defaultStallTimeout is how long the poll loop may go without progress
+// before /healthz reports it stalled, when poll.watchdogMinutes is unset
+const defaultStallTimeout = time.Hour
+
+// stallTimeout returns how long the poll loop may show no progress before
+// the daemon counts as stalled: never less than two poll intervals, backed
+// off as far as they go, so an idle daemon waiting for its next poll is not
+// mistaken for a stuck one
+func (p PollConfig) stallTimeout() time.Duration {
+	timeout := defaultStallTimeout
+	if p.WatchdogMinutes > 0 {
+		timeout = time.Duration(p.WatchdogMinutes * float64(time.Minute))
+	}
+	if min := 2 * time.Duration(max(p.IntervalMinutes, p.MaxIntervalMinutes)) * time.Minute; timeout < min {
+		timeout = min
+	}
+	return timeout
+}
+
+// TransitionMapping maps pipeline events to Jira status names. Empty entries
+// are skipped; all transitions require poll.autoTransition.
+type TransitionMapping struct {
+	OnStart     string `json:"onStart,omitempty"`
+	OnPRCreated string `json:"onPRCreated,omitempty"`
+	OnMerged    string `json:"onMerged,omitempty"`
+	OnFailure   string `json:"onFailure,omitempty"`
 }
 
-var cfg *Config
+// defaultPRCreatedStatus keeps the original behaviour for configs without
+// a transitions section
+const defaultPRCreatedStatus = "In Progress"
+
+var (
+	// configMu guards loadedConfig, which the daemon's reloads replace
+	// while runs and API requests load it
+	configMu     sync.Mutex
+	loadedConfig *Config
+)
 
 func GetConfigDir() string {
 	home, _ := os.UserHomeDir()
 	return filepath.Join(home, ".factory")
 }
 
+// repoID names the configured repository in file names: OWNER_REPO, or
+// "default" when GitHub isn't configured
+func repoID(c *Config) string {
+	if c.GitHub.Owner == "" || c.GitHub.Repo == "" {
+		return "default"
+	}
+	return c.GitHub.Owner + "_" + c.GitHub.Repo
+}
+
 func GetConfigPath() string {
 	return filepath.Join(GetConfigDir(), "config.json")
 }
@@ -64,24 +547,128 @@ func GetLogPath() string {
 	return filepath.Join(GetConfigDir(), "daemon.log")
 }
 
+// LoadConfig returns the config, reading config.json the first time
 func LoadConfig() (*Config, error) {
-	if cfg != nil {
-		return cfg, nil
+	configMu.Lock()
+	defer configMu.Unlock()
+	if loadedConfig != nil {
+		return loadedConfig, nil
+	}
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
 	}
+	loadedConfig = cfg
+	return cfg, nil
+}
 
+// readConfig reads and validates config.json
+func readConfig() (*Config, error) {
 	data, err := os.ReadFile(GetConfigPath())
 	if err != nil {
 		return nil, fmt.Errorf("config not found. Run 'factory configure' first")
 	}
 
-	cfg = &Config{}
+	cfg := &Config{}
 	if err := json.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	if v := cfg.Jira.Comments.Visibility; v != nil && v.Type != "group" && v.Type != "role" {
+		return nil, fmt.Errorf("invalid config: jira.comments.visibility.type must be \"group\" or \"role\"")
+	}
+	seen := map[string]bool{}
+	for _, v := range cfg.Templates.PromptVariants {
+		if v.Name == "" || seen[v.Name] {
+			return nil, fmt.Errorf("invalid config: templates.promptVariants need unique names")
+		}
+		if v.Weight < 0 {
+			return nil, fmt.Errorf("invalid config: prompt variant %s has a negative weight", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	if v := cfg.Jira.APIVersion; v != "" && v != "2" && v != "3" {
+		return nil, fmt.Errorf("invalid config: jira.apiVersion must be \"2\" or \"3\"")
+	}
+	if o := cfg.Jira.OAuth; o != nil && (o.ClientID == "" || cfg.Jira.UseACLI || cfg.Jira.serverAPI()) {
+		return nil, fmt.Errorf("invalid config: jira.oauth needs a clientId, jira.useAcli off, and Jira Cloud (API version 3)")
+	}
+	if a := cfg.GitHub.App; a != nil && (a.AppID == 0 || a.InstallationID == 0 || a.PrivateKey == "" || cfg.GitHub.UseGHCLI) {
+		return nil, fmt.Errorf("invalid config: github.app needs an appId, installationId, and privateKey, and useGhCli off")
+	}
+	for version := range cfg.Repo.BaseBranches {
+		if _, err := path.Match(version, ""); err != nil {
+			return nil, fmt.Errorf("invalid config: repo.baseBranches %q: %w", version, err)
+		}
+	}
+	routed := map[string]bool{}
+	for _, site := range cfg.Jira.Sites {
+		if site.BaseURL == "" || len(site.Projects) == 0 || cfg.Jira.UseACLI {
+			return nil, fmt.Errorf("invalid config: jira.sites need a baseUrl, projects, and jira.useAcli off")
+		}
+		if v := site.APIVersion; v != "" && v != "2" && v != "3" {
+			return nil, fmt.Errorf("invalid config: jira.sites apiVersion must be \"2\" or \"3\"")
+		}
+		for _, p := range site.Projects {
+			if routed[strings.ToUpper(p)] {
+				return nil, fmt.Errorf("invalid config: project %s is routed to more than one Jira site", p)
+			}
+			routed[strings.ToUpper(p)] = true
+		}
+	}
+	if _, err := cfg.Poll.schedule(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if b := cfg.Budget; b.PerIssueUSD < 0 || b.DailyUSD < 0 || b.WeeklyUSD < 0 {
+		return nil, fmt.Errorf("invalid config: budget limits can't be negative")
+	}
+	if s := cfg.Repo.SyncBase; s != "" && s != SyncRebase && s != SyncMerge && s != SyncOff {
+		return nil, fmt.Errorf("invalid config: repo.syncBase must be \"rebase\", \"merge\", or \"off\"")
+	}
+	if r := cfg.Jira.Reprocess; r.DescriptionChange < 0 || r.DescriptionChange > 1 || r.enabled() && cfg.Jira.UseACLI {
+		return nil, fmt.Errorf("invalid config: jira.reprocess needs jira.useAcli off, and a descriptionChange between 0 and 1")
+	}
+	if cfg.Review.MaxPerPoll < 0 {
+		return nil, fmt.Errorf("invalid config: review.maxPerPoll can't be negative")
+	}
+	if cfg.TDD.Enabled && cfg.Verify.TestCommand == "" {
+		return nil, fmt.Errorf("invalid config: tdd needs verify.testCommand, to check that the tests fail first")
+	}
+	for _, name := range cfg.Scan.Scanners {
+		if scanners[name] == nil {
+			return nil, fmt.Errorf("invalid config: unknown scanner %q in scan.scanners; use gosec, semgrep, or gitleaks", name)
+		}
+	}
+	if s := cfg.Scan.MinSeverity; s != "" && severityRank(s) < 0 {
+		return nil, fmt.Errorf("invalid config: scan.minSeverity must be low, medium, high, or critical")
+	}
+	if cfg.Plan.MinStoryPoints < 0 {
+		return nil, fmt.Errorf("invalid config: plan.minStoryPoints can't be negative")
+	}
+	if cfg.GC.IntervalHours < 0 || cfg.GC.LogRetentionDays < 0 {
+		return nil, fmt.Errorf("invalid config: gc.intervalHours and gc.logRetentionDays can't be negative")
+	}
+	if cfg.Transitions == (TransitionMapping{}) {
+		cfg.Transitions.OnPRCreated = defaultPRCreatedStatus
+	}
+	registerSecrets(cfg)
 
 	return cfg, nil
 }
 
+// ReloadConfig re-reads config.json, keeping the loaded config if the file
+// is no longer valid. The loaded config is swapped as a whole, so callers
+// holding the old one keep a consistent copy.
+func ReloadConfig() (*Config, error) {
+	reloaded, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+	loadedConfig = reloaded
+	return reloaded, nil
+}
+
 func SaveConfig(c *Config) error {
 	if err := os.MkdirAll(GetConfigDir(), 0700); err != nil {
 		return err
@@ -135,10 +722,11 @@ authentication-method: api-token
 func RunConfigure() error {
 	reader := bufio.NewReader(os.Stdin)
 
-	fmt.Println(`
+	fmt.Print(`
 ╔════════════════════════════════════════════════════════════════╗
 ║                 FACTORY - CONFIGURATION                        ║
 ╚════════════════════════════════════════════════════════════════╝
+
 `)
 
 	// Load existing config if any
@@ -169,11 +757,34 @@ func RunConfigure() error {
 	fmt.Println()
 
 	existing.Jira.BaseURL = prompt(reader, "Jira URL (e.g., https://company.atlassian.net)", existing.Jira.BaseURL)
-	existing.Jira.Email = prompt(reader, "Jira Email", existing.Jira.Email)
-	existing.Jira.APIToken = promptSecret(reader, "Jira API Token", existing.Jira.APIToken)
 
-	useACLI := prompt(reader, "Use Jira CLI for operations? [Y/n]", "y")
-	existing.Jira.UseACLI = strings.ToLower(useACLI) != "n"
+	oauthDefault := "n"
+	if existing.Jira.OAuth != nil {
+		oauthDefault = "y"
+	}
+	useOAuth := prompt(reader, "Sign in with an OAuth 2.0 app instead of an API token? [y/N]", oauthDefault)
+	if strings.ToLower(useOAuth) == "y" {
+		if existing.Jira.OAuth == nil {
+			existing.Jira.OAuth = &JiraOAuthConfig{}
+		}
+		o := existing.Jira.OAuth
+		fmt.Println("Create an OAuth 2.0 (3LO) app at https://developer.atlassian.com/console/myapps/")
+		fmt.Printf("with the Jira API scopes %s and callback URL %s\n", jiraOAuthScopes, o.redirectURL())
+		o.ClientID = prompt(reader, "OAuth Client ID", o.ClientID)
+		o.ClientSecret = promptSecret(reader, "OAuth Client Secret", o.ClientSecret)
+		existing.Jira.UseACLI = false
+		if err := SignInJiraOAuth(existing); err != nil {
+			return fmt.Errorf("jira sign-in failed: %w", err)
+		}
+		fmt.Println("✓ Signed in to Jira; the refresh token is in the OS keyring")
+	} else {
+		existing.Jira.OAuth = nil
+		existing.Jira.Email = prompt(reader, "Jira Email", existing.Jira.Email)
+		existing.Jira.APIToken = promptSecret(reader, "Jira API Token", existing.Jira.APIToken)
+
+		useACLI := prompt(reader, "Use Jira CLI for operations? [Y/n]", "y")
+		existing.Jira.UseACLI = strings.ToLower(useACLI) != "n"
+	}
 
 	// GitHub Configuration
 	fmt.Println()
@@ -230,8 +841,24 @@ func RunConfigure() error {
 		existing.Poll.IntervalMinutes = 5
 	}
 
-	autoTrans := prompt(reader, "Auto-transition to 'In Progress'? [Y/n]", "y")
+	autoTrans := prompt(reader, "Auto-transition Jira issues? [Y/n]", "y")
 	existing.Poll.AutoTransition = strings.ToLower(autoTrans) != "n"
+	if existing.Poll.AutoTransition {
+		if existing.Transitions.OnPRCreated == "" {
+			existing.Transitions.OnPRCreated = defaultPRCreatedStatus
+		}
+		existing.Transitions.OnStart = prompt(reader, "  Status when work starts (optional)", existing.Transitions.OnStart)
+		existing.Transitions.OnPRCreated = prompt(reader, "  Status when PR is created", existing.Transitions.OnPRCreated)
+		existing.Transitions.OnMerged = prompt(reader, "  Status when PR is merged (optional)", existing.Transitions.OnMerged)
+		existing.Transitions.OnFailure = prompt(reader, "  Status on failure (optional)", existing.Transitions.OnFailure)
+	}
+
+	observeDefault := "n"
+	if existing.Poll.ObserveOnly {
+		observeDefault = "y"
+	}
+	observe := prompt(reader, "Observer mode (preview only, no branches/PRs)? [y/N]", observeDefault)
+	existing.Poll.ObserveOnly = strings.ToLower(observe) == "y"
 
 	// Save factory config
 	if err := SaveConfig(existing); err != nil {
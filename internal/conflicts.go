@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// conflictContext is how many lines around a conflict the agent sees
+	conflictContext = 3
+	// maxConflictLines caps the conflicting hunks put in the prompt; the
+	// agent reads the files for the rest
+	maxConflictLines = 400
+)
+
+// errScanBlocked marks a resolution the security scan rejected
+var errScanBlocked = errors.New("the security scan rejected the resolution")
+
+// conflictResolver has the agent resolve the conflicts of syncing the run's
+// branch with its base branch, then scans the files it changed, like the
+// change itself, and runs the verify checks over the result. Any failure
+// gives up, which fails the run; a guard violation is returned as it is.
+func conflictResolver(cfg *Config, git *Git, issue *Issue, result *Result, lease *leaseHandle, transcript io.Writer) ConflictResolver {
+	return func(files []string) error {
+		result.enterStage("conflict")
+		defer result.enterStage("commit")
+		fmt.Println("→ Asking the agent to resolve the conflicts...")
+		progress(cfg, issue.Key, "resolving conflicts with "+git.branch)
+
+		model := ""
+		if n := len(result.Models); n > 0 {
+			model = result.Models[n-1]
+		}
+		before, err := git.Snapshot()
+		if err != nil {
+			return err
+		}
+		_, err = runAgentStep(cfg, git, agentRun{prompt: conflictPrompt(git, issue, files), model: model}, issue, result, lease, transcript)
+		if stepStopped(err) != "" {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("agent: %w", err)
+		}
+
+		if len(cfg.Scan.Scanners) > 0 {
+			changed, err := git.ChangedSince(before)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				if !slices.Contains(changed, f) {
+					changed = append(changed, f)
+				}
+			}
+			if err := scanChange(cfg, git, issue, changed, result); err != nil {
+				return fmt.Errorf("%w: %v", errScanBlocked, err)
+			}
+		}
+
+		checks := verifyChecks(cfg, git.Path())
+		if len(checks) == 0 {
+			return nil
+		}
+		failed, output, err := runChecks(checks, git.Path())
+		if failed != nil {
+			return fmt.Errorf("%s (%s) fails after resolving (%v):\n%s", failed.Name, failed.Command, err, lastLines(output, 20))
+		}
+		fmt.Println("  Checks passed")
+		return nil
+	}
+}
+
+// conflictPrompt asks the agent to resolve the conflicts in files, showing
+// it the conflicting hunks
+func conflictPrompt(git *Git, issue *Issue, files []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You implemented %s (%s) on this branch. While you worked, %s moved on, "+
+		"and bringing the branch up to date with origin/%s left merge conflicts in these files:\n\n",
+		issue.Key, issue.Title, git.branch, git.branch)
+	for _, f := range files {
+		fmt.Fprintf(&b, "- %s\n", f)
+	}
+	b.WriteString(`
+Resolve every conflict by editing the files: keep what the base branch
+changed and what this branch does for the issue, combining them where both
+touched the same code, and remove all conflict markers (<<<<<<<, =======,
+>>>>>>>). Make sure the code still builds. Change nothing else, and don't
+run git commands that stage, commit, check out, rebase, merge, or reset;
+factory continues the sync once you are done.
+`)
+
+	lines := 0
+	for _, f := range files {
+		hunks := conflictHunks(filepath.Join(git.Path(), f))
+		if hunks == "" {
+			continue
+		}
+		n := strings.Count(hunks, "\n")
+		if lines+n > maxConflictLines {
+			b.WriteString("\nThe other conflicts are too long to show here; read the files.\n")
+			break
+		}
+		lines += n
+		fmt.Fprintf(&b, "\n## %s\n```\n%s```\n", f, hunks)
+	}
+	return b.String()
+}
+
+// conflictHunks returns the conflicts in a file with a few lines around
+// each, "" for a file with none (e.g. one deleted on one side)
+func conflictHunks(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(data), "\n")
+	show := make([]bool, len(lines))
+	start := -1
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "<<<<<<< "):
+			start = i
+		case strings.HasPrefix(line, ">>>>>>> ") && start >= 0:
+			for j := max(0, start-conflictContext); j <= min(len(lines)-1, i+conflictContext); j++ {
+				show[j] = true
+			}
+			start = -1
+		}
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		if !show[i] {
+			continue
+		}
+		if i > 0 && !show[i-1] && b.Len() > 0 {
+			b.WriteString("...\n")
+		}
+		fmt.Fprintf(&b, "%d: %s\n", i+1, line)
+	}
+	return b.String()
+}
@@ -0,0 +1,356 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// daemonControl is the running daemon's state that the API can read and
+// change: the config it polls with and whether it is paused
+type daemonControl struct {
+	mu        sync.Mutex
+	cfg       *Config
+	paused    bool
+	startedAt time.Time
+	lastPoll  time.Time
+	nextPoll  time.Time
+
+	// stopping is closed when the daemon is asked to stop: no new run
+	// starts, and the one in progress may finish. interrupt is closed when
+	// the run in progress must stop too.
+	stopping      chan struct{}
+	interrupt     chan struct{}
+	interruptOnce sync.Once
+
+	// For /healthz: the last sign of the poll loop moving, and how the
+	// last polls went
+	lastProgress time.Time
+	lastSuccess  time.Time
+	lastError    string
+	lastErrorAt  time.Time
+}
+
+var control = &daemonControl{stopping: make(chan struct{}), interrupt: make(chan struct{})}
+
+func (c *daemonControl) config() *Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cfg
+}
+
+func (c *daemonControl) setConfig(cfg *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+}
+
+func (c *daemonControl) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *daemonControl) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = paused
+}
+
+func (c *daemonControl) isStopping() bool {
+	select {
+	case <-c.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *daemonControl) polled() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastPoll = time.Now()
+	c.lastProgress = c.lastPoll
+}
+
+// schedulePoll records when the next poll is due, in d, and returns d
+func (c *daemonControl) schedulePoll(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextPoll = time.Now().Add(d)
+	return d
+}
+
+// interruptRuns stops the run in progress; see leaseHandle.watchCancel
+func (c *daemonControl) interruptRuns() {
+	c.interruptOnce.Do(func() { close(c.interrupt) })
+}
+
+// DaemonStatus is the running daemon's own view, from GET /api/status
+type DaemonStatus struct {
+	PID             int       `json:"pid"`
+	StartedAt       time.Time `json:"startedAt"`
+	LastPoll        time.Time `json:"lastPoll,omitempty"`
+	NextPoll        time.Time `json:"nextPoll,omitempty"` // later while the interval backs off
+	IntervalMinutes int       `json:"intervalMinutes"`
+	Paused          bool      `json:"paused"`
+	// Stopping is set once the daemon is waiting for its last run to finish
+	Stopping bool `json:"stopping,omitempty"`
+	// Resumes is when runs start again, outside poll.activeHours
+	Resumes string `json:"resumes,omitempty"`
+	// LastError is why the last poll failed, while it is the latest
+	LastError string `json:"lastError,omitempty"`
+}
+
+func (c *daemonControl) status() DaemonStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return DaemonStatus{
+		PID:             os.Getpid(),
+		StartedAt:       c.startedAt,
+		LastPoll:        c.lastPoll,
+		NextPoll:        c.nextPoll,
+		IntervalMinutes: c.cfg.Poll.IntervalMinutes,
+		Paused:          c.paused,
+		Stopping:        c.isStopping(),
+		Resumes:         formatResume(outsideHours(c.cfg)),
+		LastError:       c.lastError,
+	}
+}
+
+// GetSocketPath is the daemon's control socket. It is only reachable by
+// the user running the daemon, so requests on it need no token.
+func GetSocketPath() string {
+	return filepath.Join(GetConfigDir(), "daemon.sock")
+}
+
+// startControlSocket serves the API on the control socket
+func startControlSocket(handler http.Handler) error {
+	path := GetSocketPath()
+	os.Remove(path) // left behind by a daemon that didn't exit cleanly
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return fmt.Errorf("control socket: %w", err)
+	}
+	apiListeners = append(apiListeners, ln)
+	go func() {
+		if err := http.Serve(ln, handler); err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Printf("Control socket error: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(control.status())
+}
+
+// handlePause stops the daemon from starting queued issues until resumed.
+// A run in progress finishes; new issues are still queued.
+func handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		control.setPaused(paused)
+		if paused {
+			fmt.Println("Paused from the API")
+		} else {
+			fmt.Println("Resumed from the API")
+			wakeDaemon()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleReload re-reads config.json; see daemonControl.reload
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := control.reload("the API"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadMu serializes reloads from the API, SIGHUP, and the config watcher
+var reloadMu sync.Mutex
+
+// reload re-reads config.json, keeping the current config if the file is
+// no longer valid. The poll loop uses it from its next poll, and a new poll
+// interval takes effect right away, as does a new API token; without one,
+// the API refuses every request. The API's listen address only changes on
+// restart. source says what asked for the reload.
+func (c *daemonControl) reload(source string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	old := c.config()
+	cfg, err := ReloadConfig()
+	if err != nil {
+		fmt.Printf("Config not reloaded from %s: %v\n", source, err)
+		return err
+	}
+	c.setConfig(cfg)
+	fmt.Printf("Config reloaded from %s\n", source)
+	if old != nil && old.Server.Listen != cfg.Server.Listen {
+		fmt.Println("  Note: server.listen changes on restart")
+	}
+	if cfg.Server.Listen != "" && cfg.Server.Token == "" {
+		fmt.Println("  Warning: server.token is empty; the API refuses every request until it is set")
+	}
+	select {
+	case configChanged <- struct{}{}:
+	default: // the poll loop hasn't seen the last reload yet
+	}
+	return nil
+}
+
+// configChanged tells the poll loop that the config was reloaded, so a new
+// poll interval applies without waiting for the old one to run out
+var configChanged = make(chan struct{}, 1)
+
+// configWatchInterval is how often the daemon checks config.json for edits
+const configWatchInterval = 5 * time.Second
+
+// watchConfig reloads the config whenever config.json is saved, so edits
+// take effect without `factory reload`. An edit that doesn't validate is
+// reported once and the current config is kept.
+func watchConfig() {
+	modTime := func() time.Time {
+		info, err := os.Stat(GetConfigPath())
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	last := modTime()
+	for range time.Tick(configWatchInterval) {
+		if t := modTime(); !t.IsZero() && !t.Equal(last) {
+			last = t
+			control.reload("config.json")
+		}
+	}
+}
+
+// watchReloadSignal reloads the config on each signal sent to sigs
+func watchReloadSignal(sigs <-chan os.Signal) {
+	for range sigs {
+		control.reload("SIGHUP")
+	}
+}
+
+// errNoDaemon means there is no running daemon to send a command to
+var errNoDaemon = errors.New("daemon not running")
+
+// callDaemon sends a request to the running daemon over its control socket
+// and returns the response body
+func callDaemon(method, path string) ([]byte, error) {
+	if pid := GetDaemonPid(); pid == 0 || !isRunning(pid) {
+		return nil, errNoDaemon
+	}
+	if _, err := os.Stat(GetSocketPath()); err != nil {
+		return nil, errNoDaemon
+	}
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", GetSocketPath())
+		}},
+	}
+	req, err := http.NewRequest(method, "http://factory"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("daemon: %s", strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// runningDaemonStatus asks the daemon for its status, or returns nil when
+// it can't be reached
+func runningDaemonStatus() *DaemonStatus {
+	body, err := callDaemon("GET", "/api/status")
+	if err != nil {
+		return nil
+	}
+	var s DaemonStatus
+	if json.Unmarshal(body, &s) != nil {
+		return nil
+	}
+	return &s
+}
+
+// PauseDaemon stops the running daemon from starting queued issues, or
+// lets it start them again
+func PauseDaemon(paused bool) error {
+	path, done := "/api/pause", "Paused: queued issues wait until `factory resume`"
+	if !paused {
+		path, done = "/api/resume", "Resumed"
+	}
+	if _, err := callDaemon("POST", path); err != nil {
+		return err
+	}
+	fmt.Println(done)
+	return nil
+}
+
+// ReloadDaemon has the running daemon re-read config.json
+func ReloadDaemon() error {
+	if _, err := callDaemon("POST", "/api/reload"); err != nil {
+		return err
+	}
+	fmt.Println("Config reloaded")
+	return nil
+}
+
+// TriggerOnDaemon puts an issue at the front of the running daemon's queue
+// and has it poll now. It returns errNoDaemon when no daemon is running.
+func TriggerOnDaemon(issueKey string, opts RunOptions, asJSON bool) error {
+	path := "/api/trigger?issue=" + url.QueryEscape(issueKey)
+	if opts.Base != "" {
+		path += "&base=" + url.QueryEscape(opts.Base)
+	}
+	if opts.Update {
+		path += "&update=true"
+	}
+	if _, err := callDaemon("POST", path); err != nil {
+		return err
+	}
+	if asJSON {
+		return PrintJSON(Result{IssueKey: issueKey, Status: "queued"})
+	}
+	fmt.Printf("Queued %s on the daemon; follow it with: factory logs %s\n", issueKey, issueKey)
+	return nil
+}
+
+// IsNoDaemon reports whether err means no daemon is running
+func IsNoDaemon(err error) bool {
+	return errors.Is(err, errNoDaemon)
+}
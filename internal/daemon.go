@@ -2,11 +2,18 @@ package internal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -16,59 +23,280 @@ type ProcessedIssue struct {
 	Status      string `json:"status"`
 	PRUrl       string `json:"prUrl,omitempty"`
 	Error       string `json:"error,omitempty"`
+	PRState     string `json:"prState,omitempty"`
+	Grade       string `json:"grade,omitempty"`
+	Notes       string `json:"notes,omitempty"`
+	Draft       bool   `json:"draft,omitempty"`
+	Stage       string `json:"stage,omitempty"`   // stage a failed run stopped at
+	Variant     string `json:"variant,omitempty"` // prompt variant used
+	// Models lists the models tried; more than one means the run escalated
+	Models       []string `json:"models,omitempty"`
+	CostUSD      float64  `json:"costUsd,omitempty"`
+	InputTokens  int      `json:"inputTokens,omitempty"`
+	OutputTokens int      `json:"outputTokens,omitempty"`
+	// TakeoverFrom records a run that resumed after another worker died
+	TakeoverFrom string `json:"takeoverFrom,omitempty"`
+	// SessionID is the run's last Claude Code session
+	SessionID string `json:"sessionId,omitempty"`
+	// Stages is how long the run spent in each stage
+	Stages []StageTiming `json:"stages,omitempty"`
+	// Base is the branch the run worked against, when not the default
+	Base string `json:"base,omitempty"`
 }
 
 var processed = make(map[string]ProcessedIssue)
 
+// processedMu serializes updates to the processed-issue file between the
+// poll loop and API handlers, which read and write the file directly
+var processedMu sync.Mutex
+
 func loadProcessed() {
-	data, err := os.ReadFile(GetProcessedPath())
-	if err == nil {
-		json.Unmarshal(data, &processed)
+	list := make(map[string]ProcessedIssue)
+	if err := readStateFile(GetProcessedPath(), &list); err == nil {
+		processedMu.Lock()
+		processed = list
+		processedMu.Unlock()
+	}
+}
+
+// processedEntry looks an issue up in the loaded processed state. API
+// handlers replace and change the map under processedMu, so the poll loop
+// reads it under the lock too.
+func processedEntry(issueKey string) (ProcessedIssue, bool) {
+	processedMu.Lock()
+	defer processedMu.Unlock()
+	info, ok := processed[issueKey]
+	return info, ok
+}
+
+// processedSnapshot copies the loaded processed state, for the poll loop
+// to range over (see processedEntry)
+func processedSnapshot() map[string]ProcessedIssue {
+	processedMu.Lock()
+	defer processedMu.Unlock()
+	list := make(map[string]ProcessedIssue, len(processed))
+	for k, v := range processed {
+		list[k] = v
 	}
+	return list
 }
 
+// saveProcessed writes processed atomically (see writeStateFile).
+// Read-modify-write changes go through updateProcessed.
 func saveProcessed() {
 	data, _ := json.MarshalIndent(processed, "", "  ")
-	os.WriteFile(GetProcessedPath(), data, 0644)
+	writeProcessed(data)
+}
+
+func writeProcessed(data []byte) error {
+	return writeStateFile(GetProcessedPath(), data)
 }
 
-// StartDaemon starts the background daemon
-func StartDaemon() error {
-	// Check if already running
+// StartDaemon starts the daemon in the background, or in this process when
+// foreground is set (for systemd, launchd, containers, or service wrappers)
+func StartDaemon(foreground bool) error {
 	if pid := GetDaemonPid(); pid > 0 {
-		if isRunning(pid) {
-			return fmt.Errorf("daemon already running (PID %d)", pid)
-		}
+		return fmt.Errorf("daemon already running (PID %d)", pid)
+	}
+
+	if !foreground {
+		return daemonize()
+	}
+
+	// The daemon owns its PID file, so the parent never races it, and holds
+	// its lock while it runs, so two daemons started at once can't both
+	// get past the check above
+	if err := writePidFile(); err != nil {
+		return err
 	}
+	defer removePidFile()
 
-	// Fork into background
-	if os.Getenv("FACTORY_DAEMON") != "1" {
-		// Start new process in background
-		cmd := exec.Command(os.Args[0], "start")
-		cmd.Env = append(os.Environ(), "FACTORY_DAEMON=1")
+	signal.Notify(stopRequests, syscall.SIGTERM, os.Interrupt)
+	go handleStopSignals(stopRequests)
+	hup := make(chan os.Signal, 1)
+	notifyReload(hup)
+	go watchReloadSignal(hup)
 
-		// Redirect output to log file
-		logFile, err := os.OpenFile(GetLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return runDaemon()
+}
+
+// stopRequests receives the signals that stop the daemon, and POST
+// /api/stop, which stops it where signals can't (Windows)
+var stopRequests = make(chan os.Signal, 2)
+
+// handleStop stops the daemon as SIGTERM would
+func handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case stopRequests <- syscall.SIGTERM:
+	default: // already stopping, with a second request pending
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// stopGrace is how long an interrupted run gets to stop its agent and
+// record its result before the daemon exits anyway
+const stopGrace = time.Minute
+
+// handleStopSignals stops the daemon gracefully on SIGTERM or Ctrl+C: no new
+// run starts, and the run in progress gets poll.drainMinutes to finish
+// before it is interrupted. A second signal interrupts it right away.
+func handleStopSignals(sigs <-chan os.Signal) {
+	<-sigs
+	cfg := control.config()
+	if cfg == nil {
+		// Still starting up; there is nothing to drain
+		removePidFile()
+		os.Exit(0)
+	}
+	close(control.stopping)
+	wakeDaemon()
+	if key := runLogs.currentRun(); key != "" {
+		fmt.Printf("Stopping: waiting up to %s for %s to finish (signal again to interrupt it)\n", cfg.Poll.drainTimeout(), key)
+	} else {
+		fmt.Println("Stopping")
+	}
+
+	select {
+	case <-sigs:
+	case <-time.After(cfg.Poll.drainTimeout()):
+	}
+	if key := runLogs.currentRun(); key != "" {
+		fmt.Printf("Stopping: interrupting %s\n", key)
+	}
+	control.interruptRuns()
+
+	select {
+	case <-sigs:
+	case <-time.After(stopGrace):
+	}
+	fmt.Println("Stopping: the run did not stop in time; exiting anyway")
+	removePidFile()
+	os.Exit(1)
+}
+
+// daemonize re-runs factory in the foreground as a detached process: a new
+// session without a controlling terminal on Unix, a detached process group on
+// Windows. It runs from the config dir with stdin on /dev/null and output to
+// the log file, and reports startup failures instead of exiting silently.
+func daemonize() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(GetLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+
+	cmd := exec.Command(exe, "start", "--foreground")
+	cmd.Dir = GetConfigDir()
+	cmd.Stdin = devNull
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachAttrs()
+
+	// The umask is inherited by the child
+	restore := setDaemonUmask()
+	err = cmd.Start()
+	restore()
+	if err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return fmt.Errorf("daemon exited during startup (%v); see %s", err, GetLogPath())
+	case <-time.After(2 * time.Second):
+	}
+
+	fmt.Printf("Daemon started (PID %d)\n", cmd.Process.Pid)
+	fmt.Printf("Logs: %s\n", GetLogPath())
+	return nil
+}
+
+// errFileLocked means another process holds a file's lock
+var errFileLocked = errors.New("file is locked")
+
+// pidFile is the running daemon's PID file, held open for its lock
+var pidFile *os.File
+
+// lockPidFile opens the PID file and locks it, or returns errFileLocked
+// when a running daemon holds it
+func lockPidFile() (*os.File, error) {
+	path := GetPidPath()
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		cmd.Stdout = logFile
-		cmd.Stderr = logFile
-
-		if err := cmd.Start(); err != nil {
-			return err
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, err
 		}
+		// A stale file removed while it was being locked is locked in
+		// vain; lock the one now at path instead
+		if fi, err := f.Stat(); err == nil {
+			if cur, err := os.Stat(path); err == nil && os.SameFile(fi, cur) {
+				return f, nil
+			}
+		}
+		f.Close()
+	}
+}
 
-		// Save PID
-		os.WriteFile(GetPidPath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+// writePidFile locks the PID file for the life of this process and records
+// its PID in it
+func writePidFile() error {
+	f, err := lockPidFile()
+	if err == errFileLocked {
+		return fmt.Errorf("daemon already running (PID %d)", GetDaemonPid())
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	pidFile = f
+	return nil
+}
 
-		fmt.Printf("Daemon started (PID %d)\n", cmd.Process.Pid)
-		fmt.Printf("Logs: %s\n", GetLogPath())
-		return nil
+// removePidFile removes the PID file and control socket if they are still
+// this process's
+func removePidFile() {
+	if pidFile == nil {
+		return
 	}
+	os.Remove(GetSocketPath())
+	removeLockedPidFile(pidFile)
+	pidFile = nil
+}
 
-	// We're in the daemon process
-	return runDaemon()
+// removeLockedPidFile removes the PID file while f holds its lock, so no
+// daemon starting meanwhile locks the file being removed, and closes f
+func removeLockedPidFile(f *os.File) {
+	// Windows can't remove a file that is still open
+	if os.Remove(GetPidPath()) != nil {
+		f.Close()
+		os.Remove(GetPidPath())
+	}
+	f.Close()
 }
 
 func runDaemon() error {
@@ -77,12 +305,38 @@ func runDaemon() error {
 		return err
 	}
 
+	control.setConfig(cfg)
+	control.startedAt = time.Now()
+	flush, err := captureOutput()
+	if err != nil {
+		return err
+	}
+	defer flush()
+	api := apiHandler()
+	if err := startControlSocket(api); err != nil {
+		fmt.Printf("  Warning: %v; CLI commands will read state files instead\n", err)
+	}
+	if cfg.Server.Listen != "" {
+		if err := startServer(cfg, api); err != nil {
+			return err
+		}
+	}
+
+	go watchConfig()
+	go watchdog(flush)
 	loadProcessed()
 
 	mode := "ACLI"
 	if !cfg.Jira.UseACLI {
 		mode = "REST"
 	}
+	if n := len(cfg.Jira.Sites); n > 0 {
+		mode += fmt.Sprintf(", %d Jira sites", n+1)
+	}
+
+	if cfg.Poll.ObserveOnly {
+		mode += " (observer)"
+	}
 
 	fmt.Printf(`
 ════════════════════════════════════════════════
@@ -93,61 +347,299 @@ func runDaemon() error {
 `, mode, cfg.Poll.IntervalMinutes)
 
 	// Run immediately
-	poll(cfg)
+	idle := 0
+	if !poll(cfg) {
+		idle++
+	}
 
-	// Then on interval
-	ticker := time.NewTicker(time.Duration(cfg.Poll.IntervalMinutes) * time.Minute)
-	for range ticker.C {
-		poll(cfg)
+	// Then on interval, or sooner when woken from the API. A reloaded config
+	// takes effect from the next poll. The interval backs off while polls
+	// find nothing to do, up to poll.maxIntervalMinutes.
+	interval := pollInterval(cfg.Poll, idle)
+	timer := time.NewTimer(control.schedulePoll(jitter(interval)))
+	for {
+		reloaded := false
+		select {
+		case <-timer.C:
+		case <-pollNow:
+		case <-configChanged:
+			reloaded = true
+		}
+		if control.isStopping() {
+			fmt.Println("Daemon stopped")
+			return nil
+		}
+		cfg = control.config()
+		if !reloaded {
+			if poll(cfg) {
+				idle = 0
+			} else {
+				idle++
+			}
+		}
+		next := pollInterval(cfg.Poll, idle)
+		switch {
+		case next == interval:
+			if reloaded {
+				continue
+			}
+		case reloaded || next < interval:
+			fmt.Printf("Polling every %dm\n", int(next.Minutes()))
+		default:
+			fmt.Printf("Nothing new in %d polls; polling every %dm\n", idle, int(next.Minutes()))
+		}
+		interval = next
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(control.schedulePoll(jitter(interval)))
 	}
+}
 
-	return nil
+const (
+	// idlePollsBeforeBackoff is how many polls in a row must find nothing
+	// to do before the interval starts to back off
+	idlePollsBeforeBackoff = 3
+	// pollJitter spreads polls by up to this fraction of the interval either
+	// way, so daemons started together don't hit Jira together
+	pollJitter = 0.1
+)
+
+// pollInterval is the time between polls after idle polls in a row found
+// nothing to do: poll.intervalMinutes, doubling with each idle poll past
+// idlePollsBeforeBackoff up to poll.maxIntervalMinutes
+func pollInterval(p PollConfig, idle int) time.Duration {
+	base := time.Duration(p.IntervalMinutes) * time.Minute
+	ceiling := time.Duration(p.MaxIntervalMinutes) * time.Minute
+	interval := base
+	for i := idlePollsBeforeBackoff; i < idle && interval < ceiling; i++ {
+		interval *= 2
+	}
+	if interval > ceiling && interval > base {
+		interval = ceiling
+	}
+	return interval
+}
+
+// jitter returns d moved randomly by up to pollJitter of it
+func jitter(d time.Duration) time.Duration {
+	spread := int64(float64(d) * pollJitter)
+	if spread <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(2*spread+1)-spread)
 }
 
-func poll(cfg *Config) {
+// pollNow wakes the daemon's poll loop early, e.g. after a retry from the
+// dashboard
+var pollNow = make(chan struct{}, 1)
+
+func wakeDaemon() {
+	select {
+	case pollNow <- struct{}{}:
+	default: // a wake-up is already pending
+	}
+}
+
+// poll fetches assigned issues, queues new ones, and works through the
+// queue. It reports whether it found anything to do, which keeps the poll
+// interval from backing off.
+func poll(cfg *Config) bool {
+	if control.isStopping() {
+		return false
+	}
 	fmt.Printf("[%s] Polling...\n", time.Now().Format("15:04:05"))
+	control.polled()
 
 	issues, err := GetAssignedIssues(cfg)
 	if err != nil {
 		fmt.Printf("Error fetching issues: %v\n", err)
-		return
+		control.pollFailed(err)
+		return false
 	}
+	control.pollSucceeded()
 
 	fmt.Printf("Found %d assigned issue(s)\n", len(issues))
 
-	// Filter new issues
+	// Pick up clears, drops, and feedback made from the CLI
+	loadProcessed()
+	resumes := outsideHours(cfg)
+	if resumes.IsZero() {
+		syncPRStates(cfg)
+		syncDraftPRs(cfg)
+	}
+
+	// Queue new issues
 	var newIssues []Issue
 	for _, issue := range issues {
-		if _, exists := processed[issue.Key]; !exists {
+		if _, exists := processedEntry(issue.Key); !exists {
 			newIssues = append(newIssues, issue)
 		}
 	}
-
-	if len(newIssues) == 0 {
+	added := enqueue(newIssues)
+	if len(added) > 0 {
+		fmt.Printf("New: %s\n", strings.Join(added, ", "))
+	}
+	// Approvals go first: "@factory approve" is no command to run again
+	added = append(added, pollPlans(cfg, issues)...)
+	added = append(added, pollCommands(cfg, issues)...)
+	added = append(added, pollReprocess(cfg, issues)...)
+	added = append(added, pollEstimates(cfg, issues)...)
+	added = append(added, pollTriage(cfg, issues)...)
+
+	// Process in queue order, which `factory queue` can change between runs
+	// Queued issues wait while a spending cap is hit, the daemon is paused,
+	// or it is outside poll.activeHours
+	ran := false
+	for {
+		if control.isStopping() {
+			break
+		}
+		if control.isPaused() {
+			fmt.Println("Paused; queued issues wait for `factory resume`")
+			break
+		}
+		// Runs are long; the window may have closed during the last one
+		if resumes = outsideHours(cfg); !resumes.IsZero() {
+			fmt.Printf("Outside active hours; queued issues wait until %s\n", formatResume(resumes))
+			break
+		}
+		if !checkBudget(cfg) {
+			break
+		}
+		item, ok := dequeue()
+		if !ok {
+			break
+		}
+		ran = true
+		runLogs.startRun(item.Key)
+		result := ProcessIssue(cfg, item.Key, RunOptions{Base: item.Base, Request: item.Request, Update: item.Update})
+		runLogs.endRun(result)
+		if result.Status == "interrupted" {
+			// Run first when the daemon starts again
+			requeue(item)
+			break
+		}
+		RecordResult(result)
+	}
+	if !ran && resumes.IsZero() {
 		fmt.Println("No new issues")
-		return
 	}
+	if control.isStopping() || !resumes.IsZero() {
+		return len(added) > 0
+	}
+
+	pollReviews(cfg)
+	publishWeeklyReport(cfg)
+	scheduledGC(cfg)
+	return len(added) > 0 || ran
+}
 
-	keys := make([]string, len(newIssues))
-	for i, issue := range newIssues {
-		keys[i] = issue.Key
+// assignedIssue is an issue in `factory list --json`
+type assignedIssue struct {
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	Priority string `json:"priority,omitempty"`
+	Status   string `json:"status"`
+	Title    string `json:"title"`
+	Factory  string `json:"factory"` // see issueState
+}
+
+// ListAssigned prints the issues a poll would find and what the daemon would
+// do with each, without processing anything
+func ListAssigned(cfg *Config, asJSON bool) error {
+	jql := assignedJQL(cfg)
+	if !asJSON {
+		fmt.Printf("JQL: %s\n", jql)
+	}
+	issues, err := GetAssignedIssues(cfg)
+	if err != nil {
+		return err
+	}
+
+	loadProcessed()
+	queue := loadQueue()
+	if asJSON {
+		list := []assignedIssue{}
+		for _, issue := range issues {
+			list = append(list, assignedIssue{Key: issue.Key, Type: issue.Type, Priority: issue.Priority,
+				Status: issue.Status, Title: issue.Title, Factory: issueState(issue.Key, queue)})
+		}
+		return PrintJSON(struct {
+			JQL    string          `json:"jql"`
+			Issues []assignedIssue `json:"issues"`
+		}{jql, list})
+	}
+	if len(issues) == 0 {
+		fmt.Println("\nNo assigned issues")
+		return nil
 	}
-	fmt.Printf("New: %s\n", strings.Join(keys, ", "))
 
-	// Process each
-	for _, issue := range newIssues {
-		result := ProcessIssue(cfg, issue.Key)
-		processed[issue.Key] = ProcessedIssue{
-			ProcessedAt: time.Now().Format(time.RFC3339),
-			Status:      result.Status,
-			PRUrl:       result.PRUrl,
-			Error:       result.Error,
+	fmt.Printf("\nAssigned Issues (%d):\n", len(issues))
+	fmt.Printf("%-12s %-8s %-9s %-14s %-12s %s\n", "Issue", "Type", "Priority", "Status", "Factory", "Title")
+	fmt.Println(strings.Repeat("-", 89))
+	pickup := 0
+	for _, issue := range issues {
+		state := issueState(issue.Key, queue)
+		if state == "new" {
+			pickup++
+		}
+		title := issue.Title
+		if short := truncate(38, title); short != title {
+			title = short + "..."
 		}
-		saveProcessed()
+		fmt.Printf("%-12s %-8s %-9s %-14s %-12s %s\n", issue.Key, orDash(issue.Type), orDash(issue.Priority),
+			orDash(issue.Status), state, title)
 	}
+	fmt.Printf("\n%d new issue(s) would be queued on the next poll\n", pickup)
+	return nil
 }
 
-// StopDaemon stops the background daemon
+// issueState is what factory has done with an issue so far: its processed
+// status, its place in the queue, or "new". processed must be loaded.
+func issueState(issueKey string, queue []QueueItem) string {
+	if info, ok := processed[issueKey]; ok {
+		return info.Status
+	}
+	if i := queueIndex(queue, issueKey); i >= 0 {
+		return fmt.Sprintf("queued #%d", i+1)
+	}
+	return "new"
+}
+
+// RecordResult stores the outcome of a run in the processed-issue state.
+// Runs skipped because another worker holds the lease are not recorded.
+func RecordResult(result *Result) {
+	if result.Status == "skipped" {
+		return
+	}
+	updateProcessed(func(list map[string]ProcessedIssue) {
+		list[result.IssueKey] = ProcessedIssue{
+			ProcessedAt:  time.Now().Format(time.RFC3339),
+			Status:       result.Status,
+			PRUrl:        result.PRUrl,
+			Error:        redact(result.Error),
+			Stage:        result.Stage,
+			TakeoverFrom: result.TakeoverFrom,
+			Draft:        result.Draft,
+			Variant:      result.Variant,
+			Models:       result.Models,
+			CostUSD:      result.CostUSD,
+			InputTokens:  result.InputTokens,
+			OutputTokens: result.OutputTokens,
+			SessionID:    result.SessionID,
+			Stages:       result.Stages,
+			Base:         result.Base,
+		}
+	})
+}
+
+// StopDaemon stops the background daemon and waits for it to exit. The
+// daemon lets the run in progress finish, for up to poll.drainMinutes, and
+// then interrupts it.
 func StopDaemon() error {
 	pid := GetDaemonPid()
 	if pid == 0 {
@@ -159,58 +651,162 @@ func StopDaemon() error {
 		return err
 	}
 
-	if err := process.Signal(syscall.SIGTERM); err != nil {
-		// Try force kill on Windows
-		process.Kill()
+	// The control API works everywhere; SIGTERM covers a daemon without it
+	if _, err := callDaemon("POST", "/api/stop"); err != nil {
+		if err := process.Signal(syscall.SIGTERM); err != nil {
+			// Windows has no SIGTERM
+			process.Kill()
+			fmt.Println("Daemon stopped")
+			return nil
+		}
+	}
+
+	drain := defaultDrainTimeout
+	if cfg, err := LoadConfig(); err == nil {
+		drain = cfg.Poll.drainTimeout()
+		for _, l := range activeLeases(cfg) {
+			if l.Owner == processOwner(pid) {
+				fmt.Printf("Waiting for %s to finish (up to %s; it is interrupted after that)...\n", l.IssueKey, drain)
+			}
+		}
+	}
+	deadline := time.Now().Add(drain + stopGrace + 10*time.Second)
+	for GetDaemonPid() == pid {
+		if time.Now().After(deadline) {
+			process.Kill()
+			fmt.Printf("Daemon did not stop within %s; killed it\n", drain+stopGrace)
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
 	}
 
-	os.Remove(GetPidPath())
 	fmt.Println("Daemon stopped")
 	return nil
 }
 
-// GetDaemonPid returns the daemon PID or 0 if not running
+// GetDaemonPid returns the daemon PID or 0 if not running. The daemon
+// holds the PID file's lock while it runs, so a PID file nobody holds is
+// left over from a daemon that crashed or a reboot, whatever process has
+// its PID now; it is removed.
 func GetDaemonPid() int {
 	data, err := os.ReadFile(GetPidPath())
 	if err != nil {
 		return 0
 	}
 	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
-	return pid
+	if pid == 0 {
+		return 0
+	}
+	f, err := lockPidFile()
+	switch {
+	case err == errFileLocked:
+		return pid
+	case err != nil:
+		// Locks aren't available here (e.g. some network file systems)
+		if isRunning(pid) {
+			return pid
+		}
+		return 0
+	}
+	removeLockedPidFile(f)
+	return 0
 }
 
 func isRunning(pid int) bool {
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
-	}
-	// On Unix, FindProcess always succeeds, so we need to check differently
-	err = process.Signal(syscall.Signal(0))
-	return err == nil
+	return processAlive(pid)
+}
+
+// statusReport is `factory status --json`
+type statusReport struct {
+	Running bool           `json:"running"`
+	PID     int            `json:"pid,omitempty"`
+	Daemon  *DaemonStatus  `json:"daemon,omitempty"` // nil when the control socket can't be reached
+	Runs    []Lease        `json:"runs"`
+	History []historyEntry `json:"history"` // most recent first
 }
 
 // ShowStatus shows daemon status and processed issues
-func ShowStatus() {
+func ShowStatus(asJSON bool) error {
 	pid := GetDaemonPid()
-	if pid > 0 && isRunning(pid) {
+	if asJSON {
+		report := statusReport{Daemon: runningDaemonStatus(), Runs: []Lease{}, History: processedHistory(readProcessed())}
+		if pid > 0 && isRunning(pid) {
+			report.Running, report.PID = true, pid
+		}
+		if cfg, err := LoadConfig(); err == nil {
+			if leases := activeLeases(cfg); len(leases) > 0 {
+				report.Runs = leases
+			}
+		}
+		return PrintJSON(report)
+	}
+
+	switch s := runningDaemonStatus(); {
+	case s != nil:
+		state := "Running"
+		if s.Paused {
+			state = "Paused"
+		}
+		if s.Resumes != "" {
+			state = "Outside active hours until " + s.Resumes
+		}
+		if s.Stopping {
+			state = "Stopping"
+		}
+		fmt.Printf("Daemon: %s (PID %d, up %s", state, s.PID, time.Since(s.StartedAt).Round(time.Second))
+		if !s.LastPoll.IsZero() {
+			fmt.Printf(", last poll %s ago, every %dm", time.Since(s.LastPoll).Round(time.Second), s.IntervalMinutes)
+		}
+		if !s.NextPoll.IsZero() {
+			fmt.Printf(", next in %s", time.Until(s.NextPoll).Round(time.Second))
+		}
+		fmt.Println(")")
+		if s.LastError != "" {
+			fmt.Printf("  Last poll failed: %s\n", s.LastError)
+		}
+	case pid > 0 && isRunning(pid):
 		fmt.Printf("Daemon: Running (PID %d)\n", pid)
-	} else {
+	default:
 		fmt.Println("Daemon: Stopped")
 	}
 
+	if cfg, err := LoadConfig(); err == nil {
+		if leases := activeLeases(cfg); len(leases) > 0 {
+			fmt.Printf("\nRunning (%d):\n", len(leases))
+			for _, l := range leases {
+				step := "starting"
+				if l.Progress != nil {
+					step = l.Progress.String()
+				}
+				fmt.Printf("  %-12s %s  %s, %s\n", l.IssueKey, l.Owner,
+					time.Since(l.AcquiredAt).Round(time.Second), step)
+			}
+		}
+	}
+
 	loadProcessed()
 	if len(processed) == 0 {
 		fmt.Println("\nNo processed issues")
-		return
+		return nil
 	}
 
 	fmt.Printf("\nProcessed Issues (%d):\n", len(processed))
-	fmt.Printf("%-12s %-10s %-40s %s\n", "Issue", "Status", "PR/Error", "When")
-	fmt.Println(strings.Repeat("-", 80))
+	fmt.Printf("%-12s %-10s %-40s %-8s %s\n", "Issue", "Status", "PR/Error", "Cost", "When")
+	fmt.Println(strings.Repeat("-", 89))
 
+	var total ProcessedIssue
 	for key, info := range processed {
+		total.CostUSD += info.CostUSD
+		total.InputTokens += info.InputTokens
+		total.OutputTokens += info.OutputTokens
 		status := "✓"
-		if info.Status != "completed" {
+		switch info.Status {
+		case "completed":
+		case "previewed", "planned", "estimated", "triaged":
+			status = "~"
+		case "dropped", "cancelled":
+			status = "-"
+		default:
 			status = "✗"
 		}
 		detail := info.PRUrl
@@ -220,28 +816,154 @@ func ShowStatus() {
 		if len(detail) > 38 {
 			detail = detail[:38] + "..."
 		}
+		cost := "-"
+		if info.CostUSD > 0 {
+			cost = fmt.Sprintf("$%.2f", info.CostUSD)
+		}
 		t, _ := time.Parse(time.RFC3339, info.ProcessedAt)
-		fmt.Printf("%-12s %-10s %-40s %s\n", key, status, detail, t.Format("Jan 02 15:04"))
+		fmt.Printf("%-12s %-10s %-40s %-8s %s\n", key, status, detail, cost, t.Format("Jan 02 15:04"))
 	}
+	fmt.Printf("\nTotal agent spend: %s\n", formatCost(total.CostUSD, total.InputTokens, total.OutputTokens))
+	return nil
 }
 
-// ClearProcessed clears processed issues
-func ClearProcessed(issueKey string) {
-	loadProcessed()
+// readProcessed returns the processed issues on disk without touching the
+// poll loop's copy
+func readProcessed() map[string]ProcessedIssue {
+	list := make(map[string]ProcessedIssue)
+	readStateFile(GetProcessedPath(), &list)
+	return list
+}
+
+// forgetProcessed removes an issue from the processed-issue file so the
+// next poll picks it up again, and reports whether it was there
+func forgetProcessed(issueKey string) bool {
+	defer lockProcessed()()
+	list := readProcessed()
+	if _, ok := list[issueKey]; !ok {
+		return false
+	}
+	delete(list, issueKey)
+	data, _ := json.MarshalIndent(list, "", "  ")
+	writeProcessed(data)
+	return true
+}
+
+// clearAllProcessed empties the processed-issue file
+func clearAllProcessed() {
+	defer lockProcessed()()
+	writeProcessed([]byte("{}"))
+}
+
+// ClearProcessed clears processed issues, through the running daemon when
+// there is one
+func ClearProcessed(issueKey string) error {
+	_, err := callDaemon("POST", "/api/clear?issue="+url.QueryEscape(issueKey))
+	switch {
+	case err == nil && issueKey == "":
+		fmt.Println("Cleared all")
+		return nil
+	case err == nil:
+		fmt.Printf("Cleared: %s\n", issueKey)
+		return nil
+	case !IsNoDaemon(err):
+		return err
+	}
+
+	updateProcessed(func(list map[string]ProcessedIssue) {
+		for key := range list {
+			if issueKey == "" || key == issueKey {
+				delete(list, key)
+			}
+		}
+	})
 	if issueKey == "" {
-		processed = make(map[string]ProcessedIssue)
 		fmt.Println("Cleared all")
 	} else {
-		delete(processed, issueKey)
 		fmt.Printf("Cleared: %s\n", issueKey)
 	}
-	saveProcessed()
+	return nil
+}
+
+// TailLogs shows recent daemon logs, or the output of an issue's latest run
+func TailLogs(issueKey string, lines int) error {
+	path := GetLogPath()
+	if issueKey != "" {
+		path = GetRunLogPath(issueKey)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("no run log for %s", issueKey)
+		}
+	}
+	return followLog(path, lines, os.Stdout)
+}
+
+// logFollowInterval is how often `factory logs` checks for new output
+const logFollowInterval = 500 * time.Millisecond
+
+// followLog writes the last lines of the file at path to out, then whatever
+// is appended to it, like tail -f, until interrupted. A file that shrinks,
+// like a run log rewritten by the issue's next run, is followed from its
+// start.
+func followLog(path string, lines int, out io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	offset, err := lastLinesOffset(f, lines)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		for {
+			n, err := f.ReadAt(buf, offset)
+			out.Write(buf[:n])
+			offset += int64(n)
+			if err != nil || n == 0 {
+				break
+			}
+		}
+		time.Sleep(logFollowInterval)
+		if info, err := os.Stat(path); err == nil && info.Size() < offset {
+			f.Close()
+			if f, err = os.Open(path); err != nil {
+				return err
+			}
+			offset = 0
+		}
+	}
 }
 
-// TailLogs shows recent daemon logs
-func TailLogs(lines int) error {
-	cmd := exec.Command("tail", "-n", strconv.Itoa(lines), "-f", GetLogPath())
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// lastLinesOffset returns where the last n lines of f start, reading
+// backwards from the end so a large log isn't read whole
+func lastLinesOffset(f *os.File, n int) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	chunk := make([]byte, 8*1024)
+	pos := end
+	newlines := 0
+	for pos > 0 {
+		size := int64(len(chunk))
+		if pos < size {
+			size = pos
+		}
+		pos -= size
+		if _, err := f.ReadAt(chunk[:size], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := size - 1; i >= 0; i-- {
+			// A trailing newline ends the last line rather than starting one
+			if chunk[i] != '\n' || pos+i == end-1 {
+				continue
+			}
+			if newlines++; newlines == n {
+				return pos + i + 1, nil
+			}
+		}
+	}
+	return 0, nil
 }
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// detachAttrs starts the daemon as the leader of a new session, detached
+// from the terminal that ran `factory start`
+func detachAttrs() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// setDaemonUmask sets a 022 umask for the daemon and returns a func that
+// restores the caller's
+func setDaemonUmask() func() {
+	old := syscall.Umask(0o022)
+	return func() { syscall.Umask(old) }
+}
+
+// notifyReload sends SIGHUP, the conventional "re-read your config"
+// signal, to c
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// restartDaemon replaces the daemon with a fresh one in the same process,
+// so its PID, PID file, and service manager stay the same
+func restartDaemon() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, []string{exe, "start", "--foreground"}, os.Environ())
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	detachedProcess       = 0x00000008
+	createNewProcessGroup = 0x00000200
+)
+
+// detachAttrs starts the daemon without a console, in its own process group
+// so Ctrl+C in the starting console doesn't reach it
+func detachAttrs() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: detachedProcess | createNewProcessGroup,
+		HideWindow:    true,
+	}
+}
+
+// setDaemonUmask is a no-op; Windows has no umask
+func setDaemonUmask() func() {
+	return func() {}
+}
+
+// notifyReload is a no-op; Windows has no SIGHUP, so the config is
+// reloaded by `factory reload` or by saving it
+func notifyReload(c chan<- os.Signal) {}
+
+// restartDaemon starts a new background daemon and exits; Windows can't
+// replace a running process's image. The PID file's lock and the API's
+// socket and address are let go of first, so the new daemon can take them.
+// The exit is a clean one: the daemon carries on, in the new process.
+func restartDaemon() error {
+	closeAPIListeners()
+	removePidFile()
+	if err := daemonize(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
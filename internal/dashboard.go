@@ -0,0 +1,148 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// dashboardState is everything the dashboard shows, from GET /api/state
+type dashboardState struct {
+	Queue   []QueueItem    `json:"queue"`
+	Runs    []Lease        `json:"runs"`
+	History []historyEntry `json:"history"` // most recent first
+}
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+func handleState(w http.ResponseWriter, r *http.Request) {
+	state := dashboardState{Queue: loadQueue(), Runs: activeLeases(control.config()), History: processedHistory(readProcessed())}
+	if state.Queue == nil {
+		state.Queue = []QueueItem{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// handleTrigger forgets an issue's last run, if any, and puts it at the
+// front of the queue, then wakes the daemon to run it
+func handleTrigger(w http.ResponseWriter, r *http.Request) {
+	key, ok := issueParam(w, r)
+	if !ok {
+		return
+	}
+	for _, l := range activeLeases(control.config()) {
+		if l.IssueKey == key {
+			http.Error(w, key+" is running", http.StatusConflict)
+			return
+		}
+	}
+	base := r.URL.Query().Get("base")
+	if base != "" {
+		if err := ValidateBaseBranch(base); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	forgetProcessed(key)
+	requeue(QueueItem{Key: key, Base: base, Update: r.URL.Query().Get("update") == "true"})
+	wakeDaemon()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRetry is handleTrigger for a failed issue, whose run picks up from
+// the failed stage where it can
+func handleRetry(w http.ResponseWriter, r *http.Request) {
+	key, ok := issueParam(w, r)
+	if !ok {
+		return
+	}
+	if info, ok := readProcessed()[key]; !ok || info.Status != "failed" {
+		http.Error(w, key+" has no failed run to retry", http.StatusConflict)
+		return
+	}
+	requestRetry(key)
+	handleTrigger(w, r)
+}
+
+// handleClear forgets an issue's last run, or every issue's without
+// ?issue=, so the next poll picks them up if they are still assigned
+func handleClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && r.URL.Query().Get("issue") == "" {
+		clearAllProcessed()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	key, ok := issueParam(w, r)
+	if !ok {
+		return
+	}
+	if !forgetProcessed(key) {
+		http.Error(w, key+" has not been processed", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRunLog serves the output of an issue's latest run
+func handleRunLog(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("issue")
+	if !issueKeyPattern.MatchString(key) {
+		http.Error(w, "invalid issue key", http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(GetRunLogPath(key))
+	if err != nil {
+		http.Error(w, "no run log for "+key, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// handleDiff serves the change of an issue's latest run
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("issue")
+	if !issueKeyPattern.MatchString(key) {
+		http.Error(w, "invalid issue key", http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(GetDiffPath(key))
+	if err != nil {
+		http.Error(w, "no diff for "+key, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	w.Write(data)
+}
+
+// issueKeyPattern is a Jira issue key; API parameters are checked against it
+// before they name a file
+var issueKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*-[0-9]+$`)
+
+// issueParam reads ?issue=KEY from a POST, writing the error response when
+// the request is unusable
+func issueParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	key := r.URL.Query().Get("issue")
+	if !issueKeyPattern.MatchString(key) {
+		http.Error(w, "invalid issue key", http.StatusBadRequest)
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GetDiffPath is where the change of the issue's latest run is kept, as a
+// patch against the base branch
+func GetDiffPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "diffs", issueKey+".patch")
+}
+
+// BranchDiff is the patch of everything the branch changed since it left
+// origin's base branch
+func (g *Git) BranchDiff() ([]byte, error) {
+	cmd := exec.Command("git", "diff", "origin/"+g.branch+"...HEAD")
+	cmd.Dir = g.repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	return out, nil
+}
+
+// saveDiff keeps a run's change for `factory diff`, with secrets redacted,
+// replacing the issue's last one. Failures only warn, since the diff is a
+// record rather than part of the run.
+func saveDiff(issueKey string, patch []byte) {
+	path := GetDiffPath(issueKey)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.WriteFile(path, []byte(redact(string(patch))), 0600)
+	}
+	if err != nil {
+		fmt.Printf("  Warning: could not save diff %s: %v\n", path, err)
+	}
+}
+
+// saveBranchDiff keeps the change on the run's branch
+func saveBranchDiff(git *Git, issueKey string) {
+	patch, err := git.BranchDiff()
+	if err != nil {
+		fmt.Printf("  Warning: could not save diff for %s: %v\n", issueKey, err)
+		return
+	}
+	saveDiff(issueKey, patch)
+}
+
+// DiffOptions controls `factory diff`
+type DiffOptions struct {
+	Stat  bool   // only the files changed and their line counts
+	Color string // auto (default, when stdout is a terminal), always, or never
+	Delta bool   // show it through delta
+}
+
+// ShowDiff prints the change of the issue's latest run
+func ShowDiff(issueKey string, opts DiffOptions) error {
+	if !issueKeyPattern.MatchString(issueKey) {
+		return fmt.Errorf("invalid issue key %q", issueKey)
+	}
+	patch, err := os.ReadFile(GetDiffPath(issueKey))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no diff recorded for %s; diffs are kept once a run commits its change", issueKey)
+	}
+	if err != nil {
+		return err
+	}
+	if len(patch) == 0 {
+		fmt.Printf("%s's latest run changed nothing against its base branch\n", issueKey)
+		return nil
+	}
+
+	if opts.Stat {
+		cmd := exec.Command("git", "apply", "--stat", "-")
+		cmd.Stdin = bytes.NewReader(patch)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+	if opts.Delta {
+		if _, err := exec.LookPath("delta"); err != nil {
+			return fmt.Errorf("delta is not installed; see https://github.com/dandavison/delta")
+		}
+		cmd := exec.Command("delta")
+		cmd.Stdin = bytes.NewReader(patch)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+
+	var color bool
+	switch opts.Color {
+	case "", "auto":
+		color = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+	case "always":
+		color = true
+	case "never":
+	default:
+		return fmt.Errorf("--color must be auto, always, or never")
+	}
+	if !color {
+		_, err := os.Stdout.Write(patch)
+		return err
+	}
+	return colorDiff(os.Stdout, patch)
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorDiff writes patch to w colored the way git colors diffs
+func colorDiff(w io.Writer, patch []byte) error {
+	const (
+		red   = "\x1b[31m"
+		green = "\x1b[32m"
+		cyan  = "\x1b[36m"
+	)
+	out := bufio.NewWriter(w)
+	header := false
+	for _, line := range strings.SplitAfter(string(patch), "\n") {
+		text := strings.TrimRight(line, "\n")
+		color := ""
+		switch {
+		case strings.HasPrefix(text, "diff "):
+			header = true
+			color = ansiBold
+		case strings.HasPrefix(text, "@@"):
+			header = false
+			color = cyan
+		case header:
+			color = ansiBold
+		case strings.HasPrefix(text, "+"):
+			color = green
+		case strings.HasPrefix(text, "-"):
+			color = red
+		}
+		if color == "" || text == "" {
+			out.WriteString(line)
+			continue
+		}
+		out.WriteString(color + text + ansiReset + line[len(text):])
+	}
+	return out.Flush()
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Values for github.readyWhen
+const (
+	ReadyOnChecks   = "checks"   // all CI checks passed
+	ReadyOnApproval = "approval" // a reviewer approved the draft
+)
+
+// MarkPRReady takes an issue's draft PR out of draft and requests its
+// reviewers, who weren't asked while it was a draft
+func MarkPRReady(cfg *Config, issueKey, prURL string) error {
+	err := markPRReady(cfg, prURL)
+	recordAudit(actorOf(cfg), "github.pr.ready", issueKey, prURL, "", err)
+	if err != nil {
+		return err
+	}
+	requestPRReviewers(cfg, issueKey, prURL)
+	return nil
+}
+
+func markPRReady(cfg *Config, prURL string) error {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		if out, err := exec.Command("gh", "pr", "ready", prURL).CombinedOutput(); err != nil {
+			return fmt.Errorf("gh pr ready failed: %s", strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	// The REST API cannot undraft a PR; GraphQL can
+	pr, err := getPullREST(cfg, prURL)
+	if err != nil {
+		return err
+	}
+	if !pr.Draft {
+		return nil
+	}
+	body, err := githubRequest(cfg, "POST", "/graphql", map[string]interface{}{
+		"query":     `mutation($id: ID!) { markPullRequestReadyForReview(input: {pullRequestId: $id}) { clientMutationId } }`,
+		"variables": map[string]string{"id": pr.NodeID},
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Errors []struct{ Message string } `json:"errors"`
+	}
+	json.Unmarshal(body, &resp)
+	if len(resp.Errors) > 0 {
+		return fmt.Errorf("github GraphQL error: %s", resp.Errors[0].Message)
+	}
+	return nil
+}
+
+// prChecksPassed reports whether every check run and commit status on the
+// PR's head commit succeeded
+func prChecksPassed(cfg *Config, prURL string) (bool, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		// Exits non-zero while checks are failing or pending
+		err := exec.Command("gh", "pr", "checks", prURL).Run()
+		return err == nil, nil
+	}
+
+	pr, err := getPullREST(cfg, prURL)
+	if err != nil {
+		return false, err
+	}
+	repo := fmt.Sprintf("/repos/%s/%s/commits/%s", cfg.GitHub.Owner, cfg.GitHub.Repo, pr.Head.SHA)
+
+	body, err := githubRequest(cfg, "GET", repo+"/check-runs", nil)
+	if err != nil {
+		return false, err
+	}
+	var runs struct {
+		CheckRuns []struct {
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+		} `json:"check_runs"`
+	}
+	json.Unmarshal(body, &runs)
+	for _, r := range runs.CheckRuns {
+		if r.Status != "completed" {
+			return false, nil
+		}
+		if r.Conclusion != "success" && r.Conclusion != "neutral" && r.Conclusion != "skipped" {
+			return false, nil
+		}
+	}
+
+	body, err = githubRequest(cfg, "GET", repo+"/status", nil)
+	if err != nil {
+		return false, err
+	}
+	var status struct {
+		State      string `json:"state"`
+		TotalCount int    `json:"total_count"`
+	}
+	json.Unmarshal(body, &status)
+	if status.TotalCount > 0 && status.State != "success" {
+		return false, nil
+	}
+	// Nothing reported yet: wait rather than undraft a PR CI never saw
+	return len(runs.CheckRuns) > 0 || status.TotalCount > 0, nil
+}
+
+// prApproved reports whether a reviewer approved the PR
+func prApproved(cfg *Config, prURL string) (bool, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		out, err := exec.Command("gh", "pr", "view", prURL, "--json", "reviewDecision", "-q", ".reviewDecision").Output()
+		if err != nil {
+			return false, fmt.Errorf("gh pr view failed: %w", err)
+		}
+		return strings.TrimSpace(string(out)) == "APPROVED", nil
+	}
+
+	num, err := prNumber(prURL)
+	if err != nil {
+		return false, err
+	}
+	body, err := githubRequest(cfg, "GET", fmt.Sprintf("/repos/%s/%s/pulls/%s/reviews", cfg.GitHub.Owner, cfg.GitHub.Repo, num), nil)
+	if err != nil {
+		return false, err
+	}
+	var reviews []struct {
+		State string `json:"state"`
+	}
+	json.Unmarshal(body, &reviews)
+	for _, r := range reviews {
+		if r.State == "APPROVED" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// syncDraftPRs undrafts open draft PRs whose github.readyWhen condition holds
+func syncDraftPRs(cfg *Config) {
+	when := cfg.GitHub.ReadyWhen
+	if when != ReadyOnChecks && when != ReadyOnApproval {
+		return
+	}
+
+	var undrafted []string
+	for key, info := range processedSnapshot() {
+		if !info.Draft || info.PRUrl == "" || info.PRState == PRStateMerged || info.PRState == PRStateClosed {
+			continue
+		}
+
+		var ready bool
+		var err error
+		if when == ReadyOnChecks {
+			ready, err = prChecksPassed(cfg, info.PRUrl)
+		} else {
+			ready, err = prApproved(cfg, info.PRUrl)
+		}
+		if err != nil || !ready {
+			continue
+		}
+
+		if err := MarkPRReady(cfg, key, info.PRUrl); err != nil {
+			fmt.Printf("Could not mark %s ready: %v\n", info.PRUrl, err)
+			continue
+		}
+		fmt.Printf("Marked ready for review: %s (%s)\n", key, info.PRUrl)
+		undrafted = append(undrafted, key)
+	}
+	if len(undrafted) > 0 {
+		markUndrafted(undrafted...)
+	}
+}
+
+// markUndrafted records that the issues' PRs are no longer drafts
+func markUndrafted(keys ...string) {
+	updateProcessed(func(list map[string]ProcessedIssue) {
+		for _, key := range keys {
+			if info, ok := list[key]; ok {
+				info.Draft = false
+				list[key] = info
+			}
+		}
+	})
+}
+
+// ReadyPR marks an issue's draft PR ready for review
+func ReadyPR(cfg *Config, issueKey string) error {
+	loadProcessed()
+	info, ok := processed[issueKey]
+	if !ok || info.PRUrl == "" {
+		return fmt.Errorf("%s has no PR", issueKey)
+	}
+	if err := MarkPRReady(cfg, issueKey, info.PRUrl); err != nil {
+		return err
+	}
+	markUndrafted(issueKey)
+	fmt.Printf("Ready for review: %s\n", info.PRUrl)
+	return nil
+}
@@ -1,21 +1,198 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Result struct {
-	IssueKey string
-	Status   string
-	PRUrl    string
-	Error    string
+	IssueKey string `json:"issueKey"`
+	Status   string `json:"status"`
+	PRUrl    string `json:"prUrl,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Stage    string `json:"stage,omitempty"`   // stage that failed, if any
+	Draft    bool   `json:"draft,omitempty"`   // PR was opened as a draft
+	Variant  string `json:"variant,omitempty"` // prompt variant the run used, if experimenting
+	// Models lists the agent.models tried, in escalation order
+	Models []string `json:"models,omitempty"`
+	// Agent spend across all attempts
+	CostUSD      float64 `json:"costUsd,omitempty"`
+	InputTokens  int     `json:"inputTokens,omitempty"`
+	OutputTokens int     `json:"outputTokens,omitempty"`
+	// TakeoverFrom is the worker whose expired lease this run took over
+	TakeoverFrom string `json:"takeoverFrom,omitempty"`
+	// SessionID is the last Claude Code session, for `claude --resume`
+	SessionID string `json:"sessionId,omitempty"`
+	// Stages is how long the run spent in each stage it reached, in order
+	Stages []StageTiming `json:"stages,omitempty"`
+	// Base is the branch the run worked against, when not
+	// repo.defaultBranch
+	Base string `json:"base,omitempty"`
+
+	stage      string // stage being timed
+	stageStart time.Time
+	// branch and before are where `factory retry` picks up a failed run
+	branch string
+	before Snapshot
+}
+
+// StageTiming is the time a run spent in one stage. A stage entered again,
+// like the agent after failed checks, adds to its first entry.
+type StageTiming struct {
+	Stage    string  `json:"stage"`
+	Seconds  float64 `json:"seconds"`
+	Attempts int     `json:"attempts,omitempty"`
+}
+
+// enterStage closes the timing of the current stage and starts timing the
+// next one
+func (r *Result) enterStage(stage string) {
+	r.endStage()
+	r.stage, r.stageStart = stage, time.Now()
+	control.progressed()
+}
+
+// endStage closes the timing of the current stage, if any
+func (r *Result) endStage() {
+	if r.stage == "" {
+		return
+	}
+	secs := time.Since(r.stageStart).Round(time.Millisecond).Seconds()
+	stage := r.stage
+	r.stage = ""
+	for i := range r.Stages {
+		if r.Stages[i].Stage == stage {
+			r.Stages[i].Seconds = math.Round((r.Stages[i].Seconds+secs)*1000) / 1000
+			r.Stages[i].Attempts++
+			return
+		}
+	}
+	r.Stages = append(r.Stages, StageTiming{Stage: stage, Seconds: secs, Attempts: 1})
+}
+
+// RunOptions adjust one run of an issue
+type RunOptions struct {
+	// Base overrides the branch the run works against; "" picks it from
+	// repo.baseBranches or defaultBranch
+	Base string
+	// Request is what an "@factory" comment asked for, added to the prompt
+	Request string
+	// Update adds commits to the issue's open PR, addressing its review
+	// comments, instead of opening a new one
+	Update bool
+	// Estimate has the agent estimate the issue on it instead of
+	// implementing it
+	Estimate bool
+	// Triage has the agent post the bug's probable root cause on the issue
+	// instead of fixing it
+	Triage bool
+}
+
+// ProcessIssue runs an issue through the pipeline
+func ProcessIssue(cfg *Config, issueKey string, opts RunOptions) *Result {
+	cfg = cfg.jiraSite(issueKey)
+	issueLock, err := tryLock(issueLockPath(issueKey))
+	switch {
+	case err == errFileLocked:
+		fmt.Printf("Skipping %s: another factory process on this machine is running it\n", issueKey)
+		return &Result{IssueKey: issueKey, Status: "skipped", Error: "running in another factory process"}
+	case err != nil:
+		// Locks aren't available here (e.g. some network file systems)
+		fmt.Printf("  Warning: could not lock %s: %v\n", issueKey, err)
+	}
+	defer issueLock.unlock()
+	lease, takeoverFrom, err := acquireLease(cfg, issueKey)
+	if err != nil {
+		fmt.Printf("Skipping %s: %v\n", issueKey, err)
+		return &Result{IssueKey: issueKey, Status: "skipped", Error: fmt.Sprintf("lease: %v", err)}
+	}
+	defer lease.release()
+	defer teeRunLog(issueKey)()
+	if takeoverFrom != "" {
+		fmt.Printf("Taking over %s from %s (lease expired)\n", issueKey, takeoverFrom)
+	}
+	slot, err := acquireRepoSlot(cfg, issueKey)
+	if err != nil {
+		// The run never started and goes back on the queue
+		fmt.Printf("Not running %s: %v\n", issueKey, err)
+		return &Result{IssueKey: issueKey, Status: "interrupted", Stage: "slot", Error: "slot: " + err.Error()}
+	}
+	if slot != nil {
+		defer slot.release()
+		go lease.watchSlot(slot)
+	}
+	workspace, err := lockWorkspace(cfg, func() bool { return lease.cancelled() || control.isStopping() })
+	switch {
+	case errors.Is(err, errLockWait):
+		result := &Result{IssueKey: issueKey, stage: "workspace"}
+		markCancelled(result, lease)
+		if !lease.cancelled() {
+			// The daemon is stopping, or the workspace stayed busy; the
+			// run never started and goes back on the queue
+			result.Status, result.Error = "interrupted", "workspace: "+err.Error()
+		}
+		fmt.Printf("Not running %s: %s\n", issueKey, result.Error)
+		return result
+	case err != nil:
+		fmt.Printf("  Warning: could not lock the workspace: %v\n", err)
+	}
+	defer workspace.unlock()
+
+	result := processIssue(cfg, issueKey, opts, lease)
+	result.endStage()
+	recordSpend(result)
+	// An implemented plan is used up; a later run plans afresh
+	if result.Status == "completed" {
+		if p := loadPlan(issueKey); p != nil && p.approved() {
+			clearPlan(issueKey)
+		}
+	}
+	saveRetryPoint(result)
+	result.TakeoverFrom = takeoverFrom
+	recordFailureLesson(cfg, result)
+	// A fetch failure usually means the issue can't be commented on either
+	if result.Status == "failed" && result.Stage != "fetch" {
+		progress(cfg, issueKey, fmt.Sprintf("failed at %s\n\n:::error\n```\n%s\n```\n:::",
+			result.Stage, strings.TrimPrefix(result.Error, result.Stage+": ")))
+		if result.Stage != "validate" && !opts.Estimate && !opts.Triage {
+			transition(cfg, issueKey, cfg.Transitions.OnFailure)
+		}
+	}
+	return result
 }
 
-func ProcessIssue(cfg *Config, issueKey string) *Result {
+// transition moves the issue to status when auto-transition is enabled and
+// the event has a status mapped
+func transition(cfg *Config, issueKey, status string) {
+	if !cfg.Poll.AutoTransition || status == "" {
+		return
+	}
+	if err := Transition(cfg, issueKey, status); err != nil {
+		fmt.Printf("  Warning: could not transition %s to %q: %v\n", issueKey, status, err)
+	}
+}
+
+// progress posts a stage update to the issue when progress comments are on
+func progress(cfg *Config, issueKey, msg string) {
+	if !cfg.Poll.ProgressComments {
+		return
+	}
+	if err := AddComment(cfg, issueKey, "factory: "+msg); err != nil {
+		fmt.Printf("  Warning: could not post progress comment: %v\n", err)
+	}
+}
+
+func processIssue(cfg *Config, issueKey string, opts RunOptions, lease *leaseHandle) *Result {
 	result := &Result{IssueKey: issueKey, Status: "started"}
 
 	fmt.Printf("\n%s\n", strings.Repeat("=", 50))
@@ -24,6 +201,7 @@ func ProcessIssue(cfg *Config, issueKey string) *Result {
 
 	// 1. Fetch issue
 	fmt.Println("→ Fetching issue...")
+	result.enterStage("fetch")
 	issue, err := GetIssue(cfg, issueKey)
 	if err != nil {
 		return fail(result, "fetch", err)
@@ -36,62 +214,491 @@ func ProcessIssue(cfg *Config, issueKey string) *Result {
 		return fail(result, "validate", fmt.Errorf("issue is closed: %s", issue.Status))
 	}
 	fmt.Printf("  Title: %s\n", issue.Title)
+	snapshotIssue(cfg, issue)
+
+	// Triages and estimates read the code as it is on the base branch,
+	// whatever the issue's PRs
+	switch {
+	case opts.Triage || !opts.Estimate && cfg.Triage.applies(issue):
+		return triageIssue(withBase(cfg, baseBranch(cfg, issue, opts.Base)), issue, opts.Request, result)
+	case opts.Estimate || cfg.Estimate.applies(issue):
+		return estimateIssue(withBase(cfg, baseBranch(cfg, issue, opts.Base)), issue, opts.Request, result)
+	}
+
+	// An open PR means the issue was worked on before, perhaps by a run
+	// whose record was cleared since; a second one would duplicate it
+	var update *issuePR
+	if !cfg.Poll.ObserveOnly {
+		pr, err := findOpenPR(cfg, issueKey)
+		switch {
+		case err != nil && opts.Update:
+			return fail(result, "update", err)
+		case err != nil:
+			fmt.Printf("  Warning: could not check for an open PR: %v\n", err)
+		case opts.Update && pr == nil:
+			return fail(result, "update", fmt.Errorf("%s has no open PR to update", issueKey))
+		case opts.Update:
+			fmt.Printf("  Updating PR: %s\n", pr.URL)
+			update = pr
+			result.PRUrl = pr.URL
+			opts.Base = pr.Base
+		case pr != nil:
+			fmt.Printf("  Already has an open PR: %s\n", pr.URL)
+			result.PRUrl = pr.URL
+			result.Status = "completed"
+			fmt.Printf("\n✓ Completed: %s (existing PR)\n", issueKey)
+			return result
+		}
+	}
+
+	if b := baseBranch(cfg, issue, opts.Base); b != cfg.Repo.DefaultBranch {
+		fmt.Printf("  Base: %s\n", b)
+		cfg = withBase(cfg, b)
+		result.Base = b
+	}
+
+	if cfg.Poll.ObserveOnly {
+		return previewIssue(cfg, issue, result)
+	}
+
+	// An issue to plan first is implemented once its plan is approved;
+	// follow-up commits to its open PR aren't planned again
+	var plan *issuePlan
+	if update == nil && cfg.Plan.applies(issue) {
+		if plan = loadPlan(issueKey); plan == nil || !plan.approved() {
+			return planIssue(cfg, issue, opts.Request, result)
+		}
+		fmt.Printf("  Plan approved by %s\n", plan.ApprovedBy)
+	}
+
+	if cfg.Poll.AssignTo != "" {
+		if err := Assign(cfg, issueKey, cfg.Poll.AssignTo); err != nil {
+			return fail(result, "assign", err)
+		}
+	}
+	transition(cfg, issueKey, cfg.Transitions.OnStart)
 
 	// 2. Setup git
 	fmt.Println("→ Setting up git...")
+	result.enterStage("setup")
 	git := NewGit(cfg)
+	hook := hookContext{Issue: issue, RepoPath: git.Path(), Base: cfg.Repo.DefaultBranch}
+	if err := runHook(cfg, HookPreClone, hook); err != nil {
+		return fail(result, "hook", err)
+	}
 	if err := git.Init(); err != nil {
 		return fail(result, "git", err)
 	}
 
-	branchName, err := git.CreateBranch(issueKey, issue.Title)
-	if err != nil {
-		return fail(result, "branch", err)
+	// An interrupted run's session, or a failed run being retried, continues
+	// in the workspace as it was left
+	session := resumableSession(cfg, git, issueKey)
+	retry := pendingRetry(git, issueKey)
+	if retry != nil && (retry.Stage == "push" || retry.Stage == "pr") {
+		return retryPublish(cfg, git, issue, hook, retry, result)
+	}
+	var branchName string
+	switch {
+	case session != nil:
+		branchName = session.Branch
+		fmt.Printf("  Resuming agent session %s\n", session.SessionID)
+	case retry != nil:
+		branchName = retry.Branch
+		fmt.Printf("  Continuing the changes left by the run that failed at %s\n", retry.Stage)
+	case update != nil:
+		if err := git.EnsureClean(issueKey, cfg.Repo.StashDirty); err != nil {
+			return fail(result, "workspace", err)
+		}
+		branchName = update.Branch
+		if err := git.CheckoutPR(branchName, issueKey); err != nil {
+			return fail(result, "branch", err)
+		}
+	default:
+		if err := git.EnsureClean(issueKey, cfg.Repo.StashDirty); err != nil {
+			return fail(result, "workspace", err)
+		}
+		if branchName, err = BranchName(cfg, issue); err != nil {
+			return fail(result, "branch", err)
+		}
+		hasOpenPR, err := openPRBranches(cfg)
+		if err != nil {
+			return fail(result, "branch", err)
+		}
+		if branchName, err = git.CreateBranch(branchName, issueKey, hasOpenPR); err != nil {
+			return fail(result, "branch", err)
+		}
 	}
 	fmt.Printf("  Branch: %s\n", branchName)
+	progress(cfg, issueKey, fmt.Sprintf("branch created: %s", branchName))
+	hook.Branch = branchName
+	result.branch = branchName
 
-	// 3. Run Claude Code
+	scope := componentPaths(cfg, issue)
+	if len(scope) > 0 {
+		fmt.Printf("  Scope: %s\n", strings.Join(scope, ", "))
+	}
+	var sparse []string
+	if cfg.Repo.SparseCheckout {
+		sparse = scope
+	}
+	if err := git.SparseCheckout(sparse); err != nil {
+		return fail(result, "git", err)
+	}
+
+	if len(issue.Attachments) > 0 {
+		fmt.Printf("→ Downloading %d attachment(s)...\n", len(issue.Attachments))
+		result.enterStage("attachments")
+		if err := git.Exclude(contextDirName + "/"); err != nil {
+			return fail(result, "attachments", err)
+		}
+		contextDir := filepath.Join(git.Path(), contextDirName)
+		defer os.RemoveAll(contextDir)
+		if err := DownloadAttachments(cfg, issue, filepath.Join(contextDir, issueKey)); err != nil {
+			return fail(result, "attachments", err)
+		}
+	}
+
+	// 3. Run Claude Code. preAgent's own changes (installed deps) are part of
+	// the snapshot and never committed; postAgent's (codegen) are.
+	if err := runHook(cfg, HookPreAgent, hook); err != nil {
+		return fail(result, "hook", err)
+	}
+	before, err := git.Snapshot()
+	if err != nil {
+		return fail(result, "git", err)
+	}
+	resume := ""
+	switch {
+	case session != nil:
+		before, resume = session.Before, session.SessionID
+	case retry != nil:
+		before = retry.Before
+	}
+	result.before = before
+	if lease.cancelled() {
+		return cancelRun(git, nil, result, lease)
+	}
 	fmt.Println("→ Running Claude Code...")
-	if err := runClaude(git.Path(), issue); err != nil {
-		return fail(result, "claude", err)
+	result.enterStage("prompt")
+	progress(cfg, issueKey, "implementation running")
+	variant := pickPromptVariant(cfg)
+	if variant != nil {
+		result.Variant = variant.Name
+		fmt.Printf("  Prompt variant: %s\n", variant.Name)
+	}
+	prompt, err := buildPrompt(cfg, git, issue, variant, opts.Request)
+	if err != nil {
+		return fail(result, "template", err)
+	}
+	if plan != nil {
+		prompt += planNote(plan)
+	}
+	if retry != nil {
+		prompt += retryNote(retry)
+	}
+	if update != nil {
+		comments, err := getReviewComments(cfg, update.URL)
+		if err != nil {
+			return fail(result, "review", err)
+		}
+		if last, err := git.LastCommitTime(); err == nil {
+			comments = latestReview(comments, last)
+		}
+		fmt.Printf("  Review comments: %d\n", len(comments))
+		prompt += updateNote(update, comments)
 	}
 
-	// 4. Commit & Push
-	if git.HasChanges() {
-		fmt.Println("→ Committing changes...")
-		msg := fmt.Sprintf("%s: %s\n\nImplemented via factory", issueKey, issue.Title)
-		if err := git.CommitAndPush(branchName, msg); err != nil {
-			return fail(result, "push", err)
+	// A model that fails, changes nothing, or can't get the tests passing
+	// hands the run to the next one in agent.models, starting over from a
+	// clean workspace
+	models := cfg.Agent.models()
+	startModel := ""
+	switch {
+	case session != nil:
+		startModel = session.Model
+	case retry != nil:
+		startModel = retry.Model
+	}
+	if startModel != "" {
+		for i, m := range models {
+			if m == startModel {
+				models = models[i:]
+				break
+			}
+		}
+	}
+	transcript := openTranscript(issueKey)
+	defer transcript.Close()
+
+	// A test-first run writes failing tests before the implementation;
+	// they are kept when escalating, and the checks must pass with them
+	var red *tddRed
+	if cfg.TDD.applies(issue) && session == nil && retry == nil && update == nil {
+		var stage string
+		red, stage, err = writeFailingTest(cfg, git, prompt, models[0], before, issue, result, lease, transcript)
+		if stage == "cancelled" {
+			all, _ := git.ChangedSince(before)
+			return cancelRun(git, all, result, lease)
+		}
+		if err != nil {
+			return fail(result, stage, err)
+		}
+		prompt += red.note()
+	}
+
+	var changed, artifacts, matched []string
+	agentStart := time.Now()
+	for i, model := range models {
+		last := i == len(models)-1
+		if model != "" {
+			result.Models = append(result.Models, model)
+			fmt.Printf("  Model: %s\n", model)
+		}
+		all, stage, err := implement(cfg, git, prompt, model, before, resume, hook, result, lease, transcript)
+		resume = ""
+		if stage == "cancelled" {
+			return cancelRun(git, all, result, lease)
+		}
+		// A session cut short by the agent failing is kept for the next run
+		if stage != "claude" {
+			clearAgentSession(issueKey)
+		}
+		if err != nil && (last || (stage != "claude" && stage != "verify")) {
+			var failure *checkFailure
+			if errors.As(err, &failure) {
+				attachCheckOutput(cfg, issueKey, failure)
+			}
+			return fail(result, stage, err)
+		}
+		changed, artifacts, matched = FilterArtifacts(all, artifactPatterns(cfg))
+		reason := "no changes"
+		if err != nil {
+			reason = truncate(200, strings.SplitN(err.Error(), "\n", 2)[0])
+		} else if len(changed) > 0 || last {
+			break
+		}
+		if err := issueBudgetErr(cfg, result); err != nil {
+			return fail(result, "budget", fmt.Errorf("%w; not escalating to %s after %s", err, models[i+1], reason))
+		}
+		fmt.Printf("→ Escalating to %s (%s)\n", models[i+1], reason)
+		progress(cfg, issueKey, fmt.Sprintf("escalating to %s: %s", models[i+1], reason))
+		clearAgentSession(issueKey)
+		var discard []string
+		for _, f := range all {
+			if !red.keeps(f) {
+				discard = append(discard, f)
+			}
+		}
+		if err := git.Discard(discard); err != nil {
+			return fail(result, "git", err)
+		}
+	}
+	agentTime := time.Since(agentStart)
+	if lease.cancelled() {
+		return cancelRun(git, append(changed, artifacts...), result, lease)
+	}
+
+	concerns := ""
+	if cfg.SelfReview.Enabled && len(changed) > 0 {
+		var stage string
+		concerns, stage, err = selfReview(cfg, git, issue, changed, result, lease, transcript)
+		if stage == "cancelled" {
+			all, _ := git.ChangedSince(before)
+			return cancelRun(git, all, result, lease)
+		}
+		if err != nil {
+			return fail(result, stage, err)
+		}
+		all, err := git.ChangedSince(before)
+		if err != nil {
+			return fail(result, "git", err)
+		}
+		changed, artifacts, matched = FilterArtifacts(all, artifactPatterns(cfg))
+	}
+
+	// 4. Commit & Push only what the agent touched
+	if outside := outOfScope(changed, scope); len(outside) > 0 {
+		return fail(result, "scope", fmt.Errorf("changed files outside %s: %s",
+			strings.Join(scope, ", "), strings.Join(outside, ", ")))
+	}
+	if cfg.TestsOnly.applies(issue) {
+		if outside := notTests(cfg, changed); len(outside) > 0 {
+			return fail(result, "scope", fmt.Errorf("tests-only run changed files that aren't tests: %s", strings.Join(outside, ", ")))
 		}
+	}
+	if len(artifacts) > 0 {
+		fmt.Printf("  Excluding %d build artifact(s) (%s)\n", len(artifacts), strings.Join(matched, ", "))
+		// A tests-only run commits nothing but tests, and a scoped one
+		// nothing outside its paths, .gitignore included
+		amend := cfg.Repo.AmendGitignore && !cfg.TestsOnly.applies(issue) && len(outOfScope([]string{".gitignore"}, scope)) == 0
+		if amend && len(changed) > 0 {
+			amended, err := git.AmendGitignore(matched)
+			if err != nil {
+				return fail(result, "git", err)
+			}
+			if amended {
+				changed = append(changed, ".gitignore")
+			}
+		}
+	}
+	if len(changed) > 0 {
+		data := newTemplateData(cfg, git.Path(), issue)
+		data.Branch = branchName
+		data.TDD = formatTDD(red)
+		data.SelfReview = formatSelfReview(concerns)
 
-		// 5. Create PR
-		fmt.Println("→ Creating PR...")
-		prTitle := fmt.Sprintf("[%s] %s", issueKey, issue.Title)
-		prBody := FormatPRBody(issue, cfg.Jira.BaseURL)
-		prURL, err := CreatePR(cfg, prTitle, prBody, branchName, cfg.Repo.DefaultBranch)
+		fmt.Printf("→ Committing %d changed file(s)...\n", len(changed))
+		result.enterStage("commit")
+		msg, err := RenderTemplate(cfg, TemplateCommit, data)
 		if err != nil {
-			return fail(result, "pr", err)
+			return fail(result, "template", err)
+		}
+		hook.Files = changed
+		if err := runHook(cfg, HookPrePush, hook); err != nil {
+			return fail(result, "hook", err)
+		}
+		if len(cfg.Scan.Scanners) > 0 {
+			if err := scanChange(cfg, git, issue, changed, result); err != nil {
+				return fail(result, "scan", err)
+			}
+		}
+		if cfg.Packets.Enabled {
+			return packageForReview(cfg, git, issue, data, msg, prompt, changed, result)
+		}
+		if err := ValidateRules(cfg, git, branchName, msg, changed); err != nil {
+			return fail(result, "rules", err)
+		}
+		var resolve ConflictResolver
+		if cfg.Repo.ResolveConflicts {
+			resolve = conflictResolver(cfg, git, issue, result, lease, transcript)
+		}
+		err = git.CommitAndPushResolving(branchName, msg, changed, resolve)
+		saveBranchDiff(git, issueKey)
+		if err != nil {
+			var conflict *ConflictError
+			switch stepStopped(err) {
+			case "security":
+				return fail(result, "security", err)
+			case "cancelled":
+				return cancelRun(git, nil, result, lease)
+			}
+			switch {
+			case errors.Is(err, errScanBlocked):
+				return fail(result, "scan", err)
+			case errors.As(err, &conflict):
+				return fail(result, "conflict", err)
+			}
+			return fail(result, "push", err)
 		}
-		result.PRUrl = prURL
-		fmt.Printf("  PR: %s\n", prURL)
 
-		// 6. Update Jira
-		fmt.Println("→ Updating Jira...")
-		AddComment(cfg, issueKey, fmt.Sprintf("PR raised: %s", prURL))
-		if cfg.Poll.AutoTransition {
-			Transition(cfg, issueKey, "In Progress")
+		if update != nil {
+			return updatePR(cfg, git, issue, update, hook, result, agentTime)
 		}
-	} else {
-		fmt.Println("  No changes detected")
+		return openPR(cfg, git, issue, data, hook, changed, result, agentTime)
 	}
 
+	fmt.Println("  No changes detected")
+	progress(cfg, issueKey, "implementation finished without changes; no PR opened")
 	result.Status = "completed"
 	fmt.Printf("\n✓ Completed: %s\n", issueKey)
 	return result
 }
 
+// openPR opens the PR for a pushed branch and reports it on the issue
+func openPR(cfg *Config, git *Git, issue *Issue, data *TemplateData, hook hookContext, changed []string, result *Result, agentTime time.Duration) *Result {
+	// 5. Create PR
+	fmt.Println("→ Creating PR...")
+	result.enterStage("pr")
+	prTitle, err := RenderTemplate(cfg, TemplatePRTitle, data)
+	if err != nil {
+		return fail(result, "template", err)
+	}
+	prBody, err := RenderTemplate(cfg, TemplatePRBody, data)
+	if err != nil {
+		return fail(result, "template", err)
+	}
+	prBody = MergePRTemplate(git.Path(), prBody)
+	prURL, err := CreatePR(cfg, prTitle, prBody, data.Branch, cfg.Repo.DefaultBranch)
+	if err != nil {
+		return fail(result, "pr", err)
+	}
+	result.PRUrl = prURL
+	result.Draft = cfg.GitHub.DraftPR
+	data.PRURL = prURL
+	fmt.Printf("  PR: %s\n", prURL)
+	AssignReviewersAndLabels(cfg, git.Path(), prURL, issue, changed)
+	hook.PRURL = prURL
+	if err := runHook(cfg, HookPostPR, hook); err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+	}
+
+	// 6. Update Jira
+	fmt.Println("→ Updating Jira...")
+	result.enterStage("jira")
+	data.Cost = formatCost(result.CostUSD, result.InputTokens, result.OutputTokens)
+	data.CostUSD = result.CostUSD
+	comment, err := RenderTemplate(cfg, TemplateComment, data)
+	if err != nil {
+		comment = fmt.Sprintf("PR raised: %s", prURL)
+	}
+	AddComment(cfg, issue.Key, comment)
+	if field := cfg.Jira.Fields.PRURL; field != "" {
+		if err := SetField(cfg, issue.Key, field, prURL); err != nil {
+			fmt.Printf("  Warning: could not set %s: %v\n", field, err)
+		}
+	}
+	// A retry that only publishes an earlier run's commit has no effort to add
+	if agentTime > 0 {
+		writeEffort(cfg, git, issue.Key, agentTime)
+	}
+	if cfg.Jira.RemoteLink {
+		if err := AddRemoteLinkREST(cfg, issue.Key, prURL, prTitle); err != nil {
+			fmt.Printf("  Warning: could not link PR on %s: %v\n", issue.Key, err)
+		}
+	}
+	transition(cfg, issue.Key, cfg.Transitions.OnPRCreated)
+
+	result.Status = "completed"
+	fmt.Printf("\n✓ Completed: %s\n", issue.Key)
+	return result
+}
+
+// writeEffort records the run's actual effort in the mapped jira.fields
+// effort fields
+func writeEffort(cfg *Config, git *Git, issueKey string, agentTime time.Duration) {
+	f := cfg.Jira.Fields
+	if f.AgentMinutes == "" && f.FilesChanged == "" && f.LinesChanged == "" {
+		return
+	}
+	files, lines, err := git.CommitStats()
+	if err != nil {
+		fmt.Printf("  Warning: could not count changes: %v\n", err)
+		f.FilesChanged, f.LinesChanged = "", ""
+	}
+	for _, e := range []struct {
+		field string
+		value float64
+	}{
+		{f.AgentMinutes, math.Round(agentTime.Minutes()*10) / 10},
+		{f.FilesChanged, float64(files)},
+		{f.LinesChanged, float64(lines)},
+	} {
+		if e.field == "" {
+			continue
+		}
+		if err := SetNumberField(cfg, issueKey, e.field, e.value); err != nil {
+			fmt.Printf("  Warning: could not set %s: %v\n", e.field, err)
+		}
+	}
+}
+
+// contextDirName is the scratch directory inside the workspace holding
+// downloaded issue context; it is git-excluded and removed after each run
+const contextDirName = ".factory-context"
+
 func fail(result *Result, stage string, err error) *Result {
 	result.Status = "failed"
+	result.Stage = stage
 	result.Error = fmt.Sprintf("%s: %v", stage, err)
 	fmt.Printf("\n✗ Failed at %s: %v\n", stage, err)
 	return result
@@ -108,52 +715,435 @@ func formatComments(comments []Comment) string {
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
-func runClaude(repoPath string, issue *Issue) error {
-	prompt := fmt.Sprintf(`Implement the following Jira issue:
+// previewIssue plans an issue read-only and reports the plan as a Jira comment
+func previewIssue(cfg *Config, issue *Issue, result *Result) *Result {
+	fmt.Println("→ Observer mode: planning only")
+	result.enterStage("setup")
+	git := NewGit(cfg)
+	if err := git.Init(); err != nil {
+		return fail(result, "git", err)
+	}
+	if err := git.EnsureClean(issue.Key, cfg.Repo.StashDirty); err != nil {
+		return fail(result, "workspace", err)
+	}
+	if err := git.Pull(); err != nil {
+		return fail(result, "git", err)
+	}
+
+	fmt.Println("→ Running Claude Code (plan only)...")
+	result.enterStage("plan")
+	plan, err := runClaudePlan(cfg, git, issue, "", planInstructions)
+	if err != nil {
+		return fail(result, "claude", err)
+	}
+	branchName, err := BranchName(cfg, issue)
+	if err != nil {
+		return fail(result, "branch", err)
+	}
+
+	comment := fmt.Sprintf(`[preview] factory would process this issue:
+- Branch: %s
+- PR: [%s] %s → %s
+
+Plan:
+%s`,
+		branchName,
+		issue.Key, issue.Title, cfg.Repo.DefaultBranch,
+		plan)
+	fmt.Println("→ Posting preview to Jira...")
+	result.enterStage("jira")
+	if err := AddComment(cfg, issue.Key, comment); err != nil {
+		fmt.Printf("  Warning: could not post preview: %v\n", err)
+	}
+
+	result.Status = "previewed"
+	fmt.Printf("\n✓ Previewed: %s\n", issue.Key)
+	return result
+}
+
+func formatEstimate(points float64) string {
+	if points == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" | **Story Points**: %g", points)
+}
+
+func formatVariables(vars map[string]string) string {
+	if len(vars) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("## %s\n%s", name, vars[name]))
+	}
+	return "\n" + strings.Join(parts, "\n\n") + "\n"
+}
+
+func formatLinks(links []IssueLink) string {
+	if len(links) == 0 {
+		return "No linked issues"
+	}
+	var lines []string
+	for _, l := range links {
+		state := l.Status
+		if l.Resolution != "" {
+			state += ", " + l.Resolution
+		}
+		lines = append(lines, fmt.Sprintf("- %s %s: %s (%s)", l.Relation, l.Key, l.Title, state))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatAttachments(repoPath string, attachments []Attachment) string {
+	var lines []string
+	for _, a := range attachments {
+		if a.LocalPath == "" {
+			continue
+		}
+		rel, err := filepath.Rel(repoPath, a.LocalPath)
+		if err != nil {
+			rel = a.LocalPath
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s, original name: %s)", rel, a.MimeType, a.Filename))
+	}
+	if len(lines) == 0 {
+		return "No attachments"
+	}
+	return "Files attached to the issue (screenshots, logs, stack traces) were saved locally.\n" +
+		"Read them for reproduction details. Do not commit or modify them.\n" +
+		strings.Join(lines, "\n")
+}
+
+// buildPrompt renders the prompt, from the variant's template when the run
+// is part of a prompt experiment, after the repo's own context file if any
+func buildPrompt(cfg *Config, git *Git, issue *Issue, variant *PromptVariant, request string) (string, error) {
+	src, err := promptSource(cfg, variant)
+	if err != nil {
+		return "", err
+	}
+	data := newTemplateData(cfg, git.Path(), issue)
+	data.Related = relatedWork(cfg, git, issue)
+	data.Request = formatRequest(request)
+	prompt, err := renderText(TemplatePrompt, src, data)
+	if err != nil {
+		return "", err
+	}
+	return withRepoContext(git.Path(), prompt), nil
+}
+
+// implement runs the agent with one model and then the verify checks,
+// feeding a failing check's output back to the agent for up to
+// verify.maxAttempts runs. With resume set, the first run continues that
+// Claude Code session. It returns the paths changed since before, and the
+// stage and error that stopped it, if any.
+func implement(cfg *Config, git *Git, prompt, model string, before Snapshot, resume string, hook hookContext, result *Result, lease *leaseHandle, transcript io.Writer) ([]string, string, error) {
+	next := prompt
+	if resume != "" {
+		next = resumePrompt
+	}
+	resumes := 0
+	for attempt := 1; ; attempt++ {
+		result.enterStage("agent")
+		session := ""
+		final, err := runAgentStep(cfg, git, agentRun{prompt: next, model: model, resume: resume, onProgress: func(p AgentProgress) {
+			if cfg.Agent.Resume && p.SessionID != "" && p.SessionID != session {
+				session = p.SessionID
+				saveAgentSession(hook.Issue.Key, agentSession{SessionID: p.SessionID, Branch: hook.Branch, Model: model, Before: before})
+			}
+		}}, hook.Issue, result, lease, transcript)
+		if final.SessionID != "" {
+			result.SessionID = final.SessionID
+		}
+		switch stepStopped(err) {
+		case "security":
+			return nil, "security", err
+		case "cancelled":
+			all, _ := git.ChangedSince(before)
+			return all, "cancelled", errCancelled
+		}
+		var timeout *agentTimeout
+		switch {
+		case err == nil:
+		case errors.As(err, &timeout) && cfg.Agent.Resume && final.SessionID != "" && resumes < cfg.Agent.maxResumes():
+			resumes++
+			fmt.Printf("→ %v; resuming session %s (%d of %d)\n", err, final.SessionID, resumes, cfg.Agent.maxResumes())
+			resume, next = final.SessionID, resumePrompt
+			attempt--
+			continue
+		case resume != "" && final.SessionID == "":
+			// The session couldn't be loaded, e.g. it was started on another host
+			fmt.Printf("→ Could not resume session %s (%v); starting over\n", resume, err)
+			resume, next = "", prompt
+			attempt--
+			continue
+		}
+		resume = ""
+		if err == nil {
+			if err := runHook(cfg, HookPostAgent, hook); err != nil {
+				return nil, "hook", err
+			}
+		}
+		all, cerr := git.ChangedSince(before)
+		if cerr != nil {
+			return nil, "git", cerr
+		}
+		if err != nil {
+			return all, "claude", err
+		}
+		checks := verifyChecks(cfg, git.Path())
+		if len(checks) == 0 || len(all) == 0 {
+			return all, "", nil
+		}
+
+		result.enterStage("verify")
+		failed, output, err := runChecks(checks, git.Path())
+		if failed == nil {
+			fmt.Println("  Checks passed")
+			return all, "", nil
+		}
+		if lease.cancelled() {
+			return all, "cancelled", errCancelled
+		}
+		if attempt >= cfg.Verify.maxAttempts() {
+			return all, "verify", &checkFailure{Check: *failed, Output: output, Attempts: attempt, Err: err}
+		}
+		if berr := issueBudgetErr(cfg, result); berr != nil {
+			return all, "budget", fmt.Errorf("%w; %s still failing", berr, failed.Name)
+		}
+		fmt.Printf("→ %s failed; sending the output back to the agent (attempt %d of %d)\n", failed.Name, attempt+1, cfg.Verify.maxAttempts())
+		progress(cfg, hook.Issue.Key, fmt.Sprintf("%s failed, retrying (attempt %d of %d)", failed.Name, attempt+1, cfg.Verify.maxAttempts()))
+		next = checkFeedback(prompt, *failed, output)
+	}
+}
+
+// agentTimeout is the error of an agent run stopped by its timeout
+type agentTimeout struct {
+	after time.Duration
+}
+
+func (t *agentTimeout) Error() string {
+	return fmt.Sprintf("timeout after %s", t.after)
+}
+
+// agentTools are the tools a run's agent works with
+const agentTools = "Read,Glob,Grep,Edit,Write,Bash"
+
+// agentRun is one agent invocation within a run
+type agentRun struct {
+	prompt, model string
+	// resume continues that Claude Code session
+	resume string
+	// tools limits the step to some of agentTools, default all of them
+	tools string
+	// onProgress sees each progress report before the lease does
+	onProgress func(AgentProgress)
+}
+
+// runAgentStep runs an agent step of the issue's run in the workspace,
+// reporting its progress on the lease and adding its spend to the result.
+// It returns the agent's last progress report. A guard violation is
+// returned as it is and a cancelled run as errCancelled; see stepStopped.
+func runAgentStep(cfg *Config, git *Git, step agentRun, issue *Issue, result *Result, lease *leaseHandle, transcript io.Writer) (AgentProgress, error) {
+	var final AgentProgress
+	err := runClaude(cfg, git.Path(), step.prompt, step.model, step.resume, step.tools, cfg.Agent.timeout(issue), lease.cancel, transcript, func(p AgentProgress) {
+		if step.onProgress != nil {
+			step.onProgress(p)
+		}
+		final = p
+		lease.setProgress(p)
+	})
+	result.CostUSD += final.CostUSD
+	result.InputTokens += final.InputTokens
+	result.OutputTokens += final.OutputTokens
+	var violation *guardViolation
+	if !errors.As(err, &violation) && (errors.Is(err, errCancelled) || lease.cancelled()) {
+		err = errCancelled
+	}
+	return final, err
+}
+
+// stepStopped returns the stage an agent step's error stops the run at:
+// "security" for a guard violation, "cancelled" for a cancelled run, and ""
+// for anything else, which the step handles itself
+func stepStopped(err error) string {
+	var violation *guardViolation
+	switch {
+	case errors.As(err, &violation):
+		return "security"
+	case errors.Is(err, errCancelled):
+		return "cancelled"
+	}
+	return ""
+}
+
+// runClaude runs Claude Code with the prompt, copying its raw output to
+// transcript and reporting its progress after every tool call. A non-empty
+// resume continues that session. tools limits the agent to some of
+// agentTools; "" allows all of them. After timeout, the agent and
+// everything it started are stopped, and the same when cancel is closed.
+func runClaude(cfg *Config, repoPath, prompt, model, resume, tools string, timeout time.Duration, cancel <-chan struct{}, transcript io.Writer, onProgress func(AgentProgress)) error {
+	if tools == "" {
+		tools = agentTools
+	}
+	args, err := claudeArgs(cfg, prompt, tools)
+	if err != nil {
+		return err
+	}
+	args = append(args, "--dangerously-skip-permissions", "--output-format", "stream-json", "--verbose")
+	// Skipping permissions allows every tool; denied ones stay denied
+	if denied := deniedTools(tools); len(denied) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(denied, ","))
+	}
+	if model != "" {
+		args = append(args, "--model", model)
+	}
+	if resume != "" {
+		args = append(args, "--resume", resume)
+	}
+	hooks := []string{hookGuard}
+	if NewGit(cfg).at(repoPath).IsShallow() {
+		// The hook fetches the history before the first command reading it
+		hooks = append(hooks, hookUnshallow)
+	}
+	settings, err := toolHookSettings(repoPath, hooks...)
+	if err != nil {
+		return err
+	}
+	args = append(args, "--settings", settings)
+	cmd := exec.Command("claude", args...)
+	cmd.Dir = repoPath
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := startGroup(cmd); err != nil {
+		return err
+	}
+	defer endGroup(cmd)
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		killGroup(cmd)
+	})
+	defer timer.Stop()
+	var cancelled atomic.Bool
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-cancel:
+			cancelled.Store(true)
+			killGroup(cmd)
+		case <-exited:
+		}
+	}()
 
-## %s: %s
+	guard := newAgentGuard(cfg, repoPath)
+	onToolCall := func(tool string, input map[string]interface{}) error {
+		if !toolAllowed(tools, tool) {
+			return &guardViolation{Tool: tool, Reason: "not allowed in this step"}
+		}
+		return guard.check(tool, input)
+	}
+	streamErr := followAgentStream(io.TeeReader(stdout, transcript), onToolCall, onProgress)
+	var violation *guardViolation
+	if errors.As(streamErr, &violation) {
+		abortGroup(cmd)
+		cmd.Wait()
+		return streamErr
+	}
+	if err := cmd.Wait(); err != nil {
+		if cancelled.Load() {
+			return errCancelled
+		}
+		if timedOut.Load() {
+			return &agentTimeout{after: timeout}
+		}
+		return err
+	}
+	return streamErr
+}
 
-**Type**: %s | **Priority**: %s
+// deniedTools returns the agentTools left out of tools
+func deniedTools(tools string) []string {
+	var denied []string
+	for _, t := range strings.Split(agentTools, ",") {
+		if !toolAllowed(tools, t) {
+			denied = append(denied, t)
+		}
+	}
+	return denied
+}
 
-## Description
-%s
+// toolAllowed reports whether tools allows a tool. Only agentTools are
+// limited; MCP tools are allowed by claudeArgs.
+func toolAllowed(tools, tool string) bool {
+	if !strings.Contains(","+agentTools+",", ","+tool+",") {
+		return true
+	}
+	return strings.Contains(","+tools+",", ","+tool+",")
+}
 
-## Acceptance Criteria
-%s
+// planInstructions end the prompt of runClaudePlan
+const planInstructions = `
 
-## Comments (Additional Context/Instructions)
-%s
+Do NOT modify any files. Instead, respond with a concise plan: the files
+you would change, what you would change in each, and any open questions.`
 
-## Instructions
-1. Analyze the codebase
-2. Review the comments above for additional context or specific instructions
-3. Implement the required changes
-4. Add/update tests if needed
-5. Keep changes minimal and focused
-6. Add TODO comments for ambiguous parts`,
-		issue.Key, issue.Title,
-		issue.Type, issue.Priority,
-		issue.Description,
-		issue.AcceptanceCriteria,
-		formatComments(issue.Comments))
+// runClaudePlan asks Claude for an implementation plan using read-only
+// tools; instructions say what to respond with instead of changes
+func runClaudePlan(cfg *Config, git *Git, issue *Issue, request, instructions string) (string, error) {
+	prompt, err := buildPrompt(cfg, git, issue, nil, request)
+	if err != nil {
+		return "", err
+	}
+	return runClaudeReadOnly(cfg, git.Path(), prompt+instructions, readOnlyTools, cfg.Agent.timeout(issue))
+}
 
-	cmd := exec.Command("claude",
-		"-p", prompt,
-		"--allowedTools", "Read,Glob,Grep,Edit,Write,Bash",
-		"--dangerously-skip-permissions",
-	)
+// readOnlyTools let the agent read the repo but change nothing
+const readOnlyTools = "Read,Glob,Grep"
+
+// runClaudeReadOnly runs Claude in repoPath with tools, which must change
+// nothing, and returns its response. The git commands tools allow, such as
+// triageTools', are checked by the git-history tool hook.
+func runClaudeReadOnly(cfg *Config, repoPath, prompt, tools string, timeout time.Duration) (string, error) {
+	args, err := claudeArgs(cfg, prompt, tools)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(tools, "Bash(") {
+		settings, err := toolHookSettings(repoPath, hookGitHistory)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, "--settings", settings)
+	}
+	cmd := exec.Command("claude", args...)
 	cmd.Dir = repoPath
-	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := startGroup(cmd); err != nil {
+		return "", err
+	}
+	defer endGroup(cmd)
 
-	done := make(chan error)
-	go func() { done <- cmd.Run() }()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
 
 	select {
 	case err := <-done:
-		return err
-	case <-time.After(10 * time.Minute):
-		cmd.Process.Kill()
-		return fmt.Errorf("timeout after 10 minutes")
+		return strings.TrimSpace(out.String()), err
+	case <-time.After(timeout):
+		killGroup(cmd)
+		return "", fmt.Errorf("timeout after %s", timeout)
 	}
 }
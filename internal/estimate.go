@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+)
+
+// EstimateConfig has the daemon estimate labelled issues instead of
+// implementing them, like `factory estimate`
+type EstimateConfig struct {
+	// Labels marks issues to estimate, e.g. "needs-estimate". Once an
+	// estimated issue loses its label, it is queued to be implemented.
+	Labels []string `json:"labels,omitempty"`
+}
+
+// applies reports whether the issue is to be estimated rather than
+// implemented
+func (c EstimateConfig) applies(issue *Issue) bool {
+	return issue.hasLabel(c.Labels)
+}
+
+// estimateInstructions end the prompt of an estimate
+const estimateInstructions = `
+
+Do NOT modify any files. Instead, study the code this issue touches and
+estimate the work. Respond with:
+- Effort: story points (1, 2, 3, 5, 8, or 13) and rough hours for a developer
+  who knows the codebase
+- Complexity: low, medium, or high, and what makes it so
+- Suggested approach: the files to change and what to change in each
+- Risks and open questions`
+
+// estimateIssue has the agent estimate the issue read-only and posts the
+// estimate on the issue; nothing is changed in the repo
+func estimateIssue(cfg *Config, issue *Issue, request string, result *Result) *Result {
+	fmt.Println("→ Estimating only")
+	result.enterStage("setup")
+	git := NewGit(cfg)
+	if err := git.Init(); err != nil {
+		return fail(result, "git", err)
+	}
+	if err := git.EnsureClean(issue.Key, cfg.Repo.StashDirty); err != nil {
+		return fail(result, "workspace", err)
+	}
+	if err := git.Pull(); err != nil {
+		return fail(result, "git", err)
+	}
+
+	fmt.Println("→ Running Claude Code (estimate only)...")
+	result.enterStage("estimate")
+	estimate, err := runClaudePlan(cfg, git, issue, request, estimateInstructions)
+	if err != nil {
+		return fail(result, "estimate", err)
+	}
+	if estimate == "" {
+		return fail(result, "estimate", fmt.Errorf("the agent returned no estimate"))
+	}
+	fmt.Printf("\n%s\n\n", estimate)
+
+	fmt.Println("→ Posting estimate to Jira...")
+	result.enterStage("jira")
+	if err := AddComment(cfg, issue.Key, "factory: estimate\n\n"+estimate); err != nil {
+		return fail(result, "jira", err)
+	}
+
+	result.Status = "estimated"
+	fmt.Printf("\n✓ Estimated: %s\n", issue.Key)
+	return result
+}
+
+// pollEstimates queues estimated issues that lost their estimate label, to
+// be implemented. It returns the issues queued.
+func pollEstimates(cfg *Config, issues []Issue) []string {
+	if len(cfg.Estimate.Labels) == 0 {
+		return nil
+	}
+	return requeueUnlabelled(cfg, issues, "estimated", cfg.Estimate.applies)
+}
+
+// requeueUnlabelled queues the issues left with status whose labels no
+// longer apply, to be implemented. Only issues updated since their run are
+// looked at, or all of them when the issue list has no update times
+// (jira.useAcli). It returns the issues queued.
+func requeueUnlabelled(cfg *Config, issues []Issue, status string, applies func(*Issue) bool) []string {
+	var queued []string
+	for _, issue := range issues {
+		info, ok := processedEntry(issue.Key)
+		if !ok || info.Status != status || hasLease(cfg, issue.Key) {
+			continue
+		}
+		ranAt, err := time.Parse(time.RFC3339, info.ProcessedAt)
+		if err != nil || !issue.Updated.IsZero() && !issue.Updated.After(ranAt) {
+			continue
+		}
+		current, err := GetIssue(cfg, issue.Key)
+		if err != nil {
+			fmt.Printf("  Warning: could not check %s's labels: %v\n", issue.Key, err)
+			continue
+		}
+		if applies(current) {
+			continue
+		}
+
+		fmt.Printf("%s is %s and lost its label; queueing it\n", issue.Key, status)
+		forgetProcessed(issue.Key)
+		queued = append(queued, enqueue([]Issue{issue})...)
+	}
+	return queued
+}
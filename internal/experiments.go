@@ -0,0 +1,39 @@
+package internal
+
+import "math/rand"
+
+// pickPromptVariant assigns a run to one of templates.promptVariants in
+// proportion to their weights. It returns nil when no experiment is set up.
+func pickPromptVariant(cfg *Config) *PromptVariant {
+	variants := cfg.Templates.PromptVariants
+	total := 0
+	for _, v := range variants {
+		total += variantWeight(v)
+	}
+	if total == 0 {
+		return nil
+	}
+	n := rand.Intn(total)
+	for i := range variants {
+		if n -= variantWeight(variants[i]); n < 0 {
+			return &variants[i]
+		}
+	}
+	return nil
+}
+
+func variantWeight(v PromptVariant) int {
+	if v.Weight == 0 {
+		return 1
+	}
+	return v.Weight
+}
+
+// promptSource returns the prompt template for a variant, falling back to
+// the configured or built-in prompt
+func promptSource(cfg *Config, variant *PromptVariant) (string, error) {
+	if variant == nil || variant.Template == "" {
+		return templateSource(cfg, TemplatePrompt)
+	}
+	return readTemplateFile(TemplatePrompt+" ("+variant.Name+")", variant.Template)
+}
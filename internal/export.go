@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stateExportVersion is bumped when the export format changes incompatibly
+const stateExportVersion = 1
+
+// stateExport is the file written by `factory export` and read by
+// `factory import`. Run logs, transcripts, leases, sessions, and the
+// workspace stay behind; they belong to the machine that made them.
+type stateExport struct {
+	Version    int                       `json:"version"`
+	ExportedAt string                    `json:"exportedAt"`
+	Processed  map[string]ProcessedIssue `json:"processed"`
+	Queue      []QueueItem               `json:"queue"`
+	// Comments are the consolidated Jira comments factory keeps editing
+	Comments map[string]factoryComment `json:"comments,omitempty"`
+	// Lessons are the lessons files, keyed by file name
+	Lessons map[string]string `json:"lessons,omitempty"`
+	Report  *reportState      `json:"report,omitempty"`
+	// Config is included with --config, with its secrets blanked
+	Config *Config `json:"config,omitempty"`
+}
+
+// redactSecrets returns a copy of c without credentials, tokens, or
+// values that commonly carry them
+func redactSecrets(c *Config) *Config {
+	r := *c
+	r.Jira.APIToken = ""
+	r.Jira.PersonalAccessToken = ""
+	if c.Jira.OAuth != nil {
+		o := *c.Jira.OAuth
+		o.ClientSecret = ""
+		r.Jira.OAuth = &o
+	}
+	if c.Jira.Sites != nil {
+		r.Jira.Sites = make([]JiraSite, len(c.Jira.Sites))
+		for i, s := range c.Jira.Sites {
+			s.APIToken, s.PersonalAccessToken = "", ""
+			r.Jira.Sites[i] = s
+		}
+	}
+	r.GitHub.Token = ""
+	r.Server.Token = ""
+	r.Notify.WebhookURL = ""
+	if c.Repo.MCPServers != nil {
+		r.Repo.MCPServers = make(map[string]MCPServer)
+		for name, s := range c.Repo.MCPServers {
+			s.Env = redactValues(s.Env)
+			s.Headers = redactValues(s.Headers)
+			r.Repo.MCPServers[name] = s
+		}
+	}
+	return &r
+}
+
+func redactValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	redacted := make(map[string]string)
+	for k := range m {
+		redacted[k] = ""
+	}
+	return redacted
+}
+
+// ExportState writes processed history, the queue, and other state that
+// should survive a move to another machine to path, and the config with
+// its secrets blanked when withConfig is set
+func ExportState(path string, withConfig bool) error {
+	export := stateExport{
+		Version:    stateExportVersion,
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Processed:  readProcessed(),
+		Queue:      loadQueue(),
+		Comments:   loadFactoryComments(),
+		Lessons:    make(map[string]string),
+	}
+	if export.Queue == nil {
+		export.Queue = []QueueItem{}
+	}
+	files, _ := filepath.Glob(filepath.Join(GetConfigDir(), "lessons", "*.md"))
+	for _, f := range files {
+		if data, err := os.ReadFile(f); err == nil {
+			export.Lessons[filepath.Base(f)] = string(data)
+		}
+	}
+	if data, err := os.ReadFile(GetReportStatePath()); err == nil {
+		var report reportState
+		if json.Unmarshal(data, &report) == nil {
+			export.Report = &report
+		}
+	}
+	if withConfig {
+		// The file as written, without the defaults LoadConfig fills in
+		data, err := os.ReadFile(GetConfigPath())
+		if err != nil {
+			return fmt.Errorf("config not found. Run 'factory configure' first")
+		}
+		var c Config
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+		export.Config = redactSecrets(&c)
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Lessons and PR links are not secret, but they are not public either
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d processed issue(s), %d queued, %d lessons file(s) to %s\n",
+		len(export.Processed), len(export.Queue), len(export.Lessons), path)
+	if withConfig {
+		fmt.Println("Config included with secrets removed; run `factory configure` after importing to enter them")
+	}
+	return nil
+}
+
+// ImportState reads a `factory export` file. By default it merges: the
+// newer run wins for issues processed on both machines, queued issues are
+// added after the local queue, and lessons are appended. replace overwrites
+// the local state instead. A config in the file is only used when none
+// exists here.
+func ImportState(path string, replace bool) error {
+	if pid := GetDaemonPid(); pid > 0 && isRunning(pid) {
+		return fmt.Errorf("daemon is running (PID %d); stop it before importing", pid)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var export stateExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return fmt.Errorf("%s is not a factory export: %w", path, err)
+	}
+	if export.Version == 0 {
+		return fmt.Errorf("%s is not a factory export", path)
+	}
+	if export.Version > stateExportVersion {
+		return fmt.Errorf("%s has unsupported export version %d", path, export.Version)
+	}
+	if err := os.MkdirAll(GetConfigDir(), 0700); err != nil {
+		return err
+	}
+
+	unlock := lockProcessed()
+	list := readProcessed()
+	if replace {
+		list = make(map[string]ProcessedIssue)
+	}
+	for key, info := range export.Processed {
+		if prev, ok := list[key]; !ok || info.ProcessedAt > prev.ProcessedAt {
+			list[key] = info
+		}
+	}
+	data, _ = json.MarshalIndent(list, "", "  ")
+	err = writeProcessed(data)
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	queue := loadQueue()
+	if replace {
+		queue = nil
+	}
+	for _, item := range export.Queue {
+		if queueIndex(queue, item.Key) < 0 {
+			queue = append(queue, item)
+		}
+	}
+	saveQueue(queue)
+
+	comments := loadFactoryComments()
+	if replace {
+		comments = make(map[string]factoryComment)
+	}
+	for key, c := range export.Comments {
+		if _, ok := comments[key]; !ok {
+			comments[key] = c
+		}
+	}
+	saveFactoryComments(comments)
+
+	if err := importLessons(export.Lessons, replace); err != nil {
+		return err
+	}
+	if export.Report != nil {
+		if _, err := os.Stat(GetReportStatePath()); err != nil || replace {
+			data, _ := json.MarshalIndent(export.Report, "", "  ")
+			os.WriteFile(GetReportStatePath(), data, 0644)
+		}
+	}
+
+	fmt.Printf("Imported %d processed issue(s), %d queued, %d lessons file(s) from %s\n",
+		len(export.Processed), len(export.Queue), len(export.Lessons), path)
+	if export.Config == nil {
+		return nil
+	}
+	if ConfigExists() {
+		fmt.Println("Kept the existing config; the exported one was not applied")
+		return nil
+	}
+	if err := SaveConfig(export.Config); err != nil {
+		return err
+	}
+	fmt.Println("Config imported without secrets; run `factory configure` to enter them")
+	return nil
+}
+
+// importLessons writes exported lessons files, appending the lessons a
+// local file doesn't already have unless replace is set
+func importLessons(lessons map[string]string, replace bool) error {
+	if len(lessons) == 0 {
+		return nil
+	}
+	dir := filepath.Join(GetConfigDir(), "lessons")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(lessons))
+	for name := range lessons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Names come from the file; only plain lessons file names are written
+		if name != filepath.Base(name) || !strings.HasSuffix(name, ".md") {
+			fmt.Printf("  Warning: skipping lessons file %q\n", name)
+			continue
+		}
+		path := filepath.Join(dir, name)
+		content := lessons[name]
+		if existing, err := os.ReadFile(path); err == nil && !replace {
+			have := map[string]bool{}
+			for _, line := range strings.Split(string(existing), "\n") {
+				have[strings.TrimSpace(line)] = true
+			}
+			merged := strings.TrimRight(string(existing), "\n") + "\n"
+			for _, line := range strings.Split(content, "\n") {
+				if l := strings.TrimSpace(line); l != "" && !have[l] {
+					merged += line + "\n"
+					have[l] = true
+				}
+			}
+			content = strings.TrimLeft(merged, "\n")
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
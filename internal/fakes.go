@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeJira is an in-memory stand-in for the parts of the Jira REST API the
+// pipeline uses, for `factory selftest`
+type fakeJira struct {
+	*httptest.Server
+	mu       sync.Mutex
+	issues   map[string]*scenarioIssue
+	comments map[string][]string // rendered comment text by issue key
+	fields   map[string]map[string]interface{}
+}
+
+// fakeTransitions are the workflow statuses the fake offers from any status
+var fakeTransitions = []string{"To Do", "In Progress", "In Review", "Done"}
+
+func newFakeJira(issues ...scenarioIssue) *fakeJira {
+	j := &fakeJira{
+		issues:   map[string]*scenarioIssue{},
+		comments: map[string][]string{},
+		fields:   map[string]map[string]interface{}{},
+	}
+	for i := range issues {
+		j.issues[issues[i].Key] = &issues[i]
+	}
+	j.Server = httptest.NewServer(http.HandlerFunc(j.serve))
+	return j
+}
+
+func (j *fakeJira) serve(w http.ResponseWriter, r *http.Request) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// /rest/api/3/issue/KEY[/comment|/transitions|/remotelink|/attachments|...]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rest/api/3/issue/"), "/")
+	if !strings.HasPrefix(r.URL.Path, "/rest/api/3/issue/") {
+		fakeNotFound(w)
+		return
+	}
+	issue := j.issues[parts[0]]
+	if issue == nil {
+		fakeNotFound(w)
+		return
+	}
+	sub := ""
+	if len(parts) > 1 {
+		sub = parts[1]
+	}
+
+	switch {
+	case sub == "" && r.Method == "GET":
+		writeJSON(w, map[string]interface{}{
+			"key": issue.Key,
+			"fields": map[string]interface{}{
+				"summary":     issue.Title,
+				"description": markdownToADF(issue.Description),
+				"issuetype":   map[string]string{"name": issue.Type},
+				"priority":    map[string]string{"name": issue.Priority},
+				"status":      map[string]string{"name": issue.Status},
+				"labels":      issue.Labels,
+				"components":  fakeNames(issue.Components),
+				"fixVersions": fakeNames(issue.FixVersions),
+			},
+		})
+	case sub == "" && r.Method == "PUT":
+		var req struct {
+			Fields map[string]interface{} `json:"fields"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if j.fields[issue.Key] == nil {
+			j.fields[issue.Key] = map[string]interface{}{}
+		}
+		for k, v := range req.Fields {
+			j.fields[issue.Key][k] = v
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case sub == "comment" && r.Method == "GET":
+		writeJSON(w, map[string]interface{}{"comments": []interface{}{}})
+	case sub == "comment" && (r.Method == "POST" || r.Method == "PUT"):
+		var req struct {
+			Body adfNode `json:"body"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		text := renderADF(req.Body)
+		if len(parts) > 2 {
+			if n, err := strconv.Atoi(parts[2]); err == nil && n > 0 && n <= len(j.comments[issue.Key]) {
+				j.comments[issue.Key][n-1] = text
+			}
+		} else {
+			j.comments[issue.Key] = append(j.comments[issue.Key], text)
+		}
+		writeJSON(w, map[string]string{"id": strconv.Itoa(len(j.comments[issue.Key]))})
+	case sub == "transitions" && r.Method == "GET":
+		var list []map[string]string
+		for i, name := range fakeTransitions {
+			list = append(list, map[string]string{"id": strconv.Itoa(i + 1), "name": name})
+		}
+		writeJSON(w, map[string]interface{}{"transitions": list})
+	case sub == "transitions" && r.Method == "POST":
+		var req struct {
+			Transition struct{ ID string } `json:"transition"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if n, err := strconv.Atoi(req.Transition.ID); err == nil && n > 0 && n <= len(fakeTransitions) {
+			issue.Status = fakeTransitions[n-1]
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "POST" || r.Method == "PUT":
+		// assignee, remotelink, attachments: accepted and ignored
+		io.Copy(io.Discard, r.Body)
+		writeJSON(w, map[string]string{})
+	default:
+		fakeNotFound(w)
+	}
+}
+
+// fakePR is a pull request opened against fakeGitHub
+type fakePR struct {
+	Number int
+	Title  string
+	Body   string
+	Head   string
+	Base   string
+	Draft  bool
+	URL    string
+}
+
+// fakeGitHub is an in-memory stand-in for the parts of the GitHub REST API
+// the pipeline uses, for `factory selftest`
+type fakeGitHub struct {
+	*httptest.Server
+	mu  sync.Mutex
+	prs []*fakePR
+}
+
+func newFakeGitHub() *fakeGitHub {
+	g := &fakeGitHub{}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	return g
+}
+
+func (g *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// /repos/OWNER/REPO/...
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "repos" {
+		fakeNotFound(w)
+		return
+	}
+	owner, repo, rest := parts[1], parts[2], parts[3:]
+
+	switch {
+	case rest[0] == "pulls" && len(rest) == 1 && r.Method == "POST":
+		var req struct {
+			Title, Body, Head, Base string
+			Draft                   bool
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, pr := range g.prs {
+			if pr.Head == req.Head {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				writeJSON(w, map[string]string{"message": "A pull request already exists"})
+				return
+			}
+		}
+		pr := &fakePR{Number: len(g.prs) + 1, Title: req.Title, Body: req.Body, Head: req.Head, Base: req.Base, Draft: req.Draft}
+		pr.URL = fmt.Sprintf("%s/%s/%s/pull/%d", g.URL, owner, repo, pr.Number)
+		g.prs = append(g.prs, pr)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, fakePRJSON(pr))
+	case rest[0] == "pulls" && len(rest) == 1:
+		list := []interface{}{}
+		head := r.URL.Query().Get("head")
+		for _, pr := range g.prs {
+			if head == "" || head == owner+":"+pr.Head {
+				list = append(list, fakePRJSON(pr))
+			}
+		}
+		writeJSON(w, list)
+	case rest[0] == "pulls" && len(rest) == 2 && r.Method == "GET":
+		n, _ := strconv.Atoi(rest[1])
+		if n < 1 || n > len(g.prs) {
+			fakeNotFound(w)
+			return
+		}
+		writeJSON(w, fakePRJSON(g.prs[n-1]))
+	case rest[0] == "pulls" && len(rest) == 3 && r.Method == "GET":
+		// reviews and review comments: none
+		writeJSON(w, []interface{}{})
+	case rest[0] == "rules":
+		writeJSON(w, []interface{}{})
+	case r.Method == "POST":
+		// reviewers and labels: accepted and ignored
+		io.Copy(io.Discard, r.Body)
+		writeJSON(w, map[string]string{})
+	default:
+		fakeNotFound(w)
+	}
+}
+
+func fakePRJSON(pr *fakePR) map[string]interface{} {
+	return map[string]interface{}{
+		"number":   pr.Number,
+		"html_url": pr.URL,
+		"title":    pr.Title,
+		"state":    PRStateOpen,
+		"draft":    pr.Draft,
+		"head":     map[string]string{"ref": pr.Head},
+		"base":     map[string]string{"ref": pr.Base},
+	}
+}
+
+func fakeNames(names []string) []map[string]string {
+	list := []map[string]string{}
+	for _, n := range names {
+		list = append(list, map[string]string{"name": n})
+	}
+	return list
+}
+
+func fakeNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	writeJSON(w, map[string]string{"message": "Not Found"})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	json.NewEncoder(w).Encode(v)
+}
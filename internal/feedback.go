@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	GradeGood      = "good"
+	GradeNeedsWork = "needs-work"
+)
+
+// RecordFeedback grades an automated PR. Notes on a needs-work grade also
+// become a lesson so future prompts learn from them.
+func RecordFeedback(cfg *Config, issueKey, grade, notes string) error {
+	if grade != GradeGood && grade != GradeNeedsWork {
+		return fmt.Errorf("grade must be %q or %q", GradeGood, GradeNeedsWork)
+	}
+
+	found := false
+	updateProcessed(func(list map[string]ProcessedIssue) {
+		info, ok := list[issueKey]
+		if !ok {
+			return
+		}
+		info.Grade = grade
+		info.Notes = notes
+		list[issueKey] = info
+		found = true
+	})
+	if !found {
+		return fmt.Errorf("%s has not been processed", issueKey)
+	}
+
+	if grade == GradeNeedsWork && notes != "" {
+		return AddLesson(cfg, fmt.Sprintf("%s needed rework: %s", issueKey, notes))
+	}
+	return nil
+}
+
+// syncPRStates records whether completed PRs were merged or closed unmerged.
+// A PR closed without merging is recorded as a lesson.
+func syncPRStates(cfg *Config) {
+	states := map[string]string{}
+	for key, info := range processed {
+		if info.PRUrl == "" || info.PRState == PRStateMerged || info.PRState == PRStateClosed {
+			continue
+		}
+		state, err := GetPRState(cfg, info.PRUrl)
+		if err != nil || state == info.PRState {
+			continue
+		}
+		states[key] = state
+
+		if state == PRStateMerged {
+			transition(cfg, key, cfg.Transitions.OnMerged)
+		}
+		if state == PRStateClosed {
+			lesson := fmt.Sprintf("%s: PR was closed without merging", key)
+			if info.Notes != "" {
+				lesson += " (" + info.Notes + ")"
+			}
+			AddLesson(cfg, lesson)
+		}
+	}
+	if len(states) > 0 {
+		updateProcessed(func(list map[string]ProcessedIssue) {
+			for key, state := range states {
+				if info, ok := list[key]; ok {
+					info.PRState = state
+					list[key] = info
+				}
+			}
+		})
+	}
+}
+
+// ShowFeedback prints grades and PR outcomes for processed issues
+func ShowFeedback(cfg *Config) {
+	loadProcessed()
+	syncPRStates(cfg)
+
+	keys := make([]string, 0, len(processed))
+	for key, info := range processed {
+		if info.PRUrl != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Println("No automated PRs yet")
+		return
+	}
+	sort.Strings(keys)
+
+	counts := map[string]int{}
+	fmt.Printf("%-12s %-8s %-11s %s\n", "Issue", "PR", "Grade", "Notes")
+	fmt.Println(strings.Repeat("-", 80))
+	for _, key := range keys {
+		info := processed[key]
+		counts["pr:"+info.PRState]++
+		counts["grade:"+info.Grade]++
+		fmt.Printf("%-12s %-8s %-11s %s\n", key, orDash(info.PRState), orDash(info.Grade), info.Notes)
+	}
+
+	fmt.Printf("\nPRs: %d | merged %d | closed %d | open %d\n",
+		len(keys), counts["pr:"+PRStateMerged], counts["pr:"+PRStateClosed], counts["pr:"+PRStateOpen])
+	fmt.Printf("Grades: good %d | needs-work %d | ungraded %d\n",
+		counts["grade:"+GradeGood], counts["grade:"+GradeNeedsWork], counts["grade:"])
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
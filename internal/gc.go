@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GCConfig controls `factory gc`, which cleans up after finished issues:
+// the branches of merged and closed PRs, and old run logs, transcripts,
+// and diffs
+type GCConfig struct {
+	// IntervalHours has the daemon run gc this often; 0 leaves it to
+	// `factory gc`
+	IntervalHours int `json:"intervalHours,omitempty"`
+	// LogRetentionDays keeps run logs, transcripts, and diffs this long,
+	// default 30
+	LogRetentionDays int `json:"logRetentionDays,omitempty"`
+	// KeepRemoteBranches leaves finished issues' branches on origin, e.g.
+	// when GitHub already deletes them on merge
+	KeepRemoteBranches bool `json:"keepRemoteBranches,omitempty"`
+}
+
+const defaultLogRetentionDays = 30
+
+func (c GCConfig) logRetention() time.Duration {
+	days := c.LogRetentionDays
+	if days <= 0 {
+		days = defaultLogRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// featureBranchIssue reads the issue key out of a feature/KEY-... branch
+var featureBranchIssue = regexp.MustCompile(`^feature/([A-Za-z][A-Za-z0-9_]*-[0-9]+)(?:-|$)`)
+
+// GCResult is what a gc removed, or would remove with dryRun
+type GCResult struct {
+	RemoteBranches []string `json:"remoteBranches"`
+	LocalBranches  []string `json:"localBranches"`
+	Logs           []string `json:"logs"`
+}
+
+// CollectGarbage deletes the branches of issues whose PR was merged or
+// closed, from the workspace and, for the PR's own branch, from origin, prunes stale worktree and
+// remote-tracking entries, and removes run logs, transcripts, and diffs
+// older than gc.logRetentionDays. With dryRun it only reports what it would delete.
+func CollectGarbage(cfg *Config, dryRun bool) (*GCResult, error) {
+	result := &GCResult{}
+	loadProcessed()
+
+	git := NewGit(cfg)
+	if _, err := os.Stat(filepath.Join(git.Path(), ".git")); err == nil {
+		workspace, err := tryLock(workspaceLockPath(cfg))
+		switch {
+		case err == errFileLocked:
+			fmt.Println("  The workspace is in use by a run; leaving branches for the next gc")
+		case err != nil:
+			return result, err
+		default:
+			err = collectBranches(cfg, git, dryRun, result)
+			workspace.unlock()
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	cutoff := time.Now().Add(-cfg.GC.logRetention())
+	for _, dir := range []string{"logs", "transcripts", "diffs"} {
+		dir = filepath.Join(GetConfigDir(), dir)
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			info, err := e.Info()
+			if err != nil || !issueKeyPattern.MatchString(name) || info.ModTime().After(cutoff) {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if !dryRun {
+				if err := os.Remove(path); err != nil {
+					fmt.Printf("  Warning: could not remove %s: %v\n", path, err)
+					continue
+				}
+			}
+			result.Logs = append(result.Logs, path)
+		}
+	}
+	return result, nil
+}
+
+// collectBranches deletes the local and remote branches of finished issues
+func collectBranches(cfg *Config, git *Git, dryRun bool, result *GCResult) error {
+	// Issues with a run in progress keep their branches whatever their
+	// last PR did
+	running := map[string]bool{}
+	for _, l := range activeLeases(cfg) {
+		running[l.IssueKey] = true
+	}
+	states := map[string]bool{}
+	finished := func(issueKey string) bool {
+		if issueKey == "" || running[issueKey] {
+			return false
+		}
+		if done, ok := states[issueKey]; ok {
+			return done
+		}
+		info, ok := processedEntry(issueKey)
+		state := info.PRState
+		if ok && info.PRUrl != "" && state != PRStateMerged && state != PRStateClosed {
+			// The daemon syncs PR states as it polls; look up ones it hasn't
+			if s, err := GetPRState(cfg, info.PRUrl); err == nil {
+				state = s
+			}
+		}
+		done := ok && info.PRUrl != "" && (state == PRStateMerged || state == PRStateClosed)
+		states[issueKey] = done
+		return done
+	}
+
+	if !dryRun {
+		git.exec("worktree", "prune")
+	}
+
+	current, _ := git.CurrentBranch()
+	locals, err := git.exec("for-each-ref", "--format=%(refname:short)", "refs/heads/")
+	if err != nil {
+		return err
+	}
+	for _, b := range strings.Fields(locals) {
+		if !finished(git.factoryBranchIssue(b)) {
+			continue
+		}
+		if !dryRun {
+			// The workspace stays on the last run's branch
+			if b == current {
+				if _, err := git.exec("checkout", git.branch); err != nil {
+					fmt.Printf("  Warning: could not leave branch %s: %v\n", b, err)
+					continue
+				}
+			}
+			if _, err := git.exec("branch", "-D", b); err != nil {
+				fmt.Printf("  Warning: could not delete branch %s: %v\n", b, err)
+				continue
+			}
+		}
+		result.LocalBranches = append(result.LocalBranches, b)
+	}
+
+	if !cfg.GC.KeepRemoteBranches {
+		remote, err := git.remoteBranches()
+		if err != nil {
+			return err
+		}
+		// A branch with an open PR is still in use, whoever opened it
+		open, err := listOpenPRs(cfg)
+		if err != nil {
+			return fmt.Errorf("listing open PRs: %w", err)
+		}
+		inUse := map[string]bool{}
+		for _, pr := range open {
+			inUse[pr.Branch] = true
+		}
+		// Only the head branch of the PR factory recorded is factory's;
+		// other feature/KEY-... branches may be teammates'
+		heads := map[string]string{}
+		for _, b := range remote {
+			m := featureBranchIssue.FindStringSubmatch(b)
+			if m == nil || inUse[b] {
+				continue
+			}
+			issueKey := strings.ToUpper(m[1])
+			if !finished(issueKey) {
+				continue
+			}
+			head, ok := heads[issueKey]
+			if !ok {
+				info, _ := processedEntry(issueKey)
+				if head, err = GetPRBranch(cfg, info.PRUrl); err != nil {
+					fmt.Printf("  Warning: could not look up the branch of %s: %v\n", info.PRUrl, err)
+				}
+				heads[issueKey] = head
+			}
+			if b != head {
+				continue
+			}
+			if !dryRun {
+				_, err := git.exec("push", "origin", "--delete", b)
+				recordAudit(actorOf(cfg), "git.delete", issueKey, "origin/"+b, "", err)
+				if err != nil {
+					fmt.Printf("  Warning: could not delete origin/%s: %v\n", b, err)
+					continue
+				}
+			}
+			result.RemoteBranches = append(result.RemoteBranches, b)
+		}
+	}
+
+	if !dryRun {
+		git.exec("remote", "prune", "origin")
+	}
+	return nil
+}
+
+// factoryBranchIssue returns the issue factory made a local branch for, or
+// "" for a branch factory didn't make
+func (g *Git) factoryBranchIssue(branch string) string {
+	if issue := g.branchIssue(branch); issue != "" {
+		return issue
+	}
+	if m := featureBranchIssue.FindStringSubmatch(branch); m != nil {
+		return strings.ToUpper(m[1])
+	}
+	return ""
+}
+
+// PrintGC reports a gc's result
+func PrintGC(result *GCResult, dryRun bool) {
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	for _, b := range result.RemoteBranches {
+		fmt.Printf("%s origin/%s\n", verb, b)
+	}
+	for _, b := range result.LocalBranches {
+		fmt.Printf("%s branch %s\n", verb, b)
+	}
+	for _, l := range result.Logs {
+		fmt.Printf("%s %s\n", verb, l)
+	}
+	fmt.Printf("%s %d remote branch(es), %d local branch(es), %d log(s)\n",
+		verb, len(result.RemoteBranches), len(result.LocalBranches), len(result.Logs))
+}
+
+type gcState struct {
+	LastRun time.Time `json:"lastRun"`
+}
+
+func GetGCStatePath() string {
+	return filepath.Join(GetConfigDir(), "gc.json")
+}
+
+// scheduledGC runs gc from the daemon every gc.intervalHours
+func scheduledGC(cfg *Config) {
+	if cfg.GC.IntervalHours <= 0 {
+		return
+	}
+	var state gcState
+	if data, err := os.ReadFile(GetGCStatePath()); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	if time.Since(state.LastRun) < time.Duration(cfg.GC.IntervalHours)*time.Hour {
+		return
+	}
+	result, err := CollectGarbage(cfg, false)
+	if err != nil {
+		fmt.Printf("Warning: gc: %v\n", err)
+	}
+	if n := len(result.RemoteBranches) + len(result.LocalBranches) + len(result.Logs); n > 0 {
+		PrintGC(result, false)
+	}
+	state.LastRun = time.Now()
+	data, _ := json.MarshalIndent(state, "", "  ")
+	os.WriteFile(GetGCStatePath(), data, 0644)
+}
@@ -1,18 +1,36 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Git struct {
 	repoPath string
-	branch   string
-	cloneURL string
+	branch   string // the run's base branch
+	// defaultBranch is repo.defaultBranch, which a fresh clone is on
+	defaultBranch string
+	cloneURL      string
+	signing       SigningConfig
+	syncBase      string
+	// cloneDepth and filter make the initial clone shallow or partial
+	cloneDepth int
+	filter     string
+	// sshKey and knownHosts configure SSH remotes, token HTTPS ones
+	// (see gitauth.go)
+	sshKey     string
+	knownHosts string
+	token      func() (string, error)
+	// actor is who pushes are recorded in the audit log as
+	actor func() auditActor
 }
 
 func NewGit(cfg *Config) *Git {
@@ -20,16 +38,47 @@ func NewGit(cfg *Config) *Git {
 	if !filepath.IsAbs(path) {
 		path = filepath.Join(GetConfigDir(), path)
 	}
+	var token func() (string, error)
+	if cfg.GitHub.Token != "" || cfg.GitHub.App != nil {
+		token = func() (string, error) { return githubToken(cfg) }
+	}
+	defaultBranch := cfg.Repo.defaultBranch
+	if defaultBranch == "" {
+		defaultBranch = cfg.Repo.DefaultBranch
+	}
 	return &Git{
-		repoPath: path,
-		branch:   cfg.Repo.DefaultBranch,
-		cloneURL: cfg.Repo.CloneURL,
+		repoPath:      path,
+		branch:        cfg.Repo.DefaultBranch,
+		defaultBranch: defaultBranch,
+		cloneURL:      cfg.Repo.CloneURL,
+		signing:       cfg.Repo.Signing,
+		syncBase:      cfg.Repo.SyncBase,
+
+		cloneDepth: cfg.Repo.CloneDepth,
+		filter:     cfg.Repo.Filter,
+
+		sshKey:     cfg.Repo.SSHKey,
+		knownHosts: cfg.Repo.KnownHosts,
+		token:      token,
+
+		actor: func() auditActor { return actorOf(cfg) },
 	}
 }
 
 func (g *Git) exec(args ...string) (string, error) {
-	cmd := exec.Command("git", args...)
+	var auth, authEnv []string
+	if sub, _ := gitSubcommand(args); remoteCommands[sub] {
+		var err error
+		if auth, authEnv, err = g.authArgs(); err != nil {
+			return "", fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+		}
+	} else if g.filter != "" {
+		// A partial clone fetches missing objects on demand, when it can
+		auth, authEnv, _ = g.authArgs()
+	}
+	cmd := exec.Command("git", append(auth, args...)...)
 	cmd.Dir = g.repoPath
+	cmd.Env = append(g.env(), authEnv...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), string(out))
@@ -43,53 +92,297 @@ func (g *Git) Init() error {
 		return err
 	}
 
+	if err := g.checkHostKey(); err != nil {
+		return err
+	}
+
 	// Clone if not exists
 	if _, err := os.Stat(filepath.Join(g.repoPath, ".git")); os.IsNotExist(err) {
 		fmt.Println("Cloning repository...")
-		cmd := exec.Command("git", "clone", g.cloneURL, g.repoPath)
+		auth, authEnv, err := g.authArgs()
+		if err != nil {
+			return fmt.Errorf("clone failed: %w", err)
+		}
+		args := append(auth, "clone")
+		if g.cloneDepth > 0 {
+			args = append(args, "--depth", strconv.Itoa(g.cloneDepth), "--no-single-branch")
+		}
+		if g.filter != "" {
+			args = append(args, "--filter", g.filter)
+		}
+		cmd := exec.Command("git", append(args, g.cloneURL, g.repoPath)...)
+		cmd.Env = append(g.env(), authEnv...)
 		if out, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("clone failed: %s", string(out))
 		}
 	}
 
 	// Configure git
-	g.exec("config", "user.email", "automation@jira-automation")
-	g.exec("config", "user.name", "Jira Automation")
+	name, email := g.identity()
+	g.exec("config", "user.email", email)
+	g.exec("config", "user.name", name)
 
+	return g.configureSigning()
+}
+
+// identity is the committer name and email factory commits as
+func (g *Git) identity() (string, string) {
+	name, email := "Jira Automation", "automation@jira-automation"
+	if g.signing.Name != "" {
+		name = g.signing.Name
+	}
+	if g.signing.Email != "" {
+		email = g.signing.Email
+	}
+	return name, email
+}
+
+// configureSigning sets up commit signing in the repo config when a signing
+// key is configured
+func (g *Git) configureSigning() error {
+	if g.signing.Key == "" {
+		return nil
+	}
+	format := g.signing.Format
+	switch format {
+	case "", "gpg", "openpgp":
+		format = "openpgp"
+	case "ssh", "x509":
+	default:
+		return fmt.Errorf("unknown repo.signing.format %q (want gpg, ssh, or x509)", g.signing.Format)
+	}
+
+	key := g.signing.Key
+	if format == "ssh" && strings.HasPrefix(key, "~/") {
+		home, _ := os.UserHomeDir()
+		key = filepath.Join(home, key[2:])
+	}
+
+	for _, kv := range [][2]string{
+		{"gpg.format", format},
+		{"user.signingkey", key},
+		{"commit.gpgsign", "true"},
+	} {
+		if _, err := g.exec("config", kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// CurrentBranch returns the branch currently checked out in the workspace
+func (g *Git) CurrentBranch() (string, error) {
+	return g.exec("rev-parse", "--abbrev-ref", "HEAD")
+}
+
+// EnsureClean refuses to run on a workspace with uncommitted changes or on a
+// branch factory did not create, so local work never ends up in an automated
+// commit. With stash set, uncommitted changes are stashed instead.
+func (g *Git) EnsureClean(issueKey string, stash bool) error {
+	branch, err := g.CurrentBranch()
+	if err != nil {
+		return err
+	}
+	if branch != g.branch && branch != g.defaultBranch && !g.isFactoryBranch(branch) && !g.isBaseBranch(branch) {
+		return fmt.Errorf("workspace %s is on non-factory branch %q; check it out to %s first", g.repoPath, branch, g.branch)
+	}
+
+	status, _ := g.exec("status", "--porcelain")
+	if status == "" {
+		return nil
+	}
+	if !stash {
+		return fmt.Errorf("workspace %s has uncommitted changes (set repo.stashDirty to stash them):\n%s", g.repoPath, status)
+	}
+
+	fmt.Println("  Stashing uncommitted workspace changes")
+	_, err = g.exec("stash", "push", "--include-untracked", "-m", "factory: auto-stash before "+issueKey)
+	return err
+}
+
+// isFactoryBranch reports whether factory created the branch. CreateBranch
+// marks its branches in the repo config, since branchPattern can produce any
+// name; feature/ branches predate the marker.
+func (g *Git) isFactoryBranch(branch string) bool {
+	if strings.HasPrefix(branch, "feature/") {
+		return true
+	}
+	issue, _ := g.exec("config", "--get", "branch."+branch+".factoryIssue")
+	return issue != ""
+}
+
+// isBaseBranch reports whether an earlier run worked against the branch,
+// e.g. a release branch from `factory trigger --base`, and so left the
+// workspace on it
+func (g *Git) isBaseBranch(branch string) bool {
+	base, _ := g.exec("config", "--get", "branch."+branch+".factoryBase")
+	return base == "true"
+}
+
+// IsShallow reports whether the workspace is a shallow clone
+func (g *Git) IsShallow() bool {
+	out, _ := g.exec("rev-parse", "--is-shallow-repository")
+	return out == "true"
+}
+
+// Unshallow fetches the history a shallow clone left out
+func (g *Git) Unshallow() error {
+	_, err := g.exec("fetch", "--unshallow", "origin")
+	return err
+}
+
+// readsHistory reports whether a tool call is a git command that looks past
+// the tip of a shallow clone
+func readsHistory(tool string, input map[string]interface{}) bool {
+	if tool != "Bash" {
+		return false
+	}
+	command, _ := input["command"].(string)
+	for _, segment := range shellSeparators.Split(command, -1) {
+		fields := strings.Fields(segment)
+		if len(fields) == 0 || fields[0] != "git" {
+			continue
+		}
+		switch sub, _ := gitSubcommand(fields[1:]); sub {
+		case "log", "blame", "bisect", "rev-list", "shortlog", "show", "describe", "annotate":
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Git) Pull() error {
 	if _, err := g.exec("checkout", g.branch); err != nil {
-		return err
+		// A base branch created since the clone, such as a new release
+		// branch, has to be fetched first
+		if _, ferr := g.exec("fetch", "origin", g.branch+":refs/remotes/origin/"+g.branch); ferr != nil {
+			return err
+		}
+		if _, err := g.exec("checkout", g.branch); err != nil {
+			return err
+		}
 	}
+	g.exec("config", "branch."+g.branch+".factoryBase", "true")
 	_, err := g.exec("pull", "origin", g.branch)
 	return err
 }
 
-func (g *Git) CreateBranch(issueKey, title string) (string, error) {
+// CreateBranch checks out the issue's feature branch and returns its name.
+// A branch factory already made for the issue, locally or on origin, is
+// reused, unless hasOpenPR reports an open PR from it, which the run's
+// pushes would change. If the name is taken by anything else (a human
+// branch, another issue's branch, an open PR), a -2, -3, ... suffix is
+// added until it is free.
+func (g *Git) CreateBranch(branchName, issueKey string, hasOpenPR func(branch string) bool) (string, error) {
 	if err := g.Pull(); err != nil {
 		return "", err
 	}
 
-	// Create branch name
-	re := regexp.MustCompile(`[^a-zA-Z0-9]+`)
-	slug := re.ReplaceAllString(strings.ToLower(title), "-")
-	slug = strings.Trim(slug, "-")
-	if len(slug) > 40 {
-		slug = slug[:40]
+	remote, err := g.remoteBranches()
+	if err != nil {
+		return "", err
+	}
+	var others []string
+	for _, b := range remote {
+		suffix, derived := strings.CutPrefix(b, branchName+"-")
+		if _, err := strconv.Atoi(suffix); b == branchName || derived && err == nil {
+			continue
+		}
+		if strings.Contains(b, issueKey) {
+			others = append(others, b)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Printf("  Note: origin also has %s\n", strings.Join(others, ", "))
 	}
-	branchName := fmt.Sprintf("feature/%s-%s", issueKey, slug)
 
-	// Check if exists
-	out, _ := g.exec("branch", "-a")
-	if strings.Contains(out, branchName) {
-		g.exec("checkout", branchName)
-	} else {
-		g.exec("checkout", "-b", branchName)
+	name := branchName
+	for n := 2; ; n++ {
+		_, localErr := g.exec("rev-parse", "--verify", "--quiet", "refs/heads/"+name)
+		local := localErr == nil
+		onRemote := containsAny(remote, name)
+
+		switch {
+		case hasOpenPR(name):
+			fmt.Printf("  Branch %s has an open PR\n", name)
+			name = fmt.Sprintf("%s-%d", branchName, n)
+			continue
+		case local && g.branchIssue(name) == issueKey:
+			_, err = g.exec("checkout", name)
+		case !local && onRemote && g.remoteBranchIsFor(name, issueKey):
+			_, err = g.exec("checkout", "-b", name, "--track", "origin/"+name)
+		case !local && !onRemote:
+			_, err = g.exec("checkout", "-b", name)
+		default:
+			fmt.Printf("  Branch %s is taken by someone else's work\n", name)
+			name = fmt.Sprintf("%s-%d", branchName, n)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = g.exec("config", "branch."+name+".factoryIssue", issueKey)
+		return name, err
+	}
+}
+
+// CheckoutPR checks out an open PR's branch as it is on origin, for
+// follow-up commits, and marks it as the issue's branch
+func (g *Git) CheckoutPR(branch, issueKey string) error {
+	if _, err := g.exec("fetch", "origin", branch+":refs/remotes/origin/"+branch); err != nil {
+		return err
+	}
+	if _, err := g.exec("checkout", "-B", branch, "--track", "origin/"+branch); err != nil {
+		return err
+	}
+	_, err := g.exec("config", "branch."+branch+".factoryIssue", issueKey)
+	return err
+}
+
+// LastCommitTime returns when the checked-out commit was made
+func (g *Git) LastCommitTime() (time.Time, error) {
+	out, err := g.exec("log", "-1", "--format=%cI")
+	if err != nil {
+		return time.Time{}, err
 	}
+	return time.Parse(time.RFC3339, out)
+}
 
-	return branchName, nil
+// remoteBranches lists the branch names on origin
+func (g *Git) remoteBranches() ([]string, error) {
+	out, err := g.exec("ls-remote", "--heads", "origin")
+	if err != nil {
+		return nil, err
+	}
+	var branches []string
+	for _, line := range strings.Split(out, "\n") {
+		if i := strings.Index(line, "refs/heads/"); i >= 0 {
+			branches = append(branches, line[i+len("refs/heads/"):])
+		}
+	}
+	return branches, nil
+}
+
+// branchIssue returns the issue factory created a local branch for
+func (g *Git) branchIssue(branch string) string {
+	issue, _ := g.exec("config", "--get", "branch."+branch+".factoryIssue")
+	return issue
+}
+
+// remoteBranchIsFor reports whether a branch on origin was pushed by factory
+// for the issue, e.g. by a worker whose lease this run took over: its tip is
+// committed as factory's identity and mentions the issue key.
+func (g *Git) remoteBranchIsFor(branch, issueKey string) bool {
+	if _, err := g.exec("fetch", "origin", branch+":refs/remotes/origin/"+branch); err != nil {
+		return false
+	}
+	out, err := g.exec("log", "-1", "--format=%ce%n%B", "origin/"+branch)
+	if err != nil {
+		return false
+	}
+	_, email := g.identity()
+	committer, message, _ := strings.Cut(out, "\n")
+	return committer == email && strings.Contains(message, issueKey)
 }
 
 func (g *Git) HasChanges() bool {
@@ -97,19 +390,375 @@ func (g *Git) HasChanges() bool {
 	return out != ""
 }
 
-func (g *Git) CommitAndPush(branch, message string) error {
-	if _, err := g.exec("add", "-A"); err != nil {
+// Snapshot records the content hash of every path git reports as changed or
+// untracked, so files the agent touches can be told apart from ones that were
+// already lying around in the workspace
+type Snapshot map[string]string
+
+func (g *Git) Snapshot() (Snapshot, error) {
+	cmd := exec.Command("git", "status", "--porcelain", "-z", "--untracked-files=all")
+	cmd.Dir = g.repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status: %w", err)
+	}
+
+	snap := Snapshot{}
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		path := entry[3:]
+		snap[path] = hashFile(filepath.Join(g.repoPath, path))
+		// Renames and copies carry the original path as the next entry
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return snap, nil
+}
+
+// ChangedSince returns the paths whose content differs from the snapshot
+func (g *Git) ChangedSince(before Snapshot) ([]string, error) {
+	after, err := g.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for path, hash := range after {
+		if before[path] != hash {
+			changed = append(changed, path)
+		}
+	}
+	// Pre-existing changes the agent reverted to the committed version
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func hashFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "missing"
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Discard reverts the given paths to HEAD, deleting those HEAD doesn't have
+func (g *Git) Discard(paths []string) error {
+	for _, p := range paths {
+		if _, err := g.exec("checkout", "HEAD", "--", p); err != nil {
+			if err := os.RemoveAll(filepath.Join(g.repoPath, p)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DropBranch checks out the default branch and deletes branch, unless it
+// is on origin and so may hold work from an earlier run
+func (g *Git) DropBranch(branch string) error {
+	if _, err := g.exec("checkout", g.branch); err != nil {
 		return err
 	}
-	if _, err := g.exec("commit", "-m", message); err != nil {
+	if _, err := g.exec("rev-parse", "--verify", "--quiet", "refs/remotes/origin/"+branch); err == nil {
+		return nil
+	}
+	_, err := g.exec("branch", "-D", branch)
+	return err
+}
+
+// Values for repo.syncBase
+const (
+	SyncRebase = "rebase"
+	SyncMerge  = "merge"
+	SyncOff    = "off"
+)
+
+// CommitAndPush stages only the given paths, commits, brings the branch up
+// to date with the base branch (see SyncBase), and pushes it
+func (g *Git) CommitAndPush(branch, message string, paths []string) error {
+	return g.CommitAndPushResolving(branch, message, paths, nil)
+}
+
+// CommitAndPushResolving is CommitAndPush with resolve called on conflicts
+// syncing with the base branch (see SyncBase)
+func (g *Git) CommitAndPushResolving(branch, message string, paths []string, resolve ConflictResolver) error {
+	if _, err := g.exec(append([]string{"add", "-A", "--"}, paths...)...); err != nil {
 		return err
 	}
-	if _, err := g.exec("push", "-u", "origin", branch); err != nil {
+	args := []string{"commit", "-m", message}
+	if g.signing.Key != "" {
+		// Fail rather than fall back to an unsigned commit that protection rejects
+		args = append(args, "-S")
+	}
+	if _, err := g.exec(args...); err != nil {
 		return err
 	}
-	return nil
+	rewritten, err := g.SyncBase(resolve)
+	if err != nil {
+		return err
+	}
+	return g.push(branch, rewritten)
+}
+
+// ConflictResolver resolves the conflicts a rebase or merge left in the
+// given files, in the working tree; git stages them after. An error gives
+// up on the sync.
+type ConflictResolver func(files []string) error
+
+// maxConflictRounds bounds how many of a rebase's commits may conflict
+const maxConflictRounds = 10
+
+// ConflictError is a sync with the base branch that conflicted and could
+// not be resolved
+type ConflictError struct {
+	Files []string
+	Err   error
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Files) == 0 {
+		return fmt.Sprintf("conflicts with the base branch: %v", e.Err)
+	}
+	return fmt.Sprintf("conflicts with the base branch in %s: %v", strings.Join(e.Files, ", "), e.Err)
+}
+
+func (e *ConflictError) Unwrap() error { return e.Err }
+
+// SyncBase rebases the checked-out branch onto the base branch as it is on
+// origin now, or merges it in with repo.syncBase "merge", so that a base
+// that moved during the run doesn't leave the PR conflicted or behind. It
+// reports whether it rewrote the branch's history, which then needs a
+// forced push. Conflicts go to resolve; without one they, like other
+// problems, only warn: the branch is pushed as it was, and the PR shows the
+// conflict. A conflict resolve fails on is returned as a *ConflictError,
+// with the branch as it was.
+func (g *Git) SyncBase(resolve ConflictResolver) (bool, error) {
+	mode := g.syncBase
+	if mode == "" {
+		mode = SyncRebase
+	}
+	if mode == SyncOff {
+		return false, nil
+	}
+	upstream := "origin/" + g.branch
+	if _, err := g.exec("fetch", "origin", g.branch+":refs/remotes/"+upstream); err != nil {
+		fmt.Printf("  Warning: could not fetch %s to sync with it: %v\n", g.branch, err)
+		return false, nil
+	}
+	if _, err := g.exec("merge-base", "--is-ancestor", upstream, "HEAD"); err == nil {
+		return false, nil
+	}
+
+	var err error
+	var abort, commit []string
+	if mode == SyncMerge {
+		fmt.Printf("  %s moved since the run started; merging it in\n", g.branch)
+		args := []string{"merge", "--no-edit", upstream}
+		commit = []string{"commit", "--no-edit"}
+		if g.signing.Key != "" {
+			args = append(args, "-S")
+			commit = append(commit, "-S")
+		}
+		abort = []string{"merge", "--abort"}
+		_, err = g.exec(args...)
+	} else {
+		fmt.Printf("  %s moved since the run started; rebasing onto it\n", g.branch)
+		abort = []string{"rebase", "--abort"}
+		// The resolved commit keeps its message
+		commit = []string{"-c", "core.editor=true", "rebase", "--continue"}
+		_, err = g.exec("rebase", upstream)
+	}
+
+	for round := 1; err != nil; round++ {
+		files := g.conflictedFiles()
+		if resolve == nil || len(files) == 0 && round == 1 {
+			g.exec(abort...)
+			fmt.Printf("  Warning: could not %s %s, pushing without it: %v\n", mode, upstream, err)
+			return false, nil
+		}
+		if len(files) == 0 {
+			// Resolved, but the sync still can't go on
+			g.exec(abort...)
+			return false, &ConflictError{Err: err}
+		}
+		if round > maxConflictRounds {
+			g.exec(abort...)
+			return false, &ConflictError{Files: files, Err: fmt.Errorf("more than %d commits conflicted", maxConflictRounds)}
+		}
+		fmt.Printf("  Conflicts in %s\n", strings.Join(files, ", "))
+		if rerr := resolve(files); rerr != nil {
+			g.exec(abort...)
+			return false, &ConflictError{Files: files, Err: rerr}
+		}
+		if marked := conflictMarked(g.repoPath, files); len(marked) > 0 {
+			g.exec(abort...)
+			return false, &ConflictError{Files: files, Err: fmt.Errorf("conflict markers left in %s", strings.Join(marked, ", "))}
+		}
+		if _, aerr := g.exec(append([]string{"add", "-A", "--"}, files...)...); aerr != nil {
+			g.exec(abort...)
+			return false, &ConflictError{Files: files, Err: aerr}
+		}
+		_, err = g.exec(commit...)
+	}
+	return mode == SyncRebase, nil
+}
+
+// conflictedFiles lists the files a rebase or merge left unmerged
+func (g *Git) conflictedFiles() []string {
+	out, _ := g.exec("diff", "--name-only", "--diff-filter=U")
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+// conflictMarked returns the files that still have conflict markers
+func conflictMarked(repoPath string, files []string) []string {
+	var marked []string
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(repoPath, f))
+		if err != nil {
+			continue // resolved by deleting it
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+				marked = append(marked, f)
+				break
+			}
+		}
+	}
+	return marked
+}
+
+// Push pushes the branch to origin
+func (g *Git) Push(branch string) error {
+	return g.push(branch, false)
+}
+
+// push pushes the branch, with force replacing what the branch had on
+// origin after a rebase, unless someone else pushed to it since factory last
+// fetched it
+func (g *Git) push(branch string, force bool) error {
+	args := []string{"push", "-u", "origin", branch}
+	if force {
+		args = []string{"push", "-u", "--force-with-lease", "origin", branch}
+	}
+	_, err := g.exec(args...)
+	head, _ := g.exec("rev-parse", "HEAD")
+	if force {
+		head += " (rebased)"
+	}
+	recordAudit(g.actor(), "git.push", g.factoryBranchIssue(branch), "origin/"+branch, head, err)
+	return err
+}
+
+// BranchFiles lists the files the checked-out branch changes relative to
+// the default branch
+func (g *Git) BranchFiles() ([]string, error) {
+	out, err := g.exec("diff", "--name-only", g.branch+"...HEAD")
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// CommitStats counts the files and lines (added plus deleted) changed by the
+// last commit; binary files count as files only
+func (g *Git) CommitStats() (files, lines int, err error) {
+	out, err := g.exec("diff", "--numstat", "HEAD^", "HEAD")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		files++
+		added, _ := strconv.Atoi(fields[0])
+		deleted, _ := strconv.Atoi(fields[1])
+		lines += added + deleted
+	}
+	return files, lines, nil
+}
+
+// Exclude adds a pattern to .git/info/exclude so factory's own scratch files
+// are never staged, without touching the repo's .gitignore
+func (g *Git) Exclude(pattern string) error {
+	path := filepath.Join(g.repoPath, ".git", "info", "exclude")
+	data, _ := os.ReadFile(path)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		pattern = "\n" + pattern
+	}
+	_, err = f.WriteString(pattern + "\n")
+	return err
 }
 
 func (g *Git) Path() string {
 	return g.repoPath
 }
+
+// at returns g working in the checkout at path instead, e.g. a worktree
+func (g *Git) at(path string) *Git {
+	c := *g
+	c.repoPath = path
+	return &c
+}
+
+const defaultBranchPattern = "feature/{{.Key}}-{{.Slug}}"
+
+// BranchData is what repo.branchPattern is rendered against
+type BranchData struct {
+	Key   string // PROJ-123
+	Title string
+	Slug  string // slugified title, at most 40 characters; see titleSlug
+	Type  string // slugified issue type: bug, story, task, ...
+}
+
+// BranchName renders the feature branch name for an issue from
+// repo.branchPattern
+func BranchName(cfg *Config, issue *Issue) (string, error) {
+	pattern := cfg.Repo.BranchPattern
+	if pattern == "" {
+		pattern = defaultBranchPattern
+	}
+
+	name, err := renderText("branchPattern", pattern, BranchData{
+		Key:   issue.Key,
+		Title: issue.Title,
+		Slug:  titleSlug(issue),
+		Type:  slugify(issue.Type),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := exec.Command("git", "check-ref-format", "--branch", name).Run(); err != nil {
+		return "", fmt.Errorf("branch pattern produced invalid branch name %q", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scpLikeURL matches git's scp-style SSH URLs, e.g. git@github.com:org/repo.git
+var scpLikeURL = regexp.MustCompile(`^(?:[\w.-]+@)?([\w.-]+):[^/]`)
+
+func isSSHURL(url string) bool {
+	return strings.HasPrefix(url, "ssh://") || (!strings.Contains(url, "://") && scpLikeURL.MatchString(url))
+}
+
+// sshHost returns the known_hosts name for an SSH URL: "host", or
+// "[host]:port" for a non-default port
+func sshHost(url string) string {
+	if rest, ok := strings.CutPrefix(url, "ssh://"); ok {
+		hostPort := strings.SplitN(rest, "/", 2)[0]
+		if i := strings.LastIndex(hostPort, "@"); i >= 0 {
+			hostPort = hostPort[i+1:]
+		}
+		if host, port, ok := strings.Cut(hostPort, ":"); ok && port != "22" {
+			return fmt.Sprintf("[%s]:%s", host, port)
+		} else if ok {
+			return host
+		}
+		return hostPort
+	}
+	if m := scpLikeURL.FindStringSubmatch(url); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+// knownHostsPath is repo.knownHosts, or ~/.ssh/known_hosts
+func (g *Git) knownHostsPath() string {
+	if g.knownHosts != "" {
+		return expandHome(g.knownHosts)
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// httpsOrigin returns scheme://host of an HTTPS clone URL, or "" for any
+// other kind of URL
+func httpsOrigin(url string) string {
+	rest, ok := strings.CutPrefix(url, "https://")
+	if !ok {
+		return ""
+	}
+	host := strings.SplitN(rest, "/", 2)[0]
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	return "https://" + host
+}
+
+// tokenHelper is a git credential helper answering with the GitHub token
+// from the environment, so the token never appears in argv or .git/config
+const tokenHelper = `!f() { test "$1" = get && echo username=x-access-token && echo "password=$FACTORY_GIT_TOKEN"; }; f`
+
+// remoteCommands are the git commands that reach the remote, and so get the
+// token; the rest run without it, even when GitHub is unreachable
+var remoteCommands = map[string]bool{"clone": true, "fetch": true, "pull": true, "push": true, "ls-remote": true}
+
+// authArgs returns the -c options that make git authenticate to an HTTPS
+// clone URL's host with the GitHub token, ahead of any ambient credential
+// helper, and the environment handing the helper the token. It fails when
+// there is no token, rather than have git try an empty one.
+func (g *Git) authArgs() (args, env []string, err error) {
+	origin := httpsOrigin(g.cloneURL)
+	if g.token == nil || origin == "" {
+		return nil, nil, nil
+	}
+	token, err := g.token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("no GitHub token for git: %w", err)
+	}
+	args = []string{
+		"-c", "credential." + origin + ".helper=",
+		"-c", "credential." + origin + ".helper=" + tokenHelper,
+	}
+	return args, []string{"FACTORY_GIT_TOKEN=" + token, "GIT_TERMINAL_PROMPT=0"}, nil
+}
+
+// env returns the environment for git commands. With repo.sshKey set, SSH
+// uses only that key and refuses hosts missing from known_hosts instead of
+// prompting, which would hang the daemon.
+func (g *Git) env() []string {
+	env := os.Environ()
+	if g.sshKey == "" {
+		return env
+	}
+	sshCmd := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s",
+		shellQuote(expandHome(g.sshKey)), shellQuote(g.knownHostsPath()))
+	return append(env, "GIT_SSH_COMMAND="+sshCmd)
+}
+
+// checkHostKey fails early, with instructions, when an SSH remote's host key
+// isn't in known_hosts
+func (g *Git) checkHostKey() error {
+	if !isSSHURL(g.cloneURL) {
+		return nil
+	}
+	host := sshHost(g.cloneURL)
+	known := g.knownHostsPath()
+	if err := exec.Command("ssh-keygen", "-F", host, "-f", known).Run(); err != nil {
+		scan := "ssh-keyscan " + host
+		if h, port, ok := strings.Cut(strings.TrimPrefix(host, "["), "]:"); ok {
+			scan = fmt.Sprintf("ssh-keyscan -p %s %s", port, h)
+		}
+		return fmt.Errorf("host key for %s not found in %s; after verifying its fingerprint, add it with: %s >> %s",
+			host, known, scan, known)
+	}
+	if g.sshKey != "" {
+		if _, err := os.Stat(expandHome(g.sshKey)); err != nil {
+			return fmt.Errorf("repo.sshKey: %w", err)
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s for GIT_SSH_COMMAND, which git runs through the shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -19,12 +19,16 @@ func CheckGHCLI() bool {
 }
 
 // CreatePRWithGH creates a PR using gh CLI
-func CreatePRWithGH(repoPath, title, body, base string) (string, error) {
-	cmd := exec.Command("gh", "pr", "create",
+func CreatePRWithGH(repoPath, title, body, base string, draft bool) (string, error) {
+	args := []string{"pr", "create",
 		"--title", title,
 		"--body", body,
 		"--base", base,
-	)
+	}
+	if draft {
+		args = append(args, "--draft")
+	}
+	cmd := exec.Command("gh", args...)
 	cmd.Dir = repoPath
 
 	output, err := cmd.CombinedOutput()
@@ -62,33 +66,44 @@ func FindPRWithGH(repoPath string) (string, error) {
 
 // CreatePR creates a PR - uses gh CLI if available, otherwise REST API
 func CreatePR(cfg *Config, title, body, head, base string) (string, error) {
+	var url string
+	var err error
 	// Try gh CLI first if no token provided or gh is available
 	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
 		git := NewGit(cfg)
-		return CreatePRWithGH(git.repoPath, title, body, base)
+		url, err = CreatePRWithGH(git.repoPath, title, body, base, cfg.GitHub.DraftPR)
+	} else {
+		// Fall back to REST API
+		url, err = CreatePRWithAPI(cfg, title, body, head, base)
 	}
-
-	// Fall back to REST API
-	return CreatePRWithAPI(cfg, title, body, head, base)
+	target := url
+	if target == "" {
+		target = head + " -> " + base
+	}
+	recordAudit(actorOf(cfg), "github.pr.create", NewGit(cfg).factoryBranchIssue(head), target, title, err)
+	return url, err
 }
 
 // CreatePRWithAPI creates a PR using GitHub REST API
 func CreatePRWithAPI(cfg *Config, title, body, head, base string) (string, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls", cfg.GitHub.Owner, cfg.GitHub.Repo)
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls", cfg.GitHub.apiURL(), cfg.GitHub.Owner, cfg.GitHub.Repo)
 
-	reqBody, _ := json.Marshal(map[string]string{
+	reqBody, _ := json.Marshal(map[string]interface{}{
 		"title": title,
 		"body":  body,
 		"head":  head,
 		"base":  base,
+		"draft": cfg.GitHub.DraftPR,
 	})
 
 	req, _ := http.NewRequest("POST", url, bytes.NewReader(reqBody))
-	req.Header.Set("Authorization", "Bearer "+cfg.GitHub.Token)
+	if err := setGitHubAuth(cfg, req); err != nil {
+		return "", err
+	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doAPI(req)
 	if err != nil {
 		return "", err
 	}
@@ -114,14 +129,16 @@ func CreatePRWithAPI(cfg *Config, title, body, head, base string) (string, error
 }
 
 func FindExistingPR(cfg *Config, head string) (string, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/pulls?head=%s:%s&state=open",
-		cfg.GitHub.Owner, cfg.GitHub.Repo, cfg.GitHub.Owner, head)
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls?head=%s:%s&state=open",
+		cfg.GitHub.apiURL(), cfg.GitHub.Owner, cfg.GitHub.Repo, cfg.GitHub.Owner, head)
 
 	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+cfg.GitHub.Token)
+	if err := setGitHubAuth(cfg, req); err != nil {
+		return "", err
+	}
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doAPI(req)
 	if err != nil {
 		return "", err
 	}
@@ -141,31 +158,284 @@ func FindExistingPR(cfg *Config, head string) (string, error) {
 	return "", fmt.Errorf("PR not found")
 }
 
-func FormatPRBody(issue *Issue, jiraURL string) string {
-	return fmt.Sprintf(`## Summary
-- **Issue**: [%s](%s/browse/%s)
-- **Type**: %s
-- **Priority**: %s
+// issuePR is an open PR for an issue
+type issuePR struct {
+	URL, Title, Branch, Base string
+}
 
-## Description
-%s
+// listedPR is an open PR as listOpenPRs lists it
+type listedPR struct {
+	issuePR
+	Author  string // GitHub login
+	Bot     bool
+	Draft   bool
+	HeadSHA string
+	Body    string
+}
 
-## Acceptance Criteria
-%s
+// findOpenPR returns an open PR whose title or branch mentions issueKey, or
+// nil if there is none. Runs use it to avoid opening a second PR for an
+// issue, e.g. after `factory clear`, and `trigger --update` to find the PR
+// to add to.
+func findOpenPR(cfg *Config, issueKey string) (*issuePR, error) {
+	prs, err := listOpenPRs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// PROJ-12 must not match PROJ-123's PR
+	mentions := regexp.MustCompile(`(?i)(^|[^a-z0-9])` + regexp.QuoteMeta(issueKey) + `([^0-9]|$)`)
+	for _, pr := range prs {
+		if mentions.MatchString(pr.Title) || mentions.MatchString(pr.Branch) {
+			return &pr.issuePR, nil
+		}
+	}
+	return nil, nil
+}
 
-## Validation
-- [ ] Code builds successfully
-- [ ] Tests pass
-- [ ] Acceptance criteria verified
+// openPRBranches returns a check for whether a branch is the head of one of
+// the repo's open PRs
+func openPRBranches(cfg *Config) (func(branch string) bool, error) {
+	prs, err := listOpenPRs(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("listing open PRs: %w", err)
+	}
+	heads := make(map[string]bool)
+	for _, pr := range prs {
+		heads[pr.Branch] = true
+	}
+	return func(branch string) bool { return heads[branch] }, nil
+}
 
-## Jira
-Closes %s
+// listOpenPRs lists the repo's open PRs, newest first
+func listOpenPRs(cfg *Config) ([]listedPR, error) {
+	var prs []listedPR
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		cmd := exec.Command("gh", "pr", "list", "--state", "open", "--limit", "1000",
+			"--json", "url,title,headRefName,baseRefName,author,isDraft,headRefOid,body")
+		cmd.Dir = NewGit(cfg).repoPath
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("gh pr list failed: %w", err)
+		}
+		var list []struct {
+			URL         string `json:"url"`
+			Title       string `json:"title"`
+			HeadRefName string `json:"headRefName"`
+			BaseRefName string `json:"baseRefName"`
+			Author      struct {
+				Login string `json:"login"`
+				IsBot bool   `json:"is_bot"`
+			} `json:"author"`
+			IsDraft    bool   `json:"isDraft"`
+			HeadRefOid string `json:"headRefOid"`
+			Body       string `json:"body"`
+		}
+		if err := json.Unmarshal(out, &list); err != nil {
+			return nil, err
+		}
+		for _, pr := range list {
+			prs = append(prs, listedPR{issuePR{pr.URL, pr.Title, pr.HeadRefName, pr.BaseRefName},
+				pr.Author.Login, pr.Author.IsBot, pr.IsDraft, pr.HeadRefOid, pr.Body})
+		}
+		return prs, nil
+	}
 
----
-*Generated by factory*`,
-		issue.Key, jiraURL, issue.Key,
-		issue.Type, issue.Priority,
-		issue.Description,
-		issue.AcceptanceCriteria,
-		issue.Key)
+	for page := 1; ; page++ {
+		body, err := githubRequest(cfg, "GET", fmt.Sprintf("/repos/%s/%s/pulls?state=open&per_page=100&page=%d",
+			cfg.GitHub.Owner, cfg.GitHub.Repo, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var list []struct {
+			HTMLURL string `json:"html_url"`
+			Title   string `json:"title"`
+			Body    string `json:"body"`
+			Draft   bool   `json:"draft"`
+			User    struct {
+				Login string `json:"login"`
+				Type  string `json:"type"`
+			} `json:"user"`
+			Head struct {
+				Ref string `json:"ref"`
+				SHA string `json:"sha"`
+			} `json:"head"`
+			Base struct {
+				Ref string `json:"ref"`
+			} `json:"base"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, pr := range list {
+			prs = append(prs, listedPR{issuePR{pr.HTMLURL, pr.Title, pr.Head.Ref, pr.Base.Ref},
+				pr.User.Login, pr.User.Type == "Bot", pr.Draft, pr.Head.SHA, pr.Body})
+		}
+		if len(list) < 100 {
+			return prs, nil
+		}
+	}
+}
+
+// githubGet reads a GitHub REST API path, through gh when github.useGhCli
+// is set
+func githubGet(cfg *Config, path string) ([]byte, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		out, err := exec.Command("gh", "api", strings.TrimPrefix(path, "/")).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("gh api failed: %s", strings.TrimSpace(string(out)))
+		}
+		return out, nil
+	}
+	return githubRequest(cfg, "GET", path, nil)
+}
+
+const (
+	PRStateOpen   = "open"
+	PRStateMerged = "merged"
+	PRStateClosed = "closed" // closed without merging
+)
+
+// GetPRState returns whether a PR is open, merged, or closed without merging
+func GetPRState(cfg *Config, prURL string) (string, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		out, err := exec.Command("gh", "pr", "view", prURL, "--json", "state", "-q", ".state").Output()
+		if err != nil {
+			return "", fmt.Errorf("gh pr view failed: %w", err)
+		}
+		return strings.ToLower(strings.TrimSpace(string(out))), nil
+	}
+
+	pr, err := getPullREST(cfg, prURL)
+	if err != nil {
+		return "", err
+	}
+	if pr.Merged {
+		return PRStateMerged, nil
+	}
+	return pr.State, nil
+}
+
+// prFiles returns the paths a PR changes
+func prFiles(cfg *Config, prURL string) ([]string, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		out, err := exec.Command("gh", "pr", "view", prURL, "--json", "files", "-q", ".files[].path").Output()
+		if err != nil {
+			return nil, fmt.Errorf("gh pr view failed: %w", err)
+		}
+		return strings.Fields(string(out)), nil
+	}
+
+	num, err := prNumber(prURL)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for page := 1; ; page++ {
+		body, err := githubRequest(cfg, "GET", fmt.Sprintf("/repos/%s/%s/pulls/%s/files?per_page=100&page=%d",
+			cfg.GitHub.Owner, cfg.GitHub.Repo, num, page), nil)
+		if err != nil {
+			return nil, err
+		}
+		var list []struct {
+			Filename string `json:"filename"`
+		}
+		if err := json.Unmarshal(body, &list); err != nil {
+			return nil, err
+		}
+		for _, f := range list {
+			files = append(files, f.Filename)
+		}
+		if len(list) < 100 {
+			return files, nil
+		}
+	}
+}
+
+type pullRequest struct {
+	NodeID string `json:"node_id"`
+	State  string `json:"state"`
+	Merged bool   `json:"merged"`
+	Draft  bool   `json:"draft"`
+	Head   struct {
+		SHA string `json:"sha"`
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+// GetPRBranch returns the head branch of a PR
+func GetPRBranch(cfg *Config, prURL string) (string, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		out, err := exec.Command("gh", "pr", "view", prURL, "--json", "headRefName", "-q", ".headRefName").Output()
+		if err != nil {
+			return "", fmt.Errorf("gh pr view failed: %w", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	pr, err := getPullREST(cfg, prURL)
+	if err != nil {
+		return "", err
+	}
+	return pr.Head.Ref, nil
+}
+
+func prNumber(prURL string) (string, error) {
+	m := regexp.MustCompile(`/pull/(\d+)`).FindStringSubmatch(prURL)
+	if m == nil {
+		return "", fmt.Errorf("not a PR URL: %s", prURL)
+	}
+	return m[1], nil
+}
+
+func getPullREST(cfg *Config, prURL string) (*pullRequest, error) {
+	num, err := prNumber(prURL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := githubRequest(cfg, "GET", fmt.Sprintf("/repos/%s/%s/pulls/%s", cfg.GitHub.Owner, cfg.GitHub.Repo, num), nil)
+	if err != nil {
+		return nil, err
+	}
+	var pr pullRequest
+	if err := json.Unmarshal(body, &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// githubRequest calls the GitHub REST API; path is relative to the API root
+// (github.apiUrl). "/graphql" goes to the GraphQL endpoint, which GitHub
+// Enterprise Server serves at /api/graphql rather than under /api/v3.
+func githubRequest(cfg *Config, method, path string, body interface{}) ([]byte, error) {
+	url := cfg.GitHub.apiURL() + path
+	if path == "/graphql" {
+		url = strings.TrimSuffix(cfg.GitHub.apiURL(), "/v3") + path
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		bodyReader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if err := setGitHubAuth(cfg, req); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := doAPI(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("github API error %d: %s", resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
 }
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GitHubAppConfig authenticates as a GitHub App installation instead of
+// with github.token. factory signs a JWT with the app's private key and
+// trades it for installation tokens, which expire after an hour and are
+// renewed as needed, so PRs and pushes come from the app's bot account.
+type GitHubAppConfig struct {
+	AppID          int64 `json:"appId"`
+	InstallationID int64 `json:"installationId"`
+	// PrivateKey is the path to the app's private key (.pem)
+	PrivateKey string `json:"privateKey"`
+}
+
+// installationToken caches the installation token between requests
+var installationToken struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// cachedToken is an installation token as kept on disk, where factory's
+// tool hooks, each a process of its own, find the one the run has
+type cachedToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func tokenCachePath(app *GitHubAppConfig) string {
+	return filepath.Join(GetConfigDir(), fmt.Sprintf("github-app-%d.token", app.InstallationID))
+}
+
+// tokenLeft reports whether a token expiring then is still worth using: a
+// token handed to git must outlive the push it is used for
+func tokenLeft(expires time.Time) bool {
+	return time.Until(expires) > 10*time.Minute
+}
+
+// githubToken returns the token GitHub requests and git authenticate with:
+// a current installation token for a GitHub App, or github.token
+func githubToken(cfg *Config) (string, error) {
+	app := cfg.GitHub.App
+	if app == nil {
+		return cfg.GitHub.Token, nil
+	}
+	installationToken.Lock()
+	defer installationToken.Unlock()
+	if installationToken.token != "" && tokenLeft(installationToken.expires) {
+		return installationToken.token, nil
+	}
+	var tok cachedToken
+	if data, err := os.ReadFile(tokenCachePath(app)); err == nil && json.Unmarshal(data, &tok) == nil &&
+		tok.Token != "" && tokenLeft(tok.ExpiresAt) {
+		installationToken.token, installationToken.expires = tok.Token, tok.ExpiresAt
+		setSecret("github.installationToken", tok.Token)
+		return tok.Token, nil
+	}
+	jwt, err := app.jwt()
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", cfg.GitHub.apiURL(), app.InstallationID)
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := doAPI(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("github app installation token error %d: %s", resp.StatusCode, string(body))
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.Token == "" {
+		return "", fmt.Errorf("github returned no installation token")
+	}
+	installationToken.token, installationToken.expires = tok.Token, tok.ExpiresAt
+	setSecret("github.installationToken", tok.Token)
+	if data, err := json.Marshal(tok); err == nil {
+		os.WriteFile(tokenCachePath(app), data, 0600)
+	}
+	return tok.Token, nil
+}
+
+// setGitHubAuth signs req with the GitHub token
+func setGitHubAuth(cfg *Config, req *http.Request) error {
+	token, err := githubToken(cfg)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// jwt returns a JSON Web Token identifying the app, good for ten minutes.
+// It is backdated a minute to allow for clock drift.
+func (a *GitHubAppConfig) jwt() (string, error) {
+	key, err := a.privateKey()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.AppID, 10),
+	})
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// privateKey reads the app's RSA key, in the PKCS#1 form GitHub downloads
+// or as PKCS#8
+func (a *GitHubAppConfig) privateKey() (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(expandHome(a.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("github.app.privateKey: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("github.app.privateKey: %s is not a PEM file", a.PrivateKey)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("github.app.privateKey: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("github.app.privateKey: not an RSA key")
+	}
+	return key, nil
+}
@@ -0,0 +1,376 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultAllowedHosts are the hosts the agent may reach with curl or wget,
+// along with their subdomains and repo.allowedHosts
+var defaultAllowedHosts = []string{
+	"localhost",
+	"127.0.0.1",
+	"github.com",
+	"githubusercontent.com",
+	"registry.npmjs.org",
+	"proxy.golang.org",
+	"pypi.org",
+	"files.pythonhosted.org",
+}
+
+// guardViolation is a red-flag tool call that aborts the run
+type guardViolation struct {
+	Tool   string
+	Reason string
+}
+
+func (v *guardViolation) Error() string {
+	return fmt.Sprintf("blocked %s: %s; run killed", v.Tool, v.Reason)
+}
+
+// agentGuard watches the agent's tool calls for destructive or exfiltrating
+// operations. It backs up --allowedTools, which can't tell `rm -rf build`
+// from `rm -rf ~`. Commands run through sh -c, bash -c, or eval are
+// checked like any other, and so are deletes in python -c or node -e code.
+// The guard tool hook applies it before each call runs (see toolhook.go);
+// runClaude checks the calls again as they stream by, and kills a run that
+// tried one.
+type agentGuard struct {
+	repoPath string
+	hosts    []string
+}
+
+func newAgentGuard(cfg *Config, repoPath string) *agentGuard {
+	return &agentGuard{
+		repoPath: filepath.Clean(repoPath),
+		hosts:    append(append([]string(nil), defaultAllowedHosts...), cfg.Repo.AllowedHosts...),
+	}
+}
+
+var (
+	shellSeparators = regexp.MustCompile(`&&|\|\||[;|\n]`)
+	urlHost         = regexp.MustCompile(`https?://([^/\s:'"?#]+)`)
+)
+
+// check returns a *guardViolation if the tool call must not run, with
+// relative paths taken from the worktree root
+func (g *agentGuard) check(tool string, input map[string]interface{}) error {
+	return g.checkIn(g.repoPath, tool, input)
+}
+
+// checkIn is check for a call made with cwd as the working directory
+func (g *agentGuard) checkIn(cwd, tool string, input map[string]interface{}) error {
+	switch tool {
+	case "Bash":
+		command, _ := input["command"].(string)
+		if reason := g.checkScript(command, cwd); reason != "" {
+			return &guardViolation{Tool: tool, Reason: reason}
+		}
+	case "Edit", "MultiEdit", "Write", "NotebookEdit":
+		path, _ := input["file_path"].(string)
+		if path == "" {
+			path, _ = input["notebook_path"].(string)
+		}
+		if rel, inside := g.relPath(path, cwd); !inside {
+			return &guardViolation{Tool: tool, Reason: "write outside the worktree: " + path}
+		} else if rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)) {
+			return &guardViolation{Tool: tool, Reason: "write inside .git: " + path}
+		}
+	}
+	return nil
+}
+
+// unquote drops shell quoting, which can hide commands and separators from
+// checkScript, as in bash -c "ls; g\it push"
+var unquote = strings.NewReplacer(`'`, "", `"`, "", `\`, "")
+
+// checkScript inspects each simple command of a shell script run in cwd,
+// both as written and with its quoting removed. cd is followed, so later
+// commands' paths are resolved where they run.
+func (g *agentGuard) checkScript(script, cwd string) string {
+	for pass, s := range []string{script, unquote.Replace(script)} {
+		dir := cwd
+		segments := shellSeparators.Split(s, -1)
+		for i, segment := range segments {
+			if reason := g.checkCommand(segment, dir); reason != "" {
+				return reason
+			}
+			if inlineCode(segment) {
+				// The code can span the separators the script was split on
+				code := strings.Join(segments[i:], ";")
+				if reason := g.checkInlineDeletes(code, dir, pass == 0); reason != "" {
+					return reason
+				}
+			}
+			dir = changeDir(segment, dir)
+		}
+	}
+	return ""
+}
+
+// changeDir returns the directory the shell is in after segment runs in
+// dir: where cd or pushd takes it, or "" once that can't be told, as with
+// cd $DIR or cd -
+func changeDir(segment, dir string) string {
+	fields := strings.Fields(segment)
+	for len(fields) > 0 && (fields[0] == "(" || fields[0] == "{") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return dir
+	}
+	if cmd := strings.TrimLeft(fields[0], "({"); cmd != "cd" && cmd != "pushd" {
+		return dir
+	}
+	var target string
+	for _, arg := range fields[1:] {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			target = strings.TrimRight(arg, ")}")
+			break
+		}
+	}
+	switch {
+	case target == "" || strings.HasPrefix(target, "~"):
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(home, strings.TrimPrefix(target, "~"))
+	case target == "-" || strings.ContainsAny(target, "$`*?"):
+		return ""
+	case filepath.IsAbs(target):
+		return filepath.Clean(target)
+	case dir == "":
+		return ""
+	}
+	return filepath.Join(dir, target)
+}
+
+// interpreters run code given on the command line with -c or -e
+var interpreters = regexp.MustCompile(`^(python[0-9.]*|node|nodejs|perl|ruby)$`)
+
+// inlineCode reports whether segment starts an interpreter on code given
+// on its command line or in a here-document
+func inlineCode(segment string) bool {
+	fields := strings.Fields(segment)
+	for len(fields) > 0 && (containsAny(fields[:1], "sudo", "env", "exec", "command", "nohup") || strings.Contains(fields[0], "=")) {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || !interpreters.MatchString(filepath.Base(fields[0])) {
+		return false
+	}
+	for _, arg := range fields[1:] {
+		switch {
+		case arg == "-" || strings.HasPrefix(arg, "<<") || arg == "--eval" || arg == "--print":
+			return true
+		case strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsAny(arg, "cep"):
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// deleteCall matches the file deleting calls of Python, Node, Perl,
+	// and Ruby, and their first argument
+	deleteCall = regexp.MustCompile(`\b(?:os\.(?:remove|unlink|rmdir|removedirs)|shutil\.rmtree|rmtree|(?:fs|fsPromises|promises)\.(?:rmSync|rmdirSync|unlinkSync|rm|rmdir|unlink)|rmSync|rmdirSync|unlinkSync|FileUtils\.(?:rm|rm_r|rm_rf|rm_f|remove_dir|remove_entry)|File\.delete|unlink|rmdir)\b\s*\(?\s*([^,);]*)`)
+	// pathDelete matches a pathlib-style delete of a path the code built
+	pathDelete = regexp.MustCompile(`\.(?:unlink|rmdir|rmtree)\(\s*\)`)
+	// stringLiteral matches a quoted string in inline code
+	stringLiteral = regexp.MustCompile(`'([^']*)'|"([^"]*)"`)
+)
+
+// literals returns the quoted strings in code, and those quoted within them
+func literals(code string) []string {
+	var all []string
+	for _, m := range stringLiteral.FindAllStringSubmatch(code, -1) {
+		s := m[1] + m[2]
+		all = append(append(all, s), literals(s)...)
+	}
+	return all
+}
+
+// checkInlineDeletes checks the paths interpreter code deletes, resolved
+// against dir, and its strings as the shell commands it may run. asWritten
+// is false once the script's quoting was removed, when a string can no
+// longer be told from a variable; as written, a delete of anything but a
+// string literal can't be checked and is refused.
+func (g *agentGuard) checkInlineDeletes(code, dir string, asWritten bool) string {
+	if asWritten {
+		for _, s := range literals(code) {
+			if reason := g.checkScript(s, dir); reason != "" {
+				return reason
+			}
+		}
+	}
+	if strings.Contains(code, "chdir") {
+		if deleteCall.MatchString(code) || pathDelete.MatchString(code) {
+			return "delete after a directory change in inline code: " + code
+		}
+	}
+	if m := pathDelete.FindString(code); m != "" {
+		return "delete of a computed path in inline code: " + code
+	}
+	for _, m := range deleteCall.FindAllStringSubmatch(code, -1) {
+		arg := strings.TrimSpace(m[1])
+		if asWritten {
+			literal := len(arg) >= 2 && (arg[0] == '\'' || arg[0] == '"') && arg[len(arg)-1] == arg[0]
+			if !literal {
+				return "delete of a computed path in inline code: " + code
+			}
+			arg = arg[1 : len(arg)-1]
+		}
+		rel, inside := g.relPath(arg, dir)
+		if arg == "" || !inside || rel == "." || rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)) {
+			return "delete of " + arg + " in inline code: " + code
+		}
+	}
+	return ""
+}
+
+// shells run the script given with -c as a command of its own
+var shells = []string{"sh", "bash", "zsh", "dash", "ksh"}
+
+// checkNested inspects the script a shell or eval runs in dir. A script
+// built at run time, from a command substitution or a variable, can't be
+// checked and is refused.
+func (g *agentGuard) checkNested(script, segment, dir string) string {
+	script = strings.TrimSpace(script)
+	if strings.Contains(script, "`") || strings.Contains(script, "$(") || strings.HasPrefix(script, "$") {
+		return "nested command built at run time: " + segment
+	}
+	return g.checkScript(script, dir)
+}
+
+// checkCommand inspects one simple shell command run in dir
+func (g *agentGuard) checkCommand(segment, dir string) string {
+	fields := strings.Fields(segment)
+	// Skip sudo, env, exec, command, and VAR=value prefixes
+	for len(fields) > 0 && (containsAny(fields[:1], "sudo", "env", "exec", "command", "nohup") || strings.Contains(fields[0], "=")) {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	segment = strings.TrimSpace(segment)
+
+	if fields[0] == "eval" {
+		return g.checkNested(strings.Join(fields[1:], " "), segment, dir)
+	}
+	if containsAny([]string{filepath.Base(fields[0])}, shells...) {
+		for i, arg := range fields[1:] {
+			if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") {
+				return g.checkNested(strings.Join(fields[i+2:], " "), segment, dir)
+			}
+		}
+	}
+
+	switch filepath.Base(fields[0]) {
+	case "git":
+		sub, args := gitSubcommand(fields[1:])
+		switch {
+		case sub == "push":
+			return "git push: " + segment
+		case sub == "remote" && len(args) > 0 && containsAny(args[:1], "add", "set-url", "remove", "rm", "rename"):
+			return "git remote change: " + segment
+		case sub == "config" && !containsAny(args, "--get", "--get-all", "--get-regexp", "--list", "-l"):
+			return "git config change: " + segment
+		}
+	case "rm":
+		destructive := false
+		var targets []string
+		for _, arg := range fields[1:] {
+			if strings.HasPrefix(arg, "-") {
+				destructive = destructive || strings.ContainsAny(arg, "rRf") || arg == "--recursive" || arg == "--force"
+				continue
+			}
+			targets = append(targets, strings.Trim(arg, `"'`))
+		}
+		if !destructive {
+			break
+		}
+		for _, t := range targets {
+			rel, inside := g.relPath(t, dir)
+			if !inside || rel == "." || rel == ".git" {
+				return "forced delete of " + t + ": " + segment
+			}
+		}
+	case "curl", "wget":
+		for _, m := range urlHost.FindAllStringSubmatch(segment, -1) {
+			if !g.allowedHost(m[1]) {
+				return "request to unknown host " + m[1] + ": " + segment
+			}
+		}
+	}
+
+	if strings.Contains(segment, ".git/config") || strings.Contains(segment, ".git/hooks") {
+		switch filepath.Base(fields[0]) {
+		case "cat", "less", "head", "tail", "grep", "ls":
+		default:
+			return "touches .git internals: " + segment
+		}
+	}
+	return ""
+}
+
+// relPath resolves path against dir, the working directory, and returns it
+// relative to the worktree, reporting whether it stays inside it. A
+// relative path in an unknown dir ("") counts as outside.
+func (g *agentGuard) relPath(path, dir string) (string, bool) {
+	if path == "" {
+		return "", true
+	}
+	if strings.HasPrefix(path, "~") || strings.HasPrefix(path, "$HOME") || strings.HasPrefix(path, "${HOME}") {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		if dir == "" {
+			return "", false
+		}
+		path = filepath.Join(dir, path)
+	}
+	rel, err := filepath.Rel(g.repoPath, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (g *agentGuard) allowedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range g.hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitSubcommand skips git's global options and returns the subcommand and
+// its arguments
+func gitSubcommand(args []string) (string, []string) {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-C" || args[i] == "-c" || args[i] == "--git-dir" || args[i] == "--work-tree":
+			i++
+		case !strings.HasPrefix(args[i], "-"):
+			return args[i], args[i+1:]
+		}
+	}
+	return "", nil
+}
+
+func containsAny(words []string, targets ...string) bool {
+	for _, w := range words {
+		for _, t := range targets {
+			if w == t {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testGuard(t *testing.T) (*agentGuard, string) {
+	repo := filepath.Join(t.TempDir(), "repo")
+	cfg := &Config{}
+	cfg.Repo.AllowedHosts = []string{"example.com"}
+	return newAgentGuard(cfg, repo), repo
+}
+
+func TestGuardScript(t *testing.T) {
+	g, repo := testGuard(t)
+	tests := []struct {
+		script  string
+		blocked bool
+	}{
+		{"go test ./...", false},
+		{"rm -rf build", false},
+		{"rm build.log", false},
+		{"rm -rf ~", true},
+		{"rm -rf /", true},
+		{"rm -rf .", true},
+		{"rm -rf .git", true},
+		{"rm -rf ../other", true},
+		{"sudo rm -rf /tmp/x", true},
+		{"git status && git push origin main", true},
+		{"git -C . push", true},
+		{"git remote add evil https://evil.test/x", true},
+		{"git config user.name x", true},
+		{"git config --get user.name", false},
+		{"curl https://github.com/x", false},
+		{"curl https://api.example.com/x", false},
+		{"curl https://evil.test/x", true},
+		{"cat .git/config", false},
+		{"echo x > .git/hooks/pre-commit", true},
+
+		// Nested shells and quoting
+		{`bash -c "ls; git push"`, true},
+		{`bash -c "ls; g\it push"`, true},
+		{`sh -c 'g''it push'`, true},
+		{`bash -c "$CMD"`, true},
+		{"eval $(cat script)", true},
+		{"eval echo hi", false},
+
+		// Working directory changes
+		{"cd .. && rm -rf x", true},
+		{"cd sub && rm -rf ..", true},
+		{"cd sub && rm -rf ../x", false},
+		{"cd sub/deep; cd ../..; rm -rf build", false},
+		{"cd $DIR && rm -rf build", true},
+		{"cd - && rm -rf build", true},
+		{"cd /tmp && rm -rf x", true},
+		{"cd " + repo + "/sub && rm -rf x", false},
+		{"(cd .. && rm -rf x)", true},
+		{"pushd .. && rm -rf x", true},
+
+		// Inline interpreter code
+		{`python3 -c "print('hi')"`, false},
+		{`python3 -c "import shutil; shutil.rmtree('build')"`, false},
+		{`python3 -c "import shutil; shutil.rmtree('/etc')"`, true},
+		{`python -c "import os; os.remove('../x')"`, true},
+		{`python -c "import os; os.remove(p)"`, true},
+		{`python -c "import pathlib; pathlib.Path('x').unlink()"`, true},
+		{`python -c "import os; os.chdir('..'); os.remove('x')"`, true},
+		{`cd .. && python -c "import os; os.remove('x')"`, true},
+		{`node -e "require('fs').rmSync('dist', {recursive: true})"`, false},
+		{`node -e "require('fs').rmSync('/home', {recursive: true})"`, true},
+		{`node -e "fs.unlinkSync(process.argv[1])"`, true},
+		{`node -e "require('child_process').execSync('git push')"`, true},
+		{`python -c "import os; os.system('rm -rf ~')"`, true},
+		{`perl -e 'unlink "/etc/passwd"'`, true},
+		{`ruby -e 'FileUtils.rm_rf("..")'`, true},
+		{"python3 - <<EOF\nimport shutil\nshutil.rmtree('/')\nEOF", true},
+		{`p"ython" -c "import shutil; shutil.rmtree('/etc')"`, true},
+	}
+	for _, tt := range tests {
+		reason := g.checkScript(tt.script, repo)
+		if blocked := reason != ""; blocked != tt.blocked {
+			t.Errorf("checkScript(%q) = %q, want blocked %v", tt.script, reason, tt.blocked)
+		}
+	}
+}
+
+func TestGuardWrites(t *testing.T) {
+	g, repo := testGuard(t)
+	tests := []struct {
+		tool, path, cwd string
+		blocked         bool
+	}{
+		{"Write", filepath.Join(repo, "main.go"), repo, false},
+		{"Edit", "main.go", repo, false},
+		{"Edit", "../main.go", repo, true},
+		{"Edit", "../main.go", filepath.Join(repo, "sub"), false},
+		{"Write", filepath.Join(repo, ".git", "config"), repo, true},
+		{"Write", "/etc/passwd", repo, true},
+		{"MultiEdit", "~/.bashrc", repo, true},
+	}
+	for _, tt := range tests {
+		err := g.checkIn(tt.cwd, tt.tool, map[string]interface{}{"file_path": tt.path})
+		if blocked := err != nil; blocked != tt.blocked {
+			t.Errorf("%s %s in %s: %v, want blocked %v", tt.tool, tt.path, tt.cwd, err, tt.blocked)
+		}
+	}
+}
+
+func TestChangeDir(t *testing.T) {
+	tests := []struct {
+		segment, dir, want string
+	}{
+		{"ls", "/r", "/r"},
+		{"cd sub", "/r", "/r/sub"},
+		{"cd ..", "/r/sub", "/r"},
+		{"cd /tmp", "/r", "/tmp"},
+		{"cd -P ..", "/r/sub", "/r"},
+		{"cd -", "/r", ""},
+		{"cd $HOME/x", "/r", ""},
+		{"cd sub", "", ""},
+		{"( cd sub", "/r", "/r/sub"},
+	}
+	for _, tt := range tests {
+		if got := changeDir(tt.segment, tt.dir); got != tt.want {
+			t.Errorf("changeDir(%q, %q) = %q, want %q", tt.segment, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestGuardToolHook(t *testing.T) {
+	_, repo := testGuard(t)
+	loadedConfig = &Config{}
+	defer func() { loadedConfig = nil }()
+	tests := []struct {
+		call    string
+		blocked bool
+	}{
+		{`{"cwd": "` + repo + `", "tool_name": "Bash", "tool_input": {"command": "rm -rf build"}}`, false},
+		{`{"cwd": "` + repo + `/sub", "tool_name": "Bash", "tool_input": {"command": "rm -rf .."}}`, true},
+		{`{"cwd": "` + repo + `", "tool_name": "Write", "tool_input": {"file_path": "/etc/hosts"}}`, true},
+		{`{"tool_name": "Read", "tool_input": {"file_path": "/etc/hosts"}}`, false},
+	}
+	for _, tt := range tests {
+		err := RunToolHook(hookGuard, repo, strings.NewReader(tt.call))
+		if blocked := err != nil; blocked != tt.blocked {
+			t.Errorf("%s: %v, want blocked %v", tt.call, err, tt.blocked)
+		}
+	}
+}
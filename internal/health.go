@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// watchdogInterval is how often the watchdog checks the poll loop
+const watchdogInterval = 30 * time.Second
+
+// progressed records a sign of the poll loop moving: a poll, a run entering
+// a stage, or its agent reporting progress
+func (c *daemonControl) progressed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastProgress = time.Now()
+}
+
+func (c *daemonControl) pollSucceeded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSuccess = time.Now()
+	c.lastError, c.lastErrorAt = "", time.Time{}
+}
+
+func (c *daemonControl) pollFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastError, c.lastErrorAt = err.Error(), time.Now()
+}
+
+// stalled reports whether the poll loop has shown no progress for longer
+// than the config allows
+func (c *daemonControl) stalled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cfg == nil || c.lastProgress.IsZero() {
+		return false
+	}
+	return time.Since(c.lastProgress) > c.cfg.Poll.stallTimeout()
+}
+
+// healthReport is the daemon's health, from GET /healthz. Status is "ok",
+// "degraded" when the last poll failed, "stalled" when the poll loop has
+// stopped making progress, or "stopping".
+// Times the daemon hasn't seen yet are left out. The poll error itself can
+// name hosts or accounts, so it stays off the unauthenticated endpoint;
+// GET /api/status has it.
+type healthReport struct {
+	Status             string     `json:"status"`
+	PID                int        `json:"pid"`
+	LastPoll           *time.Time `json:"lastPoll,omitempty"`
+	LastSuccessfulPoll *time.Time `json:"lastSuccessfulPoll,omitempty"`
+	LastProgress       *time.Time `json:"lastProgress,omitempty"`
+	LastError          string     `json:"-"`
+	LastErrorAt        *time.Time `json:"lastErrorAt,omitempty"`
+	QueueDepth         int        `json:"queueDepth"`
+	Running            []string   `json:"running"`
+}
+
+// seen returns t, or nil when it is zero
+func seen(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (c *daemonControl) health() healthReport {
+	stalled := c.stalled()
+	c.mu.Lock()
+	h := healthReport{
+		Status:             "ok",
+		PID:                os.Getpid(),
+		LastPoll:           seen(c.lastPoll),
+		LastSuccessfulPoll: seen(c.lastSuccess),
+		LastProgress:       seen(c.lastProgress),
+		LastError:          c.lastError,
+		LastErrorAt:        seen(c.lastErrorAt),
+	}
+	cfg := c.cfg
+	c.mu.Unlock()
+
+	switch {
+	case c.isStopping():
+		h.Status = "stopping"
+	case stalled:
+		h.Status = "stalled"
+	case h.LastError != "":
+		h.Status = "degraded"
+	}
+	h.QueueDepth = len(loadQueue())
+	h.Running = []string{}
+	for _, l := range activeLeases(cfg) {
+		if l.Owner == leaseOwner() {
+			h.Running = append(h.Running, l.IssueKey)
+		}
+	}
+	return h
+}
+
+// handleHealth serves the daemon's health for monitors and service
+// managers: 200 while it is polling, 503 when it is stalled or stopping.
+// It needs no token, and says nothing about issues beyond their keys or
+// about why a poll failed.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	h := control.health()
+	w.Header().Set("Content-Type", "application/json")
+	if h.Status == "stalled" || h.Status == "stopping" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h)
+}
+
+// watchdog restarts the daemon when poll.watchdogMinutes is set and the poll
+// loop has shown no progress for that long: the run in progress, if any, is
+// interrupted as on `factory stop`, and a new daemon takes over. flush
+// writes out the daemon's buffered output before it is replaced.
+func watchdog(flush func()) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if control.config().Poll.WatchdogMinutes <= 0 || control.isStopping() || !control.stalled() {
+			continue
+		}
+		fmt.Printf("Watchdog: no progress for %s; restarting the daemon\n", control.config().Poll.stallTimeout())
+		control.interruptRuns()
+		deadline := time.Now().Add(stopGrace)
+		for len(control.health().Running) > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+		}
+		flush()
+		if err := restartDaemon(); err != nil {
+			fmt.Printf("Watchdog: could not restart the daemon: %v\n", err)
+			flush()
+			removePidFile()
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyEntry is a processed issue as the dashboard and `factory history`
+// list it
+type historyEntry struct {
+	Key string `json:"key"`
+	ProcessedIssue
+}
+
+// processedHistory lists processed issues most recent first
+func processedHistory(list map[string]ProcessedIssue) []historyEntry {
+	history := []historyEntry{}
+	for key, info := range list {
+		history = append(history, historyEntry{Key: key, ProcessedIssue: info})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].ProcessedAt > history[j].ProcessedAt
+	})
+	return history
+}
+
+// PrintJSON writes v to stdout as indented JSON, for the --json flags
+func PrintJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runDuration is the total time a run spent across its stages
+func runDuration(stages []StageTiming) time.Duration {
+	var secs float64
+	for _, s := range stages {
+		secs += s.Seconds
+	}
+	return time.Duration(secs * float64(time.Second)).Round(time.Second)
+}
+
+// HistoryOptions filters and orders `factory history`
+type HistoryOptions struct {
+	Failed  bool   // only failed runs
+	Since   string // only runs in this window, e.g. "7d" or "12h"
+	Project string // only issues in this Jira project
+	Sort    string // when (default), key, status, took, or cost
+	JSON    bool
+}
+
+// historySorts order history entries; when, took, and cost put the largest
+// first
+var historySorts = map[string]func(a, b historyEntry) bool{
+	"when":   func(a, b historyEntry) bool { return a.ProcessedAt > b.ProcessedAt },
+	"key":    func(a, b historyEntry) bool { return a.Key < b.Key },
+	"status": func(a, b historyEntry) bool { return a.Status < b.Status },
+	"took":   func(a, b historyEntry) bool { return runDuration(a.Stages) > runDuration(b.Stages) },
+	"cost":   func(a, b historyEntry) bool { return a.CostUSD > b.CostUSD },
+}
+
+// parseSince reads a --since window: a Go duration, or a number of days
+// or weeks like "7d" or "2w"
+func parseSince(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if v, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				break
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid --since %q (use e.g. 7d, 2w, or 12h)", s)
+	}
+	return d, nil
+}
+
+// filterHistory applies the options' filters and order to history
+func filterHistory(history []historyEntry, opts HistoryOptions) ([]historyEntry, error) {
+	less, ok := historySorts[opts.Sort]
+	if opts.Sort == "" {
+		less, ok = historySorts["when"], true
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid --sort %q (use when, key, status, took, or cost)", opts.Sort)
+	}
+	var cutoff time.Time
+	if opts.Since != "" {
+		d, err := parseSince(opts.Since)
+		if err != nil {
+			return nil, err
+		}
+		cutoff = time.Now().Add(-d)
+	}
+	project := strings.ToUpper(strings.TrimSuffix(opts.Project, "-"))
+
+	filtered := []historyEntry{}
+	for _, h := range history {
+		if opts.Failed && h.Status != "failed" {
+			continue
+		}
+		if project != "" && !strings.HasPrefix(strings.ToUpper(h.Key), project+"-") {
+			continue
+		}
+		if !cutoff.IsZero() {
+			if t, err := time.Parse(time.RFC3339, h.ProcessedAt); err != nil || t.Before(cutoff) {
+				continue
+			}
+		}
+		filtered = append(filtered, h)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool { return less(filtered[i], filtered[j]) })
+	return filtered, nil
+}
+
+// ShowHistory lists processed issues, or one issue's last run in detail,
+// including how long each stage took
+func ShowHistory(issueKey string, opts HistoryOptions) error {
+	history := processedHistory(readProcessed())
+	if issueKey != "" {
+		for _, h := range history {
+			if h.Key == issueKey {
+				if opts.JSON {
+					return PrintJSON(h)
+				}
+				showRun(h)
+				return nil
+			}
+		}
+		return fmt.Errorf("%s has not been processed", issueKey)
+	}
+	history, err := filterHistory(history, opts)
+	if err != nil {
+		return err
+	}
+	if opts.JSON {
+		return PrintJSON(history)
+	}
+	if len(history) == 0 {
+		fmt.Println("No matching processed issues")
+		return nil
+	}
+
+	fmt.Printf("History (%d):\n", len(history))
+	fmt.Printf("%-12s %-10s %-13s %-8s %-8s %s\n", "Issue", "Status", "When", "Took", "Cost", "PR/Error")
+	fmt.Println(strings.Repeat("-", 89))
+	for _, h := range history {
+		detail := h.PRUrl
+		if detail == "" {
+			detail = h.Error
+		}
+		if short := truncate(40, detail); short != detail {
+			detail = short + "..."
+		}
+		took := "-"
+		if len(h.Stages) > 0 {
+			took = runDuration(h.Stages).String()
+		}
+		cost := "-"
+		if h.CostUSD > 0 {
+			cost = fmt.Sprintf("$%.2f", h.CostUSD)
+		}
+		t, _ := time.Parse(time.RFC3339, h.ProcessedAt)
+		fmt.Printf("%-12s %-10s %-13s %-8s %-8s %s\n", h.Key, h.Status, t.Format("Jan 02 15:04"), took, cost, orDash(detail))
+	}
+	fmt.Println("\nDetails and stage timings: factory history KEY")
+	return nil
+}
+
+// showRun prints one issue's last run
+func showRun(h historyEntry) {
+	t, _ := time.Parse(time.RFC3339, h.ProcessedAt)
+	fmt.Printf("%s: %s at %s\n", h.Key, h.Status, t.Format("Jan 02 15:04"))
+	if h.PRUrl != "" {
+		fmt.Printf("PR: %s\n", h.PRUrl)
+	}
+	if h.Base != "" {
+		fmt.Printf("Base: %s\n", h.Base)
+	}
+	if h.Error != "" {
+		fmt.Printf("Error: %s\n", h.Error)
+	}
+	if h.CostUSD > 0 {
+		fmt.Printf("Cost: %s\n", formatCost(h.CostUSD, h.InputTokens, h.OutputTokens))
+	}
+	if len(h.Stages) == 0 {
+		return
+	}
+	fmt.Printf("\nStages (%s):\n", runDuration(h.Stages))
+	for _, s := range h.Stages {
+		d := time.Duration(s.Seconds * float64(time.Second)).Round(100 * time.Millisecond)
+		if s.Attempts > 1 {
+			fmt.Printf("  %-12s %-10s %d attempts\n", s.Stage, d, s.Attempts)
+		} else {
+			fmt.Printf("  %-12s %s\n", s.Stage, d)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Hook names, matching the keys under "hooks" in the config
+const (
+	HookPreClone  = "preClone"
+	HookPreAgent  = "preAgent"
+	HookPostAgent = "postAgent"
+	HookPrePush   = "prePush"
+	HookPostPR    = "postPR"
+)
+
+// hookTimeout bounds a single hook command
+const hookTimeout = 10 * time.Minute
+
+// hookContext is what a hook learns about the run through its environment
+type hookContext struct {
+	Issue    *Issue
+	RepoPath string
+	Branch   string
+	Base     string
+	PRURL    string
+	Files    []string // changed files, once the agent has run
+}
+
+func (c hookContext) env(name string) []string {
+	env := append(os.Environ(),
+		"FACTORY_HOOK="+name,
+		"FACTORY_ISSUE_KEY="+c.Issue.Key,
+		"FACTORY_ISSUE_TITLE="+c.Issue.Title,
+		"FACTORY_ISSUE_TYPE="+c.Issue.Type,
+		"FACTORY_ISSUE_PRIORITY="+c.Issue.Priority,
+		"FACTORY_ISSUE_LABELS="+strings.Join(c.Issue.Labels, ","),
+		"FACTORY_ISSUE_COMPONENTS="+strings.Join(c.Issue.Components, ","),
+		"FACTORY_REPO_PATH="+c.RepoPath,
+		"FACTORY_BASE="+c.Base,
+	)
+	if c.Branch != "" {
+		env = append(env, "FACTORY_BRANCH="+c.Branch)
+	}
+	if len(c.Files) > 0 {
+		env = append(env, "FACTORY_CHANGED_FILES="+strings.Join(c.Files, "\n"))
+	}
+	if c.PRURL != "" {
+		env = append(env, "FACTORY_PR_URL="+c.PRURL)
+	}
+	return env
+}
+
+// runHook runs the named hook command, if configured, through the shell in
+// the workspace. preClone runs in the workspace's parent directory, since
+// the clone may not exist yet. A non-zero exit is returned as an error.
+func runHook(cfg *Config, name string, c hookContext) error {
+	command := cfg.Hooks.command(name)
+	if command == "" {
+		return nil
+	}
+	dir := c.RepoPath
+	if name == HookPreClone {
+		dir = filepath.Dir(c.RepoPath)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("→ Running %s hook...\n", name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = dir
+	cmd.Env = c.env(name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s hook timed out after %s", name, hookTimeout)
+		}
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	return nil
+}
+
+// shellCommand runs command through the platform's shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
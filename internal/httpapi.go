@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// apiTimeout bounds a single Jira or GitHub request, including reading
+	// its response
+	apiTimeout = 2 * time.Minute
+	// apiAttempts is how many times a rate-limited or failed request is sent
+	apiAttempts = 4
+	// apiBackoff is the wait before the first retry; it doubles, with
+	// jitter, for each one after
+	apiBackoff = 2 * time.Second
+	// maxRetryAfter is the longest Retry-After that is waited out; a
+	// longer one fails the request instead of holding up the run
+	maxRetryAfter = 5 * time.Minute
+)
+
+// apiClient sends Jira and GitHub API requests
+var apiClient = &http.Client{Timeout: apiTimeout}
+
+var (
+	apiCtx     context.Context
+	apiCtxOnce sync.Once
+)
+
+// apiContext is cancelled when the daemon interrupts its runs, so a request
+// or a wait to retry one doesn't hold up a daemon that has to exit
+func apiContext() context.Context {
+	apiCtxOnce.Do(func() {
+		var cancel context.CancelFunc
+		apiCtx, cancel = context.WithCancel(context.Background())
+		go func() {
+			<-control.interrupt
+			cancel()
+		}()
+	})
+	return apiCtx
+}
+
+// doAPI sends a Jira or GitHub request, retrying when the server is rate
+// limiting (429, or GitHub's 403 with Retry-After or no requests left) or
+// unavailable (503), and on network errors and other 5xx responses for
+// requests that are safe to repeat. Retry-After is honored; otherwise
+// retries back off exponentially with jitter. The response of the last
+// attempt is returned as it is, for the caller to check its status.
+func doAPI(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(apiContext())
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := apiClient.Do(req)
+		if attempt == apiAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		wait := retryBackoff(attempt)
+		switch {
+		case err != nil:
+			if !idempotent(req.Method) {
+				return nil, err
+			}
+		case rateLimited(resp):
+			if after, ok := retryAfter(resp); ok {
+				if after > maxRetryAfter {
+					return resp, nil
+				}
+				wait = after
+			}
+		case resp.StatusCode == http.StatusServiceUnavailable,
+			resp.StatusCode >= 500 && idempotent(req.Method):
+			if after, ok := retryAfter(resp); ok && after <= maxRetryAfter {
+				wait = after
+			}
+		default:
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		reason := "network error"
+		if err == nil {
+			reason = resp.Status
+		}
+		fmt.Printf("  %s %s: %s; retrying in %s\n", req.Method, req.URL.Host, reason, wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// idempotent reports whether a request can be sent again after a failure
+// that may have happened after the server acted on it
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// rateLimited reports whether the server turned the request away for
+// sending too many: 429, or GitHub's 403 for an exhausted or secondary rate
+// limit
+func rateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode == http.StatusForbidden &&
+		(resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0")
+}
+
+// retryAfter reads how long the server asked to wait: Retry-After in
+// seconds or as a date, or GitHub's X-RateLimit-Reset
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	var until time.Time
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			until = t
+		}
+	} else if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			until = time.Unix(reset, 0)
+		}
+	}
+	if until.IsZero() {
+		return 0, false
+	}
+	if d := time.Until(until); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// retryBackoff is the wait before retry n: apiBackoff doubled for each
+// earlier retry, between half and all of it at random
+func retryBackoff(n int) time.Duration {
+	d := apiBackoff << (n - 1)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
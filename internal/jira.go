@@ -6,11 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Issue struct {
@@ -22,14 +27,54 @@ type Issue struct {
 	Status             string
 	Labels             []string
 	Components         []string
+	FixVersions        []string
 	AcceptanceCriteria string
 	Comments           []Comment
+	Attachments        []Attachment
+	Links              []IssueLink
+	StoryPoints        float64
+	Variables          map[string]string // from jira.fields.variables
+	Updated            time.Time         // zero with jira.useAcli
+	// Assignee and Reporter are display names, as comment authors are
+	Assignee string
+	Reporter string
+}
+
+// jiraUser is a user field as the REST API returns it; null when unset
+type jiraUser struct {
+	DisplayName string `json:"displayName"`
+}
+
+func (u *jiraUser) name() string {
+	if u == nil {
+		return ""
+	}
+	return u.DisplayName
+}
+
+// IssueLink is a related issue, e.g. "is blocked by PROJ-12"
+type IssueLink struct {
+	Relation   string
+	Key        string
+	Title      string
+	Status     string
+	Resolution string
+}
+
+type Attachment struct {
+	Filename  string
+	URL       string
+	MimeType  string
+	Size      int64
+	LocalPath string // set once downloaded into the workspace
 }
 
 type Comment struct {
 	Author string
 	Body   string
 	Date   string
+
+	created time.Time
 }
 
 func (i *Issue) IsValidType() bool {
@@ -38,7 +83,23 @@ func (i *Issue) IsValidType() bool {
 }
 
 func (i *Issue) IsClosed() bool {
-	s := strings.ToLower(i.Status)
+	return isClosedStatus(i.Status)
+}
+
+// hasLabel reports whether the issue has one of labels, in any case
+func (i *Issue) hasLabel(labels []string) bool {
+	for _, l := range i.Labels {
+		for _, want := range labels {
+			if strings.EqualFold(l, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isClosedStatus(status string) bool {
+	s := strings.ToLower(status)
 	return s == "done" || s == "closed" || s == "resolved" || s == "cancelled"
 }
 
@@ -51,9 +112,11 @@ func GetIssueACLI(issueKey string) (*Issue, error) {
 	if out, err := execJira("view", issueKey, "-t", "{{.fields.summary}}"); err == nil {
 		issue.Title = out
 	}
+	// The Jira CLI returns wiki markup; convert it so prompts and PR bodies
+	// get Markdown
 	if out, err := execJira("view", issueKey, "-t", "{{.fields.description}}"); err == nil {
-		issue.Description = out
-		issue.AcceptanceCriteria = extractAC(out)
+		issue.Description = jiraToMarkdown(out)
+		issue.AcceptanceCriteria = extractAC(issue.Description)
 	}
 	if out, err := execJira("view", issueKey, "-t", "{{.fields.issuetype.name}}"); err == nil {
 		issue.Type = out
@@ -64,13 +127,53 @@ func GetIssueACLI(issueKey string) (*Issue, error) {
 	if out, err := execJira("view", issueKey, "-t", "{{.fields.status.name}}"); err == nil {
 		issue.Status = out
 	}
+	if out, err := execJira("view", issueKey, "-t", "{{if .fields.assignee}}{{.fields.assignee.displayName}}{{end}}"); err == nil {
+		issue.Assignee = out
+	}
+	if out, err := execJira("view", issueKey, "-t", "{{if .fields.reporter}}{{.fields.reporter.displayName}}{{end}}"); err == nil {
+		issue.Reporter = out
+	}
+	if out, err := execJira("view", issueKey, "-t", "{{range .fields.fixVersions}}{{.name}}\n{{end}}"); err == nil {
+		for _, v := range strings.Split(out, "\n") {
+			if v = strings.TrimSpace(v); v != "" {
+				issue.FixVersions = append(issue.FixVersions, v)
+			}
+		}
+	}
+	if out, err := execJira("view", issueKey, "-t", `{{range .fields.attachment}}{{.filename}}|||{{.content}}|||{{.mimeType}}|||{{.size}}
+{{end}}`); err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			parts := strings.Split(strings.TrimSpace(line), "|||")
+			if len(parts) < 4 {
+				continue
+			}
+			size, _ := strconv.ParseInt(parts[3], 10, 64)
+			issue.Attachments = append(issue.Attachments, Attachment{
+				Filename: parts[0],
+				URL:      parts[1],
+				MimeType: parts[2],
+				Size:     size,
+			})
+		}
+	}
+
+	if out, err := execJira("view", issueKey, "-t", `{{range .fields.issuelinks}}{{.type.name}}|||{{if .outwardIssue}}{{.type.outward}}|||{{.outwardIssue.key}}|||{{.outwardIssue.fields.summary}}|||{{.outwardIssue.fields.status.name}}{{else}}{{.type.inward}}|||{{.inwardIssue.key}}|||{{.inwardIssue.fields.summary}}|||{{.inwardIssue.fields.status.name}}{{end}}
+{{end}}`); err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			parts := strings.Split(strings.TrimSpace(line), "|||")
+			if len(parts) < 5 || !isContextLinkType(parts[0]) {
+				continue
+			}
+			link := IssueLink{Relation: parts[1], Key: parts[2], Title: parts[3], Status: parts[4]}
+			link.Resolution, _ = execJira("view", link.Key, "-t", "{{if .fields.resolution}}{{.fields.resolution.name}}{{end}}")
+			issue.Links = append(issue.Links, link)
+		}
+	}
 
 	return issue, nil
 }
 
-func GetAssignedIssuesACLI() ([]Issue, error) {
-	jql := `assignee = currentUser() AND status != Done AND status != Closed AND type in (Bug, Task, Story) ORDER BY updated DESC`
-
+func GetAssignedIssuesACLI(jql string) ([]Issue, error) {
 	out, err := execJira("list", "-q", jql)
 	if err != nil {
 		return nil, err
@@ -94,11 +197,21 @@ func GetAssignedIssuesACLI() ([]Issue, error) {
 	return issues, nil
 }
 
+func AssignACLI(issueKey, user string) error {
+	_, err := execJira("assign", issueKey, user)
+	return err
+}
+
 func AddCommentACLI(issueKey, comment string) error {
 	_, err := execJira("comment", issueKey, "-m", comment)
 	return err
 }
 
+func SetFieldACLI(issueKey, fieldID, value string) error {
+	_, err := execJira("edit", issueKey, "--noedit", "-o", fieldID+"="+value)
+	return err
+}
+
 func TransitionACLI(issueKey, status string) error {
 	_, err := execJira("transition", status, issueKey)
 	return err
@@ -121,10 +234,12 @@ func GetCommentsACLI(issueKey string) ([]Comment, error) {
 		}
 		parts := strings.Split(block, "|||")
 		if len(parts) >= 3 {
+			created, _ := time.Parse(jiraTimeLayout, strings.TrimSpace(parts[2]))
 			comments = append(comments, Comment{
-				Author: strings.TrimSpace(parts[0]),
-				Body:   strings.TrimSpace(parts[1]),
-				Date:   strings.TrimSpace(parts[2]),
+				Author:  strings.TrimSpace(parts[0]),
+				Body:    jiraToMarkdown(strings.TrimSpace(parts[1])),
+				Date:    formatJiraDate(strings.TrimSpace(parts[2])),
+				created: created,
 			})
 		}
 	}
@@ -143,7 +258,12 @@ func execJira(args ...string) (string, error) {
 // --- REST Implementation ---
 
 func GetIssueREST(cfg *Config, issueKey string) (*Issue, error) {
-	path := fmt.Sprintf("/rest/api/3/issue/%s?fields=summary,description,issuetype,priority,status,labels,components", issueKey)
+	cfg = cfg.jiraSite(issueKey)
+	fields := "summary,description,issuetype,priority,status,labels,components,fixVersions,attachment,issuelinks,updated,assignee,reporter"
+	if ids := cfg.Jira.Fields.customFieldIDs(); len(ids) > 0 {
+		fields += "," + strings.Join(ids, ",")
+	}
+	path := fmt.Sprintf("%s/issue/%s?fields=%s", cfg.Jira.restAPI(), issueKey, fields)
 	body, err := jiraRequest(cfg, "GET", path, nil)
 	if err != nil {
 		return nil, err
@@ -152,19 +272,32 @@ func GetIssueREST(cfg *Config, issueKey string) (*Issue, error) {
 	var data struct {
 		Key    string `json:"key"`
 		Fields struct {
-			Summary     string `json:"summary"`
-			Description struct {
-				Content []struct {
-					Content []struct {
-						Text string `json:"text"`
-					} `json:"content"`
-				} `json:"content"`
-			} `json:"description"`
-			IssueType  struct{ Name string } `json:"issuetype"`
-			Priority   struct{ Name string } `json:"priority"`
-			Status     struct{ Name string } `json:"status"`
-			Labels     []string              `json:"labels"`
-			Components []struct{ Name string } `json:"components"`
+			Summary     string                  `json:"summary"`
+			Description json.RawMessage         `json:"description"`
+			IssueType   struct{ Name string }   `json:"issuetype"`
+			Priority    struct{ Name string }   `json:"priority"`
+			Status      struct{ Name string }   `json:"status"`
+			Updated     string                  `json:"updated"`
+			Assignee    *jiraUser               `json:"assignee"`
+			Reporter    *jiraUser               `json:"reporter"`
+			Labels      []string                `json:"labels"`
+			Components  []struct{ Name string } `json:"components"`
+			FixVersions []struct{ Name string } `json:"fixVersions"`
+			Attachment  []struct {
+				Filename string `json:"filename"`
+				Content  string `json:"content"`
+				MimeType string `json:"mimeType"`
+				Size     int64  `json:"size"`
+			} `json:"attachment"`
+			IssueLinks []struct {
+				Type struct {
+					Name    string `json:"name"`
+					Inward  string `json:"inward"`
+					Outward string `json:"outward"`
+				} `json:"type"`
+				InwardIssue  *linkedIssue `json:"inwardIssue"`
+				OutwardIssue *linkedIssue `json:"outwardIssue"`
+			} `json:"issuelinks"`
 		} `json:"fields"`
 	}
 
@@ -172,21 +305,75 @@ func GetIssueREST(cfg *Config, issueKey string) (*Issue, error) {
 		return nil, err
 	}
 
-	var desc []string
-	for _, block := range data.Fields.Description.Content {
-		for _, c := range block.Content {
-			if c.Text != "" {
-				desc = append(desc, c.Text)
-			}
-		}
-	}
-	description := strings.Join(desc, "\n")
+	description := jiraText(data.Fields.Description)
 
 	var comps []string
 	for _, c := range data.Fields.Components {
 		comps = append(comps, c.Name)
 	}
+	var versions []string
+	for _, v := range data.Fields.FixVersions {
+		versions = append(versions, v.Name)
+	}
+
+	var attachments []Attachment
+	for _, a := range data.Fields.Attachment {
+		attachments = append(attachments, Attachment{
+			Filename: a.Filename,
+			URL:      a.Content,
+			MimeType: a.MimeType,
+			Size:     a.Size,
+		})
+	}
 
+	var links []IssueLink
+	for _, l := range data.Fields.IssueLinks {
+		if !isContextLinkType(l.Type.Name) {
+			continue
+		}
+		relation, other := l.Type.Outward, l.OutwardIssue
+		if other == nil {
+			relation, other = l.Type.Inward, l.InwardIssue
+		}
+		if other == nil {
+			continue
+		}
+		links = append(links, IssueLink{
+			Relation:   relation,
+			Key:        other.Key,
+			Title:      other.Fields.Summary,
+			Status:     other.Fields.Status.Name,
+			Resolution: getResolutionREST(cfg, other.Key),
+		})
+	}
+
+	var raw struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	json.Unmarshal(body, &raw)
+
+	mapping := cfg.Jira.Fields
+	ac := extractAC(description)
+	if mapping.AcceptanceCriteria != "" {
+		if v := customFieldText(raw.Fields[mapping.AcceptanceCriteria]); v != "" {
+			ac = v
+		}
+	}
+	var points float64
+	if mapping.StoryPoints != "" {
+		points, _ = strconv.ParseFloat(customFieldText(raw.Fields[mapping.StoryPoints]), 64)
+	}
+	var vars map[string]string
+	for name, id := range mapping.Variables {
+		if v := customFieldText(raw.Fields[id]); v != "" {
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			vars[name] = v
+		}
+	}
+
+	updated, _ := time.Parse(jiraTimeLayout, data.Fields.Updated)
 	return &Issue{
 		Key:                data.Key,
 		Title:              data.Fields.Summary,
@@ -196,13 +383,109 @@ func GetIssueREST(cfg *Config, issueKey string) (*Issue, error) {
 		Status:             data.Fields.Status.Name,
 		Labels:             data.Fields.Labels,
 		Components:         comps,
-		AcceptanceCriteria: extractAC(description),
+		FixVersions:        versions,
+		AcceptanceCriteria: ac,
+		Attachments:        attachments,
+		Links:              links,
+		StoryPoints:        points,
+		Variables:          vars,
+		Updated:            updated,
+		Assignee:           data.Fields.Assignee.name(),
+		Reporter:           data.Fields.Reporter.name(),
 	}, nil
 }
 
+// customFieldText renders a custom field value, which may be a string, a
+// number, an ADF document, a select option, a user, or a list of those
+func customFieldText(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		return strings.TrimSpace(str)
+	}
+	var num json.Number
+	if json.Unmarshal(raw, &num) == nil {
+		return num.String()
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			if v := customFieldText(item); v != "" {
+				parts = append(parts, v)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+
+	var obj struct {
+		Type        string `json:"type"`
+		Value       string `json:"value"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	}
+	if json.Unmarshal(raw, &obj) != nil {
+		return ""
+	}
+	switch {
+	case obj.Type == "doc":
+		var doc adfNode
+		json.Unmarshal(raw, &doc)
+		return renderADF(doc)
+	case obj.Value != "":
+		return obj.Value
+	case obj.DisplayName != "":
+		return obj.DisplayName
+	default:
+		return obj.Name
+	}
+}
+
+// jiraText renders a description or comment body: an ADF document from
+// REST API v3, or wiki markup from v2
+func jiraText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return jiraToMarkdown(text)
+	}
+	var doc adfNode
+	json.Unmarshal(raw, &doc)
+	return renderADF(doc)
+}
+
+type linkedIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary string                `json:"summary"`
+		Status  struct{ Name string } `json:"status"`
+	} `json:"fields"`
+}
+
+// getResolutionREST fetches a linked issue's resolution, which Jira omits
+// from the issuelinks payload
+func getResolutionREST(cfg *Config, issueKey string) string {
+	cfg = cfg.jiraSite(issueKey)
+	body, err := jiraRequest(cfg, "GET", fmt.Sprintf("%s/issue/%s?fields=resolution", cfg.Jira.restAPI(), issueKey), nil)
+	if err != nil {
+		return ""
+	}
+	var data struct {
+		Fields struct {
+			Resolution *struct{ Name string } `json:"resolution"`
+		} `json:"fields"`
+	}
+	if json.Unmarshal(body, &data) != nil || data.Fields.Resolution == nil {
+		return ""
+	}
+	return data.Fields.Resolution.Name
+}
+
 func GetAssignedIssuesREST(cfg *Config) ([]Issue, error) {
-	jql := url.QueryEscape(`assignee = currentUser() AND status != Done AND status != Closed AND type in (Bug, Task, Story)`)
-	path := fmt.Sprintf("/rest/api/3/search?jql=%s&fields=summary,issuetype,status&maxResults=20", jql)
+	jql := url.QueryEscape(assignedJQL(cfg))
+	path := fmt.Sprintf("%s/search?jql=%s&fields=summary,issuetype,priority,status,updated,assignee,reporter&maxResults=20", cfg.Jira.restAPI(), jql)
 
 	body, err := jiraRequest(cfg, "GET", path, nil)
 	if err != nil {
@@ -213,9 +496,13 @@ func GetAssignedIssuesREST(cfg *Config) ([]Issue, error) {
 		Issues []struct {
 			Key    string `json:"key"`
 			Fields struct {
-				Summary   string             `json:"summary"`
+				Summary   string                `json:"summary"`
 				IssueType struct{ Name string } `json:"issuetype"`
+				Priority  struct{ Name string } `json:"priority"`
 				Status    struct{ Name string } `json:"status"`
+				Updated   string                `json:"updated"`
+				Assignee  *jiraUser             `json:"assignee"`
+				Reporter  *jiraUser             `json:"reporter"`
 			} `json:"fields"`
 		} `json:"issues"`
 	}
@@ -226,34 +513,113 @@ func GetAssignedIssuesREST(cfg *Config) ([]Issue, error) {
 
 	var issues []Issue
 	for _, item := range data.Issues {
+		updated, _ := time.Parse(jiraTimeLayout, item.Fields.Updated)
 		issues = append(issues, Issue{
-			Key:    item.Key,
-			Title:  item.Fields.Summary,
-			Type:   item.Fields.IssueType.Name,
-			Status: item.Fields.Status.Name,
+			Key:      item.Key,
+			Title:    item.Fields.Summary,
+			Type:     item.Fields.IssueType.Name,
+			Priority: item.Fields.Priority.Name,
+			Status:   item.Fields.Status.Name,
+			Updated:  updated,
+			Assignee: item.Fields.Assignee.name(),
+			Reporter: item.Fields.Reporter.name(),
 		})
 	}
 	return issues, nil
 }
 
-func AddCommentREST(cfg *Config, issueKey, comment string) error {
-	path := fmt.Sprintf("/rest/api/3/issue/%s/comment", issueKey)
-	body := map[string]interface{}{
-		"body": map[string]interface{}{
-			"type": "doc", "version": 1,
-			"content": []map[string]interface{}{
-				{"type": "paragraph", "content": []map[string]interface{}{
-					{"type": "text", "text": comment},
-				}},
+// AddCommentREST posts a comment and returns its ID
+func AddCommentREST(cfg *Config, issueKey, comment string) (string, error) {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s/comment", cfg.Jira.restAPI(), issueKey)
+	body, err := jiraRequest(cfg, "POST", path, commentBody(cfg, comment))
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(body, &created)
+	return created.ID, nil
+}
+
+// UpdateCommentREST replaces a comment's body without notifying watchers
+func UpdateCommentREST(cfg *Config, issueKey, commentID, comment string) error {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s/comment/%s?notifyUsers=false", cfg.Jira.restAPI(), issueKey, commentID)
+	_, err := jiraRequest(cfg, "PUT", path, commentBody(cfg, comment))
+	return err
+}
+
+// commentBody is a comment as the REST API takes it: ADF for API v3, and
+// the text as it is for v2, as the Jira CLI posts it
+func commentBody(cfg *Config, comment string) map[string]interface{} {
+	body := map[string]interface{}{"body": markdownToADF(comment)}
+	if cfg.Jira.serverAPI() {
+		body["body"] = comment
+	}
+	if v := cfg.Jira.Comments.Visibility; v != nil {
+		body["visibility"] = map[string]string{"type": v.Type, "value": v.Value}
+	}
+	return body
+}
+
+// AddRemoteLinkREST links a PR from the issue. The URL doubles as the link's
+// global ID, so linking the same PR again updates it instead of duplicating.
+func AddRemoteLinkREST(cfg *Config, issueKey, url, title string) error {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s/remotelink", cfg.Jira.restAPI(), issueKey)
+	_, err := jiraRequest(cfg, "POST", path, map[string]interface{}{
+		"globalId":     url,
+		"relationship": "pull request",
+		"object": map[string]interface{}{
+			"url":   url,
+			"title": title,
+			"icon": map[string]string{
+				"url16x16": "https://github.com/favicon.ico",
+				"title":    "GitHub",
 			},
 		},
+	})
+	recordAudit(actorOf(cfg), "jira.link", issueKey, issueKey, url, err)
+	return err
+}
+
+// AssignREST assigns the issue to a user: an account ID on Jira Cloud, a
+// username on Jira Server and Data Center
+func AssignREST(cfg *Config, issueKey, user string) error {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s/assignee", cfg.Jira.restAPI(), issueKey)
+	field := "accountId"
+	if cfg.Jira.serverAPI() {
+		field = "name"
 	}
-	_, err := jiraRequest(cfg, "POST", path, body)
+	_, err := jiraRequest(cfg, "PUT", path, map[string]string{field: user})
+	return err
+}
+
+func SetFieldREST(cfg *Config, issueKey, fieldID, value string) error {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s", cfg.Jira.restAPI(), issueKey)
+	_, err := jiraRequest(cfg, "PUT", path, map[string]interface{}{
+		"fields": map[string]string{fieldID: value},
+	})
+	return err
+}
+
+// SetNumberFieldREST sets a number custom field
+func SetNumberFieldREST(cfg *Config, issueKey, fieldID string, value float64) error {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s", cfg.Jira.restAPI(), issueKey)
+	_, err := jiraRequest(cfg, "PUT", path, map[string]interface{}{
+		"fields": map[string]float64{fieldID: value},
+	})
 	return err
 }
 
 func GetCommentsREST(cfg *Config, issueKey string) ([]Comment, error) {
-	path := fmt.Sprintf("/rest/api/3/issue/%s/comment?orderBy=-created&maxResults=10", issueKey)
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s/comment?orderBy=-created&maxResults=10", cfg.Jira.restAPI(), issueKey)
 	body, err := jiraRequest(cfg, "GET", path, nil)
 	if err != nil {
 		return nil, err
@@ -264,14 +630,8 @@ func GetCommentsREST(cfg *Config, issueKey string) ([]Comment, error) {
 			Author struct {
 				DisplayName string `json:"displayName"`
 			} `json:"author"`
-			Body struct {
-				Content []struct {
-					Content []struct {
-						Text string `json:"text"`
-					} `json:"content"`
-				} `json:"content"`
-			} `json:"body"`
-			Created string `json:"created"`
+			Body    json.RawMessage `json:"body"`
+			Created string          `json:"created"`
 		} `json:"comments"`
 	}
 
@@ -280,27 +640,24 @@ func GetCommentsREST(cfg *Config, issueKey string) ([]Comment, error) {
 	}
 
 	var comments []Comment
-	for _, c := range data.Comments {
-		var bodyText []string
-		for _, block := range c.Body.Content {
-			for _, content := range block.Content {
-				if content.Text != "" {
-					bodyText = append(bodyText, content.Text)
-				}
-			}
-		}
+	// Jira returns newest first; present them oldest first in the prompt
+	for i := len(data.Comments) - 1; i >= 0; i-- {
+		c := data.Comments[i]
+		created, _ := time.Parse(jiraTimeLayout, c.Created)
 		comments = append(comments, Comment{
-			Author: c.Author.DisplayName,
-			Body:   strings.Join(bodyText, "\n"),
-			Date:   c.Created,
+			Author:  c.Author.DisplayName,
+			Body:    jiraText(c.Body),
+			Date:    formatJiraDate(c.Created),
+			created: created,
 		})
 	}
 	return comments, nil
 }
 
 func TransitionREST(cfg *Config, issueKey, status string) error {
+	cfg = cfg.jiraSite(issueKey)
 	// Get transitions
-	path := fmt.Sprintf("/rest/api/3/issue/%s/transitions", issueKey)
+	path := fmt.Sprintf("%s/issue/%s/transitions", cfg.Jira.restAPI(), issueKey)
 	body, err := jiraRequest(cfg, "GET", path, nil)
 	if err != nil {
 		return err
@@ -333,32 +690,152 @@ func TransitionREST(cfg *Config, issueKey, status string) error {
 }
 
 func jiraRequest(cfg *Config, method, path string, body interface{}) ([]byte, error) {
-	var bodyReader io.Reader
+	var data []byte
 	if body != nil {
-		data, _ := json.Marshal(body)
-		bodyReader = bytes.NewReader(data)
+		data, _ = json.Marshal(body)
 	}
 
-	req, err := http.NewRequest(method, cfg.Jira.BaseURL+path, bodyReader)
-	if err != nil {
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		var bodyReader io.Reader
+		if body != nil {
+			bodyReader = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(method, cfg.Jira.apiURL()+path, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := setJiraAuth(cfg, req); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := doAPI(req)
+		if err != nil {
+			return nil, err
+		}
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		// An OAuth access token can be revoked before it expires
+		if resp.StatusCode == http.StatusUnauthorized && cfg.Jira.OAuth != nil && attempt == 1 {
+			forgetJiraToken()
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("jira API error %d: %s", resp.StatusCode, string(respBody))
+		}
+		return respBody, nil
 	}
+}
 
+// setJiraAuth signs req with an OAuth access token, a personal access
+// token, or the email and API token
+func setJiraAuth(cfg *Config, req *http.Request) error {
+	if cfg.Jira.OAuth != nil {
+		token, err := jiraAccessToken(cfg)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if cfg.Jira.PersonalAccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Jira.PersonalAccessToken)
+		return nil
+	}
 	auth := base64.StdEncoding.EncodeToString([]byte(cfg.Jira.Email + ":" + cfg.Jira.APIToken))
 	req.Header.Set("Authorization", "Basic "+auth)
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// --- Attachments ---
+
+// maxAttachmentSize skips attachments too large to be useful as context
+const maxAttachmentSize = 10 << 20
+
+// DownloadAttachments saves the issue's attachments into dir and records
+// their local paths. Failures are logged and skipped so a broken attachment
+// never blocks the run.
+func DownloadAttachments(cfg *Config, issue *Issue, dir string) error {
+	cfg = cfg.jiraSite(issue.Key)
+	if len(issue.Attachments) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	for i := range issue.Attachments {
+		a := &issue.Attachments[i]
+		if a.Size > maxAttachmentSize {
+			fmt.Printf("  Skipping attachment %s (%d bytes)\n", a.Filename, a.Size)
+			continue
+		}
+
+		req, err := http.NewRequest("GET", cfg.Jira.apiLink(a.URL), nil)
+		if err == nil {
+			err = setJiraAuth(cfg, req)
+		}
+		if err != nil {
+			fmt.Printf("  Warning: attachment %s: %v\n", a.Filename, err)
+			continue
+		}
+
+		resp, err := doAPI(req)
+		if err != nil {
+			fmt.Printf("  Warning: attachment %s: %v\n", a.Filename, err)
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize))
+		resp.Body.Close()
+		if err != nil || resp.StatusCode >= 400 {
+			fmt.Printf("  Warning: attachment %s: download failed (%d)\n", a.Filename, resp.StatusCode)
+			continue
+		}
+
+		// Prefix with the index so duplicate filenames don't collide
+		path := filepath.Join(dir, fmt.Sprintf("%d-%s", i+1, filepath.Base(a.Filename)))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			fmt.Printf("  Warning: attachment %s: %v\n", a.Filename, err)
+			continue
+		}
+		a.LocalPath = path
+	}
+	return nil
+}
+
+// AddAttachmentREST uploads data to the issue as a file attachment
+func AddAttachmentREST(cfg *Config, issueKey, filename string, data []byte) error {
+	cfg = cfg.jiraSite(issueKey)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", filename)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
+	part.Write(data)
+	mw.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
+	req, err := http.NewRequest("POST", cfg.Jira.apiURL()+cfg.Jira.restAPI()+"/issue/"+issueKey+"/attachments", &body)
+	if err != nil {
+		return err
+	}
+	if err := setJiraAuth(cfg, req); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Atlassian-Token", "no-check")
+
+	resp, err := doAPI(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("jira API error %d: %s", resp.StatusCode, string(respBody))
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("jira API error %d: %s", resp.StatusCode, string(respBody))
 	}
-	return respBody, nil
+	return nil
 }
 
 // --- Unified Interface ---
@@ -378,7 +855,10 @@ func GetIssue(cfg *Config, issueKey string) (*Issue, error) {
 	}
 
 	// Fetch comments
-	comments, _ := GetComments(cfg, issueKey)
+	comments, err := GetComments(cfg, issueKey)
+	if err != nil {
+		fmt.Printf("  Warning: could not fetch comments: %v\n", err)
+	}
 	issue.Comments = comments
 
 	return issue, nil
@@ -393,23 +873,96 @@ func GetComments(cfg *Config, issueKey string) ([]Comment, error) {
 
 func GetAssignedIssues(cfg *Config) ([]Issue, error) {
 	if cfg.Jira.UseACLI {
-		return GetAssignedIssuesACLI()
+		return GetAssignedIssuesACLI(assignedJQL(cfg))
+	}
+	if len(cfg.Jira.Sites) > 0 {
+		return getAssignedIssuesAllSites(cfg)
 	}
 	return GetAssignedIssuesREST(cfg)
 }
 
+// assignedJQL selects open issues for the configured assignee, group, or
+// the authenticated user
+func assignedJQL(cfg *Config) string {
+	assignee := "assignee = currentUser()"
+	switch {
+	case cfg.Poll.AssigneeGroup != "":
+		assignee = fmt.Sprintf("assignee in membersOf(%s)", jqlQuote(cfg.Poll.AssigneeGroup))
+	case cfg.Poll.Assignee != "":
+		assignee = fmt.Sprintf("assignee = %s", jqlQuote(cfg.Poll.Assignee))
+	}
+	return projectsJQL(cfg) + assignee + ` AND status != Done AND status != Closed AND type in (Bug, Task, Story) ORDER BY updated DESC`
+}
+
+func jqlQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func Assign(cfg *Config, issueKey, user string) error {
+	var err error
+	if cfg.Jira.UseACLI {
+		err = AssignACLI(issueKey, user)
+	} else {
+		err = AssignREST(cfg, issueKey, user)
+	}
+	recordAudit(actorOf(cfg.jiraSite(issueKey)), "jira.assign", issueKey, issueKey, user, err)
+	return err
+}
+
 func AddComment(cfg *Config, issueKey, comment string) error {
+	// Comments quote errors, which can carry credentials
+	comment = redact(comment)
+	var err error
+	switch {
+	case cfg.Jira.UseACLI:
+		err = AddCommentACLI(issueKey, comment)
+	case cfg.Jira.Comments.Consolidate:
+		err = addConsolidatedComment(cfg, issueKey, comment)
+	default:
+		_, err = AddCommentREST(cfg, issueKey, comment)
+	}
+	recordAudit(actorOf(cfg.jiraSite(issueKey)), "jira.comment", issueKey, issueKey, comment, err)
+	return err
+}
+
+// SetField sets a single (custom) field on the issue to a text value
+func SetField(cfg *Config, issueKey, fieldID, value string) error {
+	var err error
 	if cfg.Jira.UseACLI {
-		return AddCommentACLI(issueKey, comment)
+		err = SetFieldACLI(issueKey, fieldID, value)
+	} else {
+		err = SetFieldREST(cfg, issueKey, fieldID, value)
 	}
-	return AddCommentREST(cfg, issueKey, comment)
+	recordAudit(actorOf(cfg.jiraSite(issueKey)), "jira.field", issueKey, issueKey, fieldID+" = "+value, err)
+	return err
+}
+
+func SetNumberField(cfg *Config, issueKey, fieldID string, value float64) error {
+	var err error
+	if cfg.Jira.UseACLI {
+		err = SetFieldACLI(issueKey, fieldID, strconv.FormatFloat(value, 'f', -1, 64))
+	} else {
+		err = SetNumberFieldREST(cfg, issueKey, fieldID, value)
+	}
+	recordAudit(actorOf(cfg.jiraSite(issueKey)), "jira.field", issueKey, issueKey, fmt.Sprintf("%s = %v", fieldID, value), err)
+	return err
 }
 
 func Transition(cfg *Config, issueKey, status string) error {
+	var err error
 	if cfg.Jira.UseACLI {
-		return TransitionACLI(issueKey, status)
+		err = TransitionACLI(issueKey, status)
+	} else {
+		err = TransitionREST(cfg, issueKey, status)
 	}
-	return TransitionREST(cfg, issueKey, status)
+	recordAudit(actorOf(cfg.jiraSite(issueKey)), "jira.transition", issueKey, issueKey, status, err)
+	return err
+}
+
+// isContextLinkType reports whether a link type is worth showing the agent
+func isContextLinkType(name string) bool {
+	n := strings.ToLower(name)
+	return strings.Contains(n, "block") || strings.Contains(n, "relat") || strings.Contains(n, "duplicat")
 }
 
 func extractAC(desc string) string {
@@ -419,3 +972,15 @@ func extractAC(desc string) string {
 	}
 	return ""
 }
+
+// jiraTimeLayout is how Jira writes timestamps, 2024-01-14T10:30:00.000+0000
+const jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// formatJiraDate shortens Jira timestamps for the prompt
+func formatJiraDate(s string) string {
+	t, err := time.Parse(jiraTimeLayout, s)
+	if err != nil {
+		return s
+	}
+	return t.Format("2006-01-02 15:04")
+}
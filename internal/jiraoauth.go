@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	atlassianAuthURL  = "https://auth.atlassian.com/authorize"
+	atlassianTokenURL = "https://auth.atlassian.com/oauth/token"
+	atlassianAPIURL   = "https://api.atlassian.com"
+	// jiraOAuthScopes are what factory does in Jira; offline_access gets
+	// the refresh token that keeps the daemon signed in
+	jiraOAuthScopes = "read:jira-work write:jira-work read:jira-user offline_access"
+	// defaultOAuthRedirect is the callback to register with the OAuth app
+	defaultOAuthRedirect = "http://localhost:8976/callback"
+	// oauthSignInTimeout bounds the wait for the browser sign-in
+	oauthSignInTimeout = 5 * time.Minute
+)
+
+// JiraOAuthConfig signs in to Jira Cloud with an Atlassian OAuth 2.0 (3LO)
+// app instead of an API token. `factory configure` signs in, records the
+// site's cloud ID, and keeps the refresh token in the OS keyring.
+type JiraOAuthConfig struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	// RedirectURL must match the app's callback URL, default
+	// http://localhost:8976/callback
+	RedirectURL string `json:"redirectUrl,omitempty"`
+	CloudID     string `json:"cloudId,omitempty"`
+}
+
+func (o *JiraOAuthConfig) redirectURL() string {
+	if o.RedirectURL == "" {
+		return defaultOAuthRedirect
+	}
+	return o.RedirectURL
+}
+
+// apiURL returns the root Jira REST paths are appended to: the site for API
+// tokens, or Atlassian's API gateway for OAuth
+func (j JiraConfig) apiURL() string {
+	if j.OAuth != nil {
+		return atlassianAPIURL + "/ex/jira/" + j.OAuth.CloudID
+	}
+	return strings.TrimSuffix(j.BaseURL, "/")
+}
+
+// apiLink points a link to the site from a Jira response, such as an
+// attachment's content URL, through apiURL
+func (j JiraConfig) apiLink(link string) string {
+	site := strings.TrimSuffix(j.BaseURL, "/")
+	if j.OAuth == nil || !strings.HasPrefix(link, site+"/") {
+		return link
+	}
+	return j.apiURL() + strings.TrimPrefix(link, site)
+}
+
+// oauthAccount is the keyring entry holding the site's refresh token
+func oauthAccount(cfg *Config) string {
+	return "jira-oauth " + strings.TrimSuffix(cfg.Jira.BaseURL, "/")
+}
+
+// oauthToken is a response from the token endpoint
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// jiraToken caches the access token between requests
+var jiraToken struct {
+	sync.Mutex
+	access  string
+	expires time.Time
+}
+
+// jiraAccessToken returns a current OAuth access token, refreshing it with
+// the refresh token from the keyring when it is about to expire. Atlassian
+// rotates refresh tokens, so the new one is saved in its place.
+func jiraAccessToken(cfg *Config) (string, error) {
+	jiraToken.Lock()
+	defer jiraToken.Unlock()
+	if jiraToken.access != "" && time.Until(jiraToken.expires) > time.Minute {
+		return jiraToken.access, nil
+	}
+	refresh, err := keyringGet(oauthAccount(cfg))
+	if err != nil {
+		return "", fmt.Errorf("not signed in to Jira with OAuth; run `factory configure`: %w", err)
+	}
+	tok, err := requestOAuthToken(map[string]string{
+		"grant_type":    "refresh_token",
+		"client_id":     cfg.Jira.OAuth.ClientID,
+		"client_secret": cfg.Jira.OAuth.ClientSecret,
+		"refresh_token": refresh,
+	})
+	if err != nil {
+		return "", fmt.Errorf("refreshing the Jira OAuth token (run `factory configure` to sign in again): %w", err)
+	}
+	if tok.RefreshToken != "" && tok.RefreshToken != refresh {
+		if err := keyringSet(oauthAccount(cfg), tok.RefreshToken); err != nil {
+			fmt.Printf("  Warning: could not save the new Jira refresh token: %v\n", err)
+		}
+	}
+	cacheJiraToken(tok)
+	return tok.AccessToken, nil
+}
+
+func cacheJiraToken(tok *oauthToken) {
+	jiraToken.access = tok.AccessToken
+	jiraToken.expires = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	setSecret("jira.oauth.access", tok.AccessToken)
+	if tok.RefreshToken != "" {
+		setSecret("jira.oauth.refresh", tok.RefreshToken)
+	}
+}
+
+// forgetJiraToken drops the cached access token, e.g. after Jira rejected it
+func forgetJiraToken() {
+	jiraToken.Lock()
+	defer jiraToken.Unlock()
+	jiraToken.access = ""
+}
+
+func requestOAuthToken(params map[string]string) (*oauthToken, error) {
+	data, _ := json.Marshal(params)
+	req, err := http.NewRequest("POST", atlassianTokenURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := doAPI(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("atlassian token error %d: %s", resp.StatusCode, string(body))
+	}
+	var tok oauthToken
+	if err := json.Unmarshal(body, &tok); err != nil || tok.AccessToken == "" {
+		return nil, fmt.Errorf("atlassian token endpoint returned no access token")
+	}
+	return &tok, nil
+}
+
+// SignInJiraOAuth runs the OAuth 2.0 authorization code flow: the user
+// approves factory in the browser, the callback is caught by a listener on
+// the redirect URL, and the code is exchanged for tokens. It records the
+// site's cloud ID in cfg and the refresh token in the keyring.
+func SignInJiraOAuth(cfg *Config) error {
+	o := cfg.Jira.OAuth
+	redirect, err := url.Parse(o.redirectURL())
+	if err != nil || redirect.Scheme != "http" || redirect.Host == "" {
+		return fmt.Errorf("jira.oauth.redirectUrl must be a local http URL such as %s", defaultOAuthRedirect)
+	}
+	ln, err := net.Listen("tcp", redirect.Host)
+	if err != nil {
+		return fmt.Errorf("listening for the OAuth callback on %s: %w", redirect.Host, err)
+	}
+	defer ln.Close()
+
+	stateBytes := make([]byte, 16)
+	rand.Read(stateBytes)
+	state := hex.EncodeToString(stateBytes)
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(redirect.Path, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "unexpected sign-in response", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			http.Error(w, "Sign-in failed: "+q.Get("error_description"), http.StatusBadRequest)
+			errs <- fmt.Errorf("sign-in failed: %s %s", q.Get("error"), q.Get("error_description"))
+			return
+		}
+		fmt.Fprintln(w, "Signed in to Jira; you can close this tab and return to factory.")
+		codes <- q.Get("code")
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	auth := atlassianAuthURL + "?" + url.Values{
+		"audience":      {"api.atlassian.com"},
+		"client_id":     {o.ClientID},
+		"scope":         {jiraOAuthScopes},
+		"redirect_uri":  {o.redirectURL()},
+		"state":         {state},
+		"response_type": {"code"},
+		"prompt":        {"consent"},
+	}.Encode()
+	fmt.Printf("\nOpen this URL to sign in to Jira (waiting up to %s):\n%s\n\n", oauthSignInTimeout, auth)
+	openBrowser(auth)
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return err
+	case <-time.After(oauthSignInTimeout):
+		return fmt.Errorf("timed out waiting for the Jira sign-in")
+	}
+
+	tok, err := requestOAuthToken(map[string]string{
+		"grant_type":    "authorization_code",
+		"client_id":     o.ClientID,
+		"client_secret": o.ClientSecret,
+		"code":          code,
+		"redirect_uri":  o.redirectURL(),
+	})
+	if err != nil {
+		return err
+	}
+	if tok.RefreshToken == "" {
+		return fmt.Errorf("no refresh token; the OAuth app needs the offline_access scope")
+	}
+	if o.CloudID, err = jiraCloudID(cfg, tok.AccessToken); err != nil {
+		return err
+	}
+	if err := keyringSet(oauthAccount(cfg), tok.RefreshToken); err != nil {
+		return fmt.Errorf("saving the refresh token: %w", err)
+	}
+	jiraToken.Lock()
+	cacheJiraToken(tok)
+	jiraToken.Unlock()
+	return nil
+}
+
+// jiraCloudID finds the cloud ID of the site at jira.baseUrl among the
+// sites the token can reach
+func jiraCloudID(cfg *Config, accessToken string) (string, error) {
+	req, err := http.NewRequest("GET", atlassianAPIURL+"/oauth/token/accessible-resources", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := doAPI(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("atlassian API error %d: %s", resp.StatusCode, string(body))
+	}
+	var sites []struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	json.Unmarshal(body, &sites)
+	site := strings.TrimSuffix(cfg.Jira.BaseURL, "/")
+	var urls []string
+	for _, s := range sites {
+		if strings.EqualFold(strings.TrimSuffix(s.URL, "/"), site) {
+			return s.ID, nil
+		}
+		urls = append(urls, s.URL)
+	}
+	return "", fmt.Errorf("the sign-in has no access to %s (it can reach: %s)", site, strings.Join(urls, ", "))
+}
+
+// openBrowser opens url in the default browser, if there is one
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if cmd.Start() == nil {
+		go cmd.Wait()
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// JiraSite is another Jira site factory works with alongside jira.baseUrl.
+// Issues whose project key is in Projects are read and updated there;
+// every other issue stays on the main site. Accounts differ from site to
+// site, so the site signs in with its own token and has its own poll
+// assignees; OAuth is only for the main site.
+type JiraSite struct {
+	// Projects are the issue key prefixes routed to the site, e.g.
+	// ["APP"] for APP-123
+	Projects            []string `json:"projects"`
+	BaseURL             string   `json:"baseUrl"`
+	Email               string   `json:"email,omitempty"`
+	APIToken            string   `json:"apiToken,omitempty"`
+	APIVersion          string   `json:"apiVersion,omitempty"`
+	PersonalAccessToken string   `json:"personalAccessToken,omitempty"`
+	// Fields replaces jira.fields for the site, whose custom field IDs
+	// differ from the main site's
+	Fields *FieldMapping `json:"fields,omitempty"`
+	// Assignee, AssigneeGroup, and AssignTo replace the poll settings of
+	// the same names on the site; unset, the site polls the issues
+	// assigned to the account its token belongs to and reassigns none
+	Assignee      string `json:"assignee,omitempty"`
+	AssigneeGroup string `json:"assigneeGroup,omitempty"`
+	AssignTo      string `json:"assignTo,omitempty"`
+}
+
+// issueProject returns the project key of an issue key, "APP" for APP-123
+func issueProject(issueKey string) string {
+	project, _, _ := strings.Cut(issueKey, "-")
+	return project
+}
+
+// siteIndex returns the index in jira.sites of the site serving issueKey,
+// or -1 for the main site
+func (c *Config) siteIndex(issueKey string) int {
+	project := issueProject(issueKey)
+	for i, s := range c.Jira.Sites {
+		for _, p := range s.Projects {
+			if strings.EqualFold(p, project) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// jiraSite returns the config to reach the Jira site serving issueKey: c
+// itself for the main site, or a copy with the site's connection
+func (c *Config) jiraSite(issueKey string) *Config {
+	if i := c.siteIndex(issueKey); i >= 0 {
+		return c.siteConfig(i)
+	}
+	return c
+}
+
+// siteConfig returns a copy of c connected to jira.sites[i]
+func (c *Config) siteConfig(i int) *Config {
+	s := c.Jira.Sites[i]
+	site := *c
+	site.Jira.BaseURL = s.BaseURL
+	site.Jira.Email = s.Email
+	site.Jira.APIToken = s.APIToken
+	site.Jira.APIVersion = s.APIVersion
+	site.Jira.PersonalAccessToken = s.PersonalAccessToken
+	site.Jira.OAuth = nil
+	site.Poll.Assignee = s.Assignee
+	site.Poll.AssigneeGroup = s.AssigneeGroup
+	site.Poll.AssignTo = s.AssignTo
+	if s.Fields != nil {
+		site.Jira.Fields = *s.Fields
+	}
+	site.Jira.Sites = nil
+	site.Jira.projects = s.Projects
+	return &site
+}
+
+// projectsJQL narrows the assigned-issues query to the projects the site
+// serves: a site's own, or everything not routed elsewhere for the main one
+func projectsJQL(cfg *Config) string {
+	if len(cfg.Jira.projects) > 0 {
+		return fmt.Sprintf("project in (%s) AND ", jqlList(cfg.Jira.projects))
+	}
+	var routed []string
+	for _, s := range cfg.Jira.Sites {
+		routed = append(routed, s.Projects...)
+	}
+	if len(routed) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("project not in (%s) AND ", jqlList(routed))
+}
+
+func jqlList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = jqlQuote(v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// getAssignedIssuesAllSites polls the main site and every jira.sites entry.
+// A site that can't be reached is skipped with a warning, unless none can.
+func getAssignedIssuesAllSites(cfg *Config) ([]Issue, error) {
+	var all []Issue
+	var failed []string
+	for i := -1; i < len(cfg.Jira.Sites); i++ {
+		site := cfg
+		if i >= 0 {
+			site = cfg.siteConfig(i)
+		}
+		issues, err := GetAssignedIssuesREST(site)
+		if err != nil {
+			fmt.Printf("  Warning: could not poll %s: %v\n", site.Jira.BaseURL, err)
+			failed = append(failed, site.Jira.BaseURL)
+			continue
+		}
+		for _, issue := range issues {
+			// Drop issues of projects routed to another site, which
+			// couldn't be updated here
+			if cfg.siteIndex(issue.Key) == i {
+				all = append(all, issue)
+			}
+		}
+	}
+	if len(failed) == len(cfg.Jira.Sites)+1 {
+		return nil, fmt.Errorf("could not poll any Jira site (%s)", strings.Join(failed, ", "))
+	}
+	return all, nil
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// keyringService names factory's entries in the OS keyring
+const keyringService = "factory"
+
+// errNoKeyring means the OS keyring can't be used here, e.g. no
+// secret-tool on Linux or no session bus for a daemon started at boot
+var errNoKeyring = errors.New("no OS keyring available")
+
+// secretPath is where a secret is kept when the OS keyring can't be used
+func secretPath(account string) string {
+	name := regexp.MustCompile(`[^A-Za-z0-9._-]+`).ReplaceAllString(account, "_")
+	return filepath.Join(GetConfigDir(), "secrets", name)
+}
+
+// keyringGet reads a secret saved by keyringSet
+func keyringGet(account string) (string, error) {
+	// A file means the keyring failed when the secret was last saved, so
+	// the keyring's copy, if any, is older
+	if data, err := os.ReadFile(secretPath(account)); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	secret, err := credRead(account)
+	if err != nil {
+		return "", fmt.Errorf("%s not found in the keyring: %w", account, err)
+	}
+	return secret, nil
+}
+
+// keyringSet saves a secret in the OS keyring (Keychain on macOS, the
+// Secret Service via secret-tool on Linux, Credential Manager on Windows),
+// or in a file only the user can read when the keyring can't be used
+func keyringSet(account, secret string) error {
+	err := credWrite(account, secret)
+	if err == nil {
+		os.Remove(secretPath(account))
+		return nil
+	}
+	path := secretPath(account)
+	_, statErr := os.Stat(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if werr := os.WriteFile(path, []byte(secret), 0600); werr != nil {
+		return werr
+	}
+	if statErr != nil {
+		fmt.Printf("  Warning: could not use the OS keyring (%v); saved %s to %s\n", err, account, path)
+	}
+	return nil
+}
+
+// keyringDelete removes a secret from the keyring and its file
+func keyringDelete(account string) {
+	credDelete(account)
+	os.Remove(secretPath(account))
+}
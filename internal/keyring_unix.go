@@ -0,0 +1,53 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// credRead reads a secret with `security` on macOS or `secret-tool`
+// elsewhere
+func credRead(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return "", errNoKeyring
+		}
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", account)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func credWrite(account, secret string) error {
+	if runtime.GOOS == "darwin" {
+		// -U replaces an existing entry. -w last, with no value, has security
+		// prompt for the secret, and confirm it, on stdin: in argv, ps would
+		// show it.
+		cmd := exec.Command("security", "add-generic-password", "-U", "-s", keyringService, "-a", account, "-w")
+		cmd.Stdin = strings.NewReader(secret + "\n" + secret + "\n")
+		return cmd.Run()
+	}
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return errNoKeyring
+	}
+	cmd := exec.Command("secret-tool", "store", "--label", keyringService+": "+account, "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(secret)
+	return cmd.Run()
+}
+
+func credDelete(account string) {
+	if runtime.GOOS == "darwin" {
+		exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", account).Run()
+		return
+	}
+	exec.Command("secret-tool", "clear", "service", keyringService, "account", account).Run()
+}
@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLeaseTTL is how long an issue lease lives without a heartbeat
+const defaultLeaseTTL = 5 * time.Minute
+
+// Lease records which worker is processing an issue. Workers sharing a lease
+// directory (the daemon and `factory trigger`, or daemons on several hosts
+// pointing lease.dir at a shared filesystem) never run the same issue twice;
+// a lease left by a dead worker expires and can be taken over.
+type Lease struct {
+	IssueKey   string    `json:"issueKey"`
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquiredAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Progress is the agent's latest progress, written with each heartbeat
+	Progress *AgentProgress `json:"progress,omitempty"`
+}
+
+type leaseHandle struct {
+	path  string
+	lease Lease
+	ttl   time.Duration
+	stop  chan struct{}
+	done  chan struct{}
+	// cancel is closed once `factory cancel` asks for the issue's run to
+	// stop, or the daemon stopping can't wait for it; interrupted tells the
+	// two apart
+	cancel      chan struct{}
+	cancelOnce  sync.Once
+	interrupted atomic.Bool
+	// takenOverBy is the worker that took the lease over from this run
+	takenOverBy atomic.Value
+	// slotTakenBy is the worker that took the run's repo slot over
+	slotTakenBy atomic.Value
+
+	mu       sync.Mutex
+	progress *AgentProgress
+	// lastWrite throttles progress-driven lease writes
+	lastWrite time.Time
+}
+
+func leaseDir(cfg *Config) string {
+	if cfg.Lease.Dir != "" {
+		return cfg.Lease.Dir
+	}
+	return filepath.Join(GetConfigDir(), "leases")
+}
+
+func leaseTTL(cfg *Config) time.Duration {
+	if cfg.Lease.TTLSeconds > 0 {
+		return time.Duration(cfg.Lease.TTLSeconds) * time.Second
+	}
+	return defaultLeaseTTL
+}
+
+func leaseOwner() string {
+	return processOwner(os.Getpid())
+}
+
+// processOwner is the lease owner name of a process on this host
+func processOwner(pid int) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, pid)
+}
+
+func readLease(path string) (Lease, error) {
+	var l Lease
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return l, err
+	}
+	return l, json.Unmarshal(data, &l)
+}
+
+func writeLease(path string, l Lease) error {
+	data, _ := json.MarshalIndent(l, "", "  ")
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// acquireLease takes the issue's lease and starts renewing it in the
+// background. takeoverFrom names the previous owner when an expired lease
+// was taken over.
+func acquireLease(cfg *Config, issueKey string) (h *leaseHandle, takeoverFrom string, err error) {
+	h, takeoverFrom, err = takeLease(filepath.Join(leaseDir(cfg), issueKey+".json"), issueKey, leaseTTL(cfg))
+	if err != nil {
+		return nil, "", err
+	}
+	// A cancel left over from an earlier run doesn't stop this one
+	os.Remove(cancelPath(cfg, issueKey))
+	go h.watchCancel(cancelPath(cfg, issueKey))
+	return h, takeoverFrom, nil
+}
+
+// takeLease takes the lease file at path on behalf of issueKey
+func takeLease(path, issueKey string, ttl time.Duration) (h *leaseHandle, takeoverFrom string, err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	l := Lease{IssueKey: issueKey, Owner: leaseOwner(), AcquiredAt: now, ExpiresAt: now.Add(ttl)}
+
+	// Fast path: nobody holds it
+	err = createLease(path, l)
+	if os.IsExist(err) {
+		takeoverFrom, err = takeOverLease(path, l, ttl)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	h = &leaseHandle{path: path, lease: l, ttl: ttl, stop: make(chan struct{}), done: make(chan struct{}), cancel: make(chan struct{})}
+	go h.heartbeat()
+	return h, takeoverFrom, nil
+}
+
+// createLease creates the lease file at path, failing with an IsExist
+// error when there is one. The lease is written in full to a temporary
+// file first and linked into place, so no worker ever reads it half
+// written.
+func createLease(path string, l Lease) error {
+	data, _ := json.MarshalIndent(l, "", "  ")
+	tmp := fmt.Sprintf("%s.%d-%d.tmp", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Link(tmp, path)
+}
+
+// leaseHeld reports whether the lease file at path is live. A lease that
+// can't be read, e.g. one being replaced on a shared filesystem, counts as
+// held until its file is older than ttl.
+func leaseHeld(path string, ttl time.Duration) (Lease, bool) {
+	l, err := readLease(path)
+	if err == nil {
+		return l, time.Now().Before(l.ExpiresAt)
+	}
+	if os.IsNotExist(err) {
+		return l, false
+	}
+	info, serr := os.Stat(path)
+	return l, serr == nil && time.Since(info.ModTime()) < ttl
+}
+
+// takeOverLease replaces the expired lease at path with l. The takeover
+// and every renewal happen under the lease's takeover lock, so two workers
+// never both end up owning it.
+func takeOverLease(path string, l Lease, ttl time.Duration) (takeoverFrom string, err error) {
+	unlock, err := lockTakeover(path, ttl)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	existing, held := leaseHeld(path, ttl)
+	if held {
+		if existing.Owner == "" {
+			return "", fmt.Errorf("lease file %s is unreadable", path)
+		}
+		return "", fmt.Errorf("leased by %s until %s", existing.Owner, existing.ExpiresAt.Format("15:04:05"))
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := createLease(path, l); err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("lost lease takeover race")
+		}
+		return "", err
+	}
+	return existing.Owner, nil
+}
+
+// lockTakeover takes the lock that guards replacing the lease at path. It
+// is a file created with O_EXCL, which works on shared filesystems where
+// flock may not; one left by a crashed worker is cleared once it is older
+// than ttl.
+func lockTakeover(path string, ttl time.Duration) (unlock func(), err error) {
+	lock := path + ".takeover"
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, serr := os.Stat(lock); serr == nil && time.Since(info.ModTime()) > ttl {
+			os.Remove(lock)
+			continue
+		}
+		break
+	}
+	return nil, fmt.Errorf("lease %s is being taken over by another worker", filepath.Base(path))
+}
+
+// heartbeat renews the lease until released
+func (h *leaseHandle) heartbeat() {
+	defer close(h.done)
+	ticker := time.NewTicker(h.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+			if !h.renew() {
+				return
+			}
+		}
+	}
+}
+
+// renew extends the lease and records the latest progress. It returns
+// false once another worker has taken the lease over, and stops the run
+// holding it.
+func (h *leaseHandle) renew() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	unlock, err := lockTakeover(h.path, h.ttl)
+	if err != nil {
+		// A takeover is being checked; the next heartbeat renews
+		return true
+	}
+	defer unlock()
+	current, err := readLease(h.path)
+	if err == nil && current.Owner != h.lease.Owner {
+		fmt.Printf("  Warning: lease for %s taken over by %s; stopping the run\n", h.lease.IssueKey, current.Owner)
+		h.takenOverBy.Store(current.Owner)
+		h.stopRun()
+		return false
+	}
+	h.lease.ExpiresAt = time.Now().Add(h.ttl)
+	h.lease.Progress = h.progress
+	h.lastWrite = time.Now()
+	if err := writeLease(h.path, h.lease); err != nil {
+		fmt.Printf("  Warning: could not renew lease for %s: %v\n", h.lease.IssueKey, err)
+	}
+	return true
+}
+
+// progressWriteInterval is the most often agent progress is written to the
+// lease between heartbeats
+const progressWriteInterval = 5 * time.Second
+
+// setProgress records agent progress in the lease, where `factory status`
+// and the daemon API read it
+func (h *leaseHandle) setProgress(p AgentProgress) {
+	control.progressed()
+	h.mu.Lock()
+	h.progress = &p
+	due := time.Since(h.lastWrite) >= progressWriteInterval
+	h.mu.Unlock()
+	if due {
+		h.renew()
+	}
+}
+
+// errNoRepoSlot is returned by acquireRepoSlot when every slot is taken
+var errNoRepoSlot = errors.New("no free slot")
+
+// acquireRepoSlot takes one of the repo's repo.maxConcurrent run slots, or
+// returns errNoRepoSlot when all of them are taken; the run is then put
+// back on the queue rather than holding the daemon while it waits. Slots
+// are leases under the lease dir, so the limit holds across every worker
+// sharing it. It returns nil when the repo has no limit.
+func acquireRepoSlot(cfg *Config, issueKey string) (*leaseHandle, error) {
+	max := cfg.Repo.MaxConcurrent
+	if max <= 0 {
+		return nil, nil
+	}
+	dir := filepath.Join(leaseDir(cfg), "repos")
+	for i := 1; i <= max; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%s-%d.json", repoID(cfg), i))
+		if h, _, err := takeLease(path, issueKey, leaseTTL(cfg)); err == nil {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("%w on %s (repo.maxConcurrent = %d)", errNoRepoSlot, repoID(cfg), max)
+}
+
+// watchSlot stops the run holding h once another worker takes slot, the
+// run's repo slot, over; carrying on would exceed repo.maxConcurrent
+func (h *leaseHandle) watchSlot(slot *leaseHandle) {
+	select {
+	case <-slot.cancel:
+		owner, _ := slot.takenOverBy.Load().(string)
+		h.slotTakenBy.Store(owner)
+		h.stopRun()
+	case <-slot.stop:
+	}
+}
+
+// activeLeases returns the unexpired leases in the lease directory
+func activeLeases(cfg *Config) []Lease {
+	paths, _ := filepath.Glob(filepath.Join(leaseDir(cfg), "*.json"))
+	leases := []Lease{}
+	for _, path := range paths {
+		if l, err := readLease(path); err == nil && time.Now().Before(l.ExpiresAt) {
+			leases = append(leases, l)
+		}
+	}
+	return leases
+}
+
+// stopRun closes the lease's cancel channel, once
+func (h *leaseHandle) stopRun() {
+	h.cancelOnce.Do(func() { close(h.cancel) })
+}
+
+// release stops the heartbeat and removes the lease if still ours
+func (h *leaseHandle) release() {
+	close(h.stop)
+	<-h.done
+	unlock, err := lockTakeover(h.path, h.ttl)
+	if err != nil {
+		// Left to expire
+		return
+	}
+	defer unlock()
+	if current, err := readLease(h.path); err == nil && current.Owner == h.lease.Owner {
+		os.Remove(h.path)
+	}
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxPromptLessons caps how many of the most recent lessons reach the prompt
+const maxPromptLessons = 30
+
+// lessonStages are the failure stages that say something about how the agent
+// should work in this repo, as opposed to Jira/GitHub/infra failures, each
+// with the lesson recorded for it. The run's error isn't recorded, since it
+// may carry secrets or output that reach every later prompt.
+var lessonStages = map[string]string{
+	"claude":   "the agent failed or timed out before finishing the change",
+	"security": "the agent guard blocked a tool call (such as git push, or a request to an unknown host)",
+	"scope":    "the change touched files outside the issue's allowed paths",
+	"verify":   "the verify checks still failed after the agent's retries",
+}
+
+// GetLessonsPath returns the lessons file for the configured repository
+func GetLessonsPath(cfg *Config) string {
+	return filepath.Join(GetConfigDir(), "lessons", repoID(cfg)+".md")
+}
+
+// LoadLessons returns the recorded lessons for the configured repository
+func LoadLessons(cfg *Config) []string {
+	data, err := os.ReadFile(GetLessonsPath(cfg))
+	if err != nil {
+		return nil
+	}
+	var lessons []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if l, ok := strings.CutPrefix(strings.TrimSpace(line), "- "); ok && l != "" {
+			lessons = append(lessons, l)
+		}
+	}
+	return lessons
+}
+
+// AddLesson appends a lesson to the repository's lessons file
+func AddLesson(cfg *Config, lesson string) error {
+	lesson = strings.Join(strings.Fields(lesson), " ")
+	if lesson == "" {
+		return fmt.Errorf("empty lesson")
+	}
+
+	path := GetLessonsPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "- %s\n", lesson)
+	return err
+}
+
+// recordFailureLesson turns an agent-related failure into a lesson
+func recordFailureLesson(cfg *Config, result *Result) {
+	reason, ok := lessonStages[result.Stage]
+	if result.Status != "failed" || !ok {
+		return
+	}
+	if err := AddLesson(cfg, fmt.Sprintf("%s failed at %s: %s", result.IssueKey, result.Stage, reason)); err != nil {
+		fmt.Printf("Warning: could not record lesson: %v\n", err)
+	}
+}
+
+func formatLessons(lessons []string) string {
+	if len(lessons) == 0 {
+		return ""
+	}
+	if len(lessons) > maxPromptLessons {
+		lessons = lessons[len(lessons)-maxPromptLessons:]
+	}
+	return "\n## Lessons From Previous Runs In This Repo\n- " + strings.Join(lessons, "\n- ") + "\n"
+}
+
+// ShowLessons prints the lessons recorded for the configured repository
+func ShowLessons(cfg *Config) {
+	lessons := LoadLessons(cfg)
+	if len(lessons) == 0 {
+		fmt.Println("No lessons recorded")
+		return
+	}
+	fmt.Printf("Lessons (%s):\n", GetLessonsPath(cfg))
+	for _, l := range lessons {
+		fmt.Printf("- %s\n", l)
+	}
+}
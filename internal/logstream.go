@@ -0,0 +1,336 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRunLogSize caps how much of the current run's log is kept for replay
+// to viewers that connect mid-run
+const maxRunLogSize = 1 << 20
+
+// logEvent is one line of output or a run boundary
+type logEvent struct {
+	Kind string // "line", "start", or "end"
+	Key  string
+	Text string
+}
+
+// logHub fans captured output out to where it went before (the daemon log),
+// live log viewers, and the current run's log
+type logHub struct {
+	mu      sync.Mutex
+	out     io.Writer
+	current string
+	lines   []string
+	size    int
+	partial string
+	subs    map[chan logEvent]bool
+	runLog  io.Writer
+	// runLogDone is closed once runLogMark reaches the hub
+	runLogDone chan struct{}
+}
+
+// runLogMark, written to stdout, ends the run log once everything written
+// before it has reached the hub; the hub drops it from the output
+const runLogMark = "\x1efactory-run-log-end\x1e\n"
+
+// runLogDrainTimeout bounds the wait for runLogMark
+const runLogDrainTimeout = 5 * time.Second
+
+var runLogs *logHub
+
+// captureOutput redirects stdout and stderr through the hub while still
+// writing everything to the original stdout (the daemon log), with secrets
+// redacted. The returned func puts them back once everything written so far
+// has been copied.
+func captureOutput() (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	origOut, origErr := os.Stdout, os.Stderr
+	runLogs = &logHub{out: origOut, subs: make(map[chan logEvent]bool)}
+	os.Stdout = w
+	os.Stderr = w
+	copied := make(chan struct{})
+	go func() {
+		out := newRedactWriter(runLogs)
+		io.Copy(out, r)
+		out.Close()
+		close(copied)
+	}()
+	return func() {
+		os.Stdout, os.Stderr = origOut, origErr
+		w.Close()
+		<-copied
+	}, nil
+}
+
+// GetRunLogPath is where the readable output of the issue's latest run is
+// kept
+func GetRunLogPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "logs", issueKey+".log")
+}
+
+// teeRunLog copies the captured output, with secrets redacted, to the
+// issue's run log until the returned func is called. The run log is one
+// more writer of the output hub, so os.Stdout stays as it is during the
+// run. A process that didn't capture its output, running a single issue
+// from the CLI, captures it for the run. Failures only warn, since the run
+// log is a record rather than part of the run.
+func teeRunLog(issueKey string) func() {
+	path := GetRunLogPath(issueKey)
+	var f *os.File
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		f, err = os.Create(path)
+	}
+	flush := func() {}
+	if err == nil && runLogs == nil {
+		var release func()
+		if release, err = captureOutput(); err == nil {
+			flush = func() {
+				release()
+				runLogs = nil
+			}
+		}
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		fmt.Printf("  Warning: could not write run log %s: %v\n", path, err)
+		return func() {}
+	}
+
+	done := runLogs.setRunLog(f)
+	return func() {
+		// Everything written so far goes to the log before it is detached
+		fmt.Fprint(os.Stdout, runLogMark)
+		select {
+		case <-done:
+		case <-time.After(runLogDrainTimeout):
+			runLogs.setRunLog(nil)
+		}
+		flush()
+		f.Close()
+	}
+}
+
+// setRunLog has the hub copy output to w as well, until runLogMark comes
+// through, which closes the returned channel; nil stops it at once
+func (h *logHub) setRunLog(w io.Writer) chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runLog = w
+	h.runLogDone = make(chan struct{})
+	return h.runLogDone
+}
+
+func (h *logHub) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	text := string(p)
+	ended := strings.Contains(text, runLogMark)
+	text = strings.ReplaceAll(text, runLogMark, "")
+	if h.out != nil {
+		io.WriteString(h.out, text)
+	}
+	if h.runLog != nil {
+		io.WriteString(h.runLog, text)
+	}
+	if ended && h.runLogDone != nil {
+		close(h.runLogDone)
+		h.runLog, h.runLogDone = nil, nil
+	}
+
+	text = h.partial + text
+	lines := strings.Split(text, "\n")
+	h.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if h.current != "" {
+			h.lines = append(h.lines, line)
+			h.size += len(line)
+			for h.size > maxRunLogSize && len(h.lines) > 0 {
+				h.size -= len(h.lines[0])
+				h.lines = h.lines[1:]
+			}
+		}
+		h.broadcast(logEvent{Kind: "line", Key: h.current, Text: line})
+	}
+	return len(p), nil
+}
+
+func (h *logHub) broadcast(ev logEvent) {
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default: // drop for slow viewers rather than block the daemon
+		}
+	}
+}
+
+// startRun marks the beginning of an issue's run; a nil hub is a no-op
+func (h *logHub) startRun(issueKey string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current = issueKey
+	h.lines = nil
+	h.size = 0
+	h.broadcast(logEvent{Kind: "start", Key: issueKey})
+}
+
+// currentRun returns the issue being run, or ""; a nil hub has none
+func (h *logHub) currentRun() string {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.current
+}
+
+func (h *logHub) endRun(result *Result) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.broadcast(logEvent{Kind: "end", Key: h.current, Text: result.Status})
+	h.current = ""
+}
+
+// subscribe returns the current run's key and buffered lines plus a channel
+// of subsequent events
+func (h *logHub) subscribe() (string, []string, chan logEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan logEvent, 256)
+	h.subs[ch] = true
+	return h.current, append([]string(nil), h.lines...), ch
+}
+
+func (h *logHub) unsubscribe(ch chan logEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+// handleLogStream streams daemon output as Server-Sent Events. With
+// ?issue=KEY only that issue's run is streamed, and the stream ends with it.
+func handleLogStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || runLogs == nil {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	want := r.URL.Query().Get("issue")
+
+	current, backlog, ch := runLogs.subscribe()
+	defer runLogs.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if current != "" && (want == "" || want == current) {
+		fmt.Fprintf(w, "event: start\ndata: %s\n\n", current)
+		for _, line := range backlog {
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if want != "" && ev.Key != want {
+				continue
+			}
+			switch ev.Kind {
+			case "start":
+				fmt.Fprintf(w, "event: start\ndata: %s\n\n", ev.Key)
+			case "end":
+				fmt.Fprintf(w, "event: end\ndata: %s %s\n\n", ev.Key, ev.Text)
+				if want != "" {
+					flusher.Flush()
+					return
+				}
+			default:
+				fmt.Fprintf(w, "data: %s\n\n", ev.Text)
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// WatchLogs connects to a daemon's API and prints its live log. An empty
+// issueKey follows every run.
+func WatchLogs(cfg *Config, server, issueKey string) error {
+	if server == "" {
+		server = cfg.Server.Listen
+	}
+	if server == "" {
+		return fmt.Errorf("no server address: set server.listen or pass --server")
+	}
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+
+	url := strings.TrimRight(server, "/") + "/api/logs"
+	if issueKey != "" {
+		url += "?issue=" + issueKey
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.Server.Token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server error %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxRunLogSize)
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			switch event {
+			case "start":
+				fmt.Printf("── %s started ──\n", data)
+			case "end":
+				fmt.Printf("── %s finished ──\n", data)
+			default:
+				fmt.Println(data)
+			}
+		case line == "":
+			event = ""
+		}
+	}
+	return scanner.Err()
+}
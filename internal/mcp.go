@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// writeMCPConfig writes the configured MCP servers to a file for
+// `claude --mcp-config` and returns its path, or "" when none are configured.
+// The file can contain tokens, so it lives in the private config dir.
+func writeMCPConfig(cfg *Config) (string, error) {
+	if len(cfg.Repo.MCPServers) == 0 {
+		return "", nil
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"mcpServers": cfg.Repo.MCPServers,
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(GetConfigDir(), "mcp.json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// mcpToolPatterns returns allowedTools entries granting every tool of each
+// configured MCP server
+func mcpToolPatterns(cfg *Config) string {
+	var names []string
+	for name := range cfg.Repo.MCPServers {
+		names = append(names, "mcp__"+name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// claudeArgs builds the common claude CLI arguments for a prompt and tool set
+func claudeArgs(cfg *Config, prompt, tools string) ([]string, error) {
+	mcpPath, err := writeMCPConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if mcpPath != "" {
+		tools += "," + mcpToolPatterns(cfg)
+	}
+
+	args := []string{"-p", prompt, "--allowedTools", tools}
+	if mcpPath != "" {
+		args = append(args, "--mcp-config", mcpPath)
+	}
+	return args, nil
+}
@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// notifyTimeout keeps a slow webhook from holding up the poll loop
+const notifyTimeout = 30 * time.Second
+
+// notify alerts the operator: the message is always logged, and posted to
+// notify.webhookUrl when one is set. Delivery failures only warn.
+func notify(cfg *Config, text string) {
+	text = redact(text)
+	fmt.Printf("  Alert: %s\n", text)
+	if cfg.Notify.WebhookURL == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]string{"text": "factory: " + text})
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(cfg.Notify.WebhookURL, "application/json", bytes.NewReader(body))
+	auditErr := err
+	if err == nil && resp.StatusCode >= 400 {
+		auditErr = fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	recordAudit(actorOf(cfg), "notify.webhook", "", "notify.webhookUrl", text, auditErr)
+	if err != nil {
+		fmt.Printf("  Warning: could not send notification: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		fmt.Printf("  Warning: notification webhook returned %d: %s\n", resp.StatusCode, respBody)
+	}
+}
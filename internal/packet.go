@@ -0,0 +1,279 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// packetManifest describes the change a review packet carries
+type packetManifest struct {
+	IssueKey   string    `json:"issueKey"`
+	Title      string    `json:"title"`
+	Branch     string    `json:"branch"`
+	Base       string    `json:"base"`
+	BaseCommit string    `json:"baseCommit"`
+	Message    string    `json:"message"`
+	PRTitle    string    `json:"prTitle"`
+	PRBody     string    `json:"prBody"`
+	Files      []string  `json:"files"`
+	PatchSHA   string    `json:"patchSha256"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Files inside a review packet
+const (
+	packetManifestFile   = "manifest.json"
+	packetPatchFile      = "change.patch"
+	packetPromptFile     = "prompt.md"
+	packetTranscriptFile = "transcript.jsonl"
+	packetIssueFile      = "issue.json"
+)
+
+func packetDir(cfg *Config) string {
+	if cfg.Packets.Dir != "" {
+		return expandHome(cfg.Packets.Dir)
+	}
+	return filepath.Join(GetConfigDir(), "packets")
+}
+
+// StagedPatch returns a binary-safe patch of the given paths against HEAD,
+// leaving the index as it was
+func (g *Git) StagedPatch(paths []string) ([]byte, error) {
+	if _, err := g.exec(append([]string{"add", "-A", "--"}, paths...)...); err != nil {
+		return nil, err
+	}
+	defer g.exec(append([]string{"reset", "-q", "--"}, paths...)...)
+	cmd := exec.Command("git", append([]string{"diff", "--cached", "--binary", "HEAD", "--"}, paths...)...)
+	cmd.Dir = g.repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	return out, nil
+}
+
+// writePacket bundles the agent's change with the prompt, transcript, and
+// issue snapshot into a .tar.gz in the packet dir, and returns its path
+func writePacket(cfg *Config, git *Git, issue *Issue, m packetManifest, prompt string) (string, error) {
+	patch, err := git.StagedPatch(m.Files)
+	if err != nil {
+		return "", err
+	}
+	saveDiff(issue.Key, patch)
+	sum := sha256.Sum256(patch)
+	m.PatchSHA = hex.EncodeToString(sum[:])
+	if m.BaseCommit, err = git.exec("rev-parse", "HEAD"); err != nil {
+		return "", err
+	}
+	m.CreatedAt = time.Now()
+
+	manifest, _ := json.MarshalIndent(m, "", "  ")
+	snapshot, _ := json.MarshalIndent(issue, "", "  ")
+	transcript, _ := os.ReadFile(GetTranscriptPath(issue.Key))
+
+	if err := os.MkdirAll(packetDir(cfg), 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(packetDir(cfg), fmt.Sprintf("%s-%s.tar.gz", issue.Key, m.CreatedAt.Format("20060102-150405")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{packetManifestFile, manifest},
+		{packetPatchFile, patch},
+		{packetPromptFile, []byte(prompt)},
+		{packetTranscriptFile, transcript},
+		{packetIssueFile, snapshot},
+	} {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), ModTime: m.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// readPacket returns the files in a review packet
+func readPacket(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a review packet: %w", path, err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a review packet: %w", path, err)
+		}
+		if files[hdr.Name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// fileSHA256 is printed with each packet so a reviewer can confirm the one
+// they approved is the one being applied
+func fileSHA256(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ApplyPacket applies a reviewed packet's change to a fresh branch, pushes
+// it, opens the PR, and records it as the issue's result. wantSHA is the
+// packet's SHA-256 as the reviewer approved it; anything in the packet
+// itself, such as its manifest, could have been changed along with the
+// patch. It returns the PR URL.
+func ApplyPacket(cfg *Config, path, wantSHA string) (string, error) {
+	if wantSHA == "" {
+		return "", fmt.Errorf("the approved packet's sha256 is required")
+	}
+	if got := fileSHA256(path); !strings.EqualFold(got, strings.TrimSpace(wantSHA)) {
+		return "", fmt.Errorf("%s has sha256 %s, not the approved %s; the packet was modified", path, orDash(got), wantSHA)
+	}
+	files, err := readPacket(path)
+	if err != nil {
+		return "", err
+	}
+	var m packetManifest
+	if err := json.Unmarshal(files[packetManifestFile], &m); err != nil || m.IssueKey == "" {
+		return "", fmt.Errorf("%s has no valid %s", path, packetManifestFile)
+	}
+	patch := files[packetPatchFile]
+	sum := sha256.Sum256(patch)
+	if hex.EncodeToString(sum[:]) != m.PatchSHA {
+		return "", fmt.Errorf("patch checksum does not match the manifest; the packet is damaged")
+	}
+	fmt.Printf("Applying %s: %s (%d file(s), packet sha256 %s)\n", m.IssueKey, m.Title, len(m.Files), fileSHA256(path))
+
+	git := NewGit(cfg)
+	if err := git.Init(); err != nil {
+		return "", err
+	}
+	if err := git.EnsureClean(m.IssueKey, cfg.Repo.StashDirty); err != nil {
+		return "", err
+	}
+	hasOpenPR, err := openPRBranches(cfg)
+	if err != nil {
+		return "", err
+	}
+	branch, err := git.CreateBranch(m.Branch, m.IssueKey, hasOpenPR)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("  Branch: %s\n", branch)
+
+	tmp, err := os.CreateTemp("", "factory-*.patch")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Write(patch)
+	tmp.Close()
+	// --3way falls back to a merge when the base moved since the packet was made
+	if _, err := git.exec("apply", "--index", "--3way", "--binary", tmp.Name()); err != nil {
+		return "", fmt.Errorf("patch does not apply (made against %s): %w", m.BaseCommit, err)
+	}
+	if err := git.CommitAndPush(branch, m.Message, m.Files); err != nil {
+		return "", err
+	}
+
+	prURL, err := CreatePR(cfg, m.PRTitle, m.PRBody, branch, m.Base)
+	if err != nil {
+		return "", err
+	}
+	if err := AddComment(cfg, m.IssueKey, fmt.Sprintf("PR raised from a reviewed packet: %s", prURL)); err != nil {
+		fmt.Printf("  Warning: could not comment on %s: %v\n", m.IssueKey, err)
+	}
+	transition(cfg, m.IssueKey, cfg.Transitions.OnPRCreated)
+
+	// The run that wrote the packet recorded no PR; keep its spend
+	result := &Result{IssueKey: m.IssueKey, Status: "completed", PRUrl: prURL}
+	if m.Base != cfg.Repo.DefaultBranch {
+		result.Base = m.Base
+	}
+	if info, ok := readProcessed()[m.IssueKey]; ok {
+		result.Variant, result.Models, result.SessionID, result.Stages = info.Variant, info.Models, info.SessionID, info.Stages
+		result.CostUSD, result.InputTokens, result.OutputTokens = info.CostUSD, info.InputTokens, info.OutputTokens
+	}
+	RecordResult(result)
+	return prURL, nil
+}
+
+// packageForReview ends a run in review-packet mode: the change is bundled
+// for offline review instead of pushed, and the workspace is reset
+func packageForReview(cfg *Config, git *Git, issue *Issue, data *TemplateData, msg, prompt string, changed []string, result *Result) *Result {
+	prTitle, err := RenderTemplate(cfg, TemplatePRTitle, data)
+	if err != nil {
+		return fail(result, "template", err)
+	}
+	prBody, err := RenderTemplate(cfg, TemplatePRBody, data)
+	if err != nil {
+		return fail(result, "template", err)
+	}
+
+	fmt.Println("→ Writing review packet...")
+	result.enterStage("packet")
+	path, err := writePacket(cfg, git, issue, packetManifest{
+		IssueKey: issue.Key,
+		Title:    issue.Title,
+		Branch:   data.Branch,
+		Base:     cfg.Repo.DefaultBranch,
+		Message:  msg,
+		PRTitle:  prTitle,
+		PRBody:   MergePRTemplate(git.Path(), prBody),
+		Files:    changed,
+	}, prompt)
+	if err != nil {
+		return fail(result, "packet", err)
+	}
+	if err := git.Discard(changed); err != nil {
+		fmt.Printf("  Warning: could not reset workspace: %v\n", err)
+	}
+	fmt.Printf("  Packet: %s\n  sha256: %s\n", path, fileSHA256(path))
+	progress(cfg, issue.Key, fmt.Sprintf("review packet %s ready (%d file(s)), awaiting offline review",
+		filepath.Base(path), len(changed)))
+
+	result.Status = "completed"
+	fmt.Printf("\n✓ Packaged: %s\n", issue.Key)
+	return result
+}
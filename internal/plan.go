@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PlanConfig has runs plan before they implement: the agent first writes
+// an implementation plan, posted on the issue, and the change is only made
+// once someone approves it with a comment, so big changes get a checkpoint
+// before code is written.
+type PlanConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Labels and MinStoryPoints limit planning to issues with one of these
+	// labels or estimated at least this high; with neither, every issue is
+	// planned first
+	Labels         []string `json:"labels,omitempty"`
+	MinStoryPoints float64  `json:"minStoryPoints,omitempty"`
+	// Approvers limits approvals to comments by these display names; empty
+	// accepts them from anyone who can comment on the issue
+	Approvers []string `json:"approvers,omitempty"`
+}
+
+// applies reports whether the issue is planned before it is implemented
+func (c PlanConfig) applies(issue *Issue) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Labels) == 0 && c.MinStoryPoints <= 0 {
+		return true
+	}
+	for _, l := range issue.Labels {
+		for _, want := range c.Labels {
+			if strings.EqualFold(l, want) {
+				return true
+			}
+		}
+	}
+	return c.MinStoryPoints > 0 && issue.StoryPoints >= c.MinStoryPoints
+}
+
+// approves reports whether the author may approve plans
+func (c PlanConfig) approves(author string) bool {
+	if len(c.Approvers) == 0 {
+		return true
+	}
+	for _, a := range c.Approvers {
+		if strings.EqualFold(a, author) {
+			return true
+		}
+	}
+	return false
+}
+
+// approvalWords start a comment that approves a plan, after any mention
+var approvalWords = []string{"👍", ":thumbsup:", "(y)", "+1", "approve", "approved", "lgtm"}
+
+// isApproval reports whether a comment approves the plan: it starts with
+// one of approvalWords, optionally after the command mention
+func isApproval(mention, body string) bool {
+	body = strings.TrimSpace(body)
+	if len(body) >= len(mention) && strings.EqualFold(body[:len(mention)], mention) {
+		body = strings.TrimSpace(body[len(mention):])
+	}
+	fields := strings.Fields(body)
+	if len(fields) == 0 {
+		return false
+	}
+	first := strings.ToLower(strings.TrimRight(fields[0], ".,!"))
+	if strings.HasPrefix(first, "👍") {
+		return true
+	}
+	for _, w := range approvalWords {
+		if first == w {
+			return true
+		}
+	}
+	return false
+}
+
+// issuePlan is a plan posted on an issue, and who approved it
+type issuePlan struct {
+	Plan       string    `json:"plan"`
+	PostedAt   time.Time `json:"postedAt"`
+	ApprovedBy string    `json:"approvedBy,omitempty"`
+	ApprovedAt time.Time `json:"approvedAt"`
+}
+
+func (p *issuePlan) approved() bool {
+	return p.ApprovedBy != ""
+}
+
+// GetPlanPath is where the issue's latest plan is kept
+func GetPlanPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "plans", issueKey+".json")
+}
+
+func loadPlan(issueKey string) *issuePlan {
+	data, err := os.ReadFile(GetPlanPath(issueKey))
+	if err != nil {
+		return nil
+	}
+	var p issuePlan
+	if json.Unmarshal(data, &p) != nil || p.Plan == "" {
+		return nil
+	}
+	return &p
+}
+
+func savePlan(issueKey string, p *issuePlan) error {
+	path := GetPlanPath(issueKey)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(p, "", "  ")
+	return os.WriteFile(path, data, 0600)
+}
+
+// clearPlan drops the issue's plan, so that its next run plans again
+func clearPlan(issueKey string) {
+	os.Remove(GetPlanPath(issueKey))
+}
+
+// planIssue ends the first phase of a planned run: the agent plans the
+// issue read-only, and the plan is posted on the issue to be approved
+func planIssue(cfg *Config, issue *Issue, request string, result *Result) *Result {
+	fmt.Println("→ Planning before implementing")
+	result.enterStage("setup")
+	git := NewGit(cfg)
+	if err := git.Init(); err != nil {
+		return fail(result, "git", err)
+	}
+	if err := git.EnsureClean(issue.Key, cfg.Repo.StashDirty); err != nil {
+		return fail(result, "workspace", err)
+	}
+	if err := git.Pull(); err != nil {
+		return fail(result, "git", err)
+	}
+
+	fmt.Println("→ Running Claude Code (plan only)...")
+	result.enterStage("plan")
+	plan, err := runClaudePlan(cfg, git, issue, request, planInstructions)
+	if err != nil {
+		return fail(result, "plan", err)
+	}
+	if plan == "" {
+		return fail(result, "plan", fmt.Errorf("the agent returned no plan"))
+	}
+
+	revise := ""
+	if cfg.Jira.Commands.Enabled {
+		revise = fmt.Sprintf(" To have it revised, comment \"%s\" followed by what to change.", cfg.Jira.Commands.mention())
+	}
+	comment := fmt.Sprintf("factory: implementation plan for review\n\n%s\n\nReply \"👍\" or \"approve\" to have factory implement it.%s",
+		plan, revise)
+	fmt.Println("→ Posting plan to Jira...")
+	result.enterStage("jira")
+	if err := AddComment(cfg, issue.Key, comment); err != nil {
+		return fail(result, "jira", err)
+	}
+	if err := savePlan(issue.Key, &issuePlan{Plan: plan, PostedAt: time.Now()}); err != nil {
+		return fail(result, "plan", err)
+	}
+
+	result.Status = "planned"
+	fmt.Printf("\n✓ Planned: %s (waiting for approval)\n", issue.Key)
+	return result
+}
+
+// planNote adds the approved plan to the implementation prompt
+func planNote(p *issuePlan) string {
+	return fmt.Sprintf("\n## Approved plan\nYou planned this change before, and %s approved the plan; implement it as planned:\n\n%s\n",
+		p.ApprovedBy, p.Plan)
+}
+
+// pollPlans looks for approvals of the plans posted on assigned issues and
+// queues the implementation of each approved one. An approval counts when
+// it was posted after the plan. It returns the issues queued.
+func pollPlans(cfg *Config, issues []Issue) []string {
+	if !cfg.Plan.Enabled {
+		return nil
+	}
+	queue := loadQueue()
+	var queued []string
+	for _, issue := range issues {
+		info, ok := processedEntry(issue.Key)
+		if !ok || info.Status != "planned" || queueIndex(queue, issue.Key) >= 0 || hasLease(cfg, issue.Key) {
+			continue
+		}
+		plan := loadPlan(issue.Key)
+		if plan == nil || plan.approved() {
+			continue
+		}
+
+		comments, err := GetComments(cfg, issue.Key)
+		if err != nil {
+			fmt.Printf("  Warning: could not check %s for plan approval: %v\n", issue.Key, err)
+			continue
+		}
+		for _, c := range comments {
+			if c.created.After(plan.PostedAt) && cfg.Plan.approves(c.Author) && isApproval(cfg.Jira.Commands.mention(), c.Body) {
+				plan.ApprovedBy, plan.ApprovedAt = c.Author, c.created
+				break
+			}
+		}
+		if !plan.approved() {
+			continue
+		}
+		if err := savePlan(issue.Key, plan); err != nil {
+			fmt.Printf("  Warning: could not save %s's plan approval: %v\n", issue.Key, err)
+			continue
+		}
+
+		fmt.Printf("%s approved the plan for %s\n", plan.ApprovedBy, issue.Key)
+		forgetProcessed(issue.Key)
+		requeue(QueueItem{Key: issue.Key, Title: issue.Title, Base: info.Base})
+		queued = append(queued, issue.Key)
+		if err := AddComment(cfg, issue.Key, fmt.Sprintf("factory: queued the implementation, as %s approved the plan", plan.ApprovedBy)); err != nil {
+			fmt.Printf("  Warning: could not acknowledge the approval: %v\n", err)
+		}
+	}
+	return queued
+}
@@ -0,0 +1,66 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// agentKillGrace is how long the agent's process group gets to exit after
+// SIGTERM before it is killed
+const agentKillGrace = 10 * time.Second
+
+// startGroup starts cmd as the leader of a new process group, so the tools
+// and servers it spawns can be stopped with it
+func startGroup(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd.Start()
+}
+
+// killGroup stops cmd's process group: SIGTERM first, then SIGKILL for
+// anything still running after agentKillGrace
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	pgid := cmd.Process.Pid
+	if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+		cmd.Process.Kill()
+		return
+	}
+	time.AfterFunc(agentKillGrace, func() { syscall.Kill(-pgid, syscall.SIGKILL) })
+}
+
+// abortGroup kills cmd's process group at once, without the grace period
+// killGroup gives, for an agent that must not go on
+func abortGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// endGroup is a no-op; a process group needs no releasing
+func endGroup(cmd *exec.Cmd) {}
+
+// processAlive reports whether a process with the PID is running as this
+// user; another user's process can't be one of factory's
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// lockFile takes an exclusive lock on f without waiting, returning
+// errFileLocked when another process holds one. The lock goes with the
+// process, so a crash or reboot never leaves it behind.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errFileLocked
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+	procLockFileEx               = kernel32.NewProc("LockFileEx")
+)
+
+const (
+	processTerminate               = 0x0001
+	processSetQuota                = 0x0100
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+
+	jobObjectExtendedLimitInformationClass = 9
+	jobObjectLimitKillOnJobClose           = 0x2000
+)
+
+// jobObjectExtendedLimitInformation is JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+type jobObjectExtendedLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoCounters              [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+// jobs holds the job object each started group runs in, until endGroup
+var jobs sync.Map // *exec.Cmd -> syscall.Handle
+
+// startGroup starts cmd in a new process group and a job object, which
+// every process it starts joins, so the whole tree can be stopped with it.
+// Without a job (e.g. when factory itself runs in a job that forbids
+// nesting), killGroup falls back to taskkill.
+func startGroup(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: createNewProcessGroup}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if job, ok := newKillOnCloseJob(); ok {
+		if assignToJob(job, cmd.Process.Pid) {
+			jobs.Store(cmd, job)
+		} else {
+			syscall.CloseHandle(job)
+		}
+	}
+	return nil
+}
+
+func newKillOnCloseJob() (syscall.Handle, bool) {
+	h, _, _ := procCreateJobObjectW.Call(0, 0)
+	if h == 0 {
+		return 0, false
+	}
+	job := syscall.Handle(h)
+	info := jobObjectExtendedLimitInformation{LimitFlags: jobObjectLimitKillOnJobClose}
+	ok, _, _ := procSetInformationJobObject.Call(uintptr(job), jobObjectExtendedLimitInformationClass,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if ok == 0 {
+		syscall.CloseHandle(job)
+		return 0, false
+	}
+	return job, true
+}
+
+func assignToJob(job syscall.Handle, pid int) bool {
+	process, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(process)
+	ok, _, _ := procAssignProcessToJobObject.Call(uintptr(job), uintptr(process))
+	return ok != 0
+}
+
+// killGroup stops cmd and every process it started
+func killGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if job, ok := jobs.Load(cmd); ok {
+		if r, _, _ := procTerminateJobObject.Call(uintptr(job.(syscall.Handle)), 1); r != 0 {
+			return
+		}
+	}
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// abortGroup is killGroup, which already stops the processes at once
+func abortGroup(cmd *exec.Cmd) {
+	killGroup(cmd)
+}
+
+// endGroup releases cmd's job object once cmd has exited, stopping anything
+// it left running
+func endGroup(cmd *exec.Cmd) {
+	if job, ok := jobs.LoadAndDelete(cmd); ok {
+		syscall.CloseHandle(job.(syscall.Handle))
+	}
+}
+
+// processAlive reports whether a process with the PID is running
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// lockFile takes an exclusive lock on f without waiting, returning
+// errFileLocked when another process holds one. Windows locks are
+// mandatory, so the byte locked is past the end of the file, leaving it
+// readable. The lock goes with the process.
+func lockFile(f *os.File) error {
+	ol := syscall.Overlapped{OffsetHigh: 1}
+	ok, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if ok != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errFileLocked
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// prTemplatePaths are the locations GitHub reads a default PR template from
+var prTemplatePaths = []string{
+	".github/pull_request_template.md",
+	".github/PULL_REQUEST_TEMPLATE.md",
+	"pull_request_template.md",
+	"PULL_REQUEST_TEMPLATE.md",
+	"docs/pull_request_template.md",
+	"docs/PULL_REQUEST_TEMPLATE.md",
+}
+
+// sectionAliases groups headings that mean the same thing, so factory's
+// "Validation" fills a template's "Testing" section and so on
+var sectionAliases = [][]string{
+	{"summary", "description", "what", "changes", "what changed", "overview", "context"},
+	{"validation", "testing", "test plan", "tests", "how to test", "checklist", "verification"},
+	{"jira", "ticket", "issue", "related issues", "links", "references"},
+	{"acceptance criteria", "requirements"},
+}
+
+var headingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+
+type mdSection struct {
+	heading string // full heading line, "" for text before the first heading
+	body    []string
+}
+
+// findPRTemplate returns the repo's PR template, or "" if it has none
+func findPRTemplate(repoPath string) string {
+	for _, p := range prTemplatePaths {
+		if data, err := os.ReadFile(filepath.Join(repoPath, p)); err == nil {
+			return string(data)
+		}
+	}
+	return ""
+}
+
+// MergePRTemplate fills the repo's PR template with factory's generated
+// body: each generated section goes under the matching template heading,
+// and anything without a match is appended. Without a template the body is
+// returned unchanged.
+func MergePRTemplate(repoPath, body string) string {
+	tmpl := findPRTemplate(repoPath)
+	if strings.TrimSpace(tmpl) == "" {
+		return body
+	}
+
+	tmplSections := splitSections(tmpl)
+	used := map[int]bool{}
+	var extra []mdSection
+
+	for _, gen := range splitSections(body) {
+		content := strings.TrimSpace(strings.Join(gen.body, "\n"))
+		if content == "" {
+			continue
+		}
+		if gen.heading == "" {
+			extra = append(extra, gen)
+			continue
+		}
+		i := matchSection(tmplSections, headingText(gen.heading), used)
+		if i < 0 {
+			extra = append(extra, gen)
+			continue
+		}
+		used[i] = true
+		tmplSections[i].body = append(trimTrailingBlank(tmplSections[i].body), "", content, "")
+	}
+
+	var out []string
+	for _, s := range tmplSections {
+		if s.heading != "" {
+			out = append(out, s.heading)
+		}
+		out = append(out, s.body...)
+	}
+	for _, s := range extra {
+		out = append(trimTrailingBlank(out), "")
+		if s.heading != "" {
+			out = append(out, s.heading)
+		}
+		out = append(out, strings.TrimSpace(strings.Join(s.body, "\n")))
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+func splitSections(md string) []mdSection {
+	sections := []mdSection{{}}
+	inFence := false
+	for _, line := range strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inFence = !inFence
+		}
+		if !inFence && headingRe.MatchString(line) {
+			sections = append(sections, mdSection{heading: line})
+			continue
+		}
+		last := &sections[len(sections)-1]
+		last.body = append(last.body, line)
+	}
+	return sections
+}
+
+func headingText(heading string) string {
+	m := headingRe.FindStringSubmatch(heading)
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(m[2], " :*_"))
+}
+
+// matchSection finds the first unused template section with the same or an
+// aliased heading
+func matchSection(sections []mdSection, heading string, used map[int]bool) int {
+	group := []string{heading}
+	for _, aliases := range sectionAliases {
+		for _, a := range aliases {
+			if a == heading {
+				group = aliases
+			}
+		}
+	}
+	for i, s := range sections {
+		if used[i] || s.heading == "" {
+			continue
+		}
+		t := headingText(s.heading)
+		for _, a := range group {
+			if t == a || strings.Contains(t, a) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func trimTrailingBlank(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
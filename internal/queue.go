@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// QueueItem is an issue waiting to be processed by the daemon
+type QueueItem struct {
+	Key     string `json:"key"`
+	Title   string `json:"title,omitempty"`
+	AddedAt string `json:"addedAt"`
+	// Base is the branch `factory trigger --base` asked the run to use
+	Base string `json:"base,omitempty"`
+	// Request is what an "@factory" comment asked the run to do
+	Request string `json:"request,omitempty"`
+	// Update adds to the issue's open PR, as `factory trigger --update`
+	Update bool `json:"update,omitempty"`
+}
+
+func GetQueuePath() string {
+	return filepath.Join(GetConfigDir(), "queue.json")
+}
+
+func loadQueue() []QueueItem {
+	var queue []QueueItem
+	readStateFile(GetQueuePath(), &queue)
+	return queue
+}
+
+func saveQueue(queue []QueueItem) {
+	data, _ := json.MarshalIndent(queue, "", "  ")
+	writeStateFile(GetQueuePath(), data)
+}
+
+func queueIndex(queue []QueueItem, key string) int {
+	for i, item := range queue {
+		if item.Key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// enqueue appends issues not already queued and returns the keys added
+func enqueue(issues []Issue) []string {
+	queue := loadQueue()
+	var added []string
+	for _, issue := range issues {
+		if queueIndex(queue, issue.Key) >= 0 {
+			continue
+		}
+		queue = append(queue, QueueItem{
+			Key:     issue.Key,
+			Title:   issue.Title,
+			AddedAt: time.Now().Format(time.RFC3339),
+		})
+		added = append(added, issue.Key)
+	}
+	saveQueue(queue)
+	return added
+}
+
+// dequeue removes and returns the head of the queue. The file is re-read
+// each time so bumps and drops made while an issue runs take effect.
+func dequeue() (QueueItem, bool) {
+	queue := loadQueue()
+	if len(queue) == 0 {
+		return QueueItem{}, false
+	}
+	saveQueue(queue[1:])
+	return queue[0], true
+}
+
+// ShowQueue lists pending issues in the order they will run
+func ShowQueue() {
+	queue := loadQueue()
+	if len(queue) == 0 {
+		fmt.Println("Queue is empty")
+		return
+	}
+
+	fmt.Printf("Queued Issues (%d):\n", len(queue))
+	fmt.Printf("%-4s %-12s %-50s %s\n", "#", "Issue", "Title", "Queued")
+	for i, item := range queue {
+		title := item.Title
+		if len(title) > 48 {
+			title = title[:48] + "..."
+		}
+		t, _ := time.Parse(time.RFC3339, item.AddedAt)
+		fmt.Printf("%-4d %-12s %-50s %s\n", i+1, item.Key, title, t.Format("Jan 02 15:04"))
+	}
+}
+
+// BumpQueue moves an issue to the front of the queue
+func BumpQueue(issueKey string) error {
+	queue := loadQueue()
+	i := queueIndex(queue, issueKey)
+	if i < 0 {
+		return fmt.Errorf("%s is not queued", issueKey)
+	}
+	item := queue[i]
+	queue = append(queue[:i], queue[i+1:]...)
+	saveQueue(append([]QueueItem{item}, queue...))
+	fmt.Printf("Bumped: %s\n", issueKey)
+	return nil
+}
+
+// requeue puts an issue at the front of the queue, moving it there if it
+// is already queued
+func requeue(item QueueItem) {
+	queue := loadQueue()
+	if i := queueIndex(queue, item.Key); i >= 0 {
+		queue = append(queue[:i], queue[i+1:]...)
+	}
+	item.AddedAt = time.Now().Format(time.RFC3339)
+	saveQueue(append([]QueueItem{item}, queue...))
+}
+
+// requeueRun forgets a processed issue's last run and queues a new one
+// first, on the same base branch, with request for the prompt. A PR the
+// last run opened gets follow-up commits rather than a twin.
+func requeueRun(issue Issue, info ProcessedIssue, request string) {
+	update := info.PRUrl != "" && info.PRState != PRStateMerged && info.PRState != PRStateClosed
+	forgetProcessed(issue.Key)
+	requeue(QueueItem{Key: issue.Key, Title: issue.Title, Base: info.Base, Request: request, Update: update})
+}
+
+// DropQueue removes an issue from the queue and marks it dropped so the
+// poller won't queue it again. `factory clear KEY` undoes this.
+func DropQueue(issueKey string) error {
+	queue := loadQueue()
+	i := queueIndex(queue, issueKey)
+	if i < 0 {
+		return fmt.Errorf("%s is not queued", issueKey)
+	}
+	saveQueue(append(queue[:i], queue[i+1:]...))
+	RecordResult(&Result{IssueKey: issueKey, Status: "dropped"})
+	fmt.Printf("Dropped: %s\n", issueKey)
+	return nil
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Caps on the related work listed in the prompt
+const (
+	maxRelatedCommits = 5
+	maxRelatedPRs     = 5
+	relatedSince      = "180.days"
+)
+
+// relatedCommit is a recent commit that mentions the issue or a component
+type relatedCommit struct {
+	Hash    string
+	Date    string
+	Subject string
+}
+
+// relatedPR is an open pull request that mentions the issue or a component
+type relatedPR struct {
+	Number int
+	Title  string
+	Branch string
+}
+
+// relatedKeywords are the issue key and the issue's components, most
+// relevant first
+func relatedKeywords(issue *Issue) []string {
+	keywords := []string{issue.Key}
+	for _, c := range issue.Components {
+		if c = strings.TrimSpace(c); c != "" {
+			keywords = append(keywords, c)
+		}
+	}
+	return keywords
+}
+
+// keywordPattern matches a keyword as a whole word, case-insensitively,
+// in POSIX extended syntax so git and Go agree on it
+func keywordPattern(keyword string) string {
+	return `(^|[^[:alnum:]])` + regexp.QuoteMeta(keyword) + `([^[:alnum:]]|$)`
+}
+
+// findRelatedCommits searches recent history for commits mentioning each
+// keyword in turn, so commits naming the issue come before those naming
+// only a component
+func findRelatedCommits(git *Git, keywords []string) ([]relatedCommit, error) {
+	var commits []relatedCommit
+	seen := map[string]bool{}
+	for _, k := range keywords {
+		out, err := git.exec("log", "HEAD", "--no-merges", "--since="+relatedSince,
+			fmt.Sprintf("--max-count=%d", maxRelatedCommits), "-i", "-E",
+			"--grep="+keywordPattern(k), "--format=%h%x09%as%x09%s")
+		if err != nil {
+			return commits, err
+		}
+		for _, line := range strings.Split(out, "\n") {
+			parts := strings.SplitN(line, "\t", 3)
+			if len(parts) < 3 || seen[parts[0]] {
+				continue
+			}
+			seen[parts[0]] = true
+			commits = append(commits, relatedCommit{Hash: parts[0], Date: parts[1], Subject: parts[2]})
+		}
+		if len(commits) >= maxRelatedCommits {
+			return commits[:maxRelatedCommits], nil
+		}
+	}
+	return commits, nil
+}
+
+// openPRs lists the repository's open pull requests
+func openPRs(cfg *Config, repoPath string) ([]relatedPR, error) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		cmd := exec.Command("gh", "pr", "list", "--state", "open", "--limit", "100",
+			"--json", "number,title,headRefName")
+		cmd.Dir = repoPath
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("gh pr list failed: %w", err)
+		}
+		var list []struct {
+			Number      int    `json:"number"`
+			Title       string `json:"title"`
+			HeadRefName string `json:"headRefName"`
+		}
+		if err := json.Unmarshal(out, &list); err != nil {
+			return nil, err
+		}
+		var prs []relatedPR
+		for _, pr := range list {
+			prs = append(prs, relatedPR{Number: pr.Number, Title: pr.Title, Branch: pr.HeadRefName})
+		}
+		return prs, nil
+	}
+
+	body, err := githubRequest(cfg, "GET", fmt.Sprintf("/repos/%s/%s/pulls?state=open&per_page=100",
+		cfg.GitHub.Owner, cfg.GitHub.Repo), nil)
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Number int    `json:"number"`
+		Title  string `json:"title"`
+		Head   struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	var prs []relatedPR
+	for _, pr := range list {
+		prs = append(prs, relatedPR{Number: pr.Number, Title: pr.Title, Branch: pr.Head.Ref})
+	}
+	return prs, nil
+}
+
+// matchRelatedPRs picks the PRs whose title or branch mentions a keyword,
+// those naming the issue first
+func matchRelatedPRs(prs []relatedPR, keywords []string) []relatedPR {
+	var matched []relatedPR
+	seen := map[int]bool{}
+	for _, k := range keywords {
+		re := regexp.MustCompile("(?i)" + keywordPattern(k))
+		for _, pr := range prs {
+			if seen[pr.Number] || !(re.MatchString(pr.Title) || re.MatchString(pr.Branch)) {
+				continue
+			}
+			seen[pr.Number] = true
+			matched = append(matched, pr)
+		}
+	}
+	if len(matched) > maxRelatedPRs {
+		matched = matched[:maxRelatedPRs]
+	}
+	return matched
+}
+
+// relatedWork is the prompt section listing recent commits and open PRs
+// related to the issue. Lookup failures only warn; the section is context,
+// not a requirement.
+func relatedWork(cfg *Config, git *Git, issue *Issue) string {
+	keywords := relatedKeywords(issue)
+	commits, err := findRelatedCommits(git, keywords)
+	if err != nil {
+		fmt.Printf("  Warning: could not search history for related commits: %v\n", err)
+	}
+	prs, err := openPRs(cfg, git.Path())
+	if err != nil {
+		fmt.Printf("  Warning: could not list open PRs: %v\n", err)
+	}
+	prs = matchRelatedPRs(prs, keywords)
+	if len(commits)+len(prs) > 0 {
+		fmt.Printf("  Related work: %d commit(s), %d open PR(s)\n", len(commits), len(prs))
+	}
+	return formatRelatedWork(commits, prs)
+}
+
+func formatRelatedWork(commits []relatedCommit, prs []relatedPR) string {
+	if len(commits) == 0 && len(prs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\n## Related Work\n")
+	b.WriteString("Recent commits and open PRs that mention this issue or its components. " +
+		"Build on them, and avoid redoing or conflicting with work in progress.\n")
+	if len(commits) > 0 {
+		b.WriteString("\nCommits:\n")
+		for _, c := range commits {
+			fmt.Fprintf(&b, "- %s (%s) %s\n", c.Hash, c.Date, c.Subject)
+		}
+	}
+	if len(prs) > 0 {
+		b.WriteString("\nOpen pull requests:\n")
+		for _, pr := range prs {
+			fmt.Fprintf(&b, "- #%d %s (branch %s)\n", pr.Number, pr.Title, pr.Branch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// renderRepoPath stands in for the workspace when rendering fixtures, so
+// attachment paths come out the same on every machine
+const renderRepoPath = "/workspace"
+
+// renderFixture is an issue that outbound content is rendered for, with
+// the lessons and related work the prompt would include
+type renderFixture struct {
+	Name    string
+	Issue   *Issue
+	Lessons []string
+	Related string
+}
+
+// renderFixtures cover the shapes of issue that change outbound formatting:
+// a full bug report, a non-English story with links and variables, and a
+// bare task whose title doesn't transliterate
+func renderFixtures() []renderFixture {
+	story := &Issue{
+		Key:         "PROJ-124",
+		Title:       "Ajouter l'export CSV des réservations",
+		Description: "Finance needs a CSV export of *all* reservations, including cancelled ones.",
+		Type:        "Story",
+		Priority:    "Medium",
+		Status:      "To Do",
+		Labels:      []string{"billing", "export"},
+		Components:  []string{"Billing API", "web"},
+		AcceptanceCriteria: "- `GET /reservations.csv` returns every reservation\n" +
+			"- Amounts use the tenant's currency",
+		Attachments: []Attachment{{
+			Filename:  "mockup.png",
+			MimeType:  "image/png",
+			Size:      2048,
+			LocalPath: filepath.Join(renderRepoPath, contextDirName, "PROJ-124", "mockup.png"),
+		}},
+		Links: []IssueLink{
+			{Relation: "is blocked by", Key: "PROJ-98", Title: "Add reservations table", Status: "Done"},
+		},
+		StoryPoints: 5,
+		Variables:   map[string]string{"tenant": "acme"},
+	}
+	return []renderFixture{
+		{Name: "bug", Issue: sampleIssue(), Lessons: []string{"Run `make generate` after editing .proto files"}},
+		{Name: "story", Issue: story, Related: formatRelatedWork(
+			[]relatedCommit{{Hash: "4f2a9c1", Date: "2024-01-09", Subject: "feat(billing-api): PROJ-98 add reservations table"}},
+			[]relatedPR{{Number: 41, Title: "[PROJ-120] Paginate reservations in the Billing API", Branch: "feature/PROJ-120-paginate-reservations"}},
+		)},
+		{Name: "minimal", Issue: &Issue{Key: "PROJ-7", Title: "ログイン画面の修正", Type: "Task", Priority: "Low"}},
+	}
+}
+
+// renderPreset is a configuration outbound content is rendered under
+type renderPreset struct {
+	Name  string
+	Apply func(cfg *Config)
+}
+
+// renderPresets render the built-in templates with the options that change
+// their output. The user's own templates render as the "config" preset.
+var renderPresets = []renderPreset{
+	{"default", func(cfg *Config) {}},
+	{"smart-commit", func(cfg *Config) {
+		cfg.Jira.SmartCommit = SmartCommitConfig{Comment: "Fixed by factory", Time: "1h"}
+	}},
+	{"custom-types", func(cfg *Config) {
+		cfg.Templates.CommitTypes = map[string]string{"Bug": "bugfix", "Story": "feature", "Task": "build"}
+		cfg.Repo.BranchPattern = "{{.Type}}/{{.Key}}-{{.Slug}}"
+	}},
+}
+
+// presetConfig is the fixed config the built-in presets start from
+func presetConfig() *Config {
+	return &Config{
+		Jira:   JiraConfig{BaseURL: "https://example.atlassian.net"},
+		GitHub: GitHubConfig{Owner: "org", Repo: "repo"},
+		Repo:   RepoConfig{DefaultBranch: "main"},
+	}
+}
+
+// renderedOutput is one piece of outbound content
+type renderedOutput struct {
+	Name string // branch, prompt, prTitle, prBody, commit, or comment
+	Text string
+}
+
+// renderOutbound renders everything factory would send for an issue: the
+// branch name, the prompt (and each prompt variant), the PR title and body,
+// the commit message, and the Jira comment
+func renderOutbound(cfg *Config, issue *Issue, lessons []string, related string) ([]renderedOutput, error) {
+	data := newTemplateData(cfg, renderRepoPath, issue)
+	data.Lessons = formatLessons(lessons)
+	data.Related = related
+	branch, err := BranchName(cfg, issue)
+	if err != nil {
+		return nil, err
+	}
+	data.Branch = branch
+	data.PRURL = fmt.Sprintf("https://github.com/%s/%s/pull/42", cfg.GitHub.Owner, cfg.GitHub.Repo)
+	data.Cost = formatCost(0.42, 1_234_000, 35_600)
+	data.CostUSD = 0.42
+
+	outputs := []renderedOutput{{"branch", branch}}
+	prompts := []*PromptVariant{nil}
+	for i := range cfg.Templates.PromptVariants {
+		prompts = append(prompts, &cfg.Templates.PromptVariants[i])
+	}
+	for _, v := range prompts {
+		src, err := promptSource(cfg, v)
+		if err != nil {
+			return nil, err
+		}
+		name := TemplatePrompt
+		if v != nil {
+			name += "-" + slugify(v.Name)
+		}
+		text, err := renderText(name, src, data)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, renderedOutput{name, text})
+	}
+	for _, name := range []string{TemplatePRTitle, TemplatePRBody, TemplateCommit, TemplateComment} {
+		text, err := RenderTemplate(cfg, name, data)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, renderedOutput{name, text})
+	}
+	return outputs, nil
+}
+
+// RenderOptions selects what `factory render` renders and checks
+type RenderOptions struct {
+	Preset    string // only this preset; "config" is the user's templates
+	IssueKey  string // render a real issue with the user's config instead of the fixtures
+	GoldenDir string // compare with golden files here
+	Update    bool   // rewrite the golden files instead of comparing
+}
+
+// Render prints the outbound content for each preset and fixture, or
+// compares it with golden files laid out as DIR/PRESET/FIXTURE/NAME.txt.
+// cfg may be nil when factory isn't configured; the "config" preset is
+// skipped then.
+func Render(cfg *Config, opts RenderOptions) error {
+	if opts.IssueKey != "" {
+		if cfg == nil {
+			return fmt.Errorf("not configured. Run: factory configure")
+		}
+		issue, err := GetIssue(cfg, opts.IssueKey)
+		if err != nil {
+			return err
+		}
+		outputs, err := renderOutbound(cfg, issue, LoadLessons(cfg), "")
+		if err != nil {
+			return err
+		}
+		printOutputs("config/"+issue.Key, outputs)
+		return nil
+	}
+
+	presets := renderPresets
+	if cfg != nil {
+		user := *cfg
+		presets = append(presets[:len(presets):len(presets)], renderPreset{"config", func(c *Config) { *c = user }})
+	}
+	var differ, checked int
+	found := false
+	for _, preset := range presets {
+		if opts.Preset != "" && opts.Preset != preset.Name {
+			continue
+		}
+		found = true
+		for _, fixture := range renderFixtures() {
+			pcfg := presetConfig()
+			preset.Apply(pcfg)
+			outputs, err := renderOutbound(pcfg, fixture.Issue, fixture.Lessons, fixture.Related)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", preset.Name, fixture.Name, err)
+			}
+			label := preset.Name + "/" + fixture.Name
+			if opts.GoldenDir == "" {
+				printOutputs(label, outputs)
+				continue
+			}
+			for _, out := range outputs {
+				path := filepath.Join(opts.GoldenDir, preset.Name, fixture.Name, out.Name+".txt")
+				checked++
+				if opts.Update {
+					if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+						return err
+					}
+					if err := os.WriteFile(path, []byte(out.Text+"\n"), 0644); err != nil {
+						return err
+					}
+					continue
+				}
+				if diff := goldenDiff(path, out.Text); diff != "" {
+					differ++
+					fmt.Printf("✗ %s/%s\n%s\n", label, out.Name, diff)
+				}
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown preset %q", opts.Preset)
+	}
+	switch {
+	case opts.GoldenDir == "":
+	case opts.Update:
+		fmt.Printf("Wrote %d golden file(s) to %s\n", checked, opts.GoldenDir)
+	case differ > 0:
+		return fmt.Errorf("%d of %d output(s) differ from %s; rerun with --update to accept", differ, checked, opts.GoldenDir)
+	default:
+		fmt.Printf("✓ %d output(s) match %s\n", checked, opts.GoldenDir)
+	}
+	return nil
+}
+
+func printOutputs(label string, outputs []renderedOutput) {
+	for _, out := range outputs {
+		fmt.Printf("==> %s/%s <==\n%s\n\n", label, out.Name, out.Text)
+	}
+}
+
+// goldenDiff describes how text differs from the golden file at path, or
+// returns "" when they match
+func goldenDiff(path, text string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "  " + err.Error()
+	}
+	want := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	got := strings.Split(text, "\n")
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if i >= len(want) || i >= len(got) || w != g {
+			return fmt.Sprintf("  line %d:\n  - %s\n  + %s", i+1, w, g)
+		}
+	}
+	return ""
+}
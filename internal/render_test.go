@@ -0,0 +1,30 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestRenderGolden checks the built-in templates' outbound content against
+// testdata/render. After an intended change, rewrite the golden files with
+// `go run . render --golden internal/testdata/render --update`.
+func TestRenderGolden(t *testing.T) {
+	for _, preset := range renderPresets {
+		for _, fixture := range renderFixtures() {
+			t.Run(preset.Name+"/"+fixture.Name, func(t *testing.T) {
+				cfg := presetConfig()
+				preset.Apply(cfg)
+				outputs, err := renderOutbound(cfg, fixture.Issue, fixture.Lessons, fixture.Related)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, out := range outputs {
+					path := filepath.Join("testdata", "render", preset.Name, fixture.Name, out.Name+".txt")
+					if diff := goldenDiff(path, out.Text); diff != "" {
+						t.Errorf("%s differs from %s:\n%s", out.Name, path, diff)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// repoContextFiles are the files a repository can use to give the agent its
+// ground rules, in order of preference. Only the first one found is used.
+// CLAUDE.md isn't one: Claude Code reads it from the worktree itself, and
+// adding it here would put it in front of the agent twice.
+var repoContextFiles = []string{".factory.md"}
+
+// maxRepoContextSize caps how much of the context file goes into the prompt
+const maxRepoContextSize = 32 * 1024
+
+// loadRepoContext returns the name and contents of the context file in the
+// worktree at repoPath, or empty strings when it has none
+func loadRepoContext(repoPath string) (string, string) {
+	if repoPath == "" {
+		return "", ""
+	}
+	for _, name := range repoContextFiles {
+		data, err := os.ReadFile(filepath.Join(repoPath, name))
+		if err != nil {
+			continue
+		}
+		text := strings.TrimSpace(string(data))
+		if text == "" {
+			continue
+		}
+		if len(text) > maxRepoContextSize {
+			fmt.Printf("  Warning: %s is over %dKB; only the start goes into the prompt\n", name, maxRepoContextSize/1024)
+			text = truncate(maxRepoContextSize, text)
+		}
+		return name, text
+	}
+	return "", ""
+}
+
+// withRepoContext prepends the repository's context file to a rendered
+// prompt, so the repo owners' rules come before the issue
+func withRepoContext(repoPath, prompt string) string {
+	name, text := loadRepoContext(repoPath)
+	if name == "" {
+		return prompt
+	}
+	fmt.Printf("  Repository guidelines: %s\n", name)
+	return fmt.Sprintf("## Repository Guidelines (from %s)\nFollow these rules from the repository's maintainers throughout.\n\n%s\n\n---\n\n%s", name, text, prompt)
+}
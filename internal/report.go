@@ -0,0 +1,350 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportInterval is how often the daemon publishes the digest
+const reportInterval = 7 * 24 * time.Hour
+
+// Report summarizes factory's runs over a period
+type Report struct {
+	Since, Until time.Time
+	Processed    int
+	PRs          int
+	Merged       int
+	Closed       int // closed without merging
+	Previewed    int
+	Failed       int
+	Escalated    int     // runs retried with a stronger model
+	CostUSD      float64 // agent cost of the period's runs
+	// Failures counts failed runs by stage
+	Failures map[string]int
+	// Variants compares prompt variants when an experiment ran
+	Variants map[string]*VariantStats
+}
+
+// VariantStats are the outcomes of runs that used one prompt variant
+type VariantStats struct {
+	Runs, PRs, Merged, Failed int
+}
+
+func percent(n, of int) string {
+	if of == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", n*100/of)
+}
+
+// BuildReport summarizes the runs recorded since the given time
+func BuildReport(since time.Time) Report {
+	loadProcessed()
+	r := Report{Since: since, Until: time.Now(), Failures: map[string]int{}, Variants: map[string]*VariantStats{}}
+	for _, info := range processed {
+		at, err := time.Parse(time.RFC3339, info.ProcessedAt)
+		if err != nil || at.Before(since) {
+			continue
+		}
+		r.Processed++
+		if info.PRUrl != "" {
+			r.PRs++
+		}
+		switch {
+		case info.PRState == PRStateMerged:
+			r.Merged++
+		case info.PRState == PRStateClosed:
+			r.Closed++
+		}
+		if len(info.Models) > 1 {
+			r.Escalated++
+		}
+		r.CostUSD += info.CostUSD
+		switch info.Status {
+		case "previewed":
+			r.Previewed++
+		case "failed":
+			r.Failed++
+			r.Failures[failureStage(info)]++
+		}
+
+		if info.Variant != "" {
+			v := r.Variants[info.Variant]
+			if v == nil {
+				v = &VariantStats{}
+				r.Variants[info.Variant] = v
+			}
+			v.Runs++
+			if info.PRUrl != "" {
+				v.PRs++
+			}
+			if info.PRState == PRStateMerged {
+				v.Merged++
+			}
+			if info.Status == "failed" {
+				v.Failed++
+			}
+		}
+	}
+	return r
+}
+
+// variantNames lists the report's prompt variants in name order
+func (r Report) variantNames() []string {
+	names := make([]string, 0, len(r.Variants))
+	for name := range r.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// failureStage is the stage a recorded run failed at; entries from before
+// the stage was recorded carry it as the error's prefix
+func failureStage(info ProcessedIssue) string {
+	if info.Stage != "" {
+		return info.Stage
+	}
+	if stage, _, ok := strings.Cut(info.Error, ":"); ok && !strings.Contains(stage, " ") {
+		return stage
+	}
+	return "unknown"
+}
+
+func (r Report) title() string {
+	return fmt.Sprintf("Factory report %s – %s", r.Since.Format("Jan 2"), r.Until.Format("Jan 2, 2006"))
+}
+
+// summary is the report's opening line
+func (r Report) summary() string {
+	s := fmt.Sprintf("%d issue(s) processed", r.Processed)
+	if r.CostUSD > 0 {
+		s += fmt.Sprintf(", $%.2f agent cost", r.CostUSD)
+	}
+	if r.Escalated > 0 {
+		s += fmt.Sprintf(", %d escalated to a stronger model", r.Escalated)
+	}
+	return s + "."
+}
+
+// reportRow is one bar in a report chart
+type reportRow struct {
+	Label string
+	Count int
+}
+
+// outcomes are the chart rows for run outcomes
+func (r Report) outcomes() []reportRow {
+	return []reportRow{
+		{"PRs created", r.PRs},
+		{"Merged", r.Merged},
+		{"Closed unmerged", r.Closed},
+		{"Previewed", r.Previewed},
+		{"Failed", r.Failed},
+	}
+}
+
+// failureRows are the chart rows for failures, most frequent first
+func (r Report) failureRows() []reportRow {
+	rows := make([]reportRow, 0, len(r.Failures))
+	for stage, n := range r.Failures {
+		rows = append(rows, reportRow{stage, n})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Label < rows[j].Label
+	})
+	return rows
+}
+
+// Markdown renders the report with text bar charts, for the terminal and
+// Jira comments
+func (r Report) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", r.title())
+	fmt.Fprintf(&b, "%s\n\n```\n", r.summary())
+	for _, row := range r.outcomes() {
+		fmt.Fprintf(&b, "%-16s %s %d\n", row.Label, bar(row.Count), row.Count)
+	}
+	b.WriteString("```\n")
+
+	if len(r.Failures) > 0 {
+		b.WriteString("\n### Failures by stage\n\n```\n")
+		for _, row := range r.failureRows() {
+			fmt.Fprintf(&b, "%-16s %s %d\n", row.Label, bar(row.Count), row.Count)
+		}
+		b.WriteString("```\n")
+	}
+
+	if len(r.Variants) > 0 {
+		b.WriteString("\n### Prompt variants\n\n```\n")
+		fmt.Fprintf(&b, "%-16s %5s %8s %11s %7s\n", "Variant", "Runs", "PR rate", "Merge rate", "Failed")
+		for _, name := range r.variantNames() {
+			v := r.Variants[name]
+			fmt.Fprintf(&b, "%-16s %5d %8s %11s %7d\n", name, v.Runs, percent(v.PRs, v.Runs), percent(v.Merged, v.PRs), v.Failed)
+		}
+		b.WriteString("```\n")
+	}
+	return b.String()
+}
+
+func bar(n int) string {
+	if n > 40 {
+		n = 40
+	}
+	return strings.Repeat("█", n)
+}
+
+// storageHTML renders the report in Confluence storage format, with chart
+// macros over the data tables
+func (r Report) storageHTML() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<p>%s</p>", html.EscapeString(r.summary()))
+
+	chart := func(title string, rows []reportRow) {
+		fmt.Fprintf(&b, `<h2>%s</h2><ac:structured-macro ac:name="chart">`+
+			`<ac:parameter ac:name="type">bar</ac:parameter>`+
+			`<ac:parameter ac:name="orientation">horizontal</ac:parameter>`+
+			`<ac:parameter ac:name="legend">false</ac:parameter>`+
+			`<ac:rich-text-body><table><tbody><tr><th>%s</th><th>Count</th></tr>`, html.EscapeString(title), html.EscapeString(title))
+		for _, row := range rows {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%d</td></tr>", html.EscapeString(row.Label), row.Count)
+		}
+		b.WriteString("</tbody></table></ac:rich-text-body></ac:structured-macro>")
+	}
+
+	chart("Outcomes", r.outcomes())
+	if len(r.Failures) > 0 {
+		chart("Failures by stage", r.failureRows())
+	}
+	if len(r.Variants) > 0 {
+		b.WriteString("<h2>Prompt variants</h2><table><tbody>" +
+			"<tr><th>Variant</th><th>Runs</th><th>PR rate</th><th>Merge rate</th><th>Failed</th></tr>")
+		for _, name := range r.variantNames() {
+			v := r.Variants[name]
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%d</td><td>%s</td><td>%s</td><td>%d</td></tr>",
+				html.EscapeString(name), v.Runs, percent(v.PRs, v.Runs), percent(v.Merged, v.PRs), v.Failed)
+		}
+		b.WriteString("</tbody></table>")
+	}
+	return b.String()
+}
+
+// PublishReport posts the report as a comment on report.jiraIssue and/or a
+// page in report.confluenceSpace
+func PublishReport(cfg *Config, r Report) error {
+	sinks := reportSinks(cfg)
+	if len(sinks) == 0 {
+		return fmt.Errorf("set report.jiraIssue or report.confluenceSpace to publish reports")
+	}
+	for _, sink := range sinks {
+		if err := publishReportTo(cfg, r, sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Where reports are published
+const (
+	sinkJira       = "jira"
+	sinkConfluence = "confluence"
+)
+
+// reportSinks are the configured report destinations
+func reportSinks(cfg *Config) []string {
+	var sinks []string
+	if cfg.Report.JiraIssue != "" {
+		sinks = append(sinks, sinkJira)
+	}
+	if cfg.Report.ConfluenceSpace != "" {
+		sinks = append(sinks, sinkConfluence)
+	}
+	return sinks
+}
+
+// publishReportTo publishes the report to one of reportSinks
+func publishReportTo(cfg *Config, r Report, sink string) error {
+	if sink == sinkJira {
+		if err := AddComment(cfg, cfg.Report.JiraIssue, r.Markdown()); err != nil {
+			return fmt.Errorf("report comment on %s: %w", cfg.Report.JiraIssue, err)
+		}
+		return nil
+	}
+	page := map[string]interface{}{
+		"type":  "page",
+		"title": r.title(),
+		"space": map[string]string{"key": cfg.Report.ConfluenceSpace},
+		"body": map[string]interface{}{
+			"storage": map[string]string{"value": r.storageHTML(), "representation": "storage"},
+		},
+	}
+	if cfg.Report.ConfluenceParent != "" {
+		page["ancestors"] = []map[string]string{{"id": cfg.Report.ConfluenceParent}}
+	}
+	_, err := jiraRequest(cfg, "POST", "/wiki/rest/api/content", page)
+	recordAudit(actorOf(cfg), "confluence.page", "", cfg.Report.ConfluenceSpace, r.title(), err)
+	if err != nil {
+		return fmt.Errorf("confluence page: %w", err)
+	}
+	return nil
+}
+
+type reportState struct {
+	LastPublished time.Time `json:"lastPublished"`
+	// Sinks is when each of reportSinks last had the report, so that one
+	// failing is retried without publishing the other twice
+	Sinks map[string]time.Time `json:"sinks,omitempty"`
+}
+
+func GetReportStatePath() string {
+	return filepath.Join(GetConfigDir(), "report.json")
+}
+
+// publishWeeklyReport publishes the digest from the daemon once a week to
+// each configured destination. A destination that fails is retried on the
+// next poll, covering the time since it last had the report.
+func publishWeeklyReport(cfg *Config) {
+	sinks := reportSinks(cfg)
+	if len(sinks) == 0 {
+		return
+	}
+	var state reportState
+	if data, err := os.ReadFile(GetReportStatePath()); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	if state.Sinks == nil {
+		state.Sinks = map[string]time.Time{}
+	}
+	if state.LastPublished.IsZero() {
+		// Start the clock at the first poll rather than publishing at once
+		state.LastPublished = time.Now()
+	}
+	for _, sink := range sinks {
+		since, ok := state.Sinks[sink]
+		if !ok {
+			since = state.LastPublished
+			state.Sinks[sink] = since
+		}
+		if time.Since(since) < reportInterval {
+			continue
+		}
+		if err := publishReportTo(cfg, BuildReport(since), sink); err != nil {
+			fmt.Printf("Warning: could not publish weekly report to %s: %v\n", sink, err)
+			continue
+		}
+		fmt.Printf("Published weekly report to %s\n", sink)
+		state.Sinks[sink] = time.Now()
+		state.LastPublished = time.Now()
+	}
+	data, _ := json.MarshalIndent(state, "", "  ")
+	os.WriteFile(GetReportStatePath(), data, 0644)
+}
@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReprocessConfig has the daemon run processed issues again when they
+// change in Jira, rather than leaving them alone for good. It needs the
+// REST API (jira.useAcli off).
+type ReprocessConfig struct {
+	// Reopened re-queues an issue moved back out of a done status, or
+	// whose resolution was cleared, since its last run
+	Reopened bool `json:"reopened,omitempty"`
+	// DescriptionChange re-queues an issue whose description changed by at
+	// least this fraction of its words since its last run, e.g. 0.2; 0
+	// ignores description edits
+	DescriptionChange float64 `json:"descriptionChange,omitempty"`
+}
+
+func (c ReprocessConfig) enabled() bool {
+	return c.Reopened || c.DescriptionChange > 0
+}
+
+// issueSnapshot is an issue as its last run saw it, to tell later changes by
+type issueSnapshot struct {
+	Description string `json:"description"`
+	// Checked is the issue's updated time when it was last looked at, so
+	// an issue is only fetched again once it changes
+	Checked time.Time `json:"checked"`
+}
+
+// GetSnapshotPath is where the issue's snapshot is kept
+func GetSnapshotPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "snapshots", issueKey+".json")
+}
+
+func loadSnapshot(issueKey string) (issueSnapshot, bool) {
+	var s issueSnapshot
+	data, err := os.ReadFile(GetSnapshotPath(issueKey))
+	if err != nil || json.Unmarshal(data, &s) != nil {
+		return s, false
+	}
+	return s, true
+}
+
+func saveSnapshot(issueKey string, s issueSnapshot) {
+	path := GetSnapshotPath(issueKey)
+	data, _ := json.MarshalIndent(s, "", "  ")
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		fmt.Printf("  Warning: could not save %s: %v\n", path, err)
+	}
+}
+
+// snapshotIssue records the issue as a run starts working on it
+func snapshotIssue(cfg *Config, issue *Issue) {
+	if !cfg.Jira.Reprocess.enabled() {
+		return
+	}
+	saveSnapshot(issue.Key, issueSnapshot{Description: issue.Description, Checked: issue.Updated})
+}
+
+// issueChanges is what happened to an issue since a time
+type issueChanges struct {
+	Description string
+	Reopened    bool
+}
+
+// getIssueChanges fetches the issue's description and whether its history
+// shows it reopened after since
+func getIssueChanges(cfg *Config, issueKey string, since time.Time) (*issueChanges, error) {
+	cfg = cfg.jiraSite(issueKey)
+	path := fmt.Sprintf("%s/issue/%s?fields=description&expand=changelog", cfg.Jira.restAPI(), issueKey)
+	body, err := jiraRequest(cfg, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Fields struct {
+			Description json.RawMessage `json:"description"`
+		} `json:"fields"`
+		Changelog struct {
+			Histories []struct {
+				Created string `json:"created"`
+				Items   []struct {
+					Field      string `json:"field"`
+					FromString string `json:"fromString"`
+					ToString   string `json:"toString"`
+				} `json:"items"`
+			} `json:"histories"`
+		} `json:"changelog"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	changes := &issueChanges{Description: jiraText(data.Fields.Description)}
+	for _, h := range data.Changelog.Histories {
+		created, err := time.Parse(jiraTimeLayout, h.Created)
+		if err != nil || !created.After(since) {
+			continue
+		}
+		for _, item := range h.Items {
+			switch strings.ToLower(item.Field) {
+			case "status":
+				if isClosedStatus(item.FromString) && !isClosedStatus(item.ToString) {
+					changes.Reopened = true
+				}
+			case "resolution":
+				if item.FromString != "" && item.ToString == "" {
+					changes.Reopened = true
+				}
+			}
+		}
+	}
+	return changes, nil
+}
+
+// descriptionChange is the fraction of words that differ between two
+// descriptions, from 0 for the same words to 1 for none in common. Word
+// order is ignored; reflowing or reformatting text changes nothing.
+func descriptionChange(before, after string) float64 {
+	count := func(s string) map[string]int {
+		words := make(map[string]int)
+		for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+		}) {
+			words[w]++
+		}
+		return words
+	}
+	a, b := count(before), count(after)
+	var shared, total int
+	for w, n := range a {
+		shared += min(n, b[w])
+		total += max(n, b[w])
+	}
+	for w, n := range b {
+		if _, ok := a[w]; !ok {
+			total += n
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 1 - float64(shared)/float64(total)
+}
+
+// pollReprocess re-queues processed issues that were reopened or whose
+// description changed enough since their last run, per jira.reprocess. It
+// returns the keys it queued.
+func pollReprocess(cfg *Config, issues []Issue) []string {
+	rp := cfg.Jira.Reprocess
+	if !rp.enabled() {
+		return nil
+	}
+	queue := loadQueue()
+	var queued []string
+	for _, issue := range issues {
+		info, ok := processedEntry(issue.Key)
+		if !ok || issue.Updated.IsZero() || queueIndex(queue, issue.Key) >= 0 || hasLease(cfg, issue.Key) {
+			continue
+		}
+		ranAt, err := time.Parse(time.RFC3339, info.ProcessedAt)
+		if err != nil {
+			continue
+		}
+		snap, ok := loadSnapshot(issue.Key)
+		if !ok {
+			// Processed before jira.reprocess was set: changes count from now
+			if i, err := GetIssue(cfg, issue.Key); err == nil {
+				fmt.Printf("  Watching %s for changes from now on\n", issue.Key)
+				saveSnapshot(issue.Key, issueSnapshot{Description: i.Description, Checked: issue.Updated})
+			}
+			continue
+		}
+		// The run's own comments and transitions update the issue too
+		if !issue.Updated.After(snap.Checked) || !issue.Updated.After(ranAt) {
+			continue
+		}
+
+		changes, err := getIssueChanges(cfg, issue.Key, ranAt)
+		if err != nil {
+			fmt.Printf("  Warning: could not check %s for changes: %v\n", issue.Key, err)
+			continue
+		}
+		var reason string
+		if rp.Reopened && changes.Reopened {
+			reason = "the issue was reopened"
+		} else if d := descriptionChange(snap.Description, changes.Description); rp.DescriptionChange > 0 && d >= rp.DescriptionChange {
+			reason = fmt.Sprintf("its description changed (%.0f%% of words)", d*100)
+		}
+		if reason == "" {
+			snap.Checked = issue.Updated
+			saveSnapshot(issue.Key, snap)
+			continue
+		}
+
+		fmt.Printf("%s changed since its last run: %s\n", issue.Key, reason)
+		// A plan for the issue as it was no longer holds
+		clearPlan(issue.Key)
+		requeueRun(issue, info, "")
+		queued = append(queued, issue.Key)
+		if err := AddComment(cfg, issue.Key, "factory: queued a new run, since "+reason); err != nil {
+			fmt.Printf("  Warning: could not comment on %s: %v\n", issue.Key, err)
+		}
+	}
+	return queued
+}
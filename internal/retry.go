@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// retryStages are the failure stages `factory retry` can pick up from
+// instead of starting over: the agent's work is still in the workspace, or
+// its commit is still on the branch
+var retryStages = map[string]bool{
+	"claude": true,
+	"verify": true,
+	"budget": true,
+	"push":   true,
+	"pr":     true,
+}
+
+// retryPoint is where a failed run left off: its branch, and for agent
+// failures the workspace state the agent started from
+type retryPoint struct {
+	Stage  string   `json:"stage"`
+	Error  string   `json:"error"`
+	Branch string   `json:"branch"`
+	Model  string   `json:"model,omitempty"` // last model tried
+	Before Snapshot `json:"before,omitempty"`
+	// Requested is set by `factory retry`; any other run starts over
+	Requested bool      `json:"requested,omitempty"`
+	FailedAt  time.Time `json:"failedAt"`
+}
+
+func retryPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "retries", issueKey+".json")
+}
+
+func loadRetryPoint(issueKey string) *retryPoint {
+	data, err := os.ReadFile(retryPath(issueKey))
+	if err != nil {
+		return nil
+	}
+	var rp retryPoint
+	if json.Unmarshal(data, &rp) != nil || rp.Branch == "" {
+		return nil
+	}
+	return &rp
+}
+
+func writeRetryPoint(issueKey string, rp retryPoint) {
+	data, _ := json.MarshalIndent(rp, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(retryPath(issueKey)), 0700); err != nil {
+		fmt.Printf("  Warning: could not save retry point: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(retryPath(issueKey), data, 0600); err != nil {
+		fmt.Printf("  Warning: could not save retry point: %v\n", err)
+	}
+}
+
+func clearRetryPoint(issueKey string) {
+	os.Remove(retryPath(issueKey))
+}
+
+// saveRetryPoint records where a failed run can be retried from, and drops
+// the issue's old retry point otherwise
+func saveRetryPoint(result *Result) {
+	if result.Status != "failed" || !retryStages[result.Stage] || result.branch == "" {
+		clearRetryPoint(result.IssueKey)
+		return
+	}
+	rp := retryPoint{
+		Stage:    result.Stage,
+		Error:    result.Error,
+		Branch:   result.branch,
+		Before:   result.before,
+		FailedAt: time.Now(),
+	}
+	if n := len(result.Models); n > 0 {
+		rp.Model = result.Models[n-1]
+	}
+	writeRetryPoint(result.IssueKey, rp)
+}
+
+// requestRetry marks the issue's retry point for its next run and returns
+// the stage that run picks up from, or "" when it starts over
+func requestRetry(issueKey string) string {
+	rp := loadRetryPoint(issueKey)
+	if rp == nil {
+		return ""
+	}
+	rp.Requested = true
+	writeRetryPoint(issueKey, *rp)
+	return rp.Stage
+}
+
+// pendingRetry returns the retry point `factory retry` asked this run to
+// pick up from, if the work it left is still there. Agent failures need the
+// workspace still on the branch with the agent's changes; push and PR
+// failures only need the local branch. Any other run starts over.
+func pendingRetry(git *Git, issueKey string) *retryPoint {
+	rp := loadRetryPoint(issueKey)
+	if rp == nil {
+		return nil
+	}
+	clearRetryPoint(issueKey)
+	if !rp.Requested {
+		return nil
+	}
+	switch rp.Stage {
+	case "push", "pr":
+		if git.branchIssue(rp.Branch) == issueKey {
+			return rp
+		}
+	default:
+		if branch, _ := git.CurrentBranch(); branch == rp.Branch {
+			return rp
+		}
+	}
+	fmt.Printf("  Starting over: the work the run that failed at %s left on %s is gone\n", rp.Stage, rp.Branch)
+	return nil
+}
+
+// retryNote tells the agent about the failed run whose changes it finds in
+// the working tree
+func retryNote(rp *retryPoint) string {
+	return fmt.Sprintf(`
+
+## Earlier Attempt
+A previous run failed at %s:
+
+%s
+
+Its changes are still in the working tree. Review them, fix what made the run
+fail, and finish the work; don't start over.
+`, rp.Stage, truncate(2000, rp.Error))
+}
+
+// retryPublish pushes the commit a failed run left on its branch and opens
+// the PR, without running the agent again
+func retryPublish(cfg *Config, git *Git, issue *Issue, hook hookContext, rp *retryPoint, result *Result) *Result {
+	if err := git.EnsureClean(issue.Key, cfg.Repo.StashDirty); err != nil {
+		return fail(result, "workspace", err)
+	}
+	if _, err := git.exec("checkout", rp.Branch); err != nil {
+		return fail(result, "git", err)
+	}
+	result.branch = rp.Branch
+	hook.Branch = rp.Branch
+	fmt.Printf("  Branch: %s (publishing the commit left by the failed run)\n", rp.Branch)
+	changed, err := git.BranchFiles()
+	if err != nil {
+		return fail(result, "git", err)
+	}
+	hook.Files = changed
+
+	fmt.Println("→ Pushing...")
+	result.enterStage("commit")
+	err = git.Push(rp.Branch)
+	saveBranchDiff(git, issue.Key)
+	if err != nil {
+		return fail(result, "push", err)
+	}
+	data := newTemplateData(cfg, git.Path(), issue)
+	data.Branch = rp.Branch
+	return openPR(cfg, git, issue, data, hook, changed, result, 0)
+}
+
+// RetryIssue re-runs a failed issue, on the daemon if one is running and
+// local isn't set. The run picks up from the failed stage when the work
+// before it is still there, and starts over otherwise. It returns the
+// result of a local run, or nil when the daemon took it.
+func RetryIssue(cfg *Config, issueKey string, local bool) (*Result, error) {
+	info, ok := readProcessed()[issueKey]
+	if !ok || info.Status != "failed" {
+		return nil, fmt.Errorf("%s has no failed run to retry; use factory trigger", issueKey)
+	}
+	if stage := requestRetry(issueKey); stage != "" {
+		fmt.Printf("Retrying %s from %s\n", issueKey, stage)
+	} else {
+		fmt.Printf("Retrying %s from the start (it failed at %s)\n", issueKey, orDash(info.Stage))
+	}
+
+	if !local {
+		path := "/api/retry?issue=" + url.QueryEscape(issueKey)
+		if info.Base != "" {
+			path += "&base=" + url.QueryEscape(info.Base)
+		}
+		_, err := callDaemon("POST", path)
+		if err == nil {
+			fmt.Printf("Queued %s on the daemon; follow it with: factory logs %s\n", issueKey, issueKey)
+			return nil, nil
+		}
+		if !IsNoDaemon(err) {
+			return nil, err
+		}
+	}
+	if err := CheckBudget(cfg); err != nil {
+		return nil, err
+	}
+	forgetProcessed(issueKey)
+	result := ProcessIssue(cfg, issueKey, RunOptions{Base: info.Base})
+	RecordResult(result)
+	return result, nil
+}
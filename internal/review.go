@@ -0,0 +1,338 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ReviewConfig has the daemon review the PRs people open for Jira issues:
+// the agent reads the change against the issue and its acceptance criteria
+// with read-only tools, and its findings are posted as a PR review comment
+type ReviewConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Authors limits reviews to PRs by these GitHub logins; empty reviews
+	// everyone's but bots' and factory's own
+	Authors []string `json:"authors,omitempty"`
+	// Drafts reviews draft PRs too
+	Drafts bool `json:"drafts,omitempty"`
+	// Rereview reviews a PR again after new commits are pushed to it;
+	// otherwise each PR is reviewed once
+	Rereview bool `json:"rereview,omitempty"`
+	// MaxPerPoll caps the reviews one poll runs, default 1
+	MaxPerPoll int `json:"maxPerPoll,omitempty"`
+}
+
+func (c ReviewConfig) maxPerPoll() int {
+	if c.MaxPerPoll <= 0 {
+		return 1
+	}
+	return c.MaxPerPoll
+}
+
+// maxReviewDiffLines caps the diff put in a review prompt; the agent reads
+// the files for the rest
+const maxReviewDiffLines = 1500
+
+// prIssueKeyPattern finds a Jira issue key in a PR's title or branch
+var prIssueKeyPattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])([a-z][a-z0-9_]*-[0-9]+)(?:[^0-9]|$)`)
+
+// prIssueKeys are the Jira issue keys a PR's title and branch name, in
+// that order; some, like "UTF-8", may name no issue
+func prIssueKeys(pr listedPR) []string {
+	var keys []string
+	for _, s := range []string{pr.Title, pr.Branch} {
+		for _, m := range prIssueKeyPattern.FindAllStringSubmatch(s, -1) {
+			keys = append(keys, strings.ToUpper(m[1]))
+		}
+	}
+	return keys
+}
+
+// prReview is a PR factory reviewed, or passed over
+type prReview struct {
+	Issue      string `json:"issue,omitempty"`
+	HeadSHA    string `json:"headSha"`
+	ReviewedAt string `json:"reviewedAt"`
+	// Skipped says why the PR got no review, e.g. its Jira issue can't be
+	// read; it is looked at again after new commits
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// GetReviewsPath is where the PRs factory reviewed are kept, by URL
+func GetReviewsPath() string {
+	return filepath.Join(GetConfigDir(), "reviews.json")
+}
+
+func loadReviews() map[string]prReview {
+	reviews := make(map[string]prReview)
+	readStateFile(GetReviewsPath(), &reviews)
+	return reviews
+}
+
+func saveReviews(reviews map[string]prReview) {
+	data, _ := json.MarshalIndent(reviews, "", "  ")
+	if err := writeStateFile(GetReviewsPath(), data); err != nil {
+		fmt.Printf("  Warning: could not save %s: %v\n", GetReviewsPath(), err)
+	}
+}
+
+// wantsReview reports whether the daemon should review the PR, given the
+// PRs factory opened itself and those it reviewed
+func wantsReview(cfg *Config, pr listedPR, own map[string]bool, reviews map[string]prReview) bool {
+	if pr.Bot || own[pr.URL] || pr.Draft && !cfg.Review.Drafts {
+		return false
+	}
+	if len(cfg.Review.Authors) > 0 {
+		found := false
+		for _, a := range cfg.Review.Authors {
+			found = found || strings.EqualFold(a, pr.Author)
+		}
+		if !found {
+			return false
+		}
+	}
+	r, ok := reviews[pr.URL]
+	switch {
+	case !ok:
+		return true
+	case r.HeadSHA == pr.HeadSHA:
+		return false
+	default:
+		// New commits: a skipped PR may now name its issue
+		return r.Skipped != "" || cfg.Review.Rereview
+	}
+}
+
+// pollReviews reviews the open PRs people opened since the last poll, up
+// to review.maxPerPoll of them. Paused daemons and spent budgets review
+// nothing.
+func pollReviews(cfg *Config) {
+	if !cfg.Review.Enabled || control.isPaused() {
+		return
+	}
+	if reason, _ := budgetExhausted(cfg, time.Now()); reason != "" {
+		return
+	}
+	prs, err := listOpenPRs(cfg)
+	if err != nil {
+		fmt.Printf("Warning: could not list PRs to review: %v\n", err)
+		return
+	}
+	own := make(map[string]bool)
+	for _, info := range processedSnapshot() {
+		own[info.PRUrl] = true
+	}
+	reviews := loadReviews()
+
+	// Closed PRs are forgotten
+	open := make(map[string]bool)
+	for _, pr := range prs {
+		open[pr.URL] = true
+	}
+	for url := range reviews {
+		if !open[url] {
+			delete(reviews, url)
+		}
+	}
+
+	done := 0
+	// Oldest first, so that a busy repo doesn't starve its older PRs
+	for i := len(prs) - 1; i >= 0 && done < cfg.Review.maxPerPoll(); i-- {
+		pr := prs[i]
+		if control.isStopping() {
+			break
+		}
+		if !wantsReview(cfg, pr, own, reviews) {
+			continue
+		}
+		done++
+		r, err := reviewPR(cfg, pr, false)
+		if err != nil {
+			fmt.Printf("Warning: could not review %s: %v\n", pr.URL, err)
+			continue
+		}
+		reviews[pr.URL] = r
+	}
+	saveReviews(reviews)
+}
+
+// ReviewPR reviews an open PR, given by URL or number, on demand. With
+// dryRun the review is printed instead of posted.
+func ReviewPR(cfg *Config, ref string, dryRun bool) error {
+	prs, err := listOpenPRs(cfg)
+	if err != nil {
+		return err
+	}
+	for _, pr := range prs {
+		if pr.URL != ref && !strings.HasSuffix(pr.URL, "/pull/"+strings.TrimPrefix(ref, "#")) {
+			continue
+		}
+		r, err := reviewPR(cfg, pr, dryRun)
+		if err != nil {
+			return err
+		}
+		if r.Skipped != "" {
+			return fmt.Errorf("not reviewing %s: %s", pr.URL, r.Skipped)
+		}
+		if !dryRun {
+			reviews := loadReviews()
+			reviews[pr.URL] = r
+			saveReviews(reviews)
+		}
+		return nil
+	}
+	return fmt.Errorf("no open PR %s in %s/%s", ref, cfg.GitHub.Owner, cfg.GitHub.Repo)
+}
+
+// reviewPR has the agent review a PR in the workspace, checked out at the
+// PR's head, and posts the review. A PR that names no readable Jira issue
+// is recorded as skipped, with the reason; an error, such as the agent
+// failing, leaves the PR to be tried again.
+func reviewPR(cfg *Config, pr listedPR, dryRun bool) (prReview, error) {
+	record := prReview{HeadSHA: pr.HeadSHA, ReviewedAt: time.Now().Format(time.RFC3339)}
+	fmt.Printf("\nReviewing %s: %s (by %s)\n", pr.URL, pr.Title, pr.Author)
+	var issue *Issue
+	var err error
+	for _, k := range prIssueKeys(pr) {
+		if issue, err = GetIssue(cfg, k); err == nil {
+			break
+		}
+	}
+	switch {
+	case issue == nil && err == nil:
+		record.Skipped = "neither its title nor its branch names a Jira issue"
+	case issue == nil:
+		record.Skipped = fmt.Sprintf("could not read its Jira issue: %v", err)
+	}
+	if record.Skipped != "" {
+		fmt.Printf("  Not reviewing it: %s\n", record.Skipped)
+		return record, nil
+	}
+	key := issue.Key
+	record.Issue = key
+	num, err := prNumber(pr.URL)
+	if err != nil {
+		return record, err
+	}
+
+	workspace, err := lockWorkspace(cfg, control.isStopping)
+	switch {
+	case errors.Is(err, errLockWait):
+		return record, fmt.Errorf("workspace: %w", err)
+	case err != nil:
+		fmt.Printf("  Warning: could not lock the workspace: %v\n", err)
+	}
+	defer workspace.unlock()
+	git := NewGit(withBase(cfg, pr.Base))
+	if err := git.Init(); err != nil {
+		return record, err
+	}
+	if err := git.EnsureClean(key, cfg.Repo.StashDirty); err != nil {
+		return record, err
+	}
+	current, err := git.CurrentBranch()
+	if err != nil {
+		return record, err
+	}
+	ref := "refs/remotes/origin/pr/" + num
+	if _, err := git.exec("fetch", "origin", pr.Base+":refs/remotes/origin/"+pr.Base, "+pull/"+num+"/head:"+ref); err != nil {
+		return record, fmt.Errorf("could not fetch the PR: %w", err)
+	}
+	if _, err := git.exec("checkout", "--detach", ref); err != nil {
+		return record, fmt.Errorf("could not check out the PR: %w", err)
+	}
+	defer git.exec("checkout", current)
+
+	diff, err := git.BranchDiff()
+	if err != nil {
+		return record, err
+	}
+	stat, _ := git.exec("diff", "--stat", "origin/"+pr.Base+"...HEAD")
+
+	fmt.Println("→ Running Claude Code (review only)...")
+	review, err := runClaudeReadOnly(cfg, git.Path(), reviewPrompt(pr, issue, string(diff), stat), readOnlyTools, cfg.Agent.timeout(issue))
+	if err != nil {
+		return record, fmt.Errorf("agent: %w", err)
+	}
+	if review == "" {
+		return record, fmt.Errorf("the agent returned no review")
+	}
+	body := fmt.Sprintf("**Automated review** against [%s](%s/browse/%s): %s\n\n%s\n\n"+
+		"_factory reviewed this change with read-only access; it did not build or run it._",
+		key, cfg.jiraSite(key).Jira.BaseURL, key, issue.Title, review)
+	if dryRun {
+		fmt.Printf("\n%s\n", body)
+		return record, nil
+	}
+	if err := postPRReview(cfg, pr.URL, key, body); err != nil {
+		return record, fmt.Errorf("could not post the review: %w", err)
+	}
+	fmt.Printf("✓ Reviewed %s\n", pr.URL)
+	return record, nil
+}
+
+// reviewPrompt asks the agent to review a PR against its Jira issue
+func reviewPrompt(pr listedPR, issue *Issue, diff, stat string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Review a pull request that %s opened for Jira issue %s. The repository is checked out at the PR's head.\n\n", pr.Author, issue.Key)
+	fmt.Fprintf(&b, "## Issue: %s\n%s\n", issue.Title, orDash(issue.Description))
+	if issue.AcceptanceCriteria != "" {
+		fmt.Fprintf(&b, "\n## Acceptance criteria\n%s\n", issue.AcceptanceCriteria)
+	}
+	fmt.Fprintf(&b, "\n## Pull request: %s\n%s\n", pr.Title, orDash(pr.Body))
+	fmt.Fprintf(&b, "\n## Files changed against %s\n%s\n", pr.Base, stat)
+
+	lines := strings.SplitAfter(diff, "\n")
+	if len(lines) > maxReviewDiffLines {
+		diff = strings.Join(lines[:maxReviewDiffLines], "") + "\n[diff truncated; read the changed files for the rest]\n"
+	}
+	fmt.Fprintf(&b, "\n## Diff\n```diff\n%s```\n", diff)
+
+	b.WriteString(`
+Do NOT modify any files. Read whatever code you need to judge the change,
+then respond with a review in this structure:
+
+### Summary
+What the change does, in two or three sentences.
+
+### Acceptance criteria
+Each criterion (or, with none listed, each thing the issue asks for), marked
+met, not met, or unclear, with where in the change it is handled.
+
+### Problems
+Bugs, missed cases, security issues, and missing tests, most serious first,
+each with the file and line. Write "None found" if there are none.
+
+### Suggestions
+Optional improvements; leave this section out if there are none.
+
+Be specific and brief, and don't praise.`)
+	return b.String()
+}
+
+// postPRReview posts a comment-only review on a PR
+func postPRReview(cfg *Config, prURL, issueKey, body string) error {
+	body = redact(body)
+	var err error
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		cmd := exec.Command("gh", "pr", "review", prURL, "--comment", "--body", body)
+		cmd.Dir = NewGit(cfg).repoPath
+		if out, cerr := cmd.CombinedOutput(); cerr != nil {
+			err = fmt.Errorf("gh pr review failed: %s", strings.TrimSpace(string(out)))
+		}
+	} else {
+		var num string
+		if num, err = prNumber(prURL); err == nil {
+			_, err = githubRequest(cfg, "POST", fmt.Sprintf("/repos/%s/%s/pulls/%s/reviews", cfg.GitHub.Owner, cfg.GitHub.Repo, num),
+				map[string]string{"body": body, "event": "COMMENT"})
+		}
+	}
+	recordAudit(actorOf(cfg), "github.pr.review", issueKey, prURL, "", err)
+	return err
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+type codeownersRule struct {
+	pattern string
+	owners  []string
+}
+
+func loadCodeowners(repoPath string) []codeownersRule {
+	for _, p := range codeownersPaths {
+		f, err := os.Open(filepath.Join(repoPath, p))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		var rules []codeownersRule
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			rules = append(rules, codeownersRule{pattern: fields[0], owners: fields[1:]})
+		}
+		return rules
+	}
+	return nil
+}
+
+// codeownersMatch implements the gitignore-style subset CODEOWNERS uses
+func codeownersMatch(pattern, file string) bool {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	if pattern == "*" {
+		return true
+	}
+
+	// Unanchored patterns without a slash match at any depth
+	if !anchored && !strings.Contains(strings.TrimSuffix(pattern, "/**"), "/") {
+		pattern = "**/" + pattern
+	}
+	return globMatch(strings.Split(pattern, "/"), strings.Split(file, "/"))
+}
+
+func globMatch(pat, parts []string) bool {
+	if len(pat) == 0 {
+		return len(parts) == 0
+	}
+	if pat[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if globMatch(pat[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pat[0], parts[0]); !ok {
+		return false
+	}
+	// A pattern naming a directory also owns everything below it, but a
+	// wildcard matches one path segment: "docs/*" owns docs/a.md, not
+	// docs/a/b.md
+	if len(pat) == 1 && len(parts) > 1 {
+		return !strings.ContainsAny(pat[0], "*?[")
+	}
+	return globMatch(pat[1:], parts[1:])
+}
+
+// codeownersFor returns the owners of the given files; the last matching
+// rule wins, as on GitHub
+func codeownersFor(repoPath string, files []string) []string {
+	rules := loadCodeowners(repoPath)
+	seen := map[string]bool{}
+	var owners []string
+	for _, file := range files {
+		for i := len(rules) - 1; i >= 0; i-- {
+			if !codeownersMatch(rules[i].pattern, file) {
+				continue
+			}
+			for _, o := range rules[i].owners {
+				if strings.HasPrefix(o, "@") && !seen[o] {
+					seen[o] = true
+					owners = append(owners, strings.TrimPrefix(o, "@"))
+				}
+			}
+			break
+		}
+	}
+	return owners
+}
+
+// prReviewers combines configured reviewers with CODEOWNERS of the changed
+// files. Teams are returned as "org/team".
+func prReviewers(cfg *Config, repoPath string, changed []string) []string {
+	seen := map[string]bool{}
+	var reviewers []string
+	add := func(r string) {
+		r = strings.TrimPrefix(r, "@")
+		if r != "" && !seen[strings.ToLower(r)] {
+			seen[strings.ToLower(r)] = true
+			reviewers = append(reviewers, r)
+		}
+	}
+	for _, r := range cfg.GitHub.Reviewers {
+		add(r)
+	}
+	if cfg.GitHub.UseCodeowners {
+		for _, r := range codeownersFor(repoPath, changed) {
+			add(r)
+		}
+	}
+	return reviewers
+}
+
+// prLabels returns the configured labels (default "automated") plus the
+// issue type
+func prLabels(cfg *Config, issue *Issue) []string {
+	labels := cfg.GitHub.Labels
+	if labels == nil {
+		labels = []string{"automated"}
+	}
+	if issue.Type != "" {
+		labels = append(append([]string(nil), labels...), strings.ToLower(issue.Type))
+	}
+	return labels
+}
+
+// AssignReviewersAndLabels requests reviews and applies labels to a new PR.
+// A draft PR is only labelled; its reviewers are asked once it is marked
+// ready (see MarkPRReady). Problems are logged; they never fail the run.
+func AssignReviewersAndLabels(cfg *Config, repoPath, prURL string, issue *Issue, changed []string) {
+	var reviewers []string
+	if !cfg.GitHub.DraftPR {
+		reviewers = prReviewers(cfg, repoPath, changed)
+	}
+	editPR(cfg, repoPath, prURL, issue.Key, reviewers, prLabels(cfg, issue))
+}
+
+// requestPRReviewers asks the reviewers of a PR's files for their review
+func requestPRReviewers(cfg *Config, issueKey, prURL string) {
+	files, err := prFiles(cfg, prURL)
+	if err != nil {
+		fmt.Printf("  Warning: could not list the files of %s: %v\n", prURL, err)
+		return
+	}
+	repoPath := NewGit(cfg).Path()
+	editPR(cfg, repoPath, prURL, issueKey, prReviewers(cfg, repoPath, files), nil)
+}
+
+// editPR requests reviewers and adds labels to a PR
+func editPR(cfg *Config, repoPath, prURL, issueKey string, reviewers, labels []string) {
+	if cfg.GitHub.UseGHCLI && CheckGHCLI() {
+		args := []string{"pr", "edit", prURL}
+		if len(reviewers) > 0 {
+			args = append(args, "--add-reviewer", strings.Join(reviewers, ","))
+		}
+		if len(labels) > 0 {
+			args = append(args, "--add-label", strings.Join(labels, ","))
+		}
+		if len(args) == 3 {
+			return
+		}
+		cmd := exec.Command("gh", args...)
+		cmd.Dir = repoPath
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("gh pr edit failed: %s", strings.TrimSpace(string(out)))
+			fmt.Printf("  Warning: %v\n", err)
+		}
+		auditReviewersAndLabels(cfg, issueKey, prURL, reviewers, labels, err, err)
+		return
+	}
+
+	num, err := prNumber(prURL)
+	if err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+		return
+	}
+	repo := fmt.Sprintf("/repos/%s/%s", cfg.GitHub.Owner, cfg.GitHub.Repo)
+
+	var reviewersErr, labelsErr error
+	if len(reviewers) > 0 {
+		var users, teams []string
+		for _, r := range reviewers {
+			if i := strings.Index(r, "/"); i >= 0 {
+				teams = append(teams, r[i+1:])
+			} else {
+				users = append(users, r)
+			}
+		}
+		_, reviewersErr = githubRequest(cfg, "POST", repo+"/pulls/"+num+"/requested_reviewers", map[string][]string{
+			"reviewers":      users,
+			"team_reviewers": teams,
+		})
+		if reviewersErr != nil {
+			fmt.Printf("  Warning: could not request reviewers: %v\n", reviewersErr)
+		}
+	}
+	if len(labels) > 0 {
+		if _, labelsErr = githubRequest(cfg, "POST", repo+"/issues/"+num+"/labels", map[string][]string{"labels": labels}); labelsErr != nil {
+			fmt.Printf("  Warning: could not add labels: %v\n", labelsErr)
+		}
+	}
+	auditReviewersAndLabels(cfg, issueKey, prURL, reviewers, labels, reviewersErr, labelsErr)
+}
+
+// auditReviewersAndLabels records the reviewers and labels put on a PR
+func auditReviewersAndLabels(cfg *Config, issueKey, prURL string, reviewers, labels []string, reviewersErr, labelsErr error) {
+	if len(reviewers) > 0 {
+		recordAudit(actorOf(cfg), "github.pr.reviewers", issueKey, prURL, strings.Join(reviewers, ", "), reviewersErr)
+	}
+	if len(labels) > 0 {
+		recordAudit(actorOf(cfg), "github.pr.labels", issueKey, prURL, strings.Join(labels, ", "), labelsErr)
+	}
+}
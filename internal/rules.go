@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// branchRule is one active rule from GitHub's rulesets for a branch
+type branchRule struct {
+	Type       string          `json:"type"`
+	Parameters json.RawMessage `json:"parameters"`
+	RulesetID  int             `json:"ruleset_id"`
+}
+
+// patternRule holds the parameters of the *_pattern rules
+type patternRule struct {
+	Name     string `json:"name"`
+	Negate   bool   `json:"negate"`
+	Operator string `json:"operator"`
+	Pattern  string `json:"pattern"`
+}
+
+// matches reports whether s satisfies the rule, negation included
+func (p patternRule) matches(s string) (bool, error) {
+	var ok bool
+	switch p.Operator {
+	case "starts_with":
+		ok = strings.HasPrefix(s, p.Pattern)
+	case "ends_with":
+		ok = strings.HasSuffix(s, p.Pattern)
+	case "contains":
+		ok = strings.Contains(s, p.Pattern)
+	case "regex":
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return true, err
+		}
+		ok = re.MatchString(s)
+	default:
+		return true, fmt.Errorf("unknown operator %q", p.Operator)
+	}
+	return ok != p.Negate, nil
+}
+
+func (p patternRule) describe() string {
+	verb := map[string]string{"starts_with": "start with", "ends_with": "end with", "contains": "contain", "regex": "match"}[p.Operator]
+	desc := fmt.Sprintf("must %s %q", verb, p.Pattern)
+	if p.Negate {
+		desc = fmt.Sprintf("must not %s %q", verb, p.Pattern)
+	}
+	if p.Name != "" {
+		desc += " (" + p.Name + ")"
+	}
+	return desc
+}
+
+// fetchBranchRules returns the rules GitHub enforces on pushes to branch
+func fetchBranchRules(cfg *Config, branch string) ([]branchRule, error) {
+	path := fmt.Sprintf("/repos/%s/%s/rules/branches/%s", cfg.GitHub.Owner, cfg.GitHub.Repo, branch)
+	body, err := githubGet(cfg, path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []branchRule
+	if err := json.Unmarshal(body, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// pushCandidate is what a push would send, as seen by repository rules
+type pushCandidate struct {
+	RepoPath string
+	Branch   string
+	Message  string
+	Email    string
+	Signed   bool
+	Files    []string
+}
+
+// checkBranchRules returns the reasons GitHub would reject the push, one
+// actionable line each
+func checkBranchRules(rules []branchRule, c pushCandidate) []string {
+	var problems []string
+	for _, r := range rules {
+		switch r.Type {
+		case "file_path_restriction":
+			var params struct {
+				Paths []string `json:"restricted_file_paths"`
+			}
+			json.Unmarshal(r.Parameters, &params)
+			for _, f := range c.Files {
+				for _, p := range params.Paths {
+					if globMatch(strings.Split(strings.TrimPrefix(p, "/"), "/"), strings.Split(f, "/")) {
+						problems = append(problems, fmt.Sprintf("%s: path is restricted by %q; revert the change or ask a maintainer", f, p))
+						break
+					}
+				}
+			}
+		case "file_extension_restriction":
+			var params struct {
+				Extensions []string `json:"restricted_file_extensions"`
+			}
+			json.Unmarshal(r.Parameters, &params)
+			for _, f := range c.Files {
+				for _, ext := range params.Extensions {
+					if strings.HasSuffix(strings.ToLower(f), strings.ToLower(strings.TrimPrefix(ext, "*"))) {
+						problems = append(problems, fmt.Sprintf("%s: %s files may not be pushed; add it to repo.artifactPatterns or remove the file", f, ext))
+						break
+					}
+				}
+			}
+		case "max_file_path_length":
+			var params struct {
+				Max int `json:"max_file_path_length"`
+			}
+			json.Unmarshal(r.Parameters, &params)
+			for _, f := range c.Files {
+				if params.Max > 0 && len(f) > params.Max {
+					problems = append(problems, fmt.Sprintf("%s: path is %d characters, the limit is %d", f, len(f), params.Max))
+				}
+			}
+		case "max_file_size":
+			var params struct {
+				MaxMB int `json:"max_file_size"`
+			}
+			json.Unmarshal(r.Parameters, &params)
+			if params.MaxMB <= 0 {
+				continue
+			}
+			for _, f := range c.Files {
+				info, err := os.Stat(filepath.Join(c.RepoPath, f))
+				if err != nil || info.IsDir() {
+					continue // deleted
+				}
+				if info.Size() > int64(params.MaxMB)<<20 {
+					problems = append(problems, fmt.Sprintf("%s: %.1f MB exceeds the %d MB file size limit", f, float64(info.Size())/(1<<20), params.MaxMB))
+				}
+			}
+		case "commit_message_pattern", "commit_author_email_pattern", "committer_email_pattern", "branch_name_pattern":
+			var p patternRule
+			json.Unmarshal(r.Parameters, &p)
+			subject, what, hint := c.Message, "commit message", "adjust templates.commit"
+			switch r.Type {
+			case "commit_author_email_pattern", "committer_email_pattern":
+				subject, what, hint = c.Email, "committer email "+c.Email, "set repo.signing.email"
+			case "branch_name_pattern":
+				subject, what, hint = c.Branch, "branch name "+c.Branch, "adjust repo.branchPattern"
+			}
+			ok, err := p.matches(subject)
+			if err != nil {
+				fmt.Printf("  Warning: skipping %s rule: %v\n", r.Type, err)
+				continue
+			}
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s %s; %s", what, p.describe(), hint))
+			}
+		case "required_signatures":
+			if !c.Signed {
+				problems = append(problems, "commits must be signed; configure repo.signing")
+			}
+		case "workflows":
+			fmt.Println("  Note: required workflows run after the push and can still block the PR")
+		}
+	}
+	return problems
+}
+
+// ValidateRules checks the commit factory is about to push against the
+// repository's rulesets, so a push GitHub would reject fails with the reasons
+// instead of an opaque remote error. Rules that can't be fetched (no access,
+// rulesets unavailable on the plan) are skipped with a warning.
+func ValidateRules(cfg *Config, g *Git, branch, message string, files []string) error {
+	rules, err := fetchBranchRules(cfg, branch)
+	if err != nil {
+		fmt.Printf("  Warning: could not fetch repository rules: %v\n", err)
+		return nil
+	}
+	_, email := g.identity()
+	problems := checkBranchRules(rules, pushCandidate{
+		RepoPath: g.Path(),
+		Branch:   branch,
+		Message:  message,
+		Email:    email,
+		Signed:   g.signing.Key != "",
+		Files:    files,
+	})
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("push would be rejected by repository rules:\n  - %s", strings.Join(problems, "\n  - "))
+}
@@ -0,0 +1,305 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScanConfig runs security scanners over the change before it is pushed.
+// Findings on the lines the run added, at or above MinSeverity, block the
+// PR and are reported on the issue and to notify.webhookUrl.
+type ScanConfig struct {
+	// Scanners to run: "gosec", "semgrep", and "gitleaks", each of which
+	// must be installed
+	Scanners []string `json:"scanners,omitempty"`
+	// MinSeverity blocks the PR: low, medium, high (default), or critical.
+	// gitleaks findings, leaked secrets, are critical.
+	MinSeverity string `json:"minSeverity,omitempty"`
+	// SemgrepConfig is semgrep's --config, default "auto"
+	SemgrepConfig string `json:"semgrepConfig,omitempty"`
+}
+
+// severities in increasing order
+var severities = []string{"low", "medium", "high", "critical"}
+
+func severityRank(s string) int {
+	for i, sev := range severities {
+		if sev == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c ScanConfig) minSeverity() string {
+	if c.MinSeverity == "" {
+		return "high"
+	}
+	return c.MinSeverity
+}
+
+func (c ScanConfig) semgrepConfig() string {
+	if c.SemgrepConfig == "" {
+		return "auto"
+	}
+	return c.SemgrepConfig
+}
+
+// scanFinding is one problem a scanner reported
+type scanFinding struct {
+	Scanner  string
+	Rule     string
+	Severity string
+	File     string
+	Line     int
+	Message  string
+}
+
+func (f scanFinding) String() string {
+	return fmt.Sprintf("%s:%d [%s] %s %s: %s", f.File, f.Line, f.Severity, f.Scanner, f.Rule, f.Message)
+}
+
+// scanners run one scanner over the repo, or the given files in it
+var scanners = map[string]func(cfg *Config, repoPath string, files []string) ([]scanFinding, error){
+	"gosec":    runGosec,
+	"semgrep":  runSemgrep,
+	"gitleaks": runGitleaks,
+}
+
+// scanChange runs the configured scanners over the changed files and fails
+// when any finding on an added line is at or above scan.minSeverity; the
+// findings are then posted on the issue and sent as an alert
+func scanChange(cfg *Config, git *Git, issue *Issue, changed []string, result *Result) error {
+	fmt.Println("→ Scanning the change...")
+	result.enterStage("scan")
+	var files []string
+	for _, f := range changed {
+		if _, err := os.Stat(filepath.Join(git.Path(), f)); err == nil {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	lines, err := addedLines(git, files)
+	if err != nil {
+		return err
+	}
+
+	threshold := severityRank(cfg.Scan.minSeverity())
+	var blocking []scanFinding
+	for _, name := range cfg.Scan.Scanners {
+		fmt.Printf("  Running %s\n", name)
+		findings, err := scanners[name](cfg, git.Path(), files)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, f := range findings {
+			f.File = relativePath(git.Path(), f.File)
+			if added, ok := lines[f.File]; !ok || added != nil && !added[f.Line] {
+				continue
+			}
+			if severityRank(f.Severity) < threshold {
+				fmt.Printf("  Below %s: %s\n", cfg.Scan.minSeverity(), f)
+				continue
+			}
+			blocking = append(blocking, f)
+		}
+	}
+	if len(blocking) == 0 {
+		fmt.Println("  No findings")
+		return nil
+	}
+
+	sort.Slice(blocking, func(i, j int) bool {
+		return severityRank(blocking[i].Severity) > severityRank(blocking[j].Severity)
+	})
+	var list strings.Builder
+	for _, f := range blocking {
+		fmt.Fprintf(&list, "- %s\n", f)
+	}
+	summary := fmt.Sprintf("%d security finding(s) at or above %s severity", len(blocking), cfg.Scan.minSeverity())
+	comment := fmt.Sprintf("factory: the security scan blocked the PR with %s:\n\n%s", summary, list.String())
+	if err := AddComment(cfg, issue.Key, comment); err != nil {
+		fmt.Printf("  Warning: could not report the findings on %s: %v\n", issue.Key, err)
+	}
+	notify(cfg, fmt.Sprintf("%s: no PR opened; %s", issue.Key, summary))
+	return fmt.Errorf("%s:\n%s", summary, strings.TrimRight(list.String(), "\n"))
+}
+
+// hunkHeader is a unified diff hunk's header; its second range is the
+// lines it adds
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+
+// addedLines maps each of files to the lines the uncommitted change added
+// to it, nil for a file that is new as a whole
+func addedLines(git *Git, files []string) (map[string]map[int]bool, error) {
+	lines := make(map[string]map[int]bool)
+	for _, f := range files {
+		lines[f] = nil
+	}
+	cmd := exec.Command("git", append([]string{"diff", "-U0", "--no-color", "--no-ext-diff", "HEAD", "--"}, files...)...)
+	cmd.Dir = git.Path()
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	var file string
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, ok := strings.CutPrefix(line, "+++ b/"); ok {
+			file = name
+			lines[file] = map[int]bool{}
+			continue
+		}
+		m := hunkHeader.FindStringSubmatch(line)
+		if m == nil || file == "" {
+			continue
+		}
+		start, _ := strconv.Atoi(m[1])
+		count := 1
+		if m[2] != "" {
+			count, _ = strconv.Atoi(m[2])
+		}
+		for i := start; i < start+count; i++ {
+			lines[file][i] = true
+		}
+	}
+	return lines, nil
+}
+
+// relativePath is path relative to the repo, as git shows it
+func relativePath(repoPath, path string) string {
+	if filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(repoPath, path); err == nil {
+			path = rel
+		}
+	}
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+}
+
+// runScanner runs a scanner in repoPath and returns what it wrote to
+// stdout, with stderr in the error when it fails
+func runScanner(repoPath, name string, args ...string) ([]byte, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, fmt.Errorf("%s is not installed", name)
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = repoPath
+	out, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return nil, fmt.Errorf("%v: %s", err, lastLines(string(exit.Stderr), 5))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+func runGosec(cfg *Config, repoPath string, files []string) ([]scanFinding, error) {
+	out, err := runScanner(repoPath, "gosec", "-fmt=json", "-no-fail", "./...")
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Issues []struct {
+			Severity string `json:"severity"`
+			RuleID   string `json:"rule_id"`
+			Details  string `json:"details"`
+			File     string `json:"file"`
+			Line     string `json:"line"` // "12" or "12-14"
+		} `json:"Issues"`
+	}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &report); err != nil {
+			return nil, fmt.Errorf("reading its report: %w", err)
+		}
+	}
+	var findings []scanFinding
+	for _, i := range report.Issues {
+		line, _ := strconv.Atoi(strings.SplitN(i.Line, "-", 2)[0])
+		findings = append(findings, scanFinding{Scanner: "gosec", Rule: i.RuleID, Severity: strings.ToLower(i.Severity),
+			File: i.File, Line: line, Message: i.Details})
+	}
+	return findings, nil
+}
+
+// semgrepSeverities maps semgrep's severities onto ours
+var semgrepSeverities = map[string]string{"error": "high", "warning": "medium", "info": "low"}
+
+func runSemgrep(cfg *Config, repoPath string, files []string) ([]scanFinding, error) {
+	args := append([]string{"scan", "--config", cfg.Scan.semgrepConfig(), "--json", "--quiet", "--metrics=off", "--"}, files...)
+	out, err := runScanner(repoPath, "semgrep", args...)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Results []struct {
+			CheckID string `json:"check_id"`
+			Path    string `json:"path"`
+			Start   struct {
+				Line int `json:"line"`
+			} `json:"start"`
+			Extra struct {
+				Severity string `json:"severity"`
+				Message  string `json:"message"`
+			} `json:"extra"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("reading its report: %w", err)
+	}
+	var findings []scanFinding
+	for _, r := range report.Results {
+		sev := strings.ToLower(r.Extra.Severity)
+		if mapped, ok := semgrepSeverities[sev]; ok {
+			sev = mapped
+		}
+		findings = append(findings, scanFinding{Scanner: "semgrep", Rule: r.CheckID, Severity: sev,
+			File: r.Path, Line: r.Start.Line, Message: strings.TrimSpace(r.Extra.Message)})
+	}
+	return findings, nil
+}
+
+func runGitleaks(cfg *Config, repoPath string, files []string) ([]scanFinding, error) {
+	report, err := os.CreateTemp("", "factory-gitleaks-*.json")
+	if err != nil {
+		return nil, err
+	}
+	report.Close()
+	defer os.Remove(report.Name())
+	if _, err := runScanner(repoPath, "gitleaks", "detect", "--no-git", "--no-banner", "--source", ".",
+		"--report-format", "json", "--report-path", report.Name(), "--exit-code", "0"); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(report.Name())
+	if err != nil {
+		return nil, err
+	}
+	// The secret itself (Match, Secret) is left out of the finding
+	var leaks []struct {
+		RuleID      string `json:"RuleID"`
+		Description string `json:"Description"`
+		File        string `json:"File"`
+		StartLine   int    `json:"StartLine"`
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &leaks); err != nil {
+			return nil, fmt.Errorf("reading its report: %w", err)
+		}
+	}
+	var findings []scanFinding
+	for _, l := range leaks {
+		findings = append(findings, scanFinding{Scanner: "gitleaks", Rule: l.RuleID, Severity: "critical",
+			File: l.File, Line: l.StartLine, Message: l.Description})
+	}
+	return findings, nil
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// weekdays maps the names poll.activeDays accepts to days
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// schedule is when the daemon may start runs, from poll.activeHours,
+// poll.activeDays, and poll.timezone
+type schedule struct {
+	days       [7]bool
+	start, end int // minutes since midnight; end before start wraps past midnight
+	loc        *time.Location
+}
+
+// schedule parses the poll config's active hours and days, returning nil
+// when the daemon is always active
+func (p PollConfig) schedule() (*schedule, error) {
+	if p.ActiveHours == "" && len(p.ActiveDays) == 0 {
+		return nil, nil
+	}
+	s := &schedule{end: 24 * 60, loc: time.Local}
+	if p.Timezone != "" {
+		loc, err := time.LoadLocation(p.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("poll.timezone: %w", err)
+		}
+		s.loc = loc
+	}
+	if p.ActiveHours != "" {
+		from, to, ok := strings.Cut(p.ActiveHours, "-")
+		var err error
+		if ok {
+			if s.start, err = clockMinutes(from); err == nil {
+				s.end, err = clockMinutes(to)
+			}
+		}
+		if !ok || err != nil || s.start == s.end {
+			return nil, fmt.Errorf("poll.activeHours must look like \"09:00-18:00\", got %q", p.ActiveHours)
+		}
+	}
+	if len(p.ActiveDays) == 0 {
+		s.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, d := range p.ActiveDays {
+		from, to, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(d)), "-")
+		if !isRange {
+			to = from
+		}
+		first, ok1 := weekdays[shortDay(from)]
+		last, ok2 := weekdays[shortDay(to)]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("poll.activeDays: %q is not a day or a range like \"mon-fri\"", d)
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			s.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return s, nil
+}
+
+// clockMinutes parses "HH:MM" into minutes since midnight; "24:00" is the
+// end of the day
+func clockMinutes(s string) (int, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil || n != 2 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// shortDay accepts full day names as well as "mon"
+func shortDay(name string) string {
+	if len(name) > 3 {
+		return name[:3]
+	}
+	return name
+}
+
+// active reports whether t falls in the schedule. A window that wraps past
+// midnight belongs to the day it starts on, so "22:00-06:00" on "fri" runs
+// into Saturday morning.
+func (s *schedule) active(t time.Time) bool {
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	if s.start < s.end {
+		return s.days[t.Weekday()] && minute >= s.start && minute < s.end
+	}
+	if minute >= s.start {
+		return s.days[t.Weekday()]
+	}
+	return minute < s.end && s.days[(t.Weekday()+6)%7]
+}
+
+// next returns when the schedule next opens after t, which it is outside of
+func (s *schedule) next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+	for d := 0; d <= 7; d++ {
+		day := midnight.AddDate(0, 0, d)
+		open := day.Add(time.Duration(s.start) * time.Minute)
+		if s.days[day.Weekday()] && open.After(t) {
+			return open
+		}
+	}
+	return t
+}
+
+// outsideHours returns when the daemon may start runs again, or the zero
+// time when it may start them now
+func outsideHours(cfg *Config) time.Time {
+	s, err := cfg.Poll.schedule()
+	if err != nil || s == nil {
+		return time.Time{}
+	}
+	now := time.Now()
+	if s.active(now) {
+		return time.Time{}
+	}
+	return s.next(now)
+}
+
+// formatResume shows when runs start again, "" when they aren't waiting
+func formatResume(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("Mon 15:04")
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// componentPaths returns the repo subtrees mapped to the issue's components
+// by repo.componentPaths, or nil when the issue isn't scoped
+func componentPaths(cfg *Config, issue *Issue) []string {
+	var paths []string
+	for _, c := range issue.Components {
+		for component, p := range cfg.Repo.ComponentPaths {
+			if strings.EqualFold(component, c) {
+				paths = append(paths, strings.Trim(path.Clean(p), "/"))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// formatPathScope is the prompt section limiting changes to the scoped paths
+func formatPathScope(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	return "\n## Scope\nOnly change files under: " + strings.Join(paths, ", ") +
+		". Changes anywhere else will be rejected.\n"
+}
+
+// outOfScope returns the changed files outside every scoped path
+func outOfScope(changed, paths []string) []string {
+	if len(paths) == 0 {
+		return nil
+	}
+	var outside []string
+	for _, file := range changed {
+		inside := false
+		for _, p := range paths {
+			if file == p || strings.HasPrefix(file, p+"/") {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			outside = append(outside, file)
+		}
+	}
+	return outside
+}
+
+// SparseCheckout limits the working tree to paths (plus top-level files), or
+// restores the full tree when paths is empty
+func (g *Git) SparseCheckout(paths []string) error {
+	if len(paths) == 0 {
+		if enabled, _ := g.exec("config", "--get", "core.sparseCheckout"); enabled != "true" {
+			return nil
+		}
+		_, err := g.exec("sparse-checkout", "disable")
+		return err
+	}
+	_, err := g.exec(append([]string{"sparse-checkout", "set", "--cone"}, paths...)...)
+	return err
+}
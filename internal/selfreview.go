@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SelfReviewConfig has a second, cheaper agent pass review the change
+// against the issue before it is committed: it fixes what it can, and its
+// remaining concerns go in the PR body
+type SelfReviewConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Model reviews the change, default "haiku"
+	Model string `json:"model,omitempty"`
+}
+
+func (c SelfReviewConfig) model() string {
+	if c.Model == "" {
+		return "haiku"
+	}
+	return c.Model
+}
+
+const (
+	// noConcerns is the self-review's whole response when it has no concerns
+	noConcerns = "NONE"
+	// selfReviewTools leave out Bash, so the review can edit the change but
+	// not commit, push, or discard it
+	selfReviewTools = "Read,Glob,Grep,Edit,Write"
+)
+
+// selfReviewPrompt asks the agent to review the uncommitted change
+func selfReviewPrompt(issue *Issue, changed []string, diff string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Another agent implemented Jira issue %s in this repository. Review its change before it is committed.\n\n", issue.Key)
+	fmt.Fprintf(&b, "## Issue: %s\n%s\n", issue.Title, orDash(issue.Description))
+	if issue.AcceptanceCriteria != "" {
+		fmt.Fprintf(&b, "\n## Acceptance criteria\n%s\n", issue.AcceptanceCriteria)
+	}
+	b.WriteString("\n## Changed files\nThe change is uncommitted; read these files, new ones included, for all of it:\n\n")
+	for _, f := range changed {
+		fmt.Fprintf(&b, "- %s\n", f)
+	}
+	if diff != "" {
+		fmt.Fprintf(&b, "\n## Diff\n```diff\n%s\n```\n", diff)
+	}
+	b.WriteString(`
+Check the change against the issue and each acceptance criterion, and look
+for obvious misses: unhandled cases, leftover debug code or TODOs, broken
+callers, and missing tests. Fix clear, small problems directly in the
+files. Don't rewrite working code, reformat, or change anything unrelated.
+
+Finish with only the concerns you did not fix, for the human reviewer, as
+a short markdown list. If there are none, respond with exactly ` + noConcerns + ".")
+	return b.String()
+}
+
+// selfReview runs the self-review pass over the changed files. Its fixes
+// are kept only if the verify checks still pass with them. It returns the
+// concerns left for the PR body, and a stage along with an error that
+// fails the run ("cancelled" for a cancelled run); the review failing
+// otherwise only warns.
+func selfReview(cfg *Config, git *Git, issue *Issue, changed []string, result *Result, lease *leaseHandle, transcript io.Writer) (string, string, error) {
+	fmt.Printf("→ Self-reviewing the change (%s)...\n", cfg.SelfReview.model())
+	result.enterStage("self-review")
+	progress(cfg, issue.Key, "self-reviewing the change")
+
+	// Kept to undo fixes that break the checks; nil data for a deleted file
+	saved := make(map[string]savedFile)
+	for _, f := range changed {
+		path := filepath.Join(git.Path(), f)
+		var s savedFile
+		if info, err := os.Stat(path); err == nil {
+			s.mode = info.Mode().Perm()
+			s.data, _ = os.ReadFile(path)
+		}
+		saved[f] = s
+	}
+	before, err := git.Snapshot()
+	if err != nil {
+		return "", "git", err
+	}
+
+	prompt := selfReviewPrompt(issue, changed, uncommittedDiff(git, changed))
+	final, err := runAgentStep(cfg, git, agentRun{prompt: prompt, model: cfg.SelfReview.model(), tools: selfReviewTools}, issue, result, lease, transcript)
+	if stage := stepStopped(err); stage != "" {
+		return "", stage, err
+	}
+
+	fixed, cerr := git.ChangedSince(before)
+	if cerr != nil {
+		return "", "git", cerr
+	}
+	concerns := strings.TrimSpace(final.Result)
+	if err != nil {
+		fmt.Printf("  Warning: self-review failed: %v\n", err)
+		concerns = ""
+	}
+	if strings.TrimRight(concerns, ".") == noConcerns {
+		concerns = ""
+	}
+	if len(fixed) == 0 {
+		return concerns, "", nil
+	}
+
+	fmt.Printf("  Self-review changed %s\n", strings.Join(fixed, ", "))
+	checks := verifyChecks(cfg, git.Path())
+	if err == nil && len(checks) > 0 {
+		failed, _, cerr := runChecks(checks, git.Path())
+		if failed == nil {
+			fmt.Println("  Checks passed")
+			return concerns, "", nil
+		}
+		err = fmt.Errorf("%s fails with them (%v)", failed.Command, cerr)
+	}
+	if err == nil {
+		return concerns, "", nil
+	}
+
+	fmt.Printf("  Undoing the self-review's changes: %v\n", err)
+	for _, f := range fixed {
+		s, ok := saved[f]
+		path := filepath.Join(git.Path(), f)
+		switch {
+		case !ok:
+			err = git.Discard([]string{f})
+		case s.data == nil:
+			err = os.RemoveAll(path)
+		default:
+			err = os.WriteFile(path, s.data, s.mode)
+			if err == nil {
+				// WriteFile keeps the mode of a file that still exists
+				err = os.Chmod(path, s.mode)
+			}
+		}
+		if err != nil {
+			return "", "git", err
+		}
+	}
+	return concerns, "", nil
+}
+
+// savedFile is a changed file as it was before the self-review
+type savedFile struct {
+	data []byte
+	mode os.FileMode
+}
+
+// uncommittedDiff is the uncommitted change to the tracked files among
+// files, cut at maxReviewDiffLines; "" when git fails
+func uncommittedDiff(git *Git, files []string) string {
+	cmd := exec.Command("git", append([]string{"diff", "--no-color", "--no-ext-diff", "HEAD", "--"}, files...)...)
+	cmd.Dir = git.Path()
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) > maxReviewDiffLines {
+		lines = append(lines[:maxReviewDiffLines], "... (diff cut; read the files for the rest)")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatSelfReview is the PR body section on the self-review's remaining
+// concerns, "" for none
+func formatSelfReview(concerns string) string {
+	if concerns == "" {
+		return ""
+	}
+	return "\n## Self-Review Concerns\n" + redact(concerns) + "\n"
+}
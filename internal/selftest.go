@@ -0,0 +1,618 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario is one end-to-end run of the pipeline against the fake Jira and
+// GitHub servers, a temporary git remote, and a scripted agent
+type Scenario struct {
+	Name  string        `json:"name"`
+	Issue scenarioIssue `json:"issue"`
+	// Config is merged over the selftest config, e.g.
+	// verify: {testCommand: make test}
+	Config json.RawMessage `json:"config,omitempty"`
+	// RemoteBranches are pushed to the remote before the run, each with an
+	// unrelated commit, to set up branch name conflicts
+	RemoteBranches []string `json:"remoteBranches,omitempty"`
+	// Agent scripts each agent run in turn; the last step repeats
+	Agent []agentStep `json:"agent"`
+	// Retry runs `factory retry --local` after the first run, which must
+	// fail; Expect is then checked against the retry
+	Retry bool `json:"retry,omitempty"`
+	// Update runs `factory trigger --update --local` after the first run,
+	// which must open a PR; Expect is then checked against the update
+	Update bool                `json:"update,omitempty"`
+	Expect scenarioExpectation `json:"expect"`
+}
+
+// scenarioIssue is the Jira issue a scenario processes
+type scenarioIssue struct {
+	Key         string   `json:"key"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Type        string   `json:"type,omitempty"`     // default Task
+	Priority    string   `json:"priority,omitempty"` // default Medium
+	Status      string   `json:"status,omitempty"`   // default To Do
+	Labels      []string `json:"labels,omitempty"`
+	Components  []string `json:"components,omitempty"`
+	FixVersions []string `json:"fixVersions,omitempty"`
+}
+
+// agentStep is what the fake agent does in one run
+type agentStep struct {
+	Files        map[string]string `json:"files,omitempty"` // written into the workspace
+	SleepSeconds float64           `json:"sleepSeconds,omitempty"`
+	Exit         int               `json:"exit,omitempty"`
+}
+
+// scenarioExpectation is checked after the run
+type scenarioExpectation struct {
+	Status     string   `json:"status"`              // completed or failed
+	Stage      string   `json:"stage,omitempty"`     // stage a failed run stopped at
+	AgentRuns  int      `json:"agentRuns,omitempty"` // exact number of agent runs
+	PRs        int      `json:"prs"`                 // exact number of PRs opened
+	Branches   []string `json:"branches,omitempty"`  // must exist on the remote
+	Files      []string `json:"files,omitempty"`     // exactly the files the PRs change
+	Base       string   `json:"base,omitempty"`      // branch every PR targets, default main
+	Comments   []string `json:"comments,omitempty"`  // each must appear in a Jira comment
+	JiraStatus string   `json:"jiraStatus,omitempty"`
+	// Resumed is the session the agent was last resumed with; the fake
+	// agent reports session "selftest-N" on its Nth run
+	Resumed string `json:"resumed,omitempty"`
+}
+
+// builtinScenarios cover the pipeline's main paths
+var builtinScenarios = []Scenario{
+	{
+		Name:  "happy-path",
+		Issue: scenarioIssue{Key: "SELF-1", Title: "Fix greeting typo", Type: "Bug", Description: "The greeting says \"Helo\"."},
+		Agent: []agentStep{{Files: map[string]string{"greeting.txt": "Hello\n"}}},
+		Expect: scenarioExpectation{
+			Status:     "completed",
+			AgentRuns:  1,
+			PRs:        1,
+			Branches:   []string{"feature/SELF-1-fix-greeting-typo"},
+			Files:      []string{"greeting.txt"},
+			Comments:   []string{"PR raised: "},
+			JiraStatus: "In Review",
+		},
+	},
+	{
+		Name:   "ci-failure-loop",
+		Issue:  scenarioIssue{Key: "SELF-2", Title: "Add feature flag", Type: "Story"},
+		Config: json.RawMessage(`{"verify": {"testCommand": "test -f tests-pass", "maxAttempts": 3}}`),
+		Agent: []agentStep{
+			{Files: map[string]string{"flag.txt": "on\n"}},
+			{Files: map[string]string{"tests-pass": ""}},
+		},
+		Expect: scenarioExpectation{
+			Status:    "completed",
+			AgentRuns: 2,
+			PRs:       1,
+			Files:     []string{"flag.txt", "tests-pass"},
+			Comments:  []string{"test failed, retrying (attempt 2 of 3)"},
+		},
+	},
+	{
+		Name:   "ci-failure-exhausted",
+		Issue:  scenarioIssue{Key: "SELF-3", Title: "Break the build", Type: "Task"},
+		Config: json.RawMessage(`{"verify": {"testCommand": "false", "maxAttempts": 2}}`),
+		Agent:  []agentStep{{Files: map[string]string{"broken.txt": "x\n"}}},
+		Expect: scenarioExpectation{
+			Status:     "failed",
+			Stage:      "verify",
+			AgentRuns:  2,
+			Comments:   []string{"failed at verify"},
+			JiraStatus: "To Do",
+		},
+	},
+	{
+		Name:           "branch-conflict",
+		Issue:          scenarioIssue{Key: "SELF-4", Title: "Add changelog", Type: "Task"},
+		RemoteBranches: []string{"feature/SELF-4-add-changelog"},
+		Agent:          []agentStep{{Files: map[string]string{"CHANGELOG.md": "# Changelog\n"}}},
+		Expect: scenarioExpectation{
+			Status:   "completed",
+			PRs:      1,
+			Branches: []string{"feature/SELF-4-add-changelog", "feature/SELF-4-add-changelog-2"},
+			Files:    []string{"CHANGELOG.md"},
+		},
+	},
+	{
+		Name:   "agent-timeout",
+		Issue:  scenarioIssue{Key: "SELF-5", Title: "Rewrite everything", Type: "Story"},
+		Config: json.RawMessage(`{"agent": {"timeoutMinutes": 0.02}}`),
+		Agent:  []agentStep{{SleepSeconds: 60}},
+		Expect: scenarioExpectation{
+			Status:   "failed",
+			Stage:    "claude",
+			Comments: []string{"timeout after 1.2s"},
+		},
+	},
+	{
+		Name:   "timeout-resume",
+		Issue:  scenarioIssue{Key: "SELF-7", Title: "Migrate the schema", Type: "Story"},
+		Config: json.RawMessage(`{"agent": {"timeoutMinutes": 0.02, "resume": true}}`),
+		Agent: []agentStep{
+			{Files: map[string]string{"schema.sql": "-- partial\n"}, SleepSeconds: 60},
+			{Files: map[string]string{"schema.sql": "-- done\n"}},
+		},
+		Expect: scenarioExpectation{
+			Status:    "completed",
+			AgentRuns: 2,
+			PRs:       1,
+			Files:     []string{"schema.sql"},
+			Resumed:   "selftest-1",
+		},
+	},
+	{
+		Name:   "retry-from-verify",
+		Issue:  scenarioIssue{Key: "SELF-8", Title: "Add rate limiting", Type: "Story"},
+		Config: json.RawMessage(`{"verify": {"testCommand": "test -f tests-pass", "maxAttempts": 1}}`),
+		Agent: []agentStep{
+			{Files: map[string]string{"limit.txt": "10/s\n"}},
+			{Files: map[string]string{"tests-pass": ""}},
+		},
+		Retry: true,
+		Expect: scenarioExpectation{
+			Status:    "completed",
+			AgentRuns: 2,
+			PRs:       1,
+			// The first run's change is kept, not redone
+			Files: []string{"limit.txt", "tests-pass"},
+		},
+	},
+	{
+		Name:  "no-changes",
+		Issue: scenarioIssue{Key: "SELF-6", Title: "Already fixed", Type: "Bug"},
+		Agent: []agentStep{{}},
+		Expect: scenarioExpectation{
+			Status:   "completed",
+			PRs:      0,
+			Comments: []string{"finished without changes"},
+		},
+	},
+	{
+		Name:           "release-branch",
+		Issue:          scenarioIssue{Key: "SELF-9", Title: "Patch the release", Type: "Bug", FixVersions: []string{"2.4.1"}},
+		Config:         json.RawMessage(`{"repo": {"baseBranches": {"2.4.*": "release/2.4"}}}`),
+		RemoteBranches: []string{"release/2.4"},
+		Agent:          []agentStep{{Files: map[string]string{"hotfix.txt": "patched\n"}}},
+		Expect: scenarioExpectation{
+			Status: "completed",
+			PRs:    1,
+			Base:   "release/2.4",
+			Files:  []string{"hotfix.txt"},
+		},
+	},
+	{
+		Name:  "update-pr",
+		Issue: scenarioIssue{Key: "SELF-10", Title: "Add retry header", Type: "Story"},
+		Agent: []agentStep{
+			{Files: map[string]string{"retry.txt": "Retry-After\n"}},
+			{Files: map[string]string{"retry.txt": "Retry-After: 30\n"}},
+		},
+		Update: true,
+		Expect: scenarioExpectation{
+			Status:    "completed",
+			AgentRuns: 2,
+			PRs:       1,
+			Files:     []string{"retry.txt"},
+			Comments:  []string{"pushed follow-up changes to"},
+		},
+	},
+}
+
+// SelftestOptions selects the scenarios `factory selftest` runs
+type SelftestOptions struct {
+	Dir  string // load *.yaml scenarios from here instead of the built-in ones
+	Run  string // only scenarios whose name contains this
+	Keep bool   // keep passing scenarios' temporary directories too
+}
+
+// Selftest runs end-to-end scenarios through ProcessIssue with everything
+// external faked, and reports which expectations failed. Each scenario runs
+// with its own HOME, so the real ~/.factory is never touched.
+func Selftest(opts SelftestOptions) error {
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("selftest needs a POSIX shell for its scripted agent")
+	}
+	scenarios := builtinScenarios
+	if opts.Dir != "" {
+		var err error
+		if scenarios, err = loadScenarios(opts.Dir); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	ran := 0
+	for _, s := range scenarios {
+		if opts.Run != "" && !strings.Contains(s.Name, opts.Run) {
+			continue
+		}
+		ran++
+		problems, dir, err := runScenario(s)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		if len(problems) == 0 {
+			fmt.Printf("✓ %s\n", s.Name)
+			if !opts.Keep {
+				os.RemoveAll(dir)
+			}
+			continue
+		}
+		failed++
+		fmt.Printf("✗ %s\n", s.Name)
+		for _, p := range problems {
+			fmt.Printf("    %s\n", p)
+		}
+		fmt.Printf("    log: %s\n", filepath.Join(dir, "run.log"))
+	}
+	if ran == 0 {
+		return fmt.Errorf("no scenarios match %q", opts.Run)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d scenario(s) failed", failed, ran)
+	}
+	fmt.Printf("\nAll %d scenario(s) passed\n", ran)
+	return nil
+}
+
+// loadScenarios reads every *.yaml or *.yml file in dir (or *.json, which
+// YAML includes); a file holds one scenario or a list of them
+func loadScenarios(dir string) ([]Scenario, error) {
+	var paths []string
+	for _, ext := range []string{"*.yaml", "*.yml", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, ext))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.yaml scenarios in %s", dir)
+	}
+	sort.Strings(paths)
+	var scenarios []Scenario
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		list, err := parseScenarios(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, s := range list {
+			if s.Name == "" || s.Issue.Key == "" || s.Expect.Status == "" {
+				return nil, fmt.Errorf("%s: scenarios need a name, issue.key, and expect.status", path)
+			}
+		}
+		scenarios = append(scenarios, list...)
+	}
+	return scenarios, nil
+}
+
+// parseScenarios reads a YAML document holding one scenario or a list of
+// them. The YAML goes through JSON, so scenarios share the config's field
+// names and their config section merges like config.json.
+func parseScenarios(data []byte) ([]Scenario, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	asJSON, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := doc.([]interface{}); ok {
+		var list []Scenario
+		err := json.Unmarshal(asJSON, &list)
+		return list, err
+	}
+	var one Scenario
+	err = json.Unmarshal(asJSON, &one)
+	return []Scenario{one}, err
+}
+
+// selftestConfig points factory at the fakes and the temporary remote
+func selftestConfig(dir, remote string, jira *fakeJira, github *fakeGitHub) *Config {
+	return &Config{
+		Jira:   JiraConfig{BaseURL: jira.URL, Email: "selftest@example.com", APIToken: "selftest"},
+		GitHub: GitHubConfig{Token: "selftest", Owner: "selftest", Repo: "app", APIURL: github.URL},
+		Repo: RepoConfig{
+			CloneURL:      remote,
+			LocalPath:     filepath.Join(dir, "workspace"),
+			DefaultBranch: "main",
+		},
+		Poll:        PollConfig{IntervalMinutes: 1, AutoTransition: true, ProgressComments: true},
+		Transitions: TransitionMapping{OnPRCreated: "In Review", OnFailure: "To Do"},
+	}
+}
+
+// runScenario runs one scenario in a fresh temporary directory and returns
+// the unmet expectations and the directory, which holds the run's log
+func runScenario(s Scenario) ([]string, string, error) {
+	dir, err := os.MkdirTemp("", "factory-selftest-")
+	if err != nil {
+		return nil, "", err
+	}
+	logPath := filepath.Join(dir, "run.log")
+
+	remote := filepath.Join(dir, "remote.git")
+	if err := seedRemote(dir, remote, s.RemoteBranches); err != nil {
+		return nil, dir, fmt.Errorf("setting up the remote: %w", err)
+	}
+	agentDir := filepath.Join(dir, "agent")
+	if err := writeFakeAgent(agentDir, s.Agent); err != nil {
+		return nil, dir, fmt.Errorf("setting up the agent: %w", err)
+	}
+
+	issue := s.Issue
+	if issue.Type == "" {
+		issue.Type = "Task"
+	}
+	if issue.Priority == "" {
+		issue.Priority = "Medium"
+	}
+	if issue.Status == "" {
+		issue.Status = "To Do"
+	}
+	jira := newFakeJira(issue)
+	defer jira.Close()
+	github := newFakeGitHub()
+	defer github.Close()
+
+	c := selftestConfig(dir, remote, jira, github)
+	if len(s.Config) > 0 {
+		if err := json.Unmarshal(s.Config, c); err != nil {
+			return nil, dir, fmt.Errorf("config: %w", err)
+		}
+	}
+
+	// Isolate ~/.factory, the agent on PATH, and the run's output
+	restore, err := isolateSelftest(filepath.Join(dir, "home"), agentDir, logPath)
+	if err != nil {
+		return nil, dir, err
+	}
+	result := ProcessIssue(c, issue.Key, RunOptions{})
+	if s.Retry {
+		RecordResult(result)
+		if result.Status != "failed" {
+			restore()
+			return []string{fmt.Sprintf("first run: got status %q, want failed before retrying", result.Status)}, dir, nil
+		}
+		if result, err = RetryIssue(c, issue.Key, true); err != nil {
+			restore()
+			return nil, dir, err
+		}
+	}
+	if s.Update {
+		RecordResult(result)
+		if result.Status != "completed" || result.PRUrl == "" {
+			restore()
+			return []string{fmt.Sprintf("first run: got status %q, want a PR to update", result.Status)}, dir, nil
+		}
+		result = ProcessIssue(c, issue.Key, RunOptions{Update: true})
+	}
+	restore()
+
+	runs, _ := os.ReadFile(filepath.Join(agentDir, "runs"))
+	resumed, _ := os.ReadFile(filepath.Join(agentDir, "resumed"))
+	problems := checkScenario(s.Expect, result, jira, github, remote, strings.TrimSpace(string(runs)))
+	if s.Expect.Resumed != "" && strings.TrimSpace(string(resumed)) != s.Expect.Resumed {
+		problems = append(problems, fmt.Sprintf("resumed session: got %q, want %q", strings.TrimSpace(string(resumed)), s.Expect.Resumed))
+	}
+	return problems, dir, nil
+}
+
+// isolateSelftest points HOME and PATH at the scenario's directories and
+// sends stdout to the log, returning a func that undoes it
+func isolateSelftest(home, agentDir, logPath string) (func(), error) {
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return nil, err
+	}
+	log, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	oldHome, oldPath, oldStdout := os.Getenv("HOME"), os.Getenv("PATH"), os.Stdout
+	os.Setenv("HOME", home)
+	os.Setenv("PATH", agentDir+string(os.PathListSeparator)+oldPath)
+	os.Stdout = log
+	return func() {
+		os.Stdout = oldStdout
+		os.Setenv("HOME", oldHome)
+		os.Setenv("PATH", oldPath)
+		log.Close()
+	}, nil
+}
+
+// seedRemote creates a bare remote with one commit on main, plus the given
+// branches, each with an unrelated commit
+func seedRemote(dir, remote string, branches []string) error {
+	seed := filepath.Join(dir, "seed")
+	run := func(args ...string) error {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Selftest", "-c", "user.email=selftest@example.com"}, args...)...)
+		cmd.Dir = seed
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %s", strings.Join(args, " "), out)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(seed, 0755); err != nil {
+		return err
+	}
+	if out, err := exec.Command("git", "init", "--bare", "-b", "main", remote).CombinedOutput(); err != nil {
+		return fmt.Errorf("git init: %s", out)
+	}
+	if err := os.WriteFile(filepath.Join(seed, "README.md"), []byte("# app\n"), 0644); err != nil {
+		return err
+	}
+	steps := [][]string{
+		{"init", "-b", "main"},
+		{"add", "README.md"},
+		{"commit", "-m", "Initial commit"},
+		{"remote", "add", "origin", remote},
+		{"push", "origin", "main"},
+	}
+	for _, b := range branches {
+		steps = append(steps,
+			[]string{"checkout", "-b", b, "main"},
+			[]string{"commit", "--allow-empty", "-m", "Unrelated work"},
+			[]string{"push", "origin", b},
+		)
+	}
+	for _, args := range steps {
+		if err := run(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFakeAgent writes a `claude` script that plays the scenario's agent
+// steps in turn, counting its runs in dir/runs, noting the session it was
+// resumed with in dir/resumed, and reporting stream-json like Claude Code's
+func writeFakeAgent(dir string, steps []agentStep) error {
+	if len(steps) == 0 {
+		steps = []agentStep{{}}
+	}
+	for i, step := range steps {
+		stepDir := filepath.Join(dir, "steps", strconv.Itoa(i+1))
+		for name, content := range step.Files {
+			path := filepath.Join(stepDir, "files", filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(stepDir, 0755); err != nil {
+			return err
+		}
+		meta := fmt.Sprintf("%g %d\n", step.SleepSeconds, step.Exit)
+		if err := os.WriteFile(filepath.Join(stepDir, "meta"), []byte(meta), 0644); err != nil {
+			return err
+		}
+	}
+
+	script := fmt.Sprintf(`#!/bin/sh
+dir=%q
+n=$(( $(cat "$dir/runs" 2>/dev/null || echo 0) + 1 ))
+echo "$n" > "$dir/runs"
+step="$dir/steps/$n"
+[ -d "$step" ] || step="$dir/steps/%d"
+read sleep code < "$step/meta"
+echo '{"type":"system","subtype":"init","session_id":"selftest-'"$n"'"}'
+prev=
+for arg in "$@"; do
+	[ "$prev" = --resume ] && echo "$arg" > "$dir/resumed"
+	prev=$arg
+done
+[ -d "$step/files" ] && cp -R "$step/files/." .
+[ "$sleep" = 0 ] || sleep "$sleep"
+echo '{"type":"assistant","message":{"content":[{"type":"text","text":"Scripted run '"$n"'"}]}}'
+echo '{"type":"result","subtype":"success","num_turns":1,"total_cost_usd":0.01,"usage":{"input_tokens":1000,"output_tokens":100}}'
+exit "$code"
+`, dir, len(steps))
+	return os.WriteFile(filepath.Join(dir, "claude"), []byte(script), 0755)
+}
+
+// checkScenario compares the run's outcome with the expectations
+func checkScenario(want scenarioExpectation, result *Result, jira *fakeJira, github *fakeGitHub, remote, agentRuns string) []string {
+	var problems []string
+	expect := func(what string, got, want interface{}) {
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			problems = append(problems, fmt.Sprintf("%s: got %v, want %v", what, got, want))
+		}
+	}
+	expect("status", result.Status, want.Status)
+	if want.Stage != "" || result.Status == "failed" {
+		expect("stage", result.Stage, want.Stage)
+	}
+	if result.Status == "failed" && want.Status != "failed" {
+		problems = append(problems, "error: "+result.Error)
+	}
+	if want.AgentRuns > 0 {
+		expect("agent runs", agentRuns, want.AgentRuns)
+	}
+
+	github.mu.Lock()
+	prs := github.prs
+	github.mu.Unlock()
+	expect("PRs", len(prs), want.PRs)
+	for _, pr := range prs {
+		base := want.Base
+		if base == "" {
+			base = "main"
+		}
+		expect("PR base", pr.Base, base)
+	}
+
+	branches := remoteRefs(remote)
+	for _, b := range want.Branches {
+		if !containsAny(branches, b) {
+			problems = append(problems, fmt.Sprintf("branch %s not on the remote (have %s)", b, strings.Join(branches, ", ")))
+		}
+	}
+
+	if want.Files != nil {
+		var files []string
+		for _, pr := range prs {
+			out, err := exec.Command("git", "--git-dir", remote, "diff", "--name-only", pr.Base+"..."+pr.Head).Output()
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("diff of %s: %v", pr.Head, err))
+				continue
+			}
+			files = append(files, strings.Fields(string(out))...)
+		}
+		sort.Strings(files)
+		wantFiles := append([]string(nil), want.Files...)
+		sort.Strings(wantFiles)
+		expect("changed files", files, wantFiles)
+	}
+
+	jira.mu.Lock()
+	defer jira.mu.Unlock()
+	comments := strings.Join(jira.comments[jira.onlyKey()], "\n---\n")
+	for _, c := range want.Comments {
+		if !strings.Contains(comments, c) {
+			problems = append(problems, fmt.Sprintf("no Jira comment contains %q", c))
+		}
+	}
+	if want.JiraStatus != "" {
+		expect("Jira status", jira.issues[jira.onlyKey()].Status, want.JiraStatus)
+	}
+	return problems
+}
+
+// onlyKey is the key of the scenario's issue
+func (j *fakeJira) onlyKey() string {
+	for key := range j.issues {
+		return key
+	}
+	return ""
+}
+
+func remoteRefs(remote string) []string {
+	out, err := exec.Command("git", "--git-dir", remote, "for-each-ref", "--format=%(refname:short)", "refs/heads").Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(out))
+}
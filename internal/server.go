@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiHandler routes the daemon's API. It is served on the control socket
+// and, with server.listen, on TCP behind the token.
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/logs", handleLogStream)
+	mux.HandleFunc("/api/logs/ticket", handleLogTicket)
+	mux.HandleFunc("/api/runs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(activeLeases(control.config()))
+	})
+	mux.HandleFunc("/api/status", handleStatus)
+	mux.HandleFunc("/api/state", handleState)
+	mux.HandleFunc("/api/runlog", handleRunLog)
+	mux.HandleFunc("/api/diff", handleDiff)
+	mux.HandleFunc("/api/trigger", handleTrigger)
+	mux.HandleFunc("/api/retry", handleRetry)
+	mux.HandleFunc("/api/cancel", handleCancel)
+	mux.HandleFunc("/api/clear", handleClear)
+	mux.HandleFunc("/api/pause", handlePause(true))
+	mux.HandleFunc("/api/resume", handlePause(false))
+	mux.HandleFunc("/api/reload", handleReload)
+	mux.HandleFunc("/api/stop", handleStop)
+	mux.HandleFunc("/api/jira/webhook", handleJiraWebhook)
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.HandleFunc("/", serveDashboard)
+	return mux
+}
+
+// apiListeners are the control socket and the TCP server, while open
+var apiListeners []io.Closer
+
+// closeAPIListeners stops serving the API, so a daemon taking over from
+// this one can listen on the same socket and address
+func closeAPIListeners() {
+	for _, l := range apiListeners {
+		l.Close()
+	}
+	apiListeners = nil
+}
+
+// startServer runs the daemon's HTTP API on cfg.Server.Listen. Every
+// request but /healthz and the dashboard page must carry the token, the one
+// in the config as last reloaded.
+func startServer(cfg *Config, handler http.Handler) error {
+	if cfg.Server.Token == "" {
+		return fmt.Errorf("server.token is required when server.listen is set")
+	}
+
+	token := func() string {
+		if c := control.config(); c != nil {
+			return c.Server.Token
+		}
+		return cfg.Server.Token
+	}
+	srv := &http.Server{Addr: cfg.Server.Listen, Handler: requireToken(token, handler)}
+	apiListeners = append(apiListeners, srv)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Server error: %v\n", err)
+		}
+	}()
+	fmt.Printf("API and dashboard listening on %s\n", cfg.Server.Listen)
+	return nil
+}
+
+// requireToken accepts the token as a bearer header only, as a query
+// parameter would leave it in access logs, proxies, and browser history.
+// /healthz and the dashboard page, which holds no data, are open. Browsers
+// can't set headers on an EventSource, so /api/logs also takes a ticket
+// from POST /api/logs/ticket; Jira's webhooks sign their body with the
+// token instead.
+func requireToken(token func() string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || (r.URL.Path == "/" && r.Method == http.MethodGet) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		want := token()
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1:
+		case r.URL.Path == "/api/logs" && useLogTicket(r.URL.Query().Get("ticket")):
+		case r.URL.Path == "/api/jira/webhook" && want != "" && signedWebhook(r, want):
+		default:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logTicketTTL is how long a ticket for /api/logs stays usable
+const logTicketTTL = time.Minute
+
+// logTickets are the unused tickets for /api/logs and when they expire.
+// Each opens one stream, so one seen in a log is of no use.
+var logTickets = struct {
+	sync.Mutex
+	expires map[string]time.Time
+}{expires: map[string]time.Time{}}
+
+// handleLogTicket issues a ticket for one /api/logs stream
+func handleLogTicket(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ticket := hex.EncodeToString(buf)
+	logTickets.Lock()
+	for t, expires := range logTickets.expires {
+		if time.Now().After(expires) {
+			delete(logTickets.expires, t)
+		}
+	}
+	logTickets.expires[ticket] = time.Now().Add(logTicketTTL)
+	logTickets.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"ticket": ticket})
+}
+
+// useLogTicket reports whether ticket is current, and uses it up
+func useLogTicket(ticket string) bool {
+	if ticket == "" {
+		return false
+	}
+	logTickets.Lock()
+	defer logTickets.Unlock()
+	expires, ok := logTickets.expires[ticket]
+	delete(logTickets.expires, ticket)
+	return ok && time.Now().Before(expires)
+}
+
+// signedWebhook reports whether r carries the X-Hub-Signature Jira adds to
+// a webhook with a secret, here the token: the body's HMAC-SHA256. The body
+// is left for the handler to read.
+func signedWebhook(r *http.Request, token string) bool {
+	sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature"), "sha256=")
+	if !ok {
+		return false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(sig)), []byte(want)) == 1
+}
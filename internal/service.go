@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// launchdLabel names the launchd job
+const launchdLabel = "io.github.imaravin.factory"
+
+// serviceManager installs the daemon as a user service: a systemd unit on
+// Linux, a launchd agent on macOS
+type serviceManager struct {
+	name string // what the user sees, e.g. "systemd unit"
+	path string // where the unit or plist is written
+	// render returns the unit or plist for the daemon
+	render func(exe string, cfg *Config) string
+	// enable starts the service now and at login; disable undoes it
+	enable  [][]string
+	disable [][]string
+}
+
+func userServiceManager() (*serviceManager, error) {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "linux":
+		dir := os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			dir = filepath.Join(home, ".config")
+		}
+		return &serviceManager{
+			name:   "systemd unit",
+			path:   filepath.Join(dir, "systemd", "user", "factory.service"),
+			render: systemdUnit,
+			enable: [][]string{
+				{"systemctl", "--user", "daemon-reload"},
+				{"systemctl", "--user", "enable", "factory.service"},
+				{"systemctl", "--user", "restart", "factory.service"},
+			},
+			disable: [][]string{{"systemctl", "--user", "disable", "--now", "factory.service"}},
+		}, nil
+	case "darwin":
+		path := filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+		return &serviceManager{
+			name:    "launchd agent",
+			path:    path,
+			render:  launchdPlist,
+			enable:  [][]string{{"launchctl", "load", "-w", path}},
+			disable: [][]string{{"launchctl", "unload", "-w", path}},
+		}, nil
+	}
+	return nil, fmt.Errorf("factory service supports systemd (Linux) and launchd (macOS); on %s, run `factory start --foreground` under a service wrapper such as NSSM", runtime.GOOS)
+}
+
+// serviceStopTimeout is how long the service manager should wait for the
+// daemon to stop: its drain time, the interrupted run's grace, and a margin
+func serviceStopTimeout(cfg *Config) int {
+	return int((cfg.Poll.drainTimeout() + stopGrace).Seconds()) + 30
+}
+
+// systemdUnit runs the daemon in the foreground as a systemd user service.
+// Only the daemon gets SIGTERM on stop, so the run in progress can drain.
+func systemdUnit(exe string, cfg *Config) string {
+	return fmt.Sprintf(`[Unit]
+Description=factory: Jira issues to pull requests
+
+[Service]
+ExecStart="%s" start --foreground
+WorkingDirectory=%s
+Environment="PATH=%s"
+Restart=on-failure
+RestartSec=30
+KillMode=mixed
+TimeoutStopSec=%d
+UMask=0022
+StandardOutput=append:%s
+StandardError=append:%s
+
+[Install]
+WantedBy=default.target
+`, exe, GetConfigDir(), os.Getenv("PATH"), serviceStopTimeout(cfg), GetLogPath(), GetLogPath())
+}
+
+// launchdPlist runs the daemon in the foreground as a launchd agent,
+// restarted unless it exits cleanly (e.g. after `factory stop`)
+func launchdPlist(exe string, cfg *Config) string {
+	esc := html.EscapeString
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+  <key>Label</key>
+  <string>%s</string>
+  <key>ProgramArguments</key>
+  <array>
+    <string>%s</string>
+    <string>start</string>
+    <string>--foreground</string>
+  </array>
+  <key>WorkingDirectory</key>
+  <string>%s</string>
+  <key>EnvironmentVariables</key>
+  <dict>
+    <key>PATH</key>
+    <string>%s</string>
+  </dict>
+  <key>RunAtLoad</key>
+  <true/>
+  <key>KeepAlive</key>
+  <dict>
+    <key>SuccessfulExit</key>
+    <false/>
+  </dict>
+  <key>ExitTimeOut</key>
+  <integer>%d</integer>
+  <key>Umask</key>
+  <integer>18</integer>
+  <key>StandardOutPath</key>
+  <string>%s</string>
+  <key>StandardErrorPath</key>
+  <string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, esc(exe), esc(GetConfigDir()), esc(os.Getenv("PATH")), serviceStopTimeout(cfg), esc(GetLogPath()), esc(GetLogPath()))
+}
+
+// ManageService installs, enables, disables, or uninstalls the daemon as a
+// systemd or launchd user service running `factory start --foreground`.
+// With printOnly, install prints the unit instead of installing it.
+func ManageService(action string, printOnly bool) error {
+	sm, err := userServiceManager()
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "install":
+		cfg, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+			exe = resolved
+		}
+		unit := sm.render(exe, cfg)
+		if printOnly {
+			fmt.Print(unit)
+			return nil
+		}
+		if pid := GetDaemonPid(); pid > 0 && isRunning(pid) {
+			return fmt.Errorf("daemon is running (PID %d); stop it with `factory stop` before installing the service", pid)
+		}
+		if err := os.MkdirAll(filepath.Dir(sm.path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(sm.path, []byte(unit), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s: %s\n", sm.name, sm.path)
+		if runtime.GOOS == "darwin" {
+			// Reinstalling replaces a loaded agent
+			exec.Command("launchctl", "unload", sm.path).Run()
+		}
+		if err := runServiceCommands(sm.enable); err != nil {
+			return err
+		}
+		fmt.Printf("Daemon started; it starts again at login and after a crash. Logs: %s\n", GetLogPath())
+		if runtime.GOOS == "linux" {
+			fmt.Println("To keep it running while you are logged out: loginctl enable-linger $USER")
+		}
+		fmt.Println("Re-run `factory service install` after moving the factory binary or changing PATH")
+	case "enable":
+		if err := requireServiceFile(sm); err != nil {
+			return err
+		}
+		if err := runServiceCommands(sm.enable); err != nil {
+			return err
+		}
+		fmt.Println("Service enabled and started")
+	case "disable":
+		if err := requireServiceFile(sm); err != nil {
+			return err
+		}
+		if err := runServiceCommands(sm.disable); err != nil {
+			return err
+		}
+		fmt.Println("Service stopped and disabled; `factory service enable` starts it again")
+	case "uninstall":
+		if err := requireServiceFile(sm); err != nil {
+			return err
+		}
+		if err := runServiceCommands(sm.disable); err != nil {
+			fmt.Printf("  Warning: %v\n", err)
+		}
+		if err := os.Remove(sm.path); err != nil {
+			return err
+		}
+		if runtime.GOOS == "linux" {
+			exec.Command("systemctl", "--user", "daemon-reload").Run()
+		}
+		fmt.Printf("Removed %s\n", sm.path)
+	default:
+		return fmt.Errorf("usage: factory service install [--print] | enable | disable | uninstall")
+	}
+	return nil
+}
+
+func requireServiceFile(sm *serviceManager) error {
+	if _, err := os.Stat(sm.path); err != nil {
+		return fmt.Errorf("no %s at %s; run `factory service install` first", sm.name, sm.path)
+	}
+	return nil
+}
+
+// runServiceCommands runs systemctl or launchctl commands in order, stopping
+// at the first that fails
+func runServiceCommands(commands [][]string) error {
+	for _, args := range commands {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
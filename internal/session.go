@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultMaxResumes is how many times a timed-out agent run is resumed
+// when agent.resume is on and agent.maxResumes is unset
+const defaultMaxResumes = 1
+
+// resumePrompt continues an interrupted Claude Code session
+const resumePrompt = `You were interrupted before finishing. Your earlier changes are still in
+the working tree. Check where you left off and continue implementing the
+issue; don't start over.`
+
+// agentSession is an agent run that can be resumed: Claude Code's session
+// ID, and the branch and workspace state the run started from
+type agentSession struct {
+	SessionID string    `json:"sessionId"`
+	Branch    string    `json:"branch"`
+	Model     string    `json:"model,omitempty"`
+	Before    Snapshot  `json:"before"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (a AgentConfig) maxResumes() int {
+	if a.MaxResumes <= 0 {
+		return defaultMaxResumes
+	}
+	return a.MaxResumes
+}
+
+func sessionPath(issueKey string) string {
+	return filepath.Join(GetConfigDir(), "sessions", issueKey+".json")
+}
+
+func loadAgentSession(issueKey string) *agentSession {
+	data, err := os.ReadFile(sessionPath(issueKey))
+	if err != nil {
+		return nil
+	}
+	var s agentSession
+	if json.Unmarshal(data, &s) != nil || s.SessionID == "" {
+		return nil
+	}
+	return &s
+}
+
+// saveAgentSession records the session as soon as Claude Code reports its
+// ID, so it survives the daemon dying mid-run
+func saveAgentSession(issueKey string, s agentSession) {
+	s.UpdatedAt = time.Now()
+	data, _ := json.MarshalIndent(s, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(sessionPath(issueKey)), 0700); err != nil {
+		fmt.Printf("  Warning: could not save agent session: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(sessionPath(issueKey), data, 0600); err != nil {
+		fmt.Printf("  Warning: could not save agent session: %v\n", err)
+	}
+}
+
+func clearAgentSession(issueKey string) {
+	os.Remove(sessionPath(issueKey))
+}
+
+// resumableSession returns the issue's interrupted session when agent.resume
+// is on and the workspace is still on the session's branch. A session whose
+// branch is gone is dropped, and the run starts over.
+func resumableSession(cfg *Config, git *Git, issueKey string) *agentSession {
+	if !cfg.Agent.Resume {
+		return nil
+	}
+	s := loadAgentSession(issueKey)
+	if s == nil {
+		return nil
+	}
+	if branch, _ := git.CurrentBranch(); branch != s.Branch || git.branchIssue(branch) != issueKey {
+		fmt.Printf("  Not resuming session %s: workspace is no longer on %s\n", s.SessionID, s.Branch)
+		clearAgentSession(issueKey)
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// writeStateFile replaces a JSON state file such as processed.json
+// atomically: data goes to a temporary file, synced to disk, which is
+// renamed over path, so a crash leaves the old file or the new one but
+// never half of one. The file it replaces is kept as path.bak, unless that
+// file was damaged itself.
+func writeStateFile(path string, data []byte) error {
+	if current, err := os.ReadFile(path); err == nil && json.Valid(current) {
+		if err := writeSynced(path+".bak", current); err != nil {
+			fmt.Printf("  Warning: could not back up %s: %v\n", path, err)
+		}
+	}
+	return writeSynced(path, data)
+}
+
+// writeSynced writes data to path through a synced temporary file
+func writeSynced(path string, data []byte) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readStateFile parses a JSON state file into v, a pointer. A file that
+// doesn't parse, e.g. after a bad hand edit or a disk error, is moved
+// aside to path.corrupt-TIME and replaced by its backup, with a warning;
+// with no usable backup v is left empty. It returns the error reading the
+// file, so a missing file can be told apart.
+func readStateFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	perr := json.Unmarshal(data, v)
+	if perr == nil {
+		return nil
+	}
+
+	// Unmarshal may have filled v in part
+	reset := func() {
+		e := reflect.ValueOf(v).Elem()
+		if e.Kind() == reflect.Map {
+			e.Set(reflect.MakeMap(e.Type()))
+		} else {
+			e.Set(reflect.Zero(e.Type()))
+		}
+	}
+	reset()
+	corrupt := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+	if err := os.Rename(path, corrupt); err != nil {
+		corrupt = path
+	}
+	backup, err := os.ReadFile(path + ".bak")
+	if err == nil && json.Unmarshal(backup, v) == nil {
+		fmt.Printf("Warning: %s could not be read (%v); restored it from %s.bak, the damaged file is %s\n", path, perr, path, corrupt)
+		if err := writeSynced(path, backup); err != nil {
+			fmt.Printf("Warning: could not restore %s: %v\n", path, err)
+		}
+		return nil
+	}
+	reset()
+	fmt.Printf("Warning: %s could not be read (%v) and has no usable backup; starting from empty, the damaged file is %s\n", path, perr, corrupt)
+	return nil
+}
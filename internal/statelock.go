@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockRetryInterval is how often a wait for a lock another process holds
+// checks again
+const lockRetryInterval = 50 * time.Millisecond
+
+// fileLock is an exclusive lock shared with other factory processes on
+// this machine, such as the daemon and `factory trigger --local`. Like the
+// PID file's lock, it goes with the process, so a crash never leaves it
+// behind.
+type fileLock struct {
+	f *os.File
+}
+
+// GetLocksDir holds the lock files
+func GetLocksDir() string {
+	return filepath.Join(GetConfigDir(), "locks")
+}
+
+// tryLock takes the lock at path, returning errFileLocked at once when
+// another process holds it
+func tryLock(path string) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+// errLockWait is the error of a lock wait that timed out or was stopped
+var errLockWait = errors.New("gave up waiting for the lock")
+
+// waitLock takes the lock at path, waiting while another process holds it,
+// for up to maxWait (0: no limit) or until stop returns true. waiting is
+// called once if it has to wait. Waiting counts as progress for the
+// daemon's watchdog.
+func waitLock(path string, waiting func(), stop func() bool, maxWait time.Duration) (*fileLock, error) {
+	start := time.Now()
+	for {
+		l, err := tryLock(path)
+		if err != errFileLocked {
+			return l, err
+		}
+		if stop != nil && stop() {
+			return nil, fmt.Errorf("%w: stopped", errLockWait)
+		}
+		if maxWait > 0 && time.Since(start) > maxWait {
+			return nil, fmt.Errorf("%w: still held after %s", errLockWait, maxWait)
+		}
+		if waiting != nil {
+			waiting()
+			waiting = nil
+		}
+		control.progressed()
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// unlock releases the lock; nil is a no-op
+func (l *fileLock) unlock() {
+	if l != nil {
+		l.f.Close()
+	}
+}
+
+// lockProcessed serializes changes to processed.json, between goroutines
+// and between processes. It returns the unlock func.
+func lockProcessed() func() {
+	processedMu.Lock()
+	l, err := waitLock(filepath.Join(GetLocksDir(), "processed.lock"), nil, nil, 0)
+	if err != nil {
+		// Locks aren't available here (e.g. some network file systems)
+		fmt.Printf("  Warning: could not lock %s: %v\n", GetProcessedPath(), err)
+	}
+	return func() {
+		l.unlock()
+		processedMu.Unlock()
+	}
+}
+
+// updateProcessed applies change to processed.json as it is on disk, under
+// the lock, so updates from the daemon and the CLI don't overwrite each
+// other. processed is left as saved.
+func updateProcessed(change func(list map[string]ProcessedIssue)) {
+	defer lockProcessed()()
+	processed = readProcessed()
+	change(processed)
+	saveProcessed()
+}
+
+// issueLockPath is the lock file a run holds on its issue, so that a run
+// started while another process on this machine, e.g. the daemon while
+// `factory trigger --local` starts, is running the issue fails at once.
+// The lease does the same across machines but can be taken over once it
+// expires.
+func issueLockPath(issueKey string) string {
+	return filepath.Join(GetLocksDir(), "issue-"+issueKey+".lock")
+}
+
+// workspaceLockPath is the lock file of the repo workspace, which one
+// run at a time may check branches out in
+func workspaceLockPath(cfg *Config) string {
+	sum := sha256.Sum256([]byte(NewGit(cfg).Path()))
+	return filepath.Join(GetLocksDir(), "workspace-"+hex.EncodeToString(sum[:8])+".lock")
+}
+
+// maxWorkspaceWait bounds how long a run waits for another process's run
+// to leave the workspace
+const maxWorkspaceWait = 30 * time.Minute
+
+// lockWorkspace takes the workspace lock for a run, waiting while another
+// process's run holds it. It gives up with errLockWait once stop returns
+// true, e.g. when the run is cancelled or the daemon is stopping, or after
+// maxWorkspaceWait.
+func lockWorkspace(cfg *Config, stop func() bool) (*fileLock, error) {
+	return waitLock(workspaceLockPath(cfg), func() {
+		fmt.Println("  Waiting for the workspace, which another factory process is using")
+	}, stop, maxWorkspaceWait)
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TDDConfig has runs work test-first: the agent first writes tests that
+// capture the issue, which must fail under verify.testCommand, and only
+// then implements the issue until they pass
+type TDDConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Labels limits test-first runs to issues with one of these labels;
+	// empty applies it to every issue
+	Labels []string `json:"labels,omitempty"`
+}
+
+// applies reports whether the issue is implemented test-first
+func (c TDDConfig) applies(issue *Issue) bool {
+	return c.Enabled && (len(c.Labels) == 0 || issue.hasLabel(c.Labels))
+}
+
+// tddTestInstructions end the prompt of the test-first step
+const tddTestInstructions = `
+
+## Test First
+Work test-first. In this step, ONLY write tests that capture the issue: for
+a bug, tests that reproduce it; otherwise, tests of its acceptance criteria.
+Don't change the code under test yet; changes to anything but test files
+are rejected. The tests must fail now and pass once
+the issue is implemented; factory runs ` + "`%s`" + ` next and expects it to fail.`
+
+// tddRed is the test-first step's outcome: the tests the agent wrote and
+// how the test command failed with them
+type tddRed struct {
+	Files   []string
+	Command string
+	Output  string
+}
+
+// writeFailingTest has the agent write tests for the issue and checks that
+// they fail. Changing any file outside defaultTestPaths fails the step. It returns the stage to fail the run at along with an error,
+// "cancelled" for a cancelled run.
+func writeFailingTest(cfg *Config, git *Git, prompt, model string, before Snapshot, issue *Issue, result *Result, lease *leaseHandle, transcript io.Writer) (*tddRed, string, error) {
+	fmt.Println("→ Writing a failing test first...")
+	result.enterStage("tdd")
+	progress(cfg, issue.Key, "writing a failing test first")
+	test := verifyCheck{Name: "test", Command: cfg.Verify.TestCommand}
+	_, err := runAgentStep(cfg, git, agentRun{prompt: prompt + fmt.Sprintf(tddTestInstructions, test.Command), model: model}, issue, result, lease, transcript)
+	if stage := stepStopped(err); stage != "" {
+		return nil, stage, err
+	}
+	if err != nil {
+		return nil, "tdd", fmt.Errorf("agent: %w", err)
+	}
+
+	all, err := git.ChangedSince(before)
+	if err != nil {
+		return nil, "git", err
+	}
+	files, _, _ := FilterArtifacts(all, artifactPatterns(cfg))
+	if len(files) == 0 {
+		return nil, "tdd", fmt.Errorf("the agent wrote no tests")
+	}
+	var outside []string
+	for _, f := range files {
+		if matchPath(f, defaultTestPaths) == "" {
+			outside = append(outside, f)
+		}
+	}
+	if len(outside) > 0 {
+		return nil, "tdd", fmt.Errorf("the test-first step changed files that aren't tests: %s", strings.Join(outside, ", "))
+	}
+	fmt.Printf("  Tests: %s\n", strings.Join(files, ", "))
+	output, err := runCheck(test, git.Path())
+	if err == nil {
+		return nil, "tdd", fmt.Errorf("%s passes with the new tests (%s), so they don't capture the issue", test.Command, strings.Join(files, ", "))
+	}
+	fmt.Println("  Tests fail, as they should")
+	return &tddRed{Files: files, Command: test.Command, Output: output}, "", nil
+}
+
+// note adds the failing tests to the implementation prompt
+func (r *tddRed) note() string {
+	return fmt.Sprintf("\n## Failing Tests\nYou already wrote tests that capture this issue, in %s, and `%s` fails with them:\n\n```\n%s\n```\n\n"+
+		"Now implement the issue so that they pass. Don't change or weaken these tests.\n",
+		strings.Join(r.Files, ", "), r.Command, lastLines(r.Output, feedbackLines))
+}
+
+// keeps reports whether the failing tests are kept when the workspace is
+// reset for the next model
+func (r *tddRed) keeps(path string) bool {
+	if r == nil {
+		return false
+	}
+	for _, f := range r.Files {
+		if f == path {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTDD is the PR body section on both steps of a test-first run, ""
+// for any other run
+func formatTDD(r *tddRed) string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("\n## Test First\n1. **Failing tests** in %s: `%s` failed before the change:\n\n```\n%s\n```\n\n"+
+		"2. **Implementation**: `%s` passes with the change.\n",
+		"`"+strings.Join(r.Files, "`, `")+"`", r.Command, redact(lastLines(r.Output, 20)), r.Command)
+}
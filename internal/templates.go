@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// Template names, used as keys in templates config and `factory template test`
+const (
+	TemplatePrompt  = "prompt"
+	TemplatePRTitle = "prTitle"
+	TemplatePRBody  = "prBody"
+	TemplateCommit  = "commit"
+	TemplateComment = "comment"
+)
+
+// TemplateData is what every template is rendered against
+type TemplateData struct {
+	Issue   *Issue
+	JiraURL string
+	Branch  string
+	Base    string
+	PRURL   string
+	// Cost is the run's agent spend, e.g. "$0.42 (1.2M in / 35k out tokens)",
+	// and CostUSD the dollars alone, 0 for a run with no recorded spend
+	Cost    string
+	CostUSD float64
+	// SmartCommit holds the configured smart-commit commands, if any
+	SmartCommit string
+	// CommitType and Scope are the conventional-commit type mapped from the
+	// issue type, and the first component slugified
+	CommitType string
+	Scope      string
+	// TestsOnly is set for issues whose runs may only change tests
+	TestsOnly bool
+	// TDD is the PR body section on a test-first run's failing tests and
+	// implementation
+	TDD string
+	// SelfReview is the PR body section on the self-review's remaining
+	// concerns
+	SelfReview string
+
+	// Prompt sections pre-rendered from the issue
+	Estimate    string
+	Variables   string
+	Links       string
+	Comments    string
+	Attachments string
+	Lessons     string
+	PathScope   string
+	// Related lists recent commits and open PRs mentioning the issue or its
+	// components; filled in when the prompt is built for a run
+	Related string
+	// Request is the "@factory" comment that asked for the run, if one did
+	Request string
+}
+
+var templateFuncs = template.FuncMap{
+	"slugify":        slugify,
+	"truncate":       truncate,
+	"markdownEscape": markdownEscape,
+	"jiraToMarkdown": jiraToMarkdown,
+	"regexReplace":   regexReplace,
+	"now":            func(layout string) string { return time.Now().Format(layout) },
+	"env":            os.Getenv,
+	"lower":          strings.ToLower,
+	"lowerFirst":     lowerFirst,
+	"upper":          strings.ToUpper,
+	"trim":           strings.TrimSpace,
+	"join":           func(sep string, items []string) string { return strings.Join(items, sep) },
+	"default": func(def, s string) string {
+		if strings.TrimSpace(s) == "" {
+			return def
+		}
+		return s
+	},
+}
+
+var defaultTemplates = map[string]string{
+	TemplatePrompt: `Implement the following Jira issue:
+
+## {{.Issue.Key}}: {{.Issue.Title}}
+
+**Type**: {{.Issue.Type}} | **Priority**: {{.Issue.Priority}}{{.Estimate}}
+
+## Description
+{{.Issue.Description}}
+
+## Acceptance Criteria
+{{.Issue.AcceptanceCriteria}}
+{{.Variables}}
+## Linked Issues
+{{.Links}}
+
+## Comments (Additional Context/Instructions)
+{{.Comments}}
+
+## Attachments
+{{.Attachments}}
+{{.Related}}{{.Lessons}}{{.PathScope}}{{.Request}}
+## Instructions
+1. Analyze the codebase
+2. Review the comments, related work, and lessons above for additional context or specific instructions
+3. Implement the required changes
+4. Add/update tests if needed
+5. Keep changes minimal and focused
+6. Add TODO comments for ambiguous parts`,
+
+	TemplatePRTitle: `[{{.Issue.Key}}] {{if .TestsOnly}}Tests: {{end}}{{.Issue.Title}}`,
+
+	TemplatePRBody: `## Summary
+- **Issue**: [{{.Issue.Key}}]({{.JiraURL}}/browse/{{.Issue.Key}})
+- **Type**: {{.Issue.Type}}
+- **Priority**: {{.Issue.Priority}}
+
+## Description
+{{.Issue.Description}}
+
+## Acceptance Criteria
+{{.Issue.AcceptanceCriteria}}
+{{.TDD}}{{.SelfReview}}
+## Validation
+- [ ] Code builds successfully
+- [ ] Tests pass
+- [ ] Acceptance criteria verified
+
+## Jira
+Closes {{.Issue.Key}}
+
+---
+*Generated by factory*`,
+
+	TemplateCommit: `{{.CommitType}}{{with .Scope}}({{.}}){{end}}: {{.Issue.Key}} {{.Issue.Title | lowerFirst}}
+
+Implemented via factory{{if .SmartCommit}}
+
+{{.Issue.Key}} {{.SmartCommit}}{{end}}`,
+
+	TemplateComment: `PR raised: {{.PRURL}}{{if .CostUSD}}
+Agent cost: {{.Cost}}{{end}}`,
+}
+
+// templateSource returns the configured template file's contents, or the
+// built-in default. Relative paths resolve against ~/.factory.
+func templateSource(cfg *Config, name string) (string, error) {
+	path := cfg.Templates.path(name)
+	if path == "" {
+		def, ok := defaultTemplates[name]
+		if !ok {
+			return "", fmt.Errorf("unknown template %q", name)
+		}
+		return def, nil
+	}
+	return readTemplateFile(name, path)
+}
+
+// readTemplateFile reads a template file; relative paths resolve against
+// ~/.factory
+func readTemplateFile(name, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(GetConfigDir(), path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return string(data), nil
+}
+
+// RenderTemplate renders the named template (configured or default)
+func RenderTemplate(cfg *Config, name string, data *TemplateData) (string, error) {
+	src, err := templateSource(cfg, name)
+	if err != nil {
+		return "", err
+	}
+	return renderText(name, src, data)
+}
+
+func renderText(name, src string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// newTemplateData builds template data for an issue. repoPath may be empty
+// when attachments have not been downloaded.
+func newTemplateData(cfg *Config, repoPath string, issue *Issue) *TemplateData {
+	data := &TemplateData{
+		Issue:       issue,
+		JiraURL:     cfg.jiraSite(issue.Key).Jira.BaseURL,
+		Base:        cfg.Repo.DefaultBranch,
+		SmartCommit: cfg.Jira.SmartCommit.commands(),
+		CommitType:  commitType(cfg, issue.Type),
+		Scope:       commitScope(issue),
+		Estimate:    formatEstimate(issue.StoryPoints),
+		Variables:   formatVariables(issue.Variables),
+		Links:       formatLinks(issue.Links),
+		Comments:    formatComments(issue.Comments),
+		Attachments: formatAttachments(repoPath, issue.Attachments),
+		Lessons:     formatLessons(LoadLessons(cfg)),
+		PathScope:   formatPathScope(componentPaths(cfg, issue)) + formatTestsOnly(cfg, issue),
+		TestsOnly:   cfg.TestsOnly.applies(issue),
+	}
+	if data.TestsOnly {
+		data.CommitType = "test"
+	}
+	return data
+}
+
+// TestTemplate renders a template by name or file path against a real
+// issue, or a sample issue when issueKey is empty
+func TestTemplate(cfg *Config, nameOrPath, issueKey string) (string, error) {
+	issue := sampleIssue()
+	var err error
+	if issueKey != "" {
+		if issue, err = GetIssue(cfg, issueKey); err != nil {
+			return "", err
+		}
+	}
+
+	data := newTemplateData(cfg, "", issue)
+	if data.Branch, err = BranchName(cfg, issue); err != nil {
+		return "", err
+	}
+	data.PRURL = fmt.Sprintf("https://github.com/%s/%s/pull/1", cfg.GitHub.Owner, cfg.GitHub.Repo)
+
+	if _, ok := defaultTemplates[nameOrPath]; ok {
+		return RenderTemplate(cfg, nameOrPath, data)
+	}
+	src, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return "", err
+	}
+	return renderText(filepath.Base(nameOrPath), string(src), data)
+}
+
+func sampleIssue() *Issue {
+	return &Issue{
+		Key:                "PROJ-123",
+		Title:              "Fix login redirect loop on expired sessions",
+		Description:        "Users with an expired session are redirected between /login and /home forever.\n\nAcceptance Criteria:\n- Expired sessions land on /login once",
+		Type:               "Bug",
+		Priority:           "High",
+		Status:             "To Do",
+		Labels:             []string{"auth"},
+		Components:         []string{"web"},
+		AcceptanceCriteria: "- Expired sessions land on /login once",
+		Comments: []Comment{
+			{Author: "Jane Reviewer", Date: "2024-01-14 10:30", Body: "Check the session middleware first."},
+		},
+	}
+}
+
+// defaultCommitTypes maps lowercased Jira issue types to conventional-commit
+// types; unmapped types become "chore"
+var defaultCommitTypes = map[string]string{
+	"bug":         "fix",
+	"story":       "feat",
+	"feature":     "feat",
+	"improvement": "feat",
+	"epic":        "feat",
+	"task":        "chore",
+	"sub-task":    "chore",
+	"subtask":     "chore",
+}
+
+func commitType(cfg *Config, issueType string) string {
+	for jiraType, t := range cfg.Templates.CommitTypes {
+		if strings.EqualFold(jiraType, issueType) {
+			return t
+		}
+	}
+	if t, ok := defaultCommitTypes[strings.ToLower(issueType)]; ok {
+		return t
+	}
+	return "chore"
+}
+
+func commitScope(issue *Issue) string {
+	if len(issue.Components) == 0 {
+		return ""
+	}
+	return slugify(issue.Components[0])
+}
+
+// --- Template Functions ---
+
+// lowerFirst lowercases the first letter unless the first word looks like
+// an acronym or identifier ("API", "iOS")
+func lowerFirst(s string) string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return s
+	}
+	word := []rune(words[0])
+	if len(word) > 1 && strings.ToUpper(words[0]) == words[0] || strings.ToLower(string(word[1:])) != string(word[1:]) {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// truncate shortens s to n runes; argument order suits pipelines:
+// {{.Issue.Title | truncate 50}}
+func truncate(n int, s string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+var markdownSpecial = regexp.MustCompile("([\\\\`*_{}\\[\\]()#+\\-.!|<>])")
+
+func markdownEscape(s string) string {
+	return markdownSpecial.ReplaceAllString(s, `\$1`)
+}
+
+func regexReplace(pattern, repl, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
@@ -0,0 +1,80 @@
+package internal
+
+import "strings"
+
+// TestsOnlyConfig runs labelled issues, such as coverage debt, through a
+// pipeline that may only add and update tests: the prompt says so, a run
+// that changes any other file fails at the scope stage, and the PR title
+// says it only has tests
+type TestsOnlyConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Labels marks the issues, default "tests"
+	Labels []string `json:"labels,omitempty"`
+	// Paths are the files runs may change, written like
+	// repo.artifactPatterns; defaults to defaultTestPaths
+	Paths []string `json:"paths,omitempty"`
+}
+
+// defaultTestPaths match the test files and directories of common languages
+var defaultTestPaths = []string{
+	"test/",
+	"tests/",
+	"__tests__/",
+	"spec/",
+	"testdata/",
+	"fixtures/",
+	"*_test.go",
+	"test_*.py",
+	"*_test.py",
+	"conftest.py",
+	"*.test.*",
+	"*.spec.*",
+	"*_spec.rb",
+	"*_test.rb",
+	"*Test.java",
+	"*Tests.java",
+	"*Test.kt",
+	"*Tests.cs",
+	"*Test.php",
+}
+
+func (c TestsOnlyConfig) labels() []string {
+	if len(c.Labels) == 0 {
+		return []string{"tests"}
+	}
+	return c.Labels
+}
+
+func (c TestsOnlyConfig) paths() []string {
+	if len(c.Paths) == 0 {
+		return defaultTestPaths
+	}
+	return c.Paths
+}
+
+// applies reports whether the issue's runs may only change tests
+func (c TestsOnlyConfig) applies(issue *Issue) bool {
+	return c.Enabled && issue.hasLabel(c.labels())
+}
+
+// formatTestsOnly is the prompt section limiting a run to tests, "" when
+// the issue isn't tests-only
+func formatTestsOnly(cfg *Config, issue *Issue) string {
+	if !cfg.TestsOnly.applies(issue) {
+		return ""
+	}
+	return "\n## Tests only\nOnly add or update tests; don't change the code under test, and leave out any test " +
+		"that fails because of a bug in it. Only change files matching: " +
+		strings.Join(cfg.TestsOnly.paths(), ", ") + ". Changes to any other file will be rejected.\n"
+}
+
+// notTests returns the changed files outside the tests-only allowlist
+func notTests(cfg *Config, changed []string) []string {
+	var outside []string
+	for _, file := range changed {
+		if matchPath(file, cfg.TestsOnly.paths()) == "" {
+			outside = append(outside, file)
+		}
+	}
+	return outside
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Claude Code runs a PreToolUse hook before each matching tool call, and a
+// hook that exits with code 2 blocks the call. Unlike runClaude, which sees
+// tool calls in the agent's output, a hook stops a call before it runs.
+
+// hookGuard applies the agent guard's policy (see guard.go) to every Bash
+// call and file write
+const hookGuard = "guard"
+
+// hookGitHistory checks the git commands of a read-only agent: they may
+// read history, but not write files through git
+const hookGitHistory = "git-history"
+
+// hookUnshallow fetches the history a shallow workspace left out before the
+// agent's first git command that reads it, so that command already sees it
+const hookUnshallow = "unshallow"
+
+// toolHookMatchers are the tools a hook runs before, where not just Bash
+var toolHookMatchers = map[string]string{hookGuard: "Bash|Edit|MultiEdit|Write|NotebookEdit"}
+
+// toolHookTimeouts are the seconds Claude Code gives a hook, where its own
+// default is too short; fetching a big repo's history takes a while
+var toolHookTimeouts = map[string]int{hookUnshallow: 600}
+
+// gitWriteOptions make git commands that read history write files or run
+// programs; git also takes any unambiguous prefix of them
+var gitWriteOptions = []string{"--output", "--ext-diff"}
+
+// toolHookSettings are the --settings that have Claude Code run factory's
+// tool hooks with names before the agent's tool calls in worktree
+func toolHookSettings(worktree string, names ...string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	var entries []interface{}
+	for _, name := range names {
+		hook := map[string]interface{}{"type": "command", "command": fmt.Sprintf("%q tool-hook %s %q", exe, name, worktree)}
+		if timeout := toolHookTimeouts[name]; timeout > 0 {
+			hook["timeout"] = timeout
+		}
+		matcher := toolHookMatchers[name]
+		if matcher == "" {
+			matcher = "Bash"
+		}
+		entries = append(entries, map[string]interface{}{"matcher": matcher, "hooks": []interface{}{hook}})
+	}
+	settings := map[string]interface{}{
+		"hooks": map[string]interface{}{"PreToolUse": entries},
+	}
+	data, err := json.Marshal(settings)
+	return string(data), err
+}
+
+// RunToolHook runs the tool hook name over the tool call Claude Code passes
+// on in, made by an agent working in worktree; an error blocks the call
+func RunToolHook(name, worktree string, in io.Reader) error {
+	var call struct {
+		Cwd       string                 `json:"cwd"`
+		ToolName  string                 `json:"tool_name"`
+		ToolInput map[string]interface{} `json:"tool_input"`
+	}
+	if err := json.NewDecoder(in).Decode(&call); err != nil {
+		return fmt.Errorf("reading the tool call: %w", err)
+	}
+	if name == hookGuard {
+		cfg, err := LoadConfig()
+		if err != nil {
+			return fmt.Errorf("guard: %w", err)
+		}
+		cwd := call.Cwd
+		if cwd == "" {
+			cwd = worktree
+		}
+		var violation *guardViolation
+		if err := newAgentGuard(cfg, worktree).checkIn(cwd, call.ToolName, call.ToolInput); errors.As(err, &violation) {
+			return fmt.Errorf("blocked %s: %s", violation.Tool, violation.Reason)
+		}
+		return nil
+	}
+	if call.ToolName != "Bash" {
+		return nil
+	}
+	command, _ := call.ToolInput["command"].(string)
+	switch name {
+	case hookGitHistory:
+		if opt := gitWriteOption(command); opt != "" {
+			return fmt.Errorf("%s is not allowed: only read history, without writing files", opt)
+		}
+		return nil
+	case hookUnshallow:
+		// Never blocks: without the history the command still runs, on
+		// what the clone has
+		if readsHistory(call.ToolName, call.ToolInput) {
+			if err := unshallowWorkspace(worktree); err != nil {
+				fmt.Fprintf(os.Stderr, "could not unshallow: %v\n", err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown tool hook %q", name)
+}
+
+// unshallowWorkspace fetches the rest of the history into the agent's
+// worktree, if it is still shallow. It authenticates like the run's own git
+// commands.
+func unshallowWorkspace(worktree string) error {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	git := NewGit(cfg).at(worktree)
+	if !git.IsShallow() {
+		return nil
+	}
+	return git.Unshallow()
+}
+
+// gitWriteOption returns the first argument of command that is one of
+// gitWriteOptions or a prefix of one, "" for none
+func gitWriteOption(command string) string {
+	for _, arg := range strings.Fields(command) {
+		arg = strings.Trim(arg, `'"`)
+		name, _, _ := strings.Cut(arg, "=")
+		if len(name) <= len("--") || !strings.HasPrefix(name, "--") {
+			continue
+		}
+		for _, opt := range gitWriteOptions {
+			if strings.HasPrefix(opt, name) {
+				return arg
+			}
+		}
+	}
+	return ""
+}
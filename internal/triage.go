@@ -0,0 +1,101 @@
+package internal
+
+import "fmt"
+
+// TriageConfig has the daemon triage labelled issues instead of fixing
+// them, like `factory triage`: the agent looks for the bug's root cause and
+// posts what it found on the issue
+type TriageConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Labels marks issues to triage, default "triage". Once a triaged
+	// issue loses its label, it is queued to be fixed.
+	Labels []string `json:"labels,omitempty"`
+}
+
+func (c TriageConfig) labels() []string {
+	if len(c.Labels) == 0 {
+		return []string{"triage"}
+	}
+	return c.Labels
+}
+
+// applies reports whether the issue is to be triaged rather than fixed
+func (c TriageConfig) applies(issue *Issue) bool {
+	return c.Enabled && issue.hasLabel(c.labels())
+}
+
+// triageTools are readOnlyTools plus the git commands that read history;
+// runClaudeReadOnly blocks their options that write files
+const triageTools = readOnlyTools + ",Bash(git log:*),Bash(git blame:*),Bash(git show:*)"
+
+// triageInstructions end the prompt of a triage
+const triageInstructions = `
+
+Do NOT modify any files. Instead, find the probable root cause of this bug.
+Read the code involved, and use git log, git blame, and git show to find
+when and why the suspect lines changed. Respond with:
+- Root cause: what goes wrong and why, and how sure you are of it
+- Where: the files and functions involved, with line numbers
+- Suspect commits: the commits that likely introduced it, with their hash,
+  author, date, and subject, or "None found"
+- Suggested fix: what to change, briefly
+- Open questions: what would confirm the cause, e.g. logs or steps to
+  reproduce`
+
+// triageIssue has the agent look for the issue's root cause read-only and
+// posts its analysis on the issue; no branch or PR is made
+func triageIssue(cfg *Config, issue *Issue, request string, result *Result) *Result {
+	fmt.Println("→ Triaging only")
+	result.enterStage("setup")
+	git := NewGit(cfg)
+	if err := git.Init(); err != nil {
+		return fail(result, "git", err)
+	}
+	if err := git.EnsureClean(issue.Key, cfg.Repo.StashDirty); err != nil {
+		return fail(result, "workspace", err)
+	}
+	if err := git.Pull(); err != nil {
+		return fail(result, "git", err)
+	}
+	// Blame needs the history a shallow clone leaves out
+	if git.IsShallow() {
+		fmt.Println("  Fetching the rest of the history")
+		if err := git.Unshallow(); err != nil {
+			fmt.Printf("  Warning: could not unshallow: %v\n", err)
+		}
+	}
+
+	fmt.Println("→ Running Claude Code (triage only)...")
+	result.enterStage("triage")
+	prompt, err := buildPrompt(cfg, git, issue, nil, request)
+	if err != nil {
+		return fail(result, "triage", err)
+	}
+	analysis, err := runClaudeReadOnly(cfg, git.Path(), prompt+triageInstructions, triageTools, cfg.Agent.timeout(issue))
+	if err != nil {
+		return fail(result, "triage", err)
+	}
+	if analysis == "" {
+		return fail(result, "triage", fmt.Errorf("the agent returned no analysis"))
+	}
+	fmt.Printf("\n%s\n\n", analysis)
+
+	fmt.Println("→ Posting triage to Jira...")
+	result.enterStage("jira")
+	if err := AddComment(cfg, issue.Key, "factory: triage\n\n"+analysis); err != nil {
+		return fail(result, "jira", err)
+	}
+
+	result.Status = "triaged"
+	fmt.Printf("\n✓ Triaged: %s\n", issue.Key)
+	return result
+}
+
+// pollTriage queues triaged issues that lost their triage label, to be
+// fixed. It returns the issues queued.
+func pollTriage(cfg *Config, issues []Issue) []string {
+	if !cfg.Triage.Enabled {
+		return nil
+	}
+	return requeueUnlabelled(cfg, issues, "triaged", cfg.Triage.applies)
+}
@@ -0,0 +1,706 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Tabs of `factory ui`
+const (
+	uiTabIssues = iota
+	uiTabRunning
+	uiTabHistory
+	uiTabPackets
+)
+
+var uiTabNames = []string{"Issues", "Running", "History", "Packets"}
+
+// uiAssignedRefresh is how often the UI re-fetches assigned issues from
+// Jira; local state (leases, processed issues, packets, logs) is re-read
+// every tick
+const (
+	uiAssignedRefresh = time.Minute
+	uiTick            = time.Second
+)
+
+// ANSI escapes the UI draws with
+const (
+	ansiAltScreen  = "\x1b[?1049h"
+	ansiMainScreen = "\x1b[?1049l"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiHome       = "\x1b[H\x1b[2J"
+	ansiReverse    = "\x1b[7m"
+	ansiBold       = "\x1b[1m"
+	ansiDim        = "\x1b[2m"
+	ansiReset      = "\x1b[0m"
+)
+
+// uiRow is one selectable line of a tab
+type uiRow struct {
+	Key    string // issue key
+	Text   string
+	Status string // processed status, on the History tab
+	Draft  bool   // the processed issue's PR is a draft
+	Packet string // packet path, on the Packets tab
+}
+
+// uiPager shows a log or a diff full-screen; logs follow new output until
+// scrolled
+type uiPager struct {
+	Title  string
+	Path   string // re-read each tick when set (run logs)
+	Lines  []string
+	Offset int
+	Follow bool
+}
+
+// uiConfirm is a pending yes/no question; run is called on "y"
+type uiConfirm struct {
+	Prompt string
+	Run    func()
+}
+
+type ui struct {
+	cfg     *Config
+	exe     string
+	tab     int
+	cursor  [4]int
+	rows    [4][]uiRow
+	header  string
+	message string
+
+	assigned      []Issue
+	assignedErr   error
+	assignedAt    time.Time
+	fetching      bool
+	pager         *uiPager
+	confirm       *uiConfirm
+	width, height int
+
+	// Background work reports back through these, so the UI state is only
+	// touched from the event loop
+	fetched  chan []Issue
+	fetchErr chan error
+	notes    chan string
+}
+
+// RunUI runs the interactive terminal UI until the user quits
+func RunUI(cfg *Config) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// Raw mode, on a Unix terminal or the Windows console alike; Ctrl+C
+	// then arrives as a key
+	in := int(os.Stdin.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("factory ui needs an interactive terminal")
+	}
+	restoreOutput, err := enableANSI()
+	if err != nil {
+		return fmt.Errorf("factory ui needs a terminal that handles ANSI escapes: %w", err)
+	}
+	defer restoreOutput()
+	saved, err := term.MakeRaw(in)
+	if err != nil {
+		return fmt.Errorf("factory ui needs an interactive terminal: %w", err)
+	}
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer func() {
+		fmt.Print(ansiShowCursor + ansiMainScreen)
+		term.Restore(in, saved)
+	}()
+
+	u := &ui{
+		cfg:      cfg,
+		exe:      exe,
+		fetched:  make(chan []Issue, 1),
+		fetchErr: make(chan error, 1),
+		notes:    make(chan string, 16),
+	}
+	keys := make(chan string)
+	go readKeys(keys)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	ticker := time.NewTicker(uiTick)
+	defer ticker.Stop()
+
+	u.fetchAssigned()
+	u.reload()
+	for {
+		u.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !u.handleKey(key) {
+				return nil
+			}
+		case issues := <-u.fetched:
+			u.fetching = false
+			u.assigned, u.assignedErr, u.assignedAt = issues, nil, time.Now()
+			u.reload()
+		case err := <-u.fetchErr:
+			u.fetching = false
+			u.assignedErr, u.assignedAt = err, time.Now()
+			u.reload()
+		case note := <-u.notes:
+			u.message = note
+			u.reload()
+		case <-ticker.C:
+			if time.Since(u.assignedAt) > uiAssignedRefresh {
+				u.fetchAssigned()
+			}
+			u.reload()
+		case <-sigs:
+			return nil
+		}
+	}
+}
+
+// readKeys turns terminal input into key names: "up", "down", "left",
+// "right", "pgup", "pgdn", "esc", "tab", "enter", "ctrl-c", or the
+// character typed
+func readKeys(keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		in := string(buf[:n])
+		switch in {
+		case "\x1b[A", "\x1bOA":
+			keys <- "up"
+		case "\x1b[B", "\x1bOB":
+			keys <- "down"
+		case "\x1b[C", "\x1bOC":
+			keys <- "right"
+		case "\x1b[D", "\x1bOD":
+			keys <- "left"
+		case "\x1b[5~":
+			keys <- "pgup"
+		case "\x1b[6~":
+			keys <- "pgdn"
+		case "\x1b":
+			keys <- "esc"
+		case "\t":
+			keys <- "tab"
+		case "\r", "\n":
+			keys <- "enter"
+		case "\x03":
+			keys <- "ctrl-c"
+		default:
+			if strings.HasPrefix(in, "\x1b") {
+				continue // other escape sequences
+			}
+			for _, r := range in {
+				keys <- string(r)
+			}
+		}
+	}
+}
+
+// fetchAssigned re-fetches assigned issues in the background
+func (u *ui) fetchAssigned() {
+	if u.fetching {
+		return
+	}
+	u.fetching = true
+	u.assignedAt = time.Now()
+	go func() {
+		issues, err := GetAssignedIssues(u.cfg)
+		if err != nil {
+			u.fetchErr <- err
+			return
+		}
+		u.fetched <- issues
+	}()
+}
+
+// reload rebuilds every tab from local state and the last fetch
+func (u *ui) reload() {
+	if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		u.width, u.height = width, height
+	}
+	if u.width <= 0 || u.height <= 0 {
+		u.width, u.height = 80, 24
+	}
+
+	loadProcessed()
+	queue := loadQueue()
+	leases := activeLeases(u.cfg)
+	running := map[string]bool{}
+	for _, l := range leases {
+		running[l.IssueKey] = true
+	}
+
+	var issues []uiRow
+	for _, issue := range u.assigned {
+		state := issueState(issue.Key, queue)
+		if running[issue.Key] {
+			state = "running"
+		}
+		issues = append(issues, uiRow{Key: issue.Key, Text: fmt.Sprintf("%-12s %-8s %-9s %-14s %-12s %s",
+			issue.Key, orDash(issue.Type), orDash(issue.Priority), orDash(issue.Status), state, issue.Title)})
+	}
+
+	var runs []uiRow
+	for _, l := range leases {
+		step := "starting"
+		if l.Progress != nil {
+			step = l.Progress.String()
+		}
+		runs = append(runs, uiRow{Key: l.IssueKey, Text: fmt.Sprintf("%-12s %-8s %s",
+			l.IssueKey, time.Since(l.AcquiredAt).Round(time.Second), step)})
+	}
+
+	var history []uiRow
+	keys := make([]string, 0, len(processed))
+	for key := range processed {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return processed[keys[i]].ProcessedAt > processed[keys[j]].ProcessedAt })
+	for _, key := range keys {
+		info := processed[key]
+		status := info.Status
+		if info.Draft {
+			status += " (draft)"
+		}
+		detail := info.PRUrl
+		if detail == "" {
+			detail = info.Error
+		}
+		t, _ := time.Parse(time.RFC3339, info.ProcessedAt)
+		history = append(history, uiRow{Key: key, Status: info.Status, Draft: info.Draft,
+			Text: fmt.Sprintf("%-12s %-12s %-17s %s", key, t.Format("Jan 02 15:04"), status, detail)})
+	}
+
+	var packets []uiRow
+	paths, _ := filepath.Glob(filepath.Join(packetDir(u.cfg), "*.tar.gz"))
+	modTime := func(path string) time.Time {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	sort.Slice(paths, func(i, j int) bool { return modTime(paths[i]).After(modTime(paths[j])) })
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".tar.gz")
+		key := name
+		// KEY-YYYYMMDD-HHMMSS
+		if len(name) > len("-20060102-150405") {
+			key = name[:len(name)-len("-20060102-150405")]
+		}
+		size := int64(0)
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+		packets = append(packets, uiRow{Key: key, Packet: path,
+			Text: fmt.Sprintf("%-12s %-40s %6dKB", key, filepath.Base(path), (size+1023)/1024)})
+	}
+
+	u.rows = [4][]uiRow{issues, runs, history, packets}
+	for tab := range u.cursor {
+		if u.cursor[tab] >= len(u.rows[tab]) {
+			u.cursor[tab] = len(u.rows[tab]) - 1
+		}
+		if u.cursor[tab] < 0 {
+			u.cursor[tab] = 0
+		}
+	}
+
+	daemon := "daemon stopped"
+	if pid := GetDaemonPid(); pid > 0 && isRunning(pid) {
+		daemon = fmt.Sprintf("daemon running (PID %d)", pid)
+	}
+	u.header = fmt.Sprintf("factory ui · %s · %d assigned, %d running, %d processed, %d packet(s)",
+		daemon, len(issues), len(runs), len(history), len(packets))
+
+	if u.pager != nil && u.pager.Path != "" {
+		u.pager.Lines = readLines(u.pager.Path)
+	}
+}
+
+func readLines(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+// selected returns the row under the cursor on the current tab
+func (u *ui) selected() (uiRow, bool) {
+	rows := u.rows[u.tab]
+	if len(rows) == 0 {
+		return uiRow{}, false
+	}
+	return rows[u.cursor[u.tab]], true
+}
+
+// handleKey applies a key press and reports whether the UI should keep
+// running
+func (u *ui) handleKey(key string) bool {
+	if key == "ctrl-c" {
+		return false
+	}
+	if u.confirm != nil {
+		c := u.confirm
+		u.confirm = nil
+		if key == "y" || key == "Y" {
+			c.Run()
+		} else {
+			u.message = "Cancelled"
+		}
+		return true
+	}
+	if u.pager != nil {
+		u.pagerKey(key)
+		return true
+	}
+
+	u.message = ""
+	page := u.listHeight()
+	switch key {
+	case "q":
+		return false
+	case "right", "tab":
+		u.tab = (u.tab + 1) % len(uiTabNames)
+	case "left":
+		u.tab = (u.tab + len(uiTabNames) - 1) % len(uiTabNames)
+	case "1", "2", "3", "4":
+		u.tab = int(key[0] - '1')
+	case "up", "k":
+		u.moveCursor(-1)
+	case "down", "j":
+		u.moveCursor(1)
+	case "pgup":
+		u.moveCursor(-page)
+	case "pgdn":
+		u.moveCursor(page)
+	case "R":
+		u.fetchAssigned()
+		u.message = "Refreshing assigned issues..."
+	case "t":
+		if row, ok := u.selected(); ok && u.tab == uiTabIssues {
+			u.trigger("trigger", row.Key)
+		}
+	case "r":
+		if row, ok := u.selected(); ok && u.tab == uiTabHistory {
+			if row.Status == "completed" {
+				u.message = row.Key + " completed; clear it with `factory clear` to run it again"
+				break
+			}
+			u.confirm = &uiConfirm{Prompt: fmt.Sprintf("Retry %s? (y/n)", row.Key), Run: func() {
+				// A failed run is picked up where it stopped; others start over
+				if row.Status == "failed" {
+					u.trigger("retry", row.Key)
+					return
+				}
+				forgetProcessed(row.Key)
+				u.trigger("trigger", row.Key)
+			}}
+		}
+	case "x":
+		if row, ok := u.selected(); ok && u.tab == uiTabRunning {
+			u.confirm = &uiConfirm{Prompt: fmt.Sprintf("Cancel %s's run and discard its changes? (y/n)", row.Key), Run: func() {
+				u.runAction("Cancelling "+row.Key+"...", "cancel", row.Key)
+			}}
+		}
+	case "l", "enter":
+		if u.tab == uiTabPackets {
+			u.showDiff()
+			break
+		}
+		if row, ok := u.selected(); ok {
+			u.pager = &uiPager{Title: "Log: " + row.Key, Path: GetRunLogPath(row.Key), Follow: true}
+			u.pager.Lines = readLines(u.pager.Path)
+		}
+	case "d":
+		switch u.tab {
+		case uiTabPackets:
+			u.showDiff()
+		case uiTabHistory:
+			if row, ok := u.selected(); ok {
+				u.showRunDiff(row.Key)
+			}
+		}
+	case "a":
+		u.approve()
+	}
+	return true
+}
+
+func (u *ui) moveCursor(delta int) {
+	c := u.cursor[u.tab] + delta
+	if c >= len(u.rows[u.tab]) {
+		c = len(u.rows[u.tab]) - 1
+	}
+	if c < 0 {
+		c = 0
+	}
+	u.cursor[u.tab] = c
+}
+
+func (u *ui) pagerKey(key string) {
+	p := u.pager
+	page := u.height - 3
+	switch key {
+	case "q", "esc":
+		u.pager = nil
+		return
+	case "up", "k":
+		p.Offset--
+		p.Follow = false
+	case "down", "j":
+		p.Offset++
+	case "pgup":
+		p.Offset -= page
+		p.Follow = false
+	case "pgdn", " ":
+		p.Offset += page
+	case "g":
+		p.Offset = 0
+		p.Follow = false
+	case "G":
+		p.Follow = true
+	}
+}
+
+// trigger processes an issue in a detached `factory trigger` or `factory
+// retry`, so the run outlives the UI; its output goes to the issue's run log
+func (u *ui) trigger(command, issueKey string) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		u.message = err.Error()
+		return
+	}
+	defer devNull.Close()
+	cmd := exec.Command(u.exe, command, issueKey)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = devNull, devNull, devNull
+	cmd.SysProcAttr = detachAttrs()
+	if err := cmd.Start(); err != nil {
+		u.message = fmt.Sprintf("Could not %s %s: %v", command, issueKey, err)
+		return
+	}
+	go cmd.Wait()
+	u.message = fmt.Sprintf("Triggered %s; press l to follow its log", issueKey)
+}
+
+// approve marks a draft PR ready (History) or applies a review packet
+// (Packets), after confirmation
+func (u *ui) approve() {
+	row, ok := u.selected()
+	if !ok {
+		return
+	}
+	switch {
+	case u.tab == uiTabHistory && row.Draft:
+		u.confirm = &uiConfirm{Prompt: fmt.Sprintf("Mark %s's draft PR ready for review? (y/n)", row.Key), Run: func() {
+			u.runAction("Marking "+row.Key+" ready...", "ready", row.Key)
+		}}
+	case u.tab == uiTabPackets:
+		// The digest shown is the one applied, so check it against the
+		// approved one before confirming
+		sha := fileSHA256(row.Packet)
+		u.confirm = &uiConfirm{Prompt: fmt.Sprintf("Apply %s (sha256 %s) and open its PR? (y/n)", filepath.Base(row.Packet), sha), Run: func() {
+			u.runAction("Applying "+filepath.Base(row.Packet)+"...", "apply-packet", "--sha256", sha, row.Packet)
+		}}
+	case u.tab == uiTabHistory:
+		u.message = row.Key + " has no draft PR to approve"
+	}
+}
+
+// runAction runs a factory command in the background and shows the last
+// line of its output when it finishes
+func (u *ui) runAction(pending string, args ...string) {
+	u.message = pending
+	go func() {
+		out, err := exec.Command(u.exe, args...).CombinedOutput()
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		last := lines[len(lines)-1]
+		if err != nil && last == "" {
+			last = err.Error()
+		}
+		u.notes <- last
+	}()
+}
+
+// showDiff pages through the selected packet's patch
+func (u *ui) showDiff() {
+	row, ok := u.selected()
+	if !ok {
+		return
+	}
+	files, err := readPacket(row.Packet)
+	if err != nil {
+		u.message = err.Error()
+		return
+	}
+	patch := strings.TrimRight(string(files[packetPatchFile]), "\n")
+	u.pager = &uiPager{Title: "Diff: " + filepath.Base(row.Packet), Lines: strings.Split(patch, "\n")}
+}
+
+// showRunDiff pages through the change of the issue's latest run
+func (u *ui) showRunDiff(key string) {
+	data, err := os.ReadFile(GetDiffPath(key))
+	if err != nil {
+		u.message = "No diff recorded for " + key
+		return
+	}
+	patch := strings.TrimRight(string(data), "\n")
+	u.pager = &uiPager{Title: "Diff: " + key, Lines: strings.Split(patch, "\n")}
+}
+
+// listHeight is the number of rows a tab can show
+func (u *ui) listHeight() int {
+	// header, tabs, column titles, message, help
+	return u.height - 5
+}
+
+func (u *ui) draw() {
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	line := func(s string) {
+		b.WriteString(clipLine(s, u.width))
+		b.WriteString("\r\n") // raw mode doesn't add the carriage return
+	}
+
+	if u.pager != nil {
+		u.drawPager(&b, line)
+		fmt.Print(b.String())
+		return
+	}
+
+	line(ansiBold + u.header + ansiReset)
+	var tabs []string
+	for i, name := range uiTabNames {
+		label := fmt.Sprintf(" %d %s (%d) ", i+1, name, len(u.rows[i]))
+		if i == u.tab {
+			label = ansiReverse + label + ansiReset
+		}
+		tabs = append(tabs, label)
+	}
+	line(strings.Join(tabs, " "))
+
+	columns := []string{
+		fmt.Sprintf("  %-12s %-8s %-9s %-14s %-12s %s", "Issue", "Type", "Priority", "Status", "Factory", "Title"),
+		fmt.Sprintf("  %-12s %-8s %s", "Issue", "Elapsed", "Progress"),
+		fmt.Sprintf("  %-12s %-12s %-17s %s", "Issue", "When", "Status", "PR/Error"),
+		fmt.Sprintf("  %-12s %-40s %8s", "Issue", "Packet", "Size"),
+	}
+	line(ansiDim + columns[u.tab] + ansiReset)
+
+	rows := u.rows[u.tab]
+	height := u.listHeight()
+	start := 0
+	if c := u.cursor[u.tab]; c >= height {
+		start = c - height + 1
+	}
+	for i := start; i < start+height; i++ {
+		switch {
+		case i < len(rows) && i == u.cursor[u.tab]:
+			line(ansiReverse + "> " + rows[i].Text + ansiReset)
+		case i < len(rows):
+			line("  " + rows[i].Text)
+		case i == 0 && u.tab == uiTabIssues && u.assignedErr != nil:
+			line("  Error fetching issues: " + u.assignedErr.Error())
+		case i == 0 && u.tab == uiTabIssues && u.fetching:
+			line("  Fetching assigned issues...")
+		case i == 0:
+			line("  Nothing here")
+		default:
+			line("")
+		}
+	}
+
+	switch {
+	case u.confirm != nil:
+		line(ansiBold + u.confirm.Prompt + ansiReset)
+	default:
+		line(u.message)
+	}
+	help := map[int]string{
+		uiTabIssues:  "t trigger  l log",
+		uiTabRunning: "x cancel  l log",
+		uiTabHistory: "r retry  a ready draft PR  l log  d diff",
+		uiTabPackets: "d diff  a apply",
+	}[u.tab]
+	b.WriteString(clipLine(ansiDim+help+"  ←/→ tabs  ↑/↓ move  R refresh  q quit"+ansiReset, u.width))
+	fmt.Print(b.String())
+}
+
+func (u *ui) drawPager(b *strings.Builder, line func(string)) {
+	p := u.pager
+	page := u.height - 2
+	last := len(p.Lines) - page
+	if last < 0 {
+		last = 0
+	}
+	if p.Follow || p.Offset > last {
+		p.Offset = last
+	}
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	follow := ""
+	if p.Path != "" && p.Follow {
+		follow = " · following"
+	}
+	end := p.Offset + page
+	if end > len(p.Lines) {
+		end = len(p.Lines)
+	}
+	line(ansiBold + fmt.Sprintf("%s  (lines %d-%d of %d%s)", p.Title, p.Offset+1, end, len(p.Lines), follow) + ansiReset)
+	for i := p.Offset; i < p.Offset+page; i++ {
+		if i < len(p.Lines) {
+			line(p.Lines[i])
+		} else {
+			line("")
+		}
+	}
+	b.WriteString(clipLine(ansiDim+"↑/↓ PgUp/PgDn scroll  g top  G end/follow  q back"+ansiReset, u.width))
+}
+
+// clipLine cuts s to width visible characters, skipping ANSI escapes when
+// counting, and resets attributes if it cut anything
+func clipLine(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	escape := false
+	for _, r := range strings.ReplaceAll(s, "\t", "    ") {
+		switch {
+		case escape:
+			b.WriteRune(r)
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+				escape = false
+			}
+			continue
+		case r == '\x1b':
+			escape = true
+			b.WriteRune(r)
+			continue
+		case r < ' ':
+			continue
+		}
+		if visible >= width {
+			b.WriteString(ansiReset)
+			break
+		}
+		b.WriteRune(r)
+		visible++
+	}
+	return b.String() + "\x1b[K"
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package internal
+
+// enableANSI is a no-op; Unix terminals interpret ANSI escapes already
+func enableANSI() (restore func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// enableANSI has the console interpret the UI's ANSI escapes, which older
+// Windows consoles print as they are unless asked
+func enableANSI() (restore func(), err error) {
+	out := windows.Handle(os.Stdout.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(out, &mode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(out, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		return nil, err
+	}
+	return func() { windows.SetConsoleMode(out, mode) }, nil
+}
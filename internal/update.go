@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reviewComment is feedback left on a PR: a review's summary, or a comment
+// on a line of the diff
+type reviewComment struct {
+	Author string
+	Body   string
+	Path   string // file a line comment is on
+	Line   int
+	Date   time.Time
+}
+
+// getReviewComments returns the reviews and line comments on a PR, oldest
+// first
+func getReviewComments(cfg *Config, prURL string) ([]reviewComment, error) {
+	num, err := prNumber(prURL)
+	if err != nil {
+		return nil, err
+	}
+	repo := fmt.Sprintf("/repos/%s/%s/pulls/%s", cfg.GitHub.Owner, cfg.GitHub.Repo, num)
+
+	var comments []reviewComment
+	for page := 1; ; page++ {
+		body, err := githubGet(cfg, fmt.Sprintf("%s/reviews?per_page=100&page=%d", repo, page))
+		if err != nil {
+			return nil, err
+		}
+		var reviews []struct {
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			Body        string    `json:"body"`
+			SubmittedAt time.Time `json:"submitted_at"`
+		}
+		if err := json.Unmarshal(body, &reviews); err != nil {
+			return nil, err
+		}
+		for _, r := range reviews {
+			if strings.TrimSpace(r.Body) != "" {
+				comments = append(comments, reviewComment{Author: r.User.Login, Body: r.Body, Date: r.SubmittedAt})
+			}
+		}
+		if len(reviews) < 100 {
+			break
+		}
+	}
+
+	for page := 1; ; page++ {
+		body, err := githubGet(cfg, fmt.Sprintf("%s/comments?per_page=100&page=%d", repo, page))
+		if err != nil {
+			return nil, err
+		}
+		var lines []struct {
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+			Body         string    `json:"body"`
+			Path         string    `json:"path"`
+			Line         int       `json:"line"`
+			OriginalLine int       `json:"original_line"`
+			CreatedAt    time.Time `json:"created_at"`
+		}
+		if err := json.Unmarshal(body, &lines); err != nil {
+			return nil, err
+		}
+		for _, c := range lines {
+			line := c.Line
+			if line == 0 {
+				line = c.OriginalLine // on code the PR has changed since
+			}
+			comments = append(comments, reviewComment{Author: c.User.Login, Body: c.Body, Path: c.Path, Line: line, Date: c.CreatedAt})
+		}
+		if len(lines) < 100 {
+			break
+		}
+	}
+
+	sort.SliceStable(comments, func(i, j int) bool { return comments[i].Date.Before(comments[j].Date) })
+	return comments, nil
+}
+
+// latestReview narrows comments to those since the branch's last commit,
+// the feedback the PR hasn't addressed yet. If there are none, all of them
+// are kept.
+func latestReview(comments []reviewComment, lastCommit time.Time) []reviewComment {
+	var latest []reviewComment
+	for _, c := range comments {
+		if c.Date.After(lastCommit) {
+			latest = append(latest, c)
+		}
+	}
+	if len(latest) == 0 {
+		return comments
+	}
+	return latest
+}
+
+// updateNote is the prompt section for a run adding to an open PR
+func updateNote(pr *issuePR, comments []reviewComment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\n## Open PR\nThis issue already has a PR, %s, and its branch is checked out. "+
+		"Make follow-up changes on top of it; don't redo the work that is there.\n", pr.URL)
+	if len(comments) == 0 {
+		b.WriteString("\nThe PR has no review comments yet.\n")
+		return b.String()
+	}
+	b.WriteString("\nAddress the review comments:\n")
+	for _, c := range comments {
+		where := ""
+		if c.Path != "" {
+			where = fmt.Sprintf(" on `%s:%d`", c.Path, c.Line)
+		}
+		fmt.Fprintf(&b, "\n**%s**%s:\n%s\n", c.Author, where, strings.TrimSpace(c.Body))
+	}
+	return b.String()
+}
+
+// updatePR reports the follow-up commit pushed to the issue's open PR
+func updatePR(cfg *Config, git *Git, issue *Issue, pr *issuePR, hook hookContext, result *Result, agentTime time.Duration) *Result {
+	hook.PRURL = pr.URL
+	if err := runHook(cfg, HookPostPR, hook); err != nil {
+		fmt.Printf("  Warning: %v\n", err)
+	}
+
+	fmt.Println("→ Updating Jira...")
+	result.enterStage("jira")
+	if err := AddComment(cfg, issue.Key, fmt.Sprintf("factory: pushed follow-up changes to %s", pr.URL)); err != nil {
+		fmt.Printf("  Warning: could not comment on %s: %v\n", issue.Key, err)
+	}
+	if agentTime > 0 {
+		writeEffort(cfg, git, issue.Key, agentTime)
+	}
+	result.Status = "completed"
+	fmt.Printf("\n✓ Completed: %s (updated %s)\n", issue.Key, pr.URL)
+	return result
+}
@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultVerifyAttempts is how many times the agent gets to make the
+	// checks pass, counting its first run
+	defaultVerifyAttempts = 3
+	// verifyTimeout bounds one run of a check command
+	verifyTimeout = 20 * time.Minute
+	// feedbackLines is how much of a check's output the agent sees
+	feedbackLines = 200
+)
+
+func (v VerifyConfig) maxAttempts() int {
+	if v.MaxAttempts <= 0 {
+		return defaultVerifyAttempts
+	}
+	return v.MaxAttempts
+}
+
+// verifyCheck is one command the agent's changes must pass
+type verifyCheck struct {
+	Name    string // build, lint, or test
+	Command string
+}
+
+// detectedChecks are the "auto" build and lint commands, keyed by the file
+// that identifies the project's language
+var detectedChecks = []struct {
+	manifest    string
+	build, lint string
+}{
+	{"go.mod", "go build ./...", "go vet ./..."},
+	{"Cargo.toml", "cargo build --quiet", "cargo clippy --quiet -- -D warnings"},
+	{"package.json", "npm run build --if-present", "npm run lint --if-present"},
+	{"pyproject.toml", "python -m compileall -q .", ""},
+	{"setup.py", "python -m compileall -q .", ""},
+	{"pom.xml", "mvn -q -DskipTests package", ""},
+	{"build.gradle.kts", "./gradlew assemble -q", ""},
+	{"build.gradle", "./gradlew assemble -q", ""},
+}
+
+// verifyChecks returns the configured checks in the order they run,
+// resolving "auto" from the project files in the workspace
+func verifyChecks(cfg *Config, repoPath string) []verifyCheck {
+	build, lint := cfg.Verify.BuildCommand, cfg.Verify.LintCommand
+	if build == "auto" || lint == "auto" {
+		var detectedBuild, detectedLint string
+		for _, d := range detectedChecks {
+			if _, err := os.Stat(filepath.Join(repoPath, d.manifest)); err == nil {
+				detectedBuild, detectedLint = d.build, d.lint
+				break
+			}
+		}
+		if build == "auto" {
+			build = detectedBuild
+		}
+		if lint == "auto" {
+			lint = detectedLint
+		}
+	}
+
+	var checks []verifyCheck
+	for _, c := range []verifyCheck{{"build", build}, {"lint", lint}, {"test", cfg.Verify.TestCommand}} {
+		if c.Command != "" {
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+// checkFailure is a check that kept failing; it carries the full output so
+// it can be attached to the issue
+type checkFailure struct {
+	Check    verifyCheck
+	Output   string
+	Attempts int
+	Err      error
+}
+
+func (f *checkFailure) Error() string {
+	return fmt.Sprintf("%s (%s) still failing after %d attempt(s) (%v):\n%s",
+		f.Check.Name, f.Check.Command, f.Attempts, f.Err, lastLines(f.Output, 20))
+}
+
+// runCheck runs a check's command in the workspace, echoing its output and
+// returning it
+func runCheck(c verifyCheck, repoPath string) (string, error) {
+	fmt.Printf("→ Running %s: %s\n", c.Name, c.Command)
+	ctx, cancel := context.WithTimeout(context.Background(), verifyTimeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := shellCommand(ctx, c.Command)
+	cmd.Dir = repoPath
+	cmd.Stdout = io.MultiWriter(os.Stdout, &out)
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return out.String(), fmt.Errorf("%s timed out after %s", c.Name, verifyTimeout)
+	}
+	return out.String(), err
+}
+
+// runChecks runs the checks in order and stops at the first failure
+func runChecks(checks []verifyCheck, repoPath string) (*verifyCheck, string, error) {
+	for i := range checks {
+		if output, err := runCheck(checks[i], repoPath); err != nil {
+			return &checks[i], output, err
+		}
+	}
+	return nil, "", nil
+}
+
+// attachCheckOutput uploads a failing check's full output to the issue so
+// it can be read without access to the machine running factory
+func attachCheckOutput(cfg *Config, issueKey string, f *checkFailure) {
+	name := fmt.Sprintf("factory-%s-%s.log", issueKey, f.Check.Name)
+	data := fmt.Sprintf("$ %s\n\n%s", f.Check.Command, f.Output)
+	err := AddAttachmentREST(cfg, issueKey, name, []byte(data))
+	recordAudit(actorOf(cfg.jiraSite(issueKey)), "jira.attachment", issueKey, issueKey, name, err)
+	if err != nil {
+		fmt.Printf("  Warning: could not attach %s output: %v\n", f.Check.Name, err)
+	}
+}
+
+// lastLines keeps the end of the output, where compilers and test runners
+// put failures and summaries
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = append([]string{fmt.Sprintf("... (%d lines omitted)", len(lines)-n)}, lines[len(lines)-n:]...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkFeedback extends the prompt with the failing check's output for the
+// agent's next attempt
+func checkFeedback(prompt string, c verifyCheck, output string) string {
+	return prompt + fmt.Sprintf(`
+
+## %s Failures
+Your changes are in the working tree, but `+"`%s`"+` fails:
+
+`+"```"+`
+%s
+`+"```"+`
+
+Fix the failures without weakening or deleting tests or lint rules, unless
+the issue asks for the checked behavior to change.`, strings.ToUpper(c.Name[:1])+c.Name[1:], c.Command, lastLines(output, feedbackLines))
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	wikiHeading   = regexp.MustCompile(`^h([1-6])\.\s+(.*)$`)
+	wikiQuote     = regexp.MustCompile(`^\{quote\}(.*)$`)
+	wikiPanel     = regexp.MustCompile(`\{panel(?::[^}]*)?\}`)
+	wikiColor     = regexp.MustCompile(`\{color(?::[^}]*)?\}`)
+	wikiImage     = regexp.MustCompile(`!([^!\s|]+)(?:\|[^!]*)?!`)
+	wikiAnchor    = regexp.MustCompile(`\{anchor:[^}]*\}`)
+	wikiUser      = regexp.MustCompile(`\[~([^\]]+)\]`)
+	wikiBreak     = regexp.MustCompile(`[ \t]*\\\\[ \t]*`)
+	wikiCodeStart = regexp.MustCompile(`^\{(code|noformat)(?::([^}]*))?\}(.*)$`)
+	wikiList      = regexp.MustCompile(`^([*#-]+)\s+(.*)$`)
+	wikiLink      = regexp.MustCompile(`\[([^|\]]+)\|([^\]]+)\]`)
+	wikiBareLink  = regexp.MustCompile(`\[(https?://[^\]]+)\]`)
+	wikiMono      = regexp.MustCompile(`\{\{(.+?)\}\}`)
+	wikiBold      = regexp.MustCompile(`(^|[\s(])\*([^*\s](?:[^*]*[^*\s])?)\*`)
+	wikiItalic    = regexp.MustCompile(`(^|[\s(])_([^_\s](?:[^_]*[^_\s])?)_`)
+	wikiStrike    = regexp.MustCompile(`(^|[\s(])-([^-\s](?:[^-]*[^-\s])?)-([\s).,]|$)`)
+)
+
+// jiraToMarkdown converts Jira wiki markup (as used by Jira Server and the
+// go-jira CLI) to Markdown
+func jiraToMarkdown(s string) string {
+	var out []string
+	var fence string // closing tag of the open code block, if any
+	inQuote := false
+	inTable := false
+
+	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence == "" && inTable && !strings.HasPrefix(trimmed, "|") {
+			// Markdown tables run on until a blank line
+			inTable = false
+			if trimmed != "" {
+				out = append(out, "")
+			}
+		}
+
+		if fence != "" {
+			if i := strings.Index(line, fence); i >= 0 {
+				if before := line[:i]; strings.TrimSpace(before) != "" {
+					out = append(out, before)
+				}
+				out = append(out, "```")
+				fence = ""
+				continue
+			}
+			out = append(out, line)
+			continue
+		}
+
+		if m := wikiCodeStart.FindStringSubmatch(trimmed); m != nil {
+			lang := strings.Split(m[2], "|")[0]
+			if strings.Contains(lang, "=") {
+				lang = "" // only options like title=..., no language
+			}
+			fence = "{" + m[1] + "}"
+			out = append(out, "```"+lang)
+			rest := m[3]
+			if i := strings.Index(rest, fence); i >= 0 {
+				if rest[:i] != "" {
+					out = append(out, rest[:i])
+				}
+				out = append(out, "```")
+				fence = ""
+			} else if rest != "" {
+				out = append(out, rest)
+			}
+			continue
+		}
+
+		// {quote} blocks: every line until the closing tag becomes "> ..."
+		if m := wikiQuote.FindStringSubmatch(trimmed); m != nil || (inQuote && strings.Contains(trimmed, "{quote}")) {
+			rest := trimmed
+			if m != nil && !inQuote {
+				rest = m[1]
+				inQuote = true
+			}
+			if i := strings.Index(rest, "{quote}"); i >= 0 {
+				if before := strings.TrimSpace(rest[:i]); before != "" {
+					out = append(out, "> "+jiraInline(before))
+				}
+				inQuote = false
+			} else if rest != "" {
+				out = append(out, "> "+jiraInline(rest))
+			}
+			continue
+		}
+		if inQuote {
+			out = append(out, strings.TrimRight("> "+jiraInline(line), " "))
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "||") {
+			cells := splitTableRow(trimmed, "||")
+			out = append(out, "| "+strings.Join(cells, " | ")+" |")
+			out = append(out, "|"+strings.Repeat(" --- |", len(cells)))
+			inTable = true
+			continue
+		}
+		if strings.HasPrefix(trimmed, "|") && strings.HasSuffix(trimmed, "|") {
+			cells := splitTableRow(trimmed, "|")
+			if !inTable {
+				// Markdown tables need a header row; use an empty one
+				out = append(out, "|"+strings.Repeat("   |", len(cells)))
+				out = append(out, "|"+strings.Repeat(" --- |", len(cells)))
+				inTable = true
+			}
+			out = append(out, "| "+strings.Join(cells, " | ")+" |")
+			continue
+		}
+
+		if trimmed == "----" {
+			out = append(out, "---")
+			continue
+		}
+
+		if m := wikiHeading.FindStringSubmatch(trimmed); m != nil {
+			out = append(out, strings.Repeat("#", int(m[1][0]-'0'))+" "+jiraInline(m[2]))
+			continue
+		}
+
+		if m := wikiList.FindStringSubmatch(trimmed); m != nil {
+			marker := m[1]
+			indent := strings.Repeat("  ", len(marker)-1)
+			bullet := "- "
+			if marker[len(marker)-1] == '#' {
+				bullet = "1. "
+			}
+			out = append(out, indent+bullet+jiraInline(m[2]))
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "bq. ") {
+			out = append(out, "> "+jiraInline(trimmed[4:]))
+			continue
+		}
+
+		out = append(out, jiraInline(line))
+	}
+	if fence != "" {
+		out = append(out, "```")
+	}
+	return strings.Join(out, "\n")
+}
+
+// splitTableRow splits a wiki table row on sep, keeping links like
+// [text|url] intact
+func splitTableRow(row, sep string) []string {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, sep), sep)
+	var cells []string
+	depth, start := 0, 0
+	for i := 0; i < len(row); i++ {
+		switch {
+		case row[i] == '[' || row[i] == '{':
+			depth++
+		case (row[i] == ']' || row[i] == '}') && depth > 0:
+			depth--
+		case depth == 0 && strings.HasPrefix(row[i:], sep):
+			cells = append(cells, jiraInline(strings.TrimSpace(row[start:i])))
+			i += len(sep) - 1
+			start = i + 1
+		}
+	}
+	cells = append(cells, jiraInline(strings.TrimSpace(row[start:])))
+	for i, c := range cells {
+		cells[i] = strings.ReplaceAll(c, "|", "\\|")
+	}
+	return cells
+}
+
+// jiraInline converts inline wiki markup within a single line
+func jiraInline(s string) string {
+	s = wikiPanel.ReplaceAllString(s, "")
+	s = wikiColor.ReplaceAllString(s, "")
+	s = wikiAnchor.ReplaceAllString(s, "")
+	s = wikiBreak.ReplaceAllString(s, "  \n")
+	s = wikiUser.ReplaceAllString(s, "@$1")
+	s = wikiImage.ReplaceAllString(s, "![$1]($1)")
+	s = wikiMono.ReplaceAllString(s, "`$1`")
+	s = wikiLink.ReplaceAllString(s, "[$1]($2)")
+	s = wikiBareLink.ReplaceAllString(s, "<$1>")
+	s = wikiBold.ReplaceAllString(s, "$1**$2**")
+	s = wikiItalic.ReplaceAllString(s, "$1*$2*")
+	s = wikiStrike.ReplaceAllString(s, "$1~~$2~~$3")
+	return s
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/imaravin/factory/internal"
 )
@@ -27,7 +30,10 @@ func main() {
 		if !internal.ConfigExists() {
 			fatal(fmt.Errorf("not configured. Run: factory configure"))
 		}
-		if err := internal.StartDaemon(); err != nil {
+		fs := flag.NewFlagSet("start", flag.ExitOnError)
+		foreground := fs.Bool("foreground", false, "run in this process instead of detaching")
+		fs.Parse(os.Args[2:])
+		if err := internal.StartDaemon(*foreground); err != nil {
 			fatal(err)
 		}
 
@@ -37,11 +43,93 @@ func main() {
 		}
 
 	case "status":
-		internal.ShowStatus()
+		fs := flag.NewFlagSet("status", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print machine-readable JSON")
+		fs.Parse(os.Args[2:])
+		if err := internal.ShowStatus(*asJSON); err != nil {
+			fatal(err)
+		}
+
+	case "list":
+		if !internal.ConfigExists() {
+			fatal(fmt.Errorf("not configured. Run: factory configure"))
+		}
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print machine-readable JSON")
+		fs.Parse(os.Args[2:])
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if err := internal.ListAssigned(cfg, *asJSON); err != nil {
+			fatal(err)
+		}
+
+	case "history":
+		fs := flag.NewFlagSet("history", flag.ExitOnError)
+		failed := fs.Bool("failed", false, "only failed runs")
+		since := fs.String("since", "", "only runs in this window, e.g. 7d, 2w, or 12h")
+		project := fs.String("project", "", "only issues in this Jira project")
+		sortBy := fs.String("sort", "when", "order by when, key, status, took, or cost")
+		asJSON := fs.Bool("json", false, "print machine-readable JSON")
+		fs.Parse(os.Args[2:])
+		if err := internal.ShowHistory(fs.Arg(0), internal.HistoryOptions{
+			Failed:  *failed,
+			Since:   *since,
+			Project: *project,
+			Sort:    *sortBy,
+			JSON:    *asJSON,
+		}); err != nil {
+			fatal(err)
+		}
+
+	case "audit":
+		fs := flag.NewFlagSet("audit", flag.ExitOnError)
+		issue := fs.String("issue", "", "only actions on this issue")
+		action := fs.String("action", "", "only this action, or a group like jira or github.pr")
+		since := fs.String("since", "", "only actions in this window, e.g. 7d, 2w, or 12h")
+		failed := fs.Bool("failed", false, "only actions that failed")
+		asJSON := fs.Bool("json", false, "print machine-readable JSON")
+		fs.Parse(os.Args[2:])
+		if err := internal.ShowAudit(internal.AuditOptions{
+			Issue:  *issue,
+			Action: *action,
+			Since:  *since,
+			Failed: *failed,
+			JSON:   *asJSON,
+		}); err != nil {
+			fatal(err)
+		}
+
+	case "ui":
+		if !internal.ConfigExists() {
+			fatal(fmt.Errorf("not configured. Run: factory configure"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if err := internal.RunUI(cfg); err != nil {
+			fatal(err)
+		}
 
 	case "trigger":
-		if len(os.Args) < 3 {
-			fatal(fmt.Errorf("usage: factory trigger <ISSUE-KEY>"))
+		fs := flag.NewFlagSet("trigger", flag.ExitOnError)
+		local := fs.Bool("local", false, "run in this process even if the daemon is running")
+		asJSON := fs.Bool("json", false, "print the result as JSON; run output goes to stderr")
+		base := fs.String("base", "", "branch to work against instead of repo.defaultBranch, e.g. release/2.4")
+		update := fs.Bool("update", false, "add commits to the issue's open PR, addressing its review comments")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory trigger [--local] [--json] [--base BRANCH] [--update] <ISSUE-KEY>"))
+		}
+		key := fs.Arg(0)
+		// Flags may also follow the key: factory trigger KEY --base release/2.4
+		fs.Parse(fs.Args()[1:])
+		if *base != "" {
+			if err := internal.ValidateBaseBranch(*base); err != nil {
+				fatal(err)
+			}
 		}
 		if !internal.ConfigExists() {
 			fatal(fmt.Errorf("not configured. Run: factory configure"))
@@ -50,20 +138,388 @@ func main() {
 		if err != nil {
 			fatal(err)
 		}
-		result := internal.ProcessIssue(cfg, os.Args[2])
-		if result.Status != "completed" {
+		opts := internal.RunOptions{Base: *base, Update: *update}
+		if !*local {
+			err := internal.TriggerOnDaemon(key, opts, *asJSON)
+			if err == nil {
+				return
+			}
+			if !internal.IsNoDaemon(err) {
+				fatal(err)
+			}
+		}
+		if err := internal.CheckBudget(cfg); err != nil {
+			fatal(err)
+		}
+		stdout := os.Stdout
+		if *asJSON {
+			os.Stdout = os.Stderr
+		}
+		result := internal.ProcessIssue(cfg, key, opts)
+		internal.RecordResult(result)
+		os.Stdout = stdout
+		if *asJSON {
+			internal.PrintJSON(result)
+		}
+		if result.Status != "completed" && result.Status != "previewed" && result.Status != "planned" {
+			os.Exit(1)
+		}
+
+	case "estimate":
+		fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+		base := fs.String("base", "", "branch to estimate against instead of repo.defaultBranch")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory estimate [--base BRANCH] <ISSUE-KEY>"))
+		}
+		key := fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+		if *base != "" {
+			if err := internal.ValidateBaseBranch(*base); err != nil {
+				fatal(err)
+			}
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		// An estimate isn't a run of the issue; the daemon still picks it up
+		result := internal.ProcessIssue(cfg, key, internal.RunOptions{Base: *base, Estimate: true})
+		if result.Status != "estimated" {
+			os.Exit(1)
+		}
+
+	case "triage":
+		fs := flag.NewFlagSet("triage", flag.ExitOnError)
+		base := fs.String("base", "", "branch to triage against instead of repo.defaultBranch")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory triage [--base BRANCH] <ISSUE-KEY>"))
+		}
+		key := fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+		if *base != "" {
+			if err := internal.ValidateBaseBranch(*base); err != nil {
+				fatal(err)
+			}
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		// Like an estimate, a triage isn't a run of the issue
+		result := internal.ProcessIssue(cfg, key, internal.RunOptions{Base: *base, Triage: true})
+		if result.Status != "triaged" {
+			os.Exit(1)
+		}
+
+	case "review":
+		fs := flag.NewFlagSet("review", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "print the review instead of posting it")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory review [--dry-run] <PR-URL|NUMBER>"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if err := internal.ReviewPR(cfg, fs.Arg(0), *dryRun); err != nil {
+			fatal(err)
+		}
+
+	case "retry":
+		fs := flag.NewFlagSet("retry", flag.ExitOnError)
+		local := fs.Bool("local", false, "run in this process even if the daemon is running")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory retry [--local] <ISSUE-KEY>"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		result, err := internal.RetryIssue(cfg, fs.Arg(0), *local)
+		if err != nil {
+			fatal(err)
+		}
+		if result != nil && result.Status != "completed" && result.Status != "previewed" && result.Status != "planned" {
 			os.Exit(1)
 		}
 
+	case "cancel":
+		if len(os.Args) < 3 {
+			fatal(fmt.Errorf("usage: factory cancel <ISSUE-KEY>"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if err := internal.CancelIssue(cfg, os.Args[2]); err != nil {
+			fatal(err)
+		}
+
 	case "clear":
 		key := ""
 		if len(os.Args) >= 3 {
 			key = os.Args[2]
 		}
-		internal.ClearProcessed(key)
+		if err := internal.ClearProcessed(key); err != nil {
+			fatal(err)
+		}
+
+	case "pause", "resume":
+		if err := internal.PauseDaemon(cmd == "pause"); err != nil {
+			fatal(err)
+		}
+
+	case "reload":
+		if err := internal.ReloadDaemon(); err != nil {
+			fatal(err)
+		}
+
+	case "feedback":
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if len(os.Args) < 3 {
+			internal.ShowFeedback(cfg)
+			break
+		}
+		fs := flag.NewFlagSet("feedback", flag.ExitOnError)
+		grade := fs.String("grade", "", "good or needs-work")
+		notes := fs.String("notes", "", "what was good or needed rework")
+		fs.Parse(os.Args[3:])
+		if err := internal.RecordFeedback(cfg, os.Args[2], *grade, *notes); err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Feedback recorded: %s (%s)\n", os.Args[2], *grade)
+
+	case "service":
+		fs := flag.NewFlagSet("service", flag.ExitOnError)
+		printOnly := fs.Bool("print", false, "with install, print the unit instead of installing it")
+		if len(os.Args) < 3 {
+			fatal(fmt.Errorf("usage: factory service install [--print] | enable | disable | uninstall"))
+		}
+		fs.Parse(os.Args[3:])
+		if err := internal.ManageService(os.Args[2], *printOnly); err != nil {
+			fatal(err)
+		}
+
+	case "queue":
+		var err error
+		switch {
+		case len(os.Args) >= 4 && os.Args[2] == "bump":
+			err = internal.BumpQueue(os.Args[3])
+		case len(os.Args) >= 4 && os.Args[2] == "drop":
+			err = internal.DropQueue(os.Args[3])
+		case len(os.Args) == 2:
+			internal.ShowQueue()
+		default:
+			err = fmt.Errorf("usage: factory queue [bump|drop <ISSUE-KEY>]")
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+	case "ready":
+		if len(os.Args) < 3 {
+			fatal(fmt.Errorf("usage: factory ready <ISSUE-KEY>"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if err := internal.ReadyPR(cfg, os.Args[2]); err != nil {
+			fatal(err)
+		}
+
+	case "template":
+		if len(os.Args) < 4 || os.Args[2] != "test" {
+			fatal(fmt.Errorf("usage: factory template test <NAME|FILE> [ISSUE-KEY]"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		key := ""
+		if len(os.Args) >= 5 {
+			key = os.Args[4]
+		}
+		out, err := internal.TestTemplate(cfg, os.Args[3], key)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(out)
+
+	case "render":
+		fs := flag.NewFlagSet("render", flag.ExitOnError)
+		preset := fs.String("preset", "", "only render this preset (default, smart-commit, custom-types, config)")
+		issue := fs.String("issue", "", "render a real issue with your config instead of the fixtures")
+		golden := fs.String("golden", "", "compare with the golden files in this directory")
+		update := fs.Bool("update", false, "with --golden, rewrite the golden files")
+		fs.Parse(os.Args[2:])
+		var cfg *internal.Config
+		if internal.ConfigExists() {
+			var err error
+			if cfg, err = internal.LoadConfig(); err != nil {
+				fatal(err)
+			}
+		}
+		if err := internal.Render(cfg, internal.RenderOptions{
+			Preset:    *preset,
+			IssueKey:  *issue,
+			GoldenDir: *golden,
+			Update:    *update,
+		}); err != nil {
+			fatal(err)
+		}
+
+	case "selftest":
+		fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+		dir := fs.String("scenarios", "", "run the *.yaml scenarios in this directory instead of the built-in ones")
+		run := fs.String("run", "", "only run scenarios whose name contains this")
+		keep := fs.Bool("keep", false, "keep each scenario's temporary directory and log")
+		fs.Parse(os.Args[2:])
+		if err := internal.Selftest(internal.SelftestOptions{Dir: *dir, Run: *run, Keep: *keep}); err != nil {
+			fatal(err)
+		}
+
+	case "lessons":
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		if len(os.Args) >= 4 && os.Args[2] == "add" {
+			if err := internal.AddLesson(cfg, strings.Join(os.Args[3:], " ")); err != nil {
+				fatal(err)
+			}
+			fmt.Println("Lesson added")
+		} else {
+			internal.ShowLessons(cfg)
+		}
+
+	case "gc":
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		fs := flag.NewFlagSet("gc", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "list what would be deleted without deleting it")
+		fs.Parse(os.Args[2:])
+		result, err := internal.CollectGarbage(cfg, *dryRun)
+		if result != nil {
+			internal.PrintGC(result, *dryRun)
+		}
+		if err != nil {
+			fatal(err)
+		}
+
+	case "watch":
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		fs := flag.NewFlagSet("watch", flag.ExitOnError)
+		server := fs.String("server", "", "daemon API address (default: server.listen)")
+		fs.Parse(os.Args[2:])
+		if err := internal.WatchLogs(cfg, *server, fs.Arg(0)); err != nil {
+			fatal(err)
+		}
+
+	case "report":
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		fs := flag.NewFlagSet("report", flag.ExitOnError)
+		days := fs.Int("days", 7, "report on the last N days")
+		publish := fs.Bool("publish", false, "post to the configured Jira issue / Confluence space")
+		fs.Parse(os.Args[2:])
+		report := internal.BuildReport(time.Now().AddDate(0, 0, -*days))
+		fmt.Print(report.Markdown())
+		if *publish {
+			if err := internal.PublishReport(cfg, report); err != nil {
+				fatal(err)
+			}
+			fmt.Println("\nPublished")
+		}
+
+	case "apply-packet":
+		fs := flag.NewFlagSet("apply-packet", flag.ExitOnError)
+		sha := fs.String("sha256", "", "the packet's SHA-256, as approved")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 || *sha == "" {
+			fatal(fmt.Errorf("usage: factory apply-packet --sha256 <DIGEST> <FILE>"))
+		}
+		cfg, err := internal.LoadConfig()
+		if err != nil {
+			fatal(err)
+		}
+		prURL, err := internal.ApplyPacket(cfg, fs.Arg(0), *sha)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("PR: %s\n", prURL)
+
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		withConfig := fs.Bool("config", false, "include the config, with secrets removed")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory export [--config] <FILE>"))
+		}
+		if err := internal.ExportState(fs.Arg(0), *withConfig); err != nil {
+			fatal(err)
+		}
+
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		replace := fs.Bool("replace", false, "replace local state instead of merging into it")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory import [--replace] <FILE>"))
+		}
+		if err := internal.ImportState(fs.Arg(0), *replace); err != nil {
+			fatal(err)
+		}
 
 	case "logs":
-		internal.TailLogs(50)
+		key := ""
+		if len(os.Args) > 2 {
+			key = os.Args[2]
+		}
+		if err := internal.TailLogs(key, 50); err != nil {
+			fatal(err)
+		}
+
+	case "diff":
+		fs := flag.NewFlagSet("diff", flag.ExitOnError)
+		stat := fs.Bool("stat", false, "only list the files changed and their line counts")
+		color := fs.String("color", "auto", "color the diff: auto, always, or never")
+		delta := fs.Bool("delta", false, "show the diff through delta")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() < 1 {
+			fatal(fmt.Errorf("usage: factory diff [--stat] [--color auto|always|never] [--delta] <KEY>"))
+		}
+		if err := internal.ShowDiff(fs.Arg(0), internal.DiffOptions{
+			Stat:  *stat,
+			Color: *color,
+			Delta: *delta,
+		}); err != nil {
+			fatal(err)
+		}
+
+	case "tool-hook":
+		// Run by Claude Code before the agent's tool calls; exit code 2 blocks one
+		if len(os.Args) < 4 {
+			fatal(fmt.Errorf("usage: factory tool-hook <NAME> <WORKTREE>"))
+		}
+		if err := internal.RunToolHook(os.Args[2], os.Args[3], os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 
 	case "version", "-v", "--version":
 		fmt.Printf("factory v%s\n", version)
@@ -88,12 +544,69 @@ USAGE:
 
 COMMANDS:
     configure    Setup Jira, GitHub, and repository settings
-    start        Start the background daemon
-    stop         Stop the daemon
-    status       Show daemon status and processed issues
-    trigger KEY  Process a specific issue immediately
+    start [--foreground]
+                 Start the background daemon (or run it in this process)
+    stop         Stop the daemon once the run in progress finishes
+    service install [--print] | enable | disable | uninstall
+                 Run the daemon as a systemd (Linux) or launchd (macOS) user service
+    status [--json]
+                 Show daemon status and processed issues
+    list [--json]
+                 Show assigned issues the daemon would pick up
+    history [--failed] [--since 7d] [--project PROJ] [--sort FIELD] [--json] [KEY]
+                 List processed issues, or one issue's last run with stage timings
+    audit [--issue KEY] [--action ACTION] [--since 7d] [--failed] [--json]
+                 List the pushes, PRs, and Jira changes factory made
+    ui           Browse issues, runs, and history; trigger, retry, cancel, and approve
+    trigger [--local] [--json] [--base BRANCH] [--update] KEY
+                 Process a specific issue now (on the daemon, if running), optionally against another branch
+                 or as follow-up commits on its open PR
+    estimate [--base BRANCH] KEY
+                 Post an effort estimate and suggested approach on an issue, changing no code
+    triage [--base BRANCH] KEY
+                 Post a bug's probable root cause and suspect commits on it, changing no code
+    review [--dry-run] PR
+                 Review a PR against its Jira issue and post the review
+    retry [--local] KEY
+                 Re-run a failed issue, from the stage it failed at where possible
+    cancel KEY   Stop an issue's run, discarding its changes and branch
     clear [KEY]  Clear processed issues (reprocess)
-    logs         Tail daemon logs
+    pause        Stop the daemon from starting queued issues
+    resume       Let the daemon start queued issues again
+    reload       Have the daemon re-read its config
+    queue        List issues waiting to be processed
+    queue bump KEY
+                 Move an issue to the front of the queue
+    queue drop KEY
+                 Remove an issue from the queue
+    ready KEY    Mark an issue's draft PR ready for review
+    feedback     Show PR outcomes and grades
+    feedback KEY --grade good|needs-work [--notes TEXT]
+                 Grade an automated PR
+    template test NAME|FILE [KEY]
+                 Render a template against an issue (or a sample)
+    render [--preset NAME] [--issue KEY] [--golden DIR [--update]]
+                 Render all outbound content, or check it against golden files
+    report [--days N] [--publish]
+                 Summarize recent runs, optionally posting to Jira/Confluence
+    selftest [--scenarios DIR] [--run NAME] [--keep]
+                 Run end-to-end scenarios against fake Jira/GitHub
+    apply-packet --sha256 DIGEST FILE
+                 Push a reviewed packet's change and open its PR
+    lessons      Show lessons learned for the repo
+    lessons add TEXT
+                 Record a lesson to include in future prompts
+    export [--config] FILE
+                 Save processed history, the queue, and lessons (and the config, without secrets)
+    import [--replace] FILE
+                 Merge an export into this machine's state
+    logs [KEY]   Tail daemon logs, or an issue's latest run
+    diff [--stat] [--color auto|always|never] [--delta] KEY
+                 Show the change an issue's latest run made
+    gc [--dry-run]
+                 Delete finished issues' branches and old run logs
+    watch [--server ADDR] [KEY]
+                 Stream live run output from a daemon's API
     help         Show this help
 
 QUICK START: